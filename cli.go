@@ -0,0 +1,468 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"mx-ui/config"
+	"mx-ui/database"
+	"mx-ui/web/service"
+)
+
+// 以下子命令直接读写数据库，不需要面板在运行。修改数据后通过PID文件找到运行中的面板，
+// 发送SIGHUP使其重新加载，面板未运行时变更在下次启动时生效
+
+const inboundUsage = `用法:
+  inbound list [-json]
+  inbound add -protocol <协议> -port <端口> [-tag 标签] [-remark 备注] [-settings JSON] [-stream JSON] [-disable] [-json]
+  inbound del <ID> [-json]
+  inbound enable <ID> [-json]
+  inbound disable <ID> [-json]`
+
+const clientUsage = `用法:
+  client list [-inbound ID] [-json]
+  client add -inbound <ID> -email <邮箱> [-uuid UUID] [-days 天数] [-limit GB] [-limitIp 数量] [-outbound 出站标签] [-remark 备注] [-disable] [-json]
+  client del <ID|邮箱> [-json]
+  client reset-traffic <ID|邮箱> [-json]
+  client extend <ID|邮箱> -days <天数> [-json]`
+
+const subUsage = `用法:
+  sub link <邮箱> [-json]`
+
+// runInboundCmd 处理 inbound 子命令
+func runInboundCmd(args []string) {
+	if len(args) == 0 {
+		exitUsage(inboundUsage)
+	}
+	initCLIDB()
+
+	action, args := args[0], args[1:]
+	fs := flag.NewFlagSet("inbound "+action, flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "以JSON格式输出")
+	noReload := fs.Bool("no-reload", false, "不通知运行中的面板重新加载")
+	inboundService := service.InboundService{}
+
+	switch action {
+	case "list":
+		parseCLIArgs(fs, args)
+		inbounds, err := inboundService.GetInbounds()
+		if err != nil {
+			exitErr("获取入站列表失败:", err)
+		}
+		if *jsonOutput {
+			printJSON(inbounds)
+			return
+		}
+		clientService := service.ClientService{}
+		w := newTable("ID", "协议", "端口", "标签", "状态", "客户端", "备注")
+		for _, inbound := range inbounds {
+			clients, _ := clientService.GetClients(inbound.ID)
+			fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%d\t%s\n",
+				inbound.ID, inbound.Protocol, inbound.Port, inbound.Tag,
+				enableText(inbound.Enable), len(clients), inbound.Remark)
+		}
+		w.Flush()
+	case "add":
+		inbound := &database.InboundConfig{}
+		fs.StringVar(&inbound.Protocol, "protocol", "", "协议: vless, vmess, trojan, shadowsocks 等")
+		fs.IntVar(&inbound.Port, "port", 0, "端口")
+		fs.StringVar(&inbound.Tag, "tag", "", "标签")
+		fs.StringVar(&inbound.Remark, "remark", "", "备注")
+		fs.StringVar(&inbound.Settings, "settings", "", "入站设置（JSON）")
+		fs.StringVar(&inbound.StreamSettings, "stream", "", "传输设置（JSON）")
+		disable := fs.Bool("disable", false, "添加后不启用")
+		parseCLIArgs(fs, args)
+		inbound.Enable = !*disable
+		// VLESS入站必须设置decryption，未指定设置时使用默认值
+		if inbound.Protocol == "vless" && inbound.Settings == "" {
+			inbound.Settings = `{"decryption":"none"}`
+		}
+
+		err := inboundService.AddInbound(inbound)
+		if err != nil {
+			exitErr("添加入站失败:", err)
+		}
+		if *jsonOutput {
+			printJSON(inbound)
+		} else {
+			fmt.Printf("已添加入站 %d: %s 端口 %d\n", inbound.ID, inbound.Protocol, inbound.Port)
+		}
+		notifyReload(*noReload)
+	case "del":
+		id := parseCLIID(parseCLIArgs(fs, args), inboundUsage)
+		inbound, err := inboundService.GetInbound(id)
+		if err != nil {
+			exitErr("入站不存在:", id)
+		}
+		err = inboundService.DelInbound(id)
+		if err != nil {
+			exitErr("删除入站失败:", err)
+		}
+		if *jsonOutput {
+			printJSON(inbound)
+		} else {
+			fmt.Printf("已删除入站 %d 及其客户端\n", id)
+		}
+		notifyReload(*noReload)
+	case "enable", "disable":
+		id := parseCLIID(parseCLIArgs(fs, args), inboundUsage)
+		enable := action == "enable"
+		err := inboundService.SetInboundEnable(id, enable)
+		if err != nil {
+			exitErr("设置入站状态失败:", err)
+		}
+		if *jsonOutput {
+			inbound, err := inboundService.GetInbound(id)
+			if err != nil {
+				exitErr("获取入站失败:", err)
+			}
+			printJSON(inbound)
+		} else {
+			fmt.Printf("入站 %d 已%s\n", id, enableText(enable))
+		}
+		notifyReload(*noReload)
+	default:
+		exitUsage(inboundUsage)
+	}
+}
+
+// runClientCmd 处理 client 子命令
+func runClientCmd(args []string) {
+	if len(args) == 0 {
+		exitUsage(clientUsage)
+	}
+	initCLIDB()
+
+	action, args := args[0], args[1:]
+	fs := flag.NewFlagSet("client "+action, flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "以JSON格式输出")
+	noReload := fs.Bool("no-reload", false, "不通知运行中的面板重新加载")
+	clientService := service.ClientService{}
+
+	switch action {
+	case "list":
+		inboundID := fs.Uint("inbound", 0, "只列出指定入站的客户端")
+		parseCLIArgs(fs, args)
+		clients, err := clientService.GetClients(*inboundID)
+		if err != nil {
+			exitErr("获取客户端列表失败:", err)
+		}
+		if *jsonOutput {
+			printJSON(clients)
+			return
+		}
+		w := newTable("ID", "入站", "邮箱", "UUID", "状态", "已用/限额", "到期时间", "IP限制", "备注")
+		for _, client := range clients {
+			fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s / %s\t%s\t%s\t%s\n",
+				client.ID, client.InboundID, client.Email, client.UUID, clientStateText(client),
+				service.FormatTraffic(client.Used), service.FormatLimit(client.Limit),
+				expiryText(client.ExpiryTime), limitIPText(client.LimitIP), client.Remark)
+		}
+		w.Flush()
+	case "add":
+		client := &database.ClientConfig{}
+		inboundID := fs.Uint("inbound", 0, "入站ID")
+		fs.StringVar(&client.Email, "email", "", "邮箱，用于区分客户端")
+		fs.StringVar(&client.UUID, "uuid", "", "UUID或密码，为空时随机生成")
+		days := fs.Int("days", 0, "有效天数，0表示永不过期")
+		limitGB := fs.Float64("limit", 0, "流量限制（GB），0表示不限")
+		fs.IntVar(&client.LimitIP, "limitIp", 0, "同时在线的IP数量限制，0表示不限")
+		fs.StringVar(&client.OutboundTag, "outbound", "", "该客户端的流量从指定标签的出站发出")
+		fs.StringVar(&client.Remark, "remark", "", "备注")
+		disable := fs.Bool("disable", false, "添加后不启用")
+		parseCLIArgs(fs, args)
+		if *days < 0 || *limitGB < 0 {
+			exitErr("有效天数和流量限制不能为负数")
+		}
+		client.InboundID = *inboundID
+		client.Enable = !*disable
+		client.Limit = int64(*limitGB * 1024 * 1024 * 1024)
+		if *days > 0 {
+			client.ExpiryTime = time.Now().AddDate(0, 0, *days).UnixMilli()
+		}
+
+		err := clientService.AddClient(client)
+		if err != nil {
+			exitErr("添加客户端失败:", err)
+		}
+		if *jsonOutput {
+			printJSON(client)
+		} else {
+			fmt.Printf("已添加客户端 %d: %s UUID %s\n", client.ID, client.Email, client.UUID)
+		}
+		notifyReload(*noReload)
+	case "del":
+		client := findCLIClient(parseCLIArgs(fs, args))
+		err := clientService.DelClient(client.ID)
+		if err != nil {
+			exitErr("删除客户端失败:", err)
+		}
+		if *jsonOutput {
+			printJSON(client)
+		} else {
+			fmt.Printf("已删除客户端 %s\n", client.Email)
+		}
+		notifyReload(*noReload)
+	case "reset-traffic":
+		client := findCLIClient(parseCLIArgs(fs, args))
+		err := clientService.ResetClientTraffic(client.ID)
+		if err != nil {
+			exitErr("重置客户端流量失败:", err)
+		}
+		if *jsonOutput {
+			printCLIClient(client.ID)
+		} else {
+			fmt.Printf("客户端 %s 流量已重置\n", client.Email)
+		}
+		notifyReload(*noReload)
+	case "extend":
+		days := fs.Int("days", 0, "延长的天数")
+		client := findCLIClient(parseCLIArgs(fs, args))
+		expiry, err := clientService.ExtendClient(client.ID, *days)
+		if err != nil {
+			exitErr("延长客户端有效期失败:", err)
+		}
+		if *jsonOutput {
+			printCLIClient(client.ID)
+		} else {
+			fmt.Printf("客户端 %s 到期时间延长至 %s\n", client.Email, expiryText(expiry))
+		}
+		notifyReload(*noReload)
+	default:
+		exitUsage(clientUsage)
+	}
+}
+
+// runSubCmd 处理 sub 子命令
+func runSubCmd(args []string) {
+	if len(args) == 0 || args[0] != "link" {
+		exitUsage(subUsage)
+	}
+	initCLIDB()
+
+	fs := flag.NewFlagSet("sub link", flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "以JSON格式输出")
+	positional := parseCLIArgs(fs, args[1:])
+	if len(positional) != 1 {
+		exitUsage(subUsage)
+	}
+
+	clientService := service.ClientService{}
+	client, err := clientService.GetClientByEmail(positional[0])
+	if err != nil {
+		exitErr("客户端不存在:", positional[0])
+	}
+	link, err := clientService.GetSubLink(client)
+	if err != nil {
+		exitErr("获取订阅链接失败:", err)
+	}
+	if *jsonOutput {
+		printJSON(map[string]string{
+			"email": client.Email,
+			"link":  link,
+		})
+		return
+	}
+	fmt.Println(link)
+}
+
+// initCLIDB 打开数据库，失败时退出
+func initCLIDB() {
+	err := database.InitDB(config.GetDBPath())
+	if err != nil {
+		exitErr("初始化数据库错误:", err)
+	}
+}
+
+// parseCLIArgs 解析参数并返回位置参数，位置参数可以出现在选项之前或之后
+func parseCLIArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		_ = fs.Parse(args)
+		if fs.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// parseCLIID 解析唯一的位置参数为ID
+func parseCLIID(positional []string, usage string) uint {
+	if len(positional) != 1 {
+		exitUsage(usage)
+	}
+	id, err := strconv.ParseUint(positional[0], 10, 32)
+	if err != nil || id == 0 {
+		exitErr("ID无效:", positional[0])
+	}
+	return uint(id)
+}
+
+// findCLIClient 按ID或邮箱查找客户端，参数为数字时优先按ID查找，不存在时退出
+func findCLIClient(positional []string) *database.ClientConfig {
+	if len(positional) != 1 {
+		exitUsage(clientUsage)
+	}
+	clientService := service.ClientService{}
+	if id, err := strconv.ParseUint(positional[0], 10, 32); err == nil {
+		client, err := clientService.GetClient(uint(id))
+		if err == nil {
+			return client
+		}
+	}
+	client, err := clientService.GetClientByEmail(positional[0])
+	if err == nil {
+		return client
+	}
+	exitErr("客户端不存在:", positional[0])
+	return nil
+}
+
+// printCLIClient 以JSON格式输出客户端的最新数据
+func printCLIClient(id uint) {
+	clientService := service.ClientService{}
+	client, err := clientService.GetClient(id)
+	if err != nil {
+		exitErr("获取客户端失败:", err)
+	}
+	printJSON(client)
+}
+
+// notifyReload 记录配置历史版本，并向运行中的面板发送SIGHUP，使其重启服务并重新应用Xray配置。
+// 提示信息输出到标准错误，标准输出只包含命令的结果
+func notifyReload(skip bool) {
+	revisionService := service.ConfigRevisionService{}
+	_, err := revisionService.Record("cli", "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "记录配置历史版本失败:", err)
+	}
+	if skip {
+		return
+	}
+	process, err := findRunningPanel()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "面板未运行，变更将在下次启动时生效")
+		return
+	}
+	err = process.Signal(syscall.SIGHUP)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "通知面板重新加载失败，请手动重启面板:", err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "已通知运行中的面板重新加载")
+}
+
+// findRunningPanel 根据PID文件查找运行中的面板进程。
+// 支持/proc的系统上会确认该进程与当前程序是同一个可执行文件，避免PID被复用时误发信号
+func findRunningPanel() (*os.Process, error) {
+	data, err := os.ReadFile(config.GetPIDPath())
+	if err != nil {
+		return nil, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return nil, fmt.Errorf("PID文件内容无效: %q", data)
+	}
+
+	if _, err := os.Stat("/proc/self/exe"); err == nil {
+		exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+		if err != nil {
+			return nil, err
+		}
+		self, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		if filepath.Clean(exe) != filepath.Clean(self) {
+			return nil, errors.New("PID文件中的进程不是面板")
+		}
+	}
+	return os.FindProcess(pid)
+}
+
+// writePIDFile 写入当前进程的PID
+func writePIDFile() error {
+	return os.WriteFile(config.GetPIDPath(), []byte(strconv.Itoa(os.Getpid())), 0644)
+}
+
+// removePIDFile 删除PID文件，文件已属于其他进程时保留
+func removePIDFile() error {
+	data, err := os.ReadFile(config.GetPIDPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	return os.Remove(config.GetPIDPath())
+}
+
+func newTable(headers ...string) *tabwriter.Writer {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	return w
+}
+
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		exitErr("生成JSON失败:", err)
+	}
+	fmt.Println(string(data))
+}
+
+func exitUsage(usage string) {
+	fmt.Fprintln(os.Stderr, usage)
+	os.Exit(2)
+}
+
+func exitErr(a ...interface{}) {
+	fmt.Fprintln(os.Stderr, a...)
+	os.Exit(1)
+}
+
+func enableText(enable bool) string {
+	if enable {
+		return "启用"
+	}
+	return "禁用"
+}
+
+// clientStateText 客户端状态，区分手动禁用和因过期、超流量或超出IP限制而不可用
+func clientStateText(client *database.ClientConfig) string {
+	if !client.Enable {
+		return "禁用"
+	}
+	clientService := service.ClientService{}
+	if !clientService.IsClientValid(client) {
+		return "不可用"
+	}
+	return "启用"
+}
+
+func expiryText(expiry int64) string {
+	if expiry <= 0 {
+		return "永不过期"
+	}
+	return time.UnixMilli(expiry).Format("2006-01-02 15:04")
+}
+
+func limitIPText(limit int) string {
+	if limit <= 0 {
+		return "不限"
+	}
+	return strconv.Itoa(limit)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"mx-ui/config"
+	"mx-ui/database"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupCLITest 把数据目录切换到临时目录，命令行使用其中的数据库
+func setupCLITest(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	dataDir := config.DataDirPath
+	config.DataDirPath = dir
+	t.Cleanup(func() {
+		database.CloseDB()
+		config.DataDirPath = dataDir
+	})
+}
+
+// runCLI 执行子命令，返回标准输出和标准错误的内容。命令执行后数据库保持打开，便于检查
+func runCLI(t *testing.T, run func(args []string), args ...string) (string, string) {
+	t.Helper()
+	database.CloseDB()
+	stdoutFile, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdoutFile.Close()
+	stderrFile, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderrFile.Close()
+
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdoutFile, stderrFile
+	defer func() {
+		os.Stdout, os.Stderr = stdout, stderr
+	}()
+	run(args)
+
+	outData, err := os.ReadFile(stdoutFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	errData, err := os.ReadFile(stderrFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(outData), string(errData)
+}
+
+// parseCLIJSON 解析命令的标准输出，输出必须只包含JSON
+func parseCLIJSON(t *testing.T, stdout string, v interface{}) {
+	t.Helper()
+	err := json.Unmarshal([]byte(stdout), v)
+	if err != nil {
+		t.Fatalf("标准输出不是JSON: %v\n%s", err, stdout)
+	}
+}
+
+func TestCLIJSONOutput(t *testing.T) {
+	setupCLITest(t)
+
+	stdout, stderr := runCLI(t, runInboundCmd, "add", "-protocol", "vless", "-port", "443", "-tag", "vless-443", "-json")
+	inbound := &database.InboundConfig{}
+	parseCLIJSON(t, stdout, inbound)
+	if inbound.ID == 0 || inbound.Port != 443 || inbound.Settings != `{"decryption":"none"}` {
+		t.Errorf("添加的入站为 %+v", inbound)
+	}
+	if !strings.Contains(stderr, "面板未运行") {
+		t.Errorf("通知面板的提示应输出到标准错误，实际为 %q", stderr)
+	}
+
+	stdout, _ = runCLI(t, runClientCmd, "add", "-inbound", "1", "-email", "alice", "-limit", "1", "-days", "5", "-json")
+	client := &database.ClientConfig{}
+	parseCLIJSON(t, stdout, client)
+	if client.Email != "alice" || client.UUID == "" || client.Limit != 1<<30 {
+		t.Errorf("添加的客户端为 %+v", client)
+	}
+
+	err := database.GetDB().Model(client).Update("used", 1<<29).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, _ = runCLI(t, runClientCmd, "reset-traffic", "alice", "-json")
+	reset := &database.ClientConfig{}
+	parseCLIJSON(t, stdout, reset)
+	if reset.ID != client.ID || reset.Used != 0 {
+		t.Errorf("重置流量后的客户端为 %+v", reset)
+	}
+
+	stdout, _ = runCLI(t, runClientCmd, "extend", "alice", "-days", "10", "-json")
+	extended := &database.ClientConfig{}
+	parseCLIJSON(t, stdout, extended)
+	wantExpiry := time.Now().AddDate(0, 0, 15)
+	if expiry := time.UnixMilli(extended.ExpiryTime); expiry.Sub(wantExpiry).Abs() > time.Minute {
+		t.Errorf("延长后的到期时间为 %v，期望 %v", expiry, wantExpiry)
+	}
+
+	stdout, _ = runCLI(t, runInboundCmd, "disable", "1", "-json")
+	disabled := &database.InboundConfig{}
+	parseCLIJSON(t, stdout, disabled)
+	if disabled.ID != inbound.ID || disabled.Enable {
+		t.Errorf("禁用后的入站为 %+v", disabled)
+	}
+
+	stdout, _ = runCLI(t, runClientCmd, "del", "alice", "-json")
+	deleted := &database.ClientConfig{}
+	parseCLIJSON(t, stdout, deleted)
+	if deleted.ID != client.ID || deleted.Email != "alice" {
+		t.Errorf("删除的客户端为 %+v", deleted)
+	}
+
+	stdout, _ = runCLI(t, runInboundCmd, "del", "1", "-json", "-no-reload")
+	deletedInbound := &database.InboundConfig{}
+	parseCLIJSON(t, stdout, deletedInbound)
+	if deletedInbound.ID != inbound.ID || deletedInbound.Tag != "vless-443" {
+		t.Errorf("删除的入站为 %+v", deletedInbound)
+	}
+
+	var count int64
+	database.GetDB().Model(&database.InboundConfig{}).Count(&count)
+	if count != 0 {
+		t.Errorf("删除后入站数量为 %d", count)
+	}
+}
+
+func TestCLITextOutput(t *testing.T) {
+	setupCLITest(t)
+
+	stdout, stderr := runCLI(t, runInboundCmd, "add", "-protocol", "trojan", "-port", "8443", "-remark", "jp")
+	if stdout != "已添加入站 1: trojan 端口 8443\n" {
+		t.Errorf("标准输出为 %q", stdout)
+	}
+	if stderr != "面板未运行，变更将在下次启动时生效\n" {
+		t.Errorf("标准错误为 %q", stderr)
+	}
+
+	// 位置参数可以出现在选项之前，-no-reload 时不输出通知的提示
+	runCLI(t, runClientCmd, "add", "-inbound", "1", "-email", "bob", "-uuid", "secret")
+	stdout, stderr = runCLI(t, runClientCmd, "reset-traffic", "bob", "-no-reload")
+	if stdout != "客户端 bob 流量已重置\n" || stderr != "" {
+		t.Errorf("输出为 %q %q", stdout, stderr)
+	}
+
+	stdout, _ = runCLI(t, runClientCmd, "list", "-inbound", "1")
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") || !strings.Contains(lines[1], "bob") ||
+		!strings.Contains(lines[1], "secret") || !strings.Contains(lines[1], "永不过期") {
+		t.Errorf("客户端列表为:\n%s", stdout)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 )
 
 const (
@@ -17,9 +18,14 @@ const (
 	BasePath       = "/app/"
 	DataDirName    = "mx-ui"
 	TempPath       = "temp"
+	BackupDirName  = "backup"
 	DBName         = "mx-ui.db"
+	BinDirName     = "bin"
+	XrayConfigName = "config.json"
 	CertFileName   = "mx-ui.cert"
 	KeyFileName    = "mx-ui.key"
+	BanListName    = "banned_ips.txt"
+	PIDFileName    = "mx-ui.pid"
 	DefaultWebPort = 54321
 	Debug          = "debug"
 	Info           = "info"
@@ -36,6 +42,14 @@ var (
 
 var LogLevel = Info
 
+// 日志输出格式和轮转设置
+var (
+	LogFormat     = "text"
+	LogMaxSize    = 10 // 单个日志文件最大大小（MB）
+	LogMaxAge     = 7  // 轮转后日志的保留天数
+	LogMaxBackups = 10 // 轮转后日志的最大保留个数
+)
+
 func GetVersion() string {
 	return Version
 }
@@ -48,7 +62,15 @@ func GetLogLevel() string {
 	return LogLevel
 }
 
+// GetLogFormat 获取日志输出格式（text 或 json）
+func GetLogFormat() string {
+	return LogFormat
+}
+
 func GetDBPath() string {
+	if DBPath != "" {
+		return DBPath
+	}
 	return path.Join(DataDirPath, DBName)
 }
 
@@ -56,6 +78,40 @@ func GetTempPath() string {
 	return path.Join(DataDirPath, TempPath)
 }
 
+// GetBinFolderPath 获取Xray二进制文件所在目录
+func GetBinFolderPath() string {
+	return BinDirName
+}
+
+// GetXrayBinaryPath 获取Xray二进制文件路径
+func GetXrayBinaryPath() string {
+	name := fmt.Sprintf("xray-%s-%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return path.Join(GetBinFolderPath(), name)
+}
+
+// GetXrayConfigPath 获取生成的Xray配置文件路径
+func GetXrayConfigPath() string {
+	return path.Join(GetBinFolderPath(), XrayConfigName)
+}
+
+// GetBackupPath 获取默认的本地备份目录
+func GetBackupPath() string {
+	return path.Join(DataDirPath, BackupDirName)
+}
+
+// GetBanListPath 获取默认的IP封禁列表文件路径
+func GetBanListPath() string {
+	return path.Join(DataDirPath, BanListName)
+}
+
+// GetPIDPath 获取运行中面板的PID文件路径，命令行修改数据后据此通知面板重新加载
+func GetPIDPath() string {
+	return path.Join(DataDirPath, PIDFileName)
+}
+
 func GetCertFile() string {
 	return path.Join(DataDirPath, CertFileName)
 }
@@ -68,14 +124,41 @@ func GetDefaultWebPort() int {
 	return DefaultWebPort
 }
 
+// GetListenAddr 获取配置的Web监听地址，为空时监听所有地址
+func GetListenAddr() string {
+	return ListenAddr
+}
+
+// GetSocketMode 获取配置的Unix套接字文件权限，为0时表示未配置
+func GetSocketMode() (os.FileMode, error) {
+	if SocketMode == "" {
+		return 0, nil
+	}
+	return ParseSocketMode(SocketMode)
+}
+
+// GetWebPort 获取配置的Web端口，为0时表示未配置，应使用数据库中的设置
+func GetWebPort() int {
+	return WebPort
+}
+
+// GetDrainTimeout 获取停止服务器时等待进行中请求完成的时间
+func GetDrainTimeout() time.Duration {
+	return time.Duration(DrainTimeout) * time.Second
+}
+
+// GetShutdownTimeout 获取退出时执行全部关闭步骤的总时间
+func GetShutdownTimeout() time.Duration {
+	return time.Duration(ShutdownTimeout) * time.Second
+}
+
 // 初始化函数
 func init() {
 	initDataDir()
 }
 
-// 初始化数据目录
+// 初始化默认数据目录，目录在加载配置后创建
 func initDataDir() {
-	var err error
 	// 获取用户根目录
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -96,21 +179,27 @@ func initDataDir() {
 		}
 	}
 
-	// 创建数据目录
-	err = os.MkdirAll(DataDirPath, os.ModePerm)
-	if err != nil {
-		fmt.Println("无法创建数据目录:", err)
-	}
-
 	// 设置日志文件路径
 	LogFilePath = path.Join(DataDirPath, "mx-ui.log")
+}
 
-	// 创建临时目录
-	tempPath := GetTempPath()
-	err = os.MkdirAll(tempPath, os.ModePerm)
+// initDirs 创建数据目录和临时目录
+func initDirs() error {
+	err := os.MkdirAll(DataDirPath, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("无法创建数据目录: %v", err)
+	}
+	if DBPath != "" {
+		err = os.MkdirAll(filepath.Dir(DBPath), os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("无法创建数据库目录: %v", err)
+		}
+	}
+	err = os.MkdirAll(GetTempPath(), os.ModePerm)
 	if err != nil {
-		fmt.Println("无法创建临时目录:", err)
+		return fmt.Errorf("无法创建临时目录: %v", err)
 	}
+	return nil
 }
 
 // NormalizeFilePath 标准化文件路径，处理不同操作系统的文件路径
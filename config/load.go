@@ -0,0 +1,299 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// 环境变量前缀
+const EnvPrefix = "MXUI_"
+
+// 配置文件名（不含扩展名），按 .yaml、.yml、.toml 的顺序查找
+const ConfigFileName = "mx-ui"
+
+var configFileExts = []string{".yaml", ".yml", ".toml"}
+
+// Options 启动配置，字符串为空、数字为0或指针为nil表示未设置。
+// RestartMax 可以设置为0表示不自动重启，因此用指针区分未设置
+type Options struct {
+	ConfigFile string `yaml:"-" toml:"-"`
+	DataDir    string `yaml:"data_dir" toml:"data_dir"`
+	DBPath     string `yaml:"db_path" toml:"db_path"`
+	LogLevel   string `yaml:"log_level" toml:"log_level"`
+	LogFormat  string `yaml:"log_format" toml:"log_format"`
+	LogFile    string `yaml:"log_file" toml:"log_file"`
+	Listen     string `yaml:"listen" toml:"listen"`
+	SocketMode string `yaml:"socket_mode" toml:"socket_mode"`
+	WebPort    int    `yaml:"web_port" toml:"web_port"`
+
+	RestartPolicy string `yaml:"restart_policy" toml:"restart_policy"`
+	RestartMax    *int   `yaml:"restart_max" toml:"restart_max"`
+
+	DrainTimeout    int `yaml:"drain_timeout" toml:"drain_timeout"`
+	ShutdownTimeout int `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+var (
+	// LoadedConfigFile 实际加载的配置文件路径，未加载时为空
+	LoadedConfigFile string
+	// DBPath 数据库文件路径，为空时使用数据目录下的默认文件
+	DBPath string
+	// ListenAddr Web服务器监听地址，为空时监听所有地址，unix: 开头时监听Unix套接字
+	ListenAddr string
+	// SocketMode Unix套接字文件的权限（八进制），为空时使用数据库中的设置
+	SocketMode string
+	// WebPort Web服务器端口，为0时使用数据库中的设置
+	WebPort int
+	// RestartPolicy 服务器运行中出错时的处理方式
+	RestartPolicy = RestartPolicyExit
+	// RestartMax 出错后最多自动重启的次数，收到SIGHUP手动重启后重新计数
+	RestartMax = 3
+	// DrainTimeout 停止服务器时等待进行中的HTTP请求完成的秒数
+	DrainTimeout = 10
+	// ShutdownTimeout 退出时执行全部关闭步骤的总秒数，超时后跳过剩余步骤
+	ShutdownTimeout = 30
+)
+
+// 服务器运行中出错时的处理方式
+const (
+	RestartPolicyExit    = "exit"
+	RestartPolicyRestart = "restart"
+)
+
+// Load 按 命令行参数 > 环境变量 > 配置文件 > 默认值 的优先级加载配置并创建数据目录，
+// 数据库中的设置优先级低于这里的配置，由使用方在未配置时读取
+func Load(flags *Options) error {
+	if flags == nil {
+		flags = &Options{}
+	}
+	env, err := readEnv()
+	if err != nil {
+		return err
+	}
+
+	configFile := flags.ConfigFile
+	if configFile == "" {
+		configFile = env.ConfigFile
+	}
+	file := &Options{}
+	if configFile != "" {
+		err = readConfigFile(configFile, file)
+		if err != nil {
+			return err
+		}
+		LoadedConfigFile = configFile
+	} else if found := findConfigFile(firstNonEmpty(flags.DataDir, env.DataDir)); found != "" {
+		err = readConfigFile(found, file)
+		if err != nil {
+			return err
+		}
+		LoadedConfigFile = found
+	}
+
+	options := mergeOptions(file, env, flags)
+	if options.LogLevel != "" {
+		switch strings.ToLower(options.LogLevel) {
+		case Debug, Info, Notice, Warn, "warning", Error:
+			LogLevel = strings.ToLower(options.LogLevel)
+		default:
+			return fmt.Errorf("未知日志级别: %v", options.LogLevel)
+		}
+	}
+	if options.LogFormat != "" {
+		if options.LogFormat != "text" && options.LogFormat != "json" {
+			return fmt.Errorf("未知日志格式: %v", options.LogFormat)
+		}
+		LogFormat = options.LogFormat
+	}
+	if options.SocketMode != "" {
+		_, err = ParseSocketMode(options.SocketMode)
+		if err != nil {
+			return err
+		}
+	}
+	if options.WebPort < 0 || options.WebPort > 65535 {
+		return fmt.Errorf("无效的端口: %v", options.WebPort)
+	}
+	if options.RestartPolicy != "" {
+		if options.RestartPolicy != RestartPolicyExit && options.RestartPolicy != RestartPolicyRestart {
+			return fmt.Errorf("未知的重启策略: %v", options.RestartPolicy)
+		}
+		RestartPolicy = options.RestartPolicy
+	}
+	if options.RestartMax != nil {
+		if *options.RestartMax < 0 {
+			return fmt.Errorf("无效的最大重启次数: %v", *options.RestartMax)
+		}
+		RestartMax = *options.RestartMax
+	}
+	if options.DrainTimeout < 0 {
+		return fmt.Errorf("无效的请求等待时间: %v", options.DrainTimeout)
+	}
+	if options.DrainTimeout > 0 {
+		DrainTimeout = options.DrainTimeout
+	}
+	if options.ShutdownTimeout < 0 {
+		return fmt.Errorf("无效的关闭超时时间: %v", options.ShutdownTimeout)
+	}
+	if options.ShutdownTimeout > 0 {
+		ShutdownTimeout = options.ShutdownTimeout
+	}
+
+	if options.DataDir != "" {
+		DataDirPath = options.DataDir
+	}
+	LogFilePath = path.Join(DataDirPath, "mx-ui.log")
+	if options.LogFile != "" {
+		LogFilePath = options.LogFile
+	}
+	DBPath = options.DBPath
+	ListenAddr = options.Listen
+	SocketMode = options.SocketMode
+	WebPort = options.WebPort
+
+	return initDirs()
+}
+
+// ParseSocketMode 解析八进制的Unix套接字文件权限，例如 0660
+func ParseSocketMode(mode string) (os.FileMode, error) {
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 0777 {
+		return 0, fmt.Errorf("无效的套接字权限: %v", mode)
+	}
+	return os.FileMode(value), nil
+}
+
+// readEnv 读取 MXUI_ 开头的环境变量
+func readEnv() (*Options, error) {
+	options := &Options{
+		ConfigFile: os.Getenv(EnvPrefix + "CONFIG"),
+		DataDir:    os.Getenv(EnvPrefix + "DATA_DIR"),
+		DBPath:     os.Getenv(EnvPrefix + "DB_PATH"),
+		LogLevel:   os.Getenv(EnvPrefix + "LOG_LEVEL"),
+		LogFormat:  os.Getenv(EnvPrefix + "LOG_FORMAT"),
+		LogFile:    os.Getenv(EnvPrefix + "LOG_FILE"),
+		Listen:     os.Getenv(EnvPrefix + "LISTEN"),
+		SocketMode: os.Getenv(EnvPrefix + "SOCKET_MODE"),
+
+		RestartPolicy: os.Getenv(EnvPrefix + "RESTART_POLICY"),
+	}
+	var err error
+	options.WebPort, err = readEnvInt("WEB_PORT")
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv(EnvPrefix+"RESTART_MAX") != "" {
+		restartMax, err := readEnvInt("RESTART_MAX")
+		if err != nil {
+			return nil, err
+		}
+		options.RestartMax = &restartMax
+	}
+	options.DrainTimeout, err = readEnvInt("DRAIN_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+	options.ShutdownTimeout, err = readEnvInt("SHUTDOWN_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// readEnvInt 读取整数环境变量，未设置时返回0
+func readEnvInt(name string) (int, error) {
+	value := os.Getenv(EnvPrefix + name)
+	if value == "" {
+		return 0, nil
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("环境变量 %s%s 无效: %v", EnvPrefix, name, value)
+	}
+	return result, nil
+}
+
+// findConfigFile 依次在当前目录、数据目录和 /etc/mx-ui 中查找配置文件
+func findConfigFile(dataDir string) string {
+	if dataDir == "" {
+		dataDir = DataDirPath
+	}
+	dirs := []string{".", dataDir}
+	if runtime.GOOS != "windows" {
+		dirs = append(dirs, path.Join("/etc", DataDirName))
+	}
+	for _, dir := range dirs {
+		for _, ext := range configFileExts {
+			file := filepath.Join(dir, ConfigFileName+ext)
+			if info, err := os.Stat(file); err == nil && !info.IsDir() {
+				return file
+			}
+		}
+	}
+	return ""
+}
+
+// readConfigFile 按扩展名解析 YAML 或 TOML 配置文件
+func readConfigFile(file string, options *Options) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".toml":
+		err = toml.Unmarshal(data, options)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, options)
+	default:
+		return fmt.Errorf("不支持的配置文件格式: %v", file)
+	}
+	if err != nil {
+		return fmt.Errorf("解析配置文件 %v 失败: %v", file, err)
+	}
+	return nil
+}
+
+// mergeOptions 合并多个来源的配置，靠后的来源优先
+func mergeOptions(sources ...*Options) *Options {
+	merged := &Options{}
+	for _, source := range sources {
+		merged.DataDir = firstNonEmpty(source.DataDir, merged.DataDir)
+		merged.DBPath = firstNonEmpty(source.DBPath, merged.DBPath)
+		merged.LogLevel = firstNonEmpty(source.LogLevel, merged.LogLevel)
+		merged.LogFormat = firstNonEmpty(source.LogFormat, merged.LogFormat)
+		merged.LogFile = firstNonEmpty(source.LogFile, merged.LogFile)
+		merged.Listen = firstNonEmpty(source.Listen, merged.Listen)
+		merged.SocketMode = firstNonEmpty(source.SocketMode, merged.SocketMode)
+		merged.RestartPolicy = firstNonEmpty(source.RestartPolicy, merged.RestartPolicy)
+		if source.WebPort != 0 {
+			merged.WebPort = source.WebPort
+		}
+		if source.RestartMax != nil {
+			merged.RestartMax = source.RestartMax
+		}
+		if source.DrainTimeout != 0 {
+			merged.DrainTimeout = source.DrainTimeout
+		}
+		if source.ShutdownTimeout != 0 {
+			merged.ShutdownTimeout = source.ShutdownTimeout
+		}
+	}
+	return merged
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadTestConfig 在临时数据目录中写入配置文件content（为空时不写入）并加载，返回加载后的最大重启次数。
+// 测试结束时恢复被修改的全局配置
+func loadTestConfig(t *testing.T, name string, content string, flags *Options) int {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	dataDir, logFile, loaded := DataDirPath, LogFilePath, LoadedConfigFile
+	restartMax, restartPolicy := RestartMax, RestartPolicy
+	t.Cleanup(func() {
+		DataDirPath, LogFilePath, LoadedConfigFile = dataDir, logFile, loaded
+		RestartMax, RestartPolicy = restartMax, restartPolicy
+	})
+
+	if flags == nil {
+		flags = &Options{}
+	}
+	flags.DataDir = dir
+	if content != "" {
+		flags.ConfigFile = filepath.Join(dir, name)
+		err := os.WriteFile(flags.ConfigFile, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := Load(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return RestartMax
+}
+
+func TestLoadRestartMax(t *testing.T) {
+	t.Setenv(EnvPrefix+"RESTART_MAX", "")
+	zero := 0
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     string
+		flags   *Options
+		want    int
+	}{
+		{name: "未设置时使用默认值", want: 3},
+		{name: "YAML中设置为0", file: "mx-ui.yaml", content: "restart_max: 0\n", want: 0},
+		{name: "TOML中设置为0", file: "mx-ui.toml", content: "restart_max = 0\n", want: 0},
+		{name: "YAML中设置为5", file: "mx-ui.yaml", content: "restart_max: 5\n", want: 5},
+		{name: "配置文件未设置", file: "mx-ui.yaml", content: "restart_policy: restart\n", want: 3},
+		{name: "环境变量设置为0", env: "0", want: 0},
+		{name: "环境变量优先于配置文件", file: "mx-ui.yaml", content: "restart_max: 5\n", env: "0", want: 0},
+		{name: "参数优先于环境变量", env: "5", flags: &Options{RestartMax: &zero}, want: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(EnvPrefix+"RESTART_MAX", test.env)
+			if got := loadTestConfig(t, test.file, test.content, test.flags); got != test.want {
+				t.Errorf("最大重启次数为 %d，期望 %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadInvalidRestartMax(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv(EnvPrefix+"RESTART_MAX", "-1")
+	err := Load(&Options{DataDir: dir})
+	if err == nil || err.Error() != "无效的最大重启次数: -1" {
+		t.Errorf("最大重启次数为负数时错误为 %v", err)
+	}
+	t.Setenv(EnvPrefix+"RESTART_MAX", "many")
+	err = Load(&Options{DataDir: dir})
+	if err == nil || err.Error() != "环境变量 MXUI_RESTART_MAX 无效: many" {
+		t.Errorf("最大重启次数不是数字时错误为 %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// 备份文件必须包含的表
+var requiredTables = []string{"users", "settings", "inbound_configs", "client_configs"}
+
+// Backup 使用 VACUUM INTO 将当前数据库一致地备份到dstPath，dstPath必须不存在
+func Backup(dstPath string) error {
+	if db == nil {
+		return errors.New("数据库未初始化")
+	}
+	err := os.MkdirAll(filepath.Dir(dstPath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return db.Exec("VACUUM INTO ?", dstPath).Error
+}
+
+// CheckBackup 检查备份文件是否是完整且可被当前版本使用的数据库
+func CheckBackup(path string) error {
+	backupDB, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("不是有效的数据库文件: %v", err)
+	}
+	sqlDB, err := backupDB.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	var result string
+	err = backupDB.Raw("PRAGMA integrity_check").Scan(&result).Error
+	if err != nil {
+		return fmt.Errorf("不是有效的数据库文件: %v", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("数据库完整性检查失败: %s", result)
+	}
+
+	for _, table := range requiredTables {
+		if !backupDB.Migrator().HasTable(table) {
+			return fmt.Errorf("缺少数据表: %s", table)
+		}
+	}
+
+	version, err := currentVersion(backupDB)
+	if err != nil {
+		return err
+	}
+	if version > LatestVersion() {
+		return fmt.Errorf("备份的数据库版本(%d)高于当前程序支持的版本(%d)", version, LatestVersion())
+	}
+	return nil
+}
+
+// pendingRestorePath 等待恢复的备份文件路径，与数据库位于同一目录以便原子替换
+func pendingRestorePath() string {
+	return dbPath + ".pending"
+}
+
+// Restore 使用srcPath处的备份替换当前数据库并重新初始化。
+// 调用前必须停止所有使用数据库的请求和后台任务，运行中的面板应使用StageRestore
+func Restore(srcPath string) error {
+	err := CheckBackup(srcPath)
+	if err != nil {
+		return err
+	}
+	if dbPath == "" {
+		return errors.New("数据库未初始化")
+	}
+
+	// 先复制到同目录下的临时文件，再通过重命名原子地替换
+	tmpPath := dbPath + ".restore"
+	err = copyFile(srcPath, tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	return replaceDB(tmpPath)
+}
+
+// StageRestore 校验srcPath处的备份并保存为待恢复文件，由ApplyPendingRestore在停止服务器和后台任务后替换数据库
+func StageRestore(srcPath string) error {
+	err := CheckBackup(srcPath)
+	if err != nil {
+		return err
+	}
+	if dbPath == "" {
+		return errors.New("数据库未初始化")
+	}
+	tmpPath := pendingRestorePath() + ".tmp"
+	err = copyFile(srcPath, tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	err = os.Rename(tmpPath, pendingRestorePath())
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// ApplyPendingRestore 存在待恢复的备份时用它替换当前数据库，返回是否执行了恢复。
+// 调用前必须停止所有使用数据库的请求和后台任务
+func ApplyPendingRestore() (bool, error) {
+	if dbPath == "" {
+		return false, errors.New("数据库未初始化")
+	}
+	pendingPath := pendingRestorePath()
+	if _, err := os.Stat(pendingPath); os.IsNotExist(err) {
+		return false, nil
+	}
+	defer os.Remove(pendingPath)
+	// 暂存后文件可能被改动，替换前再检查一次
+	err := CheckBackup(pendingPath)
+	if err != nil {
+		return false, err
+	}
+	return true, replaceDB(pendingPath)
+}
+
+// replaceDB 关闭当前数据库，用srcPath重命名覆盖数据库文件后重新初始化，srcPath必须与数据库位于同一目录
+func replaceDB(srcPath string) error {
+	if db != nil {
+		sqlDB, err := db.DB()
+		if err == nil {
+			sqlDB.Close()
+		}
+	}
+	err := os.Rename(srcPath, dbPath)
+	if err != nil {
+		// 替换失败时重新打开原数据库
+		if initErr := InitDB(dbPath); initErr != nil {
+			return fmt.Errorf("%v; 重新打开数据库失败: %v", err, initErr)
+		}
+		return err
+	}
+	return InitDB(dbPath)
+}
+
+// copyFile 复制文件
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,223 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// initTestDB 在临时目录中初始化数据库并迁移到最新版本
+func initTestDB(t *testing.T) string {
+	t.Helper()
+	path := openTestDB(t)
+	err := MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// inboundTags 获取当前数据库中所有入站的标签
+func inboundTags(t *testing.T) string {
+	t.Helper()
+	var tags []string
+	err := db.Model(&InboundConfig{}).Order("id").Pluck("tag", &tags).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(tags, ",")
+}
+
+func TestBackupAndCheck(t *testing.T) {
+	initTestDB(t)
+	err := db.Create(&InboundConfig{Protocol: "vless", Tag: "a", Port: 443}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backupPath := filepath.Join(t.TempDir(), "backup", "mx-ui.db")
+	err = Backup(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckBackup(backupPath)
+	if err != nil {
+		t.Fatalf("备份应通过检查: %v", err)
+	}
+	// VACUUM INTO 要求目标不存在
+	err = Backup(backupPath)
+	if err == nil {
+		t.Error("备份到已存在的文件应返回错误")
+	}
+
+	dir := t.TempDir()
+	notDB := filepath.Join(dir, "not.db")
+	err = os.WriteFile(notDB, []byte(strings.Repeat("not a database", 100)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckBackup(notDB)
+	if err == nil || !strings.HasPrefix(err.Error(), "不是有效的数据库文件") {
+		t.Errorf("不是数据库时错误为 %v", err)
+	}
+
+	// 缺少必需数据表的数据库
+	empty := filepath.Join(dir, "empty.db")
+	err = copyFile(backupPath, empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = withDB(empty, func() error {
+		return db.Migrator().DropTable("client_configs")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckBackup(empty)
+	if err == nil || err.Error() != "缺少数据表: client_configs" {
+		t.Errorf("缺少数据表时错误为 %v", err)
+	}
+
+	// 更新版本程序创建的备份
+	newer := filepath.Join(dir, "newer.db")
+	err = copyFile(backupPath, newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = withDB(newer, func() error {
+		return db.Create(&SchemaVersionRecord{Version: LatestVersion() + 1, Name: "future", AppliedAt: time.Now()}).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckBackup(newer)
+	want := fmt.Sprintf("备份的数据库版本(%d)高于当前程序支持的版本(%d)", LatestVersion()+1, LatestVersion())
+	if err == nil || err.Error() != want {
+		t.Errorf("备份版本过高时错误为 %v", err)
+	}
+}
+
+// withDB 临时把全局数据库切换到path执行f，之后恢复原来的数据库
+func withDB(path string, f func() error) error {
+	savedDB, savedPath := db, dbPath
+	defer func() {
+		CloseDB()
+		db, dbPath = savedDB, savedPath
+	}()
+	err := OpenDB(path)
+	if err != nil {
+		return err
+	}
+	return f()
+}
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	initTestDB(t)
+	err := db.Create(&InboundConfig{Protocol: "vless", Tag: "a", Port: 443}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	backupPath := filepath.Join(t.TempDir(), "mx-ui.db")
+	err = Backup(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Create(&InboundConfig{Protocol: "trojan", Tag: "b", Port: 8443}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Restore(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := inboundTags(t); tags != "a" {
+		t.Errorf("恢复后的入站为 %q，期望 a", tags)
+	}
+	assertVersion(t, LatestVersion())
+	if _, err := os.Stat(backupPath); err != nil {
+		t.Error("恢复不应移动备份文件")
+	}
+
+	err = Restore(filepath.Join(t.TempDir(), "missing.db"))
+	if err == nil {
+		t.Error("备份文件不存在时应返回错误")
+	}
+	if tags := inboundTags(t); tags != "a" {
+		t.Errorf("恢复失败后的入站为 %q", tags)
+	}
+}
+
+func TestStageAndApplyPendingRestore(t *testing.T) {
+	path := initTestDB(t)
+	err := db.Create(&InboundConfig{Protocol: "vless", Tag: "a", Port: 443}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	backupPath := filepath.Join(t.TempDir(), "mx-ui.db")
+	err = Backup(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Create(&InboundConfig{Protocol: "trojan", Tag: "b", Port: 8443}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有暂存的备份时不恢复
+	restored, err := ApplyPendingRestore()
+	if err != nil || restored {
+		t.Fatalf("没有暂存的备份时结果为 %v %v", restored, err)
+	}
+
+	// 暂存后数据库不变，直到应用
+	err = StageRestore(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := inboundTags(t); tags != "a,b" {
+		t.Errorf("暂存后的入站为 %q，数据库不应变化", tags)
+	}
+	restored, err = ApplyPendingRestore()
+	if err != nil || !restored {
+		t.Fatalf("应用暂存的备份时结果为 %v %v", restored, err)
+	}
+	if tags := inboundTags(t); tags != "a" {
+		t.Errorf("恢复后的入站为 %q，期望 a", tags)
+	}
+	if _, err := os.Stat(path + ".pending"); !os.IsNotExist(err) {
+		t.Error("恢复后应删除暂存的备份")
+	}
+
+	// 无效的备份不能暂存；暂存后被改动的备份在应用时被拒绝并删除
+	notDB := filepath.Join(t.TempDir(), "not.db")
+	err = os.WriteFile(notDB, []byte(strings.Repeat("not a database", 100)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = StageRestore(notDB)
+	if err == nil {
+		t.Error("无效的备份不应暂存")
+	}
+	err = StageRestore(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = copyFile(notDB, path+".pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err = ApplyPendingRestore()
+	if err == nil || restored {
+		t.Errorf("暂存的备份无效时结果为 %v %v", restored, err)
+	}
+	if _, err := os.Stat(path + ".pending"); !os.IsNotExist(err) {
+		t.Error("无效的暂存备份应被删除")
+	}
+	if tags := inboundTags(t); tags != "a" {
+		t.Errorf("恢复失败后的入站为 %q", tags)
+	}
+}
@@ -8,15 +8,20 @@ import (
 	"gorm.io/gorm"
 )
 
-var db *gorm.DB
+var (
+	db     *gorm.DB
+	dbPath string
+)
 
 // GetDB 获取数据库连接
 func GetDB() *gorm.DB {
 	return db
 }
 
-// InitDB 初始化数据库
-func InitDB(dbPath string) error {
+// OpenDB 打开数据库但不执行迁移
+func OpenDB(path string) error {
+	dbPath = path
+
 	// 确保数据库所在目录存在
 	dir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
@@ -41,43 +46,28 @@ func InitDB(dbPath string) error {
 	sqlDB.SetMaxIdleConns(1)
 	sqlDB.SetConnMaxLifetime(0)
 
-	// 自动迁移数据库表结构
-	err = initModels()
-	if err != nil {
-		return err
-	}
-
-	// 初始化默认数据
-	initUser()
-
 	return nil
 }
 
-// initModels 初始化数据库表结构
-func initModels() error {
-	return db.AutoMigrate(
-		&User{},
-		&Setting{},
-		&InboundConfig{},
-		&ClientConfig{},
-		&ServerStat{},
-	)
-}
-
-// initUser 初始化默认用户
-func initUser() {
-	var count int64
-	db.Model(&User{}).Count(&count)
-	if count > 0 {
-		return
+// CloseDB 关闭数据库连接，退出前调用
+func CloseDB() error {
+	if db == nil {
+		return nil
 	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
 
-	// 创建默认管理员账户
-	user := User{
-		Username: "admin",
-		Password: "admin",
+// InitDB 打开数据库并迁移到最新版本
+func InitDB(path string) error {
+	err := OpenDB(path)
+	if err != nil {
+		return err
 	}
-	db.Create(&user)
+	return MigrateUp()
 }
 
 // User 用户模型
@@ -104,19 +94,67 @@ type InboundConfig struct {
 	Settings       string
 	StreamSettings string
 	Remark         string
+	// RealityCheck 最近一次检查REALITY目标网站的结果（JSON），只由检查接口写入
+	RealityCheck string
+}
+
+// OutboundConfig 出站配置模型，生成Xray配置时合并到配置模板的出站之后
+type OutboundConfig struct {
+	gorm.Model
+	Tag            string
+	Protocol       string
+	Enable         bool
+	SendThrough    string
+	Settings       string
+	StreamSettings string
+	Mux            string
+	Remark         string
+}
+
+// RoutingRule 路由规则模型，按Sort排序后放在配置模板的路由规则之前。
+// 同一条规则中的各个条件需要同时满足
+type RoutingRule struct {
+	gorm.Model
+	Sort        int
+	Enable      bool
+	Domain      []string `gorm:"serializer:json"`
+	IP          []string `gorm:"serializer:json"`
+	Geosite     []string `gorm:"serializer:json"`
+	GeoIP       []string `gorm:"serializer:json"`
+	Port        string
+	Network     string
+	Protocol    []string `gorm:"serializer:json"`
+	InboundTag  []string `gorm:"serializer:json"`
+	User        []string `gorm:"serializer:json"`
+	OutboundTag string
+	BalancerTag string
+	Remark      string
+}
+
+// ConfigRevision Xray配置历史版本模型，Config为生成的配置，Snapshot为生成它的入站、客户端、出站、路由规则和配置模板
+type ConfigRevision struct {
+	gorm.Model
+	Author   string
+	Remark   string
+	Config   string
+	Snapshot string
 }
 
-// ClientConfig 客户端配置模型
+// ClientConfig 客户端配置模型，OutboundTag不为空时该客户端的流量从指定出站发出
 type ClientConfig struct {
 	gorm.Model
-	InboundID  uint
-	Email      string
-	UUID       string
-	Enable     bool
-	ExpiryTime int64
-	Limit      int64
-	Used       int64
-	Remark     string
+	InboundID     uint
+	Email         string
+	UUID          string
+	Enable        bool
+	ExpiryTime    int64
+	Limit         int64
+	Used          int64
+	SubID         string
+	Remark        string
+	LimitIP       int
+	DisabledUntil int64
+	OutboundTag   string
 }
 
 // ServerStat 服务器统计数据模型
@@ -134,53 +172,6 @@ func IsNotFound(err error) bool {
 	return err == gorm.ErrRecordNotFound
 }
 
-// AutoMigrate 自动迁移数据库表结构
-func AutoMigrate() error {
-	// 在此处添加所有需要迁移的模型
-	return db.AutoMigrate(
-		&User{},
-		&Setting{},
-		&InboundConfig{},
-		&ClientConfig{},
-		&ServerStat{},
-	)
-}
-
-// InitData 初始化默认数据
-func InitData() {
-	InitUser()
-	InitSetting()
-}
-
-// InitUser 初始化默认用户
-func InitUser() {
-	var count int64
-	db.Model(&User{}).Count(&count)
-	if count > 0 {
-		return
-	}
-
-	// 创建默认管理员账户
-	user := User{
-		Username: "admin",
-		Password: "admin",
-	}
-	db.Create(&user)
-}
-
-// InitSetting 初始化默认设置
-func InitSetting() {
-	settings := []Setting{
-		{Key: "webPort", Value: "54321"},
-		{Key: "webBasePath", Value: "/"},
-		{Key: "xrayConfigTemplate", Value: GetDefaultXrayConfigTemplate()},
-	}
-
-	for _, setting := range settings {
-		db.FirstOrCreate(&Setting{}, Setting{Key: setting.Key}).Update("Value", setting.Value)
-	}
-}
-
 // GetDefaultXrayConfigTemplate 获取默认的Xray配置模板
 func GetDefaultXrayConfigTemplate() string {
 	return `{
@@ -192,7 +183,12 @@ func GetDefaultXrayConfigTemplate() string {
 		"inbounds": [],
 		"outbounds": [
 			{
+				"tag": "direct",
 				"protocol": "freedom"
+			},
+			{
+				"tag": "blocked",
+				"protocol": "blackhole"
 			}
 		],
 		"routing": {
@@ -0,0 +1,568 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mx-ui/logger"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Migration 一次带编号的数据库迁移
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaVersionRecord 已应用的迁移记录
+type SchemaVersionRecord struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// TableName 迁移记录表名
+func (SchemaVersionRecord) TableName() string {
+	return "schema_version"
+}
+
+// MigrationStatus 迁移状态
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrations 所有迁移，按版本号递增排列，已发布的迁移不能修改
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "init_schema",
+		Up: func(tx *gorm.DB) error {
+			// 使用当时的结构快照，避免后续模型变化影响本次迁移
+			type User struct {
+				gorm.Model
+				Username string
+				Password string
+			}
+			type Setting struct {
+				gorm.Model
+				Key   string `gorm:"unique"`
+				Value string
+			}
+			type InboundConfig struct {
+				gorm.Model
+				Protocol       string
+				Tag            string
+				Port           int
+				Enable         bool
+				Settings       string
+				StreamSettings string
+				Remark         string
+			}
+			type ClientConfig struct {
+				gorm.Model
+				InboundID  uint
+				Email      string
+				UUID       string
+				Enable     bool
+				ExpiryTime int64
+				Limit      int64
+				Used       int64
+				SubID      string
+				Remark     string
+			}
+			type ServerStat struct {
+				gorm.Model
+				Date       string `gorm:"uniqueIndex:idx_server_stat_date"`
+				CPU        float64
+				Mem        float64
+				NetworkIn  int64
+				NetworkOut int64
+			}
+			return tx.AutoMigrate(&User{}, &Setting{}, &InboundConfig{}, &ClientConfig{}, &ServerStat{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("server_stats", "client_configs", "inbound_configs", "settings", "users")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "default_data",
+		Up: func(tx *gorm.DB) error {
+			now := time.Now()
+			var count int64
+			err := tx.Table("users").Where("deleted_at IS NULL").Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				// 创建默认管理员账户
+				err = tx.Table("users").Create(map[string]interface{}{
+					"username":   "admin",
+					"password":   "admin",
+					"created_at": now,
+					"updated_at": now,
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			defaults := map[string]string{
+				"webPort":     "54321",
+				"webBasePath": "/",
+			}
+			for key, value := range defaults {
+				err = tx.Table("settings").Where("key = ?", key).Count(&count).Error
+				if err != nil {
+					return err
+				}
+				if count > 0 {
+					continue
+				}
+				err = tx.Table("settings").Create(map[string]interface{}{
+					"key":        key,
+					"value":      value,
+					"created_at": now,
+					"updated_at": now,
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		// 默认数据可能已被修改，回滚时保留
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	},
+	{
+		Version: 3,
+		Name:    "hash_user_passwords",
+		Up: func(tx *gorm.DB) error {
+			var users []struct {
+				ID       uint
+				Password string
+			}
+			err := tx.Table("users").Select("id, password").Find(&users).Error
+			if err != nil {
+				return err
+			}
+			for _, user := range users {
+				if IsPasswordHashed(user.Password) {
+					continue
+				}
+				hashed, err := HashPassword(user.Password)
+				if err != nil {
+					return err
+				}
+				err = tx.Table("users").Where("id = ?", user.ID).Update("password", hashed).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return errors.New("密码哈希无法还原为明文")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "client_limit_ip",
+		Up: func(tx *gorm.DB) error {
+			type ClientConfig struct {
+				LimitIP       int   `gorm:"default:0"`
+				DisabledUntil int64 `gorm:"default:0"`
+			}
+			for _, field := range []string{"LimitIP", "DisabledUntil"} {
+				if tx.Migrator().HasColumn(&ClientConfig{}, field) {
+					continue
+				}
+				err := tx.Migrator().AddColumn(&ClientConfig{}, field)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			type ClientConfig struct {
+				LimitIP       int
+				DisabledUntil int64
+			}
+			for _, field := range []string{"LimitIP", "DisabledUntil"} {
+				err := tx.Migrator().DropColumn(&ClientConfig{}, field)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 5,
+		Name:    "outbound_configs",
+		Up: func(tx *gorm.DB) error {
+			type OutboundConfig struct {
+				gorm.Model
+				Tag            string
+				Protocol       string
+				Enable         bool
+				SendThrough    string
+				Settings       string
+				StreamSettings string
+				Mux            string
+				Remark         string
+			}
+			err := tx.AutoMigrate(&OutboundConfig{})
+			if err != nil {
+				return err
+			}
+			// 旧版默认模板的路由规则指向未定义的blocked出站，
+			// 自定义模板沿用了这条规则时补充一个blackhole出站
+			var template string
+			err = tx.Table("settings").Where("key = ? AND deleted_at IS NULL", "xrayConfigTemplate").
+				Select("value").Scan(&template).Error
+			if err != nil || !blockedOutboundMissing(template) {
+				return err
+			}
+			return tx.Create(&OutboundConfig{
+				Tag:      "blocked",
+				Protocol: "blackhole",
+				Enable:   true,
+				Remark:   "升级时为路由规则自动添加",
+			}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("outbound_configs")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "routing_rules",
+		Up: func(tx *gorm.DB) error {
+			type RoutingRule struct {
+				gorm.Model
+				Sort        int
+				Enable      bool
+				Domain      []string `gorm:"serializer:json"`
+				IP          []string `gorm:"serializer:json"`
+				Geosite     []string `gorm:"serializer:json"`
+				GeoIP       []string `gorm:"serializer:json"`
+				Port        string
+				Network     string
+				Protocol    []string `gorm:"serializer:json"`
+				InboundTag  []string `gorm:"serializer:json"`
+				User        []string `gorm:"serializer:json"`
+				OutboundTag string
+				BalancerTag string
+				Remark      string
+			}
+			return tx.AutoMigrate(&RoutingRule{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("routing_rules")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "client_outbound_tag",
+		Up: func(tx *gorm.DB) error {
+			type ClientConfig struct {
+				OutboundTag string `gorm:"default:''"`
+			}
+			if tx.Migrator().HasColumn(&ClientConfig{}, "OutboundTag") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&ClientConfig{}, "OutboundTag")
+		},
+		Down: func(tx *gorm.DB) error {
+			type ClientConfig struct {
+				OutboundTag string
+			}
+			return tx.Migrator().DropColumn(&ClientConfig{}, "OutboundTag")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "config_revisions",
+		Up: func(tx *gorm.DB) error {
+			type ConfigRevision struct {
+				gorm.Model
+				Author   string
+				Remark   string
+				Config   string
+				Snapshot string
+			}
+			return tx.AutoMigrate(&ConfigRevision{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("config_revisions")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "inbound_reality_check",
+		Up: func(tx *gorm.DB) error {
+			type InboundConfig struct {
+				RealityCheck string `gorm:"default:''"`
+			}
+			if tx.Migrator().HasColumn(&InboundConfig{}, "RealityCheck") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&InboundConfig{}, "RealityCheck")
+		},
+		Down: func(tx *gorm.DB) error {
+			type InboundConfig struct {
+				RealityCheck string
+			}
+			return tx.Migrator().DropColumn(&InboundConfig{}, "RealityCheck")
+		},
+	},
+}
+
+// blockedOutboundMissing 判断配置模板中是否有路由规则指向未定义的blocked出站
+func blockedOutboundMissing(template string) bool {
+	if template == "" {
+		return false
+	}
+	var config struct {
+		Outbounds []struct {
+			Tag string `json:"tag"`
+		} `json:"outbounds"`
+		Routing struct {
+			Rules []struct {
+				OutboundTag string `json:"outboundTag"`
+			} `json:"rules"`
+		} `json:"routing"`
+	}
+	if json.Unmarshal([]byte(template), &config) != nil {
+		return false
+	}
+	for _, outbound := range config.Outbounds {
+		if outbound.Tag == "blocked" {
+			return false
+		}
+	}
+	for _, rule := range config.Routing.Rules {
+		if rule.OutboundTag == "blocked" {
+			return true
+		}
+	}
+	return false
+}
+
+// LatestVersion 获取程序支持的最新数据库版本
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// HashPassword 使用bcrypt对密码进行哈希
+func HashPassword(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// IsPasswordHashed 判断密码是否已经是bcrypt哈希
+func IsPasswordHashed(password string) bool {
+	return strings.HasPrefix(password, "$2a$") || strings.HasPrefix(password, "$2b$") || strings.HasPrefix(password, "$2y$")
+}
+
+// CheckPassword 校验明文密码与哈希是否匹配
+func CheckPassword(hashed string, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)) == nil
+}
+
+// ensureSchemaVersionTable 确保迁移记录表存在
+func ensureSchemaVersionTable(tx *gorm.DB) error {
+	return tx.AutoMigrate(&SchemaVersionRecord{})
+}
+
+// currentVersion 获取已应用的最高迁移版本，未应用任何迁移时返回0
+func currentVersion(tx *gorm.DB) (int, error) {
+	if !tx.Migrator().HasTable(&SchemaVersionRecord{}) {
+		return 0, nil
+	}
+	var version int
+	err := tx.Model(&SchemaVersionRecord{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// CurrentVersion 获取当前数据库的结构版本
+func CurrentVersion() (int, error) {
+	if db == nil {
+		return 0, errors.New("数据库未初始化")
+	}
+	return currentVersion(db)
+}
+
+// checkVersion 拒绝使用由更新版本程序创建的数据库
+func checkVersion(tx *gorm.DB) error {
+	version, err := currentVersion(tx)
+	if err != nil {
+		return err
+	}
+	if version > LatestVersion() {
+		return fmt.Errorf("数据库版本(%d)高于当前程序支持的版本(%d)，请升级程序", version, LatestVersion())
+	}
+	return nil
+}
+
+// MigrateUp 依次应用所有未应用的迁移，每个迁移在独立事务中执行
+func MigrateUp() error {
+	return MigrateTo(LatestVersion())
+}
+
+// MigrateTo 将数据库迁移到指定版本，高于当前版本时升级，低于当前版本时回滚
+func MigrateTo(target int) error {
+	if db == nil {
+		return errors.New("数据库未初始化")
+	}
+	if target < 0 || target > LatestVersion() {
+		return fmt.Errorf("目标版本必须在0-%d之间", LatestVersion())
+	}
+	err := ensureSchemaVersionTable(db)
+	if err != nil {
+		return err
+	}
+	err = checkVersion(db)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Version > target || applied[m.Version] {
+			continue
+		}
+		err = runMigration(m, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= target || !applied[m.Version] {
+			continue
+		}
+		err = runMigration(m, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runMigration 在事务中执行一次迁移并更新迁移记录
+func runMigration(m Migration, up bool) error {
+	direction := "升级"
+	if !up {
+		direction = "回滚"
+	}
+	logger.Infof("数据库迁移%s: %d %s", direction, m.Version, m.Name)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if up {
+			err := m.Up(tx)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&SchemaVersionRecord{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		}
+		err := m.Down(tx)
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&SchemaVersionRecord{}, m.Version).Error
+	})
+	if err != nil {
+		return fmt.Errorf("迁移%s %d(%s)失败: %v", direction, m.Version, m.Name, err)
+	}
+	return nil
+}
+
+// appliedVersions 获取已应用的迁移版本集合
+func appliedVersions(tx *gorm.DB) (map[int]bool, error) {
+	var records []SchemaVersionRecord
+	err := tx.Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]bool{}
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+	return applied, nil
+}
+
+// GetMigrationStatus 获取所有迁移的应用状态
+func GetMigrationStatus() ([]MigrationStatus, error) {
+	if db == nil {
+		return nil, errors.New("数据库未初始化")
+	}
+	err := ensureSchemaVersionTable(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []SchemaVersionRecord
+	err = db.Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+	recordMap := map[int]SchemaVersionRecord{}
+	for _, record := range records {
+		recordMap[record.Version] = record
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range migrations {
+		record, ok := recordMap[m.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:   m.Version,
+			Name:      m.Name,
+			Applied:   ok,
+			AppliedAt: record.AppliedAt,
+		})
+		delete(recordMap, m.Version)
+	}
+	// 数据库中存在程序未知的迁移（由更新版本创建）
+	for _, record := range recordMap {
+		statuses = append(statuses, MigrationStatus{
+			Version:   record.Version,
+			Name:      record.Name,
+			Applied:   true,
+			AppliedAt: record.AppliedAt,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
@@ -0,0 +1,247 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// openTestDB 在临时目录中打开一个空数据库，不执行迁移
+func openTestDB(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mx-ui.db")
+	err := OpenDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		CloseDB()
+		db = nil
+		dbPath = ""
+	})
+	return path
+}
+
+// assertVersion 检查数据库当前的结构版本
+func assertVersion(t *testing.T, want int) {
+	t.Helper()
+	version, err := CurrentVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != want {
+		t.Fatalf("数据库版本为 %d，期望 %d", version, want)
+	}
+}
+
+func TestMigrateFromV0(t *testing.T) {
+	openTestDB(t)
+
+	// 迁移机制引入前创建的数据库：没有迁移记录表，密码为明文，
+	// 自定义模板沿用了旧版默认模板中指向blocked的路由规则
+	type User struct {
+		gorm.Model
+		Username string
+		Password string
+	}
+	type Setting struct {
+		gorm.Model
+		Key   string `gorm:"unique"`
+		Value string
+	}
+	err := db.AutoMigrate(&User{}, &Setting{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Create(&User{Username: "root", Password: "secret"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := `{"outbounds":[{"tag":"direct","protocol":"freedom"}],"routing":{"rules":[{"type":"field","ip":["geoip:private"],"outboundTag":"blocked"}]}}`
+	err = db.Create(&Setting{Key: "xrayConfigTemplate", Value: template}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, 0)
+
+	err = MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, LatestVersion())
+
+	// 已有用户时不创建默认管理员，明文密码被哈希后仍可登录
+	var users []User
+	err = db.Find(&users).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Username != "root" {
+		t.Fatalf("迁移后的用户为 %+v", users)
+	}
+	if !IsPasswordHashed(users[0].Password) || !CheckPassword(users[0].Password, "secret") {
+		t.Errorf("迁移后的密码为 %q，应为 secret 的哈希", users[0].Password)
+	}
+	if CheckPassword(users[0].Password, "admin") {
+		t.Error("错误的密码不应通过校验")
+	}
+
+	var outbounds []OutboundConfig
+	err = db.Find(&outbounds).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outbounds) != 1 || outbounds[0].Tag != "blocked" || outbounds[0].Protocol != "blackhole" || !outbounds[0].Enable {
+		t.Errorf("应为路由规则自动添加blocked出站，实际为 %+v", outbounds)
+	}
+
+	// 再次迁移不重复执行
+	err = MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&OutboundConfig{}).Count(&count)
+	if count != 1 {
+		t.Errorf("再次迁移后出站数量为 %d", count)
+	}
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	openTestDB(t)
+	err := MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 新数据库创建默认管理员，密码已哈希
+	user := &User{}
+	err = db.First(user).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "admin" || !CheckPassword(user.Password, "admin") {
+		t.Errorf("默认管理员为 %s %q", user.Username, user.Password)
+	}
+	var count int64
+	db.Model(&OutboundConfig{}).Count(&count)
+	if count != 0 {
+		t.Errorf("没有配置模板时不应添加出站，实际有 %d 个", count)
+	}
+}
+
+func TestBlockedOutboundMissing(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     bool
+	}{
+		{name: "使用默认模板", template: "", want: false},
+		{name: "默认模板中定义了blocked", template: GetDefaultXrayConfigTemplate(), want: false},
+		{name: "规则指向未定义的blocked", template: `{"routing":{"rules":[{"outboundTag":"blocked"}]}}`, want: true},
+		{name: "没有指向blocked的规则", template: `{"routing":{"rules":[{"outboundTag":"direct"}]}}`, want: false},
+		{name: "模板不是有效的JSON", template: `{`, want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := blockedOutboundMissing(test.template); got != test.want {
+				t.Errorf("结果为 %v，期望 %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMigrateTo(t *testing.T) {
+	openTestDB(t)
+	err := MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 回滚到版本4后，之后迁移创建的表和字段被删除
+	err = MigrateTo(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, 4)
+	for _, table := range []string{"outbound_configs", "routing_rules", "config_revisions"} {
+		if db.Migrator().HasTable(table) {
+			t.Errorf("回滚后不应存在数据表 %s", table)
+		}
+	}
+	if db.Migrator().HasColumn(&ClientConfig{}, "OutboundTag") || db.Migrator().HasColumn(&InboundConfig{}, "RealityCheck") {
+		t.Error("回滚后不应存在之后添加的字段")
+	}
+	if !db.Migrator().HasColumn(&ClientConfig{}, "LimitIP") {
+		t.Error("版本4添加的字段应保留")
+	}
+	statuses, err := GetMigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.Applied != (status.Version <= 4) {
+			t.Errorf("迁移 %d %s 的状态为 %v", status.Version, status.Name, status.Applied)
+		}
+	}
+
+	// 再次升级到最新版本
+	err = MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, LatestVersion())
+	err = db.Create(&RoutingRule{Enable: true, Domain: []string{"example.com"}, OutboundTag: "direct"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 密码哈希不能回滚，之前的迁移已回滚，停在版本3
+	err = MigrateTo(2)
+	if err == nil || err.Error() != "迁移回滚 3(hash_user_passwords)失败: 密码哈希无法还原为明文" {
+		t.Errorf("回滚密码哈希时错误为 %v", err)
+	}
+	assertVersion(t, 3)
+
+	err = MigrateTo(LatestVersion() + 1)
+	if err == nil || err.Error() != fmt.Sprintf("目标版本必须在0-%d之间", LatestVersion()) {
+		t.Errorf("目标版本超出范围时错误为 %v", err)
+	}
+}
+
+func TestCheckVersionNewerDatabase(t *testing.T) {
+	openTestDB(t)
+	err := MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer := LatestVersion() + 1
+	err = db.Create(&SchemaVersionRecord{Version: newer, Name: "future", AppliedAt: time.Now()}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 更新版本程序创建的数据库不能使用，也不能升级或回滚
+	want := fmt.Sprintf("数据库版本(%d)高于当前程序支持的版本(%d)，请升级程序", newer, LatestVersion())
+	err = MigrateUp()
+	if err == nil || err.Error() != want {
+		t.Errorf("升级时错误为 %v", err)
+	}
+	err = MigrateTo(1)
+	if err == nil || err.Error() != want {
+		t.Errorf("回滚时错误为 %v", err)
+	}
+	assertVersion(t, newer)
+
+	statuses, err := GetMigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := statuses[len(statuses)-1]
+	if last.Version != newer || last.Name != "future" || !last.Applied {
+		t.Errorf("未知的迁移状态为 %+v", last)
+	}
+}
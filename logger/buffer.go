@@ -0,0 +1,179 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 内存中保留的最近日志条数
+const bufferSize = 2000
+
+// Entry 一条内存中的日志
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+
+	level slog.Level
+}
+
+// NewEntry 创建一条日志，用于将其他来源的日志按相同格式返回
+func NewEntry(t time.Time, level slog.Level, message string) Entry {
+	return Entry{
+		Time:    t,
+		Level:   LevelName(level),
+		Message: message,
+		level:   level,
+	}
+}
+
+// ringBuffer 固定容量的日志环形缓冲区，支持订阅新日志
+type ringBuffer struct {
+	lock        sync.Mutex
+	entries     []Entry
+	next        int
+	full        bool
+	subscribers map[chan Entry]*subscriber
+}
+
+// subscriber 订阅者只接收不低于level且包含keyword的日志
+type subscriber struct {
+	level   slog.Level
+	keyword string
+}
+
+var buffer = &ringBuffer{
+	entries:     make([]Entry, bufferSize),
+	subscribers: map[chan Entry]*subscriber{},
+}
+
+// add 追加一条日志并通知订阅者，订阅者处理不过来时丢弃
+func (b *ringBuffer) add(entry Entry) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+	for ch, sub := range b.subscribers {
+		if !entry.Match(sub.level, sub.keyword) {
+			continue
+		}
+		select {
+		case ch <- entry:
+		default:
+		}
+	}
+}
+
+// list 按时间顺序返回所有日志
+func (b *ringBuffer) list() []Entry {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.listLocked()
+}
+
+// listLocked 同list，调用方需持有锁
+func (b *ringBuffer) listLocked() []Entry {
+	if !b.full {
+		return append([]Entry{}, b.entries[:b.next]...)
+	}
+	entries := make([]Entry, 0, len(b.entries))
+	entries = append(entries, b.entries[b.next:]...)
+	return append(entries, b.entries[:b.next]...)
+}
+
+// Match 判断日志是否不低于level且包含keyword（不区分大小写）
+func (e *Entry) Match(level slog.Level, keyword string) bool {
+	if e.level < level {
+		return false
+	}
+	if keyword == "" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(e.Message), strings.ToLower(keyword))
+}
+
+// GetLogs 获取内存中最近的面板日志，limit为0时不限制条数
+func GetLogs(level slog.Level, keyword string, limit int) []Entry {
+	return filterEntries(buffer.list(), level, keyword, limit)
+}
+
+// filterEntries 筛选日志，limit大于0时只保留最后limit条
+func filterEntries(list []Entry, level slog.Level, keyword string, limit int) []Entry {
+	var entries []Entry
+	for _, entry := range list {
+		if entry.Match(level, keyword) {
+			entries = append(entries, entry)
+		}
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries
+}
+
+// Follow 获取内存中最近的面板日志并订阅之后产生的新日志，两者在同一把锁下完成，
+// 不会遗漏或重复。通道只接收不低于level且包含keyword的日志，使用完毕后需调用返回的取消函数
+func Follow(level slog.Level, keyword string, limit int) ([]Entry, <-chan Entry, func()) {
+	ch := make(chan Entry, 100)
+	buffer.lock.Lock()
+	entries := filterEntries(buffer.listLocked(), level, keyword, limit)
+	buffer.subscribers[ch] = &subscriber{level: level, keyword: keyword}
+	buffer.lock.Unlock()
+
+	var once sync.Once
+	return entries, ch, func() {
+		once.Do(func() {
+			buffer.lock.Lock()
+			delete(buffer.subscribers, ch)
+			buffer.lock.Unlock()
+		})
+	}
+}
+
+// bufferHandler 将日志同时交给下层处理器和内存缓冲区
+type bufferHandler struct {
+	next  slog.Handler
+	attrs []slog.Attr
+}
+
+func (h *bufferHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *bufferHandler) Handle(ctx context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	writeAttr := func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value.Resolve())
+		return true
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	r.Attrs(writeAttr)
+
+	buffer.add(NewEntry(r.Time, r.Level, b.String()))
+	return h.next.Handle(ctx, r)
+}
+
+func (h *bufferHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &bufferHandler{
+		next:  h.next.WithAttrs(attrs),
+		attrs: append(append([]slog.Attr{}, h.attrs...), attrs...),
+	}
+}
+
+func (h *bufferHandler) WithGroup(name string) slog.Handler {
+	return &bufferHandler{
+		next:  h.next.WithGroup(name),
+		attrs: h.attrs,
+	}
+}
@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 跟踪日志时，订阅前后并发写入的日志既不能遗漏也不能重复。
+// 订阅后写入的条数小于通道容量，不会因处理不过来而丢弃
+func TestFollowNoGap(t *testing.T) {
+	InitLogger(LevelInfo)
+
+	const total = 150
+	// 缓冲区是全局的，每次运行使用不同的关键字
+	keyword := fmt.Sprintf("follow-test-%d", time.Now().UnixNano())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	started := make(chan struct{})
+	go func() {
+		defer wg.Done()
+		for i := 0; i < total; i++ {
+			if i == total/2 {
+				close(started)
+			}
+			Infof("%s %d", keyword, i)
+		}
+	}()
+
+	<-started
+	entries, ch, cancel := Follow(LevelInfo, keyword, 0)
+	defer cancel()
+	wg.Wait()
+
+	seen := map[string]int{}
+	for _, entry := range entries {
+		seen[entry.Message]++
+	}
+	timeout := time.After(5 * time.Second)
+	for len(seen) < total {
+		select {
+		case entry := <-ch:
+			seen[entry.Message]++
+		case <-timeout:
+			t.Fatalf("只收到 %d 条日志，应为 %d 条", len(seen), total)
+		}
+	}
+	for i := 0; i < total; i++ {
+		message := fmt.Sprintf("%s %d", keyword, i)
+		if seen[message] != 1 {
+			t.Fatalf("%q 收到 %d 次", message, seen[message])
+		}
+	}
+}
@@ -1,164 +1,277 @@
 package logger
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"log/slog"
 	"mx-ui/config"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+)
 
-	"github.com/op/go-logging"
+// 日志级别
+const (
+	LevelDebug   = slog.LevelDebug
+	LevelInfo    = slog.LevelInfo
+	LevelNotice  = slog.Level(2)
+	LevelWarning = slog.LevelWarn
+	LevelError   = slog.LevelError
 )
 
-var logger *logging.Logger
-
-func InitLogger(level logging.Level) {
-	// 创建日志对象
-	logger = logging.MustGetLogger(config.GetName())
-
-	// 创建日志格式
-	consoleFormat := logging.MustStringFormatter(
-		`%{time:2006-01-02 15:04:05.000} %{level} %{message}`,
-	)
-	fileFormat := logging.MustStringFormatter(
-		`%{time:2006-01-02 15:04:05.000} %{level} %{message}`,
-	)
-
-	// 控制台输出
-	consoleBackend := logging.NewLogBackend(os.Stderr, "", 0)
-	consoleFormatter := logging.NewBackendFormatter(consoleBackend, consoleFormat)
-	consoleBackendLeveled := logging.AddModuleLevel(consoleFormatter)
-	consoleBackendLeveled.SetLevel(level, "")
-
-	// 文件输出
-	var backends []logging.Backend
-	backends = append(backends, consoleBackendLeveled)
-
-	// 创建日志文件
-	logFilePath := config.LogFilePath
-	if logFilePath != "" {
-		// 确保日志目录存在
-		logDir := config.DataDirPath
-		err := os.MkdirAll(logDir, 0755)
-		if err != nil {
-			logger.Error("创建日志目录失败:", err)
-			return
-		}
+var (
+	// logger 重新初始化时整体替换，记录日志时无需加锁
+	logger   atomic.Pointer[slog.Logger]
+	logLevel = new(slog.LevelVar)
+	logFile  *RotateWriter
+	initLock sync.Mutex
+)
 
-		// 打开或创建日志文件
-		logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+// ParseLevel 将配置中的级别名称转换为日志级别
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case config.Debug:
+		return LevelDebug, nil
+	case config.Info:
+		return LevelInfo, nil
+	case config.Notice:
+		return LevelNotice, nil
+	case config.Warn, "warning":
+		return LevelWarning, nil
+	case config.Error:
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("未知日志级别: %v", name)
+}
+
+// LevelName 获取日志级别的名称
+func LevelName(level slog.Level) string {
+	switch {
+	case level < LevelInfo:
+		return "DEBUG"
+	case level < LevelNotice:
+		return "INFO"
+	case level < LevelWarning:
+		return "NOTICE"
+	case level < LevelError:
+		return "WARNING"
+	default:
+		return "ERROR"
+	}
+}
+
+// InitLogger 初始化日志，输出到标准错误和按配置轮转的日志文件。
+// 可以重复调用，替换日志记录器后再关闭原来的日志文件
+func InitLogger(level slog.Level) {
+	initLock.Lock()
+	defer initLock.Unlock()
+
+	logLevel.Set(level)
+
+	var writers []io.Writer
+	writers = append(writers, os.Stderr)
+
+	oldFile := logFile
+	logFile = nil
+	if config.LogFilePath != "" {
+		file, err := NewRotateWriter(
+			config.LogFilePath,
+			int64(config.LogMaxSize)*1024*1024,
+			time.Duration(config.LogMaxAge)*24*time.Hour,
+			config.LogMaxBackups,
+		)
 		if err != nil {
-			logger.Error("打开日志文件失败:", err)
-			return
+			fmt.Fprintln(os.Stderr, "打开日志文件失败:", err)
+		} else {
+			logFile = file
+			writers = append(writers, file)
 		}
+	}
 
-		// 创建文件后端
-		fileBackend := logging.NewLogBackend(logFile, "", 0)
-		fileFormatter := logging.NewBackendFormatter(fileBackend, fileFormat)
-		fileBackendLeveled := logging.AddModuleLevel(fileFormatter)
-		fileBackendLeveled.SetLevel(level, "")
-		backends = append(backends, fileBackendLeveled)
+	logger.Store(slog.New(&bufferHandler{next: newHandler(io.MultiWriter(writers...), config.GetLogFormat())}))
+	if oldFile != nil {
+		oldFile.Close()
+	}
+}
+
+// SetLevel 运行时修改日志级别，立即对所有日志生效
+func SetLevel(level slog.Level) {
+	logLevel.Set(level)
+}
 
-		// 每日轮转日志文件
-		go rotateLogDaily(logFilePath)
+// GetLevel 获取当前日志级别
+func GetLevel() slog.Level {
+	return logLevel.Level()
+}
+
+// newHandler 按格式创建日志处理器
+func newHandler(w io.Writer, format string) slog.Handler {
+	options := &slog.HandlerOptions{
+		Level: logLevel,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.LevelKey {
+				a.Value = slog.StringValue(LevelName(a.Value.Any().(slog.Level)))
+			}
+			return a
+		},
+	}
+	if format == "json" {
+		return slog.NewJSONHandler(w, options)
 	}
+	return &textHandler{lock: &sync.Mutex{}, w: w, level: logLevel}
+}
 
-	// 设置后端
-	logging.SetBackend(backends...)
+// textHandler 文本格式：时间 级别 消息 key=value...
+type textHandler struct {
+	lock  *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+	attrs []slog.Attr
 }
 
-// 轮转日志文件
-func rotateLogDaily(logPath string) {
-	for {
-		// 计算下一次轮转时间（第二天的零点）
-		now := time.Now()
-		next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
-		duration := next.Sub(now)
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
 
-		// 等待到轮转时间
-		time.Sleep(duration)
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Time.Format("2006-01-02 15:04:05.000"))
+	b.WriteByte(' ')
+	b.WriteString(LevelName(r.Level))
+	b.WriteByte(' ')
+	b.WriteString(r.Message)
 
-		// 重命名当前日志文件
-		backupPath := logPath + "." + now.Format("2006-01-02")
-		err := os.Rename(logPath, backupPath)
-		if err != nil {
-			Error("轮转日志文件失败:", err)
-			continue
+	writeAttr := func(a slog.Attr) bool {
+		b.WriteByte(' ')
+		b.WriteString(a.Key)
+		b.WriteByte('=')
+		value := a.Value.Resolve().String()
+		if strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
 		}
+		b.WriteString(value)
+		return true
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	r.Attrs(writeAttr)
+	b.WriteByte('\n')
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &textHandler{
+		lock:  h.lock,
+		w:     h.w,
+		level: h.level,
+		attrs: append(append([]slog.Attr{}, h.attrs...), attrs...),
+	}
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	// 文本格式不区分分组
+	return h
+}
 
-		// 重新初始化日志器
-		level := logging.GetLevel("")
-		InitLogger(level)
+// log 记录一条日志，args按空格拼接为消息
+func log(level slog.Level, args ...interface{}) {
+	if l := logger.Load(); l != nil {
+		l.Log(context.Background(), level, strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+	}
+}
+
+// logf 记录一条格式化日志
+func logf(level slog.Level, format string, args ...interface{}) {
+	if l := logger.Load(); l != nil {
+		l.Log(context.Background(), level, fmt.Sprintf(format, args...))
+	}
+}
+
+// logw 记录一条带键值对的结构化日志
+func logw(level slog.Level, msg string, keysAndValues ...interface{}) {
+	if l := logger.Load(); l != nil {
+		l.Log(context.Background(), level, msg, keysAndValues...)
 	}
 }
 
 // Debug 记录调试级别日志
 func Debug(args ...interface{}) {
-	if logger != nil {
-		logger.Debug(args...)
-	}
+	log(LevelDebug, args...)
 }
 
 // Debugf 记录调试级别日志（格式化）
 func Debugf(format string, args ...interface{}) {
-	if logger != nil {
-		logger.Debugf(format, args...)
-	}
+	logf(LevelDebug, format, args...)
+}
+
+// Debugw 记录调试级别日志（键值对）
+func Debugw(msg string, keysAndValues ...interface{}) {
+	logw(LevelDebug, msg, keysAndValues...)
 }
 
 // Info 记录信息级别日志
 func Info(args ...interface{}) {
-	if logger != nil {
-		logger.Info(args...)
-	}
+	log(LevelInfo, args...)
 }
 
 // Infof 记录信息级别日志（格式化）
 func Infof(format string, args ...interface{}) {
-	if logger != nil {
-		logger.Infof(format, args...)
-	}
+	logf(LevelInfo, format, args...)
+}
+
+// Infow 记录信息级别日志（键值对）
+func Infow(msg string, keysAndValues ...interface{}) {
+	logw(LevelInfo, msg, keysAndValues...)
 }
 
 // Warning 记录警告级别日志
 func Warning(args ...interface{}) {
-	if logger != nil {
-		logger.Warning(args...)
-	}
+	log(LevelWarning, args...)
 }
 
 // Warningf 记录警告级别日志（格式化）
 func Warningf(format string, args ...interface{}) {
-	if logger != nil {
-		logger.Warningf(format, args...)
-	}
+	logf(LevelWarning, format, args...)
+}
+
+// Warningw 记录警告级别日志（键值对）
+func Warningw(msg string, keysAndValues ...interface{}) {
+	logw(LevelWarning, msg, keysAndValues...)
 }
 
 // Error 记录错误级别日志
 func Error(args ...interface{}) {
-	if logger != nil {
-		logger.Error(args...)
-	}
+	log(LevelError, args...)
 }
 
 // Errorf 记录错误级别日志（格式化）
 func Errorf(format string, args ...interface{}) {
-	if logger != nil {
-		logger.Errorf(format, args...)
-	}
+	logf(LevelError, format, args...)
+}
+
+// Errorw 记录错误级别日志（键值对）
+func Errorw(msg string, keysAndValues ...interface{}) {
+	logw(LevelError, msg, keysAndValues...)
 }
 
-// Fatal 记录致命级别日志
+// Fatal 记录致命级别日志并退出
 func Fatal(args ...interface{}) {
-	if logger != nil {
-		logger.Fatal(args...)
-	}
+	log(LevelError, args...)
+	os.Exit(1)
 }
 
-// Fatalf 记录致命级别日志（格式化）
+// Fatalf 记录致命级别日志（格式化）并退出
 func Fatalf(format string, args ...interface{}) {
-	if logger != nil {
-		logger.Fatalf(format, args...)
-	}
+	logf(LevelError, format, args...)
+	os.Exit(1)
 }
 
 // GetWriter 获取日志写入器（用于其他需要io.Writer的场合）
@@ -170,6 +283,6 @@ func GetWriter() io.Writer {
 type logWriter struct{}
 
 func (w *logWriter) Write(p []byte) (n int, err error) {
-	Info(string(p))
+	Info(strings.TrimRight(string(p), "\n"))
 	return len(p), nil
-} 
\ No newline at end of file
+}
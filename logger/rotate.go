@@ -0,0 +1,257 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotateWriter 按大小和日期轮转的日志文件，可被多个goroutine并发写入
+type RotateWriter struct {
+	lock       sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	file    *os.File
+	size    int64
+	openDay string
+
+	// 压缩和清理在后台串行执行
+	cleanLock sync.Mutex
+}
+
+// NewRotateWriter 创建轮转日志文件，maxSize为0时不按大小轮转，maxAge和maxBackups为0时不限制
+func NewRotateWriter(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotateWriter, error) {
+	w := &RotateWriter{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = w.open()
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open 打开或创建日志文件
+func (w *RotateWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	w.openDay = info.ModTime().Format("2006-01-02")
+	if w.size == 0 {
+		w.openDay = time.Now().Format("2006-01-02")
+	}
+	return nil
+}
+
+// Write 写入日志，写入前按需轮转
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.shouldRotate(len(p)) {
+		err := w.rotate()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "轮转日志文件失败:", err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate 立即轮转日志文件
+func (w *RotateWriter) Rotate() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	return w.rotate()
+}
+
+// Close 关闭日志文件
+func (w *RotateWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *RotateWriter) shouldRotate(n int) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.maxSize > 0 && w.size+int64(n) > w.maxSize {
+		return true
+	}
+	return time.Now().Format("2006-01-02") != w.openDay
+}
+
+// rotate 关闭当前文件并重命名为备份，再打开新文件，调用方需持有锁
+func (w *RotateWriter) rotate() error {
+	err := w.file.Close()
+	if err != nil {
+		return err
+	}
+
+	backup := w.backupName()
+	renameErr := os.Rename(w.path, backup)
+
+	// 无论重命名是否成功都要重新打开文件，保证后续日志可写
+	err = w.open()
+	if err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	go w.compressAndClean(backup)
+	return nil
+}
+
+// backupName 生成不重复的备份文件名
+func (w *RotateWriter) backupName() string {
+	name := w.path + "." + time.Now().Format("20060102-150405")
+	backup := name
+	for i := 1; ; i++ {
+		_, err1 := os.Stat(backup)
+		_, err2 := os.Stat(backup + ".gz")
+		if os.IsNotExist(err1) && os.IsNotExist(err2) {
+			return backup
+		}
+		backup = fmt.Sprintf("%s.%d", name, i)
+	}
+}
+
+// compressAndClean 压缩备份文件并按保留策略删除旧备份
+func (w *RotateWriter) compressAndClean(backup string) {
+	w.cleanLock.Lock()
+	defer w.cleanLock.Unlock()
+
+	err := compressFile(backup)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "压缩日志文件失败:", err)
+	}
+	err = w.clean()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "清理旧日志文件失败:", err)
+	}
+}
+
+// clean 删除超过保留时间或超出保留数量的备份
+func (w *RotateWriter) clean() error {
+	if w.maxAge <= 0 && w.maxBackups <= 0 {
+		return nil
+	}
+
+	dir := filepath.Dir(w.path)
+	prefix := filepath.Base(w.path) + "."
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	type backupFile struct {
+		path    string
+		modTime time.Time
+	}
+	var backups []backupFile
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{
+			path:    filepath.Join(dir, entry.Name()),
+			modTime: info.ModTime(),
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].modTime.After(backups[j].modTime)
+	})
+
+	cutoff := time.Now().Add(-w.maxAge)
+	for i, backup := range backups {
+		expired := w.maxAge > 0 && backup.modTime.Before(cutoff)
+		excess := w.maxBackups > 0 && i >= w.maxBackups
+		if expired || excess {
+			err = os.Remove(backup.path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compressFile 将文件压缩为.gz并删除原文件
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	// 保留原修改时间，清理时按时间排序
+	_ = os.Chtimes(path+".gz", info.ModTime(), info.ModTime())
+	in.Close()
+	return os.Remove(path)
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"mx-ui/config"
 	"mx-ui/database"
@@ -15,97 +20,179 @@ import (
 	"mx-ui/web"
 	"mx-ui/web/global"
 	"mx-ui/web/service"
-
-	"github.com/op/go-logging"
 )
 
 func runWebServer() {
 	log.Printf("启动 %v %v", config.GetName(), config.GetVersion())
 
-	switch config.GetLogLevel() {
-	case config.Debug:
-		logger.InitLogger(logging.DEBUG)
-	case config.Info:
-		logger.InitLogger(logging.INFO)
-	case config.Notice:
-		logger.InitLogger(logging.NOTICE)
-	case config.Warn:
-		logger.InitLogger(logging.WARNING)
-	case config.Error:
-		logger.InitLogger(logging.ERROR)
-	default:
-		log.Fatalf("未知日志级别: %v", config.GetLogLevel())
+	level, err := logger.ParseLevel(config.GetLogLevel())
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
+	logger.InitLogger(level)
 
-	err := database.InitDB(config.GetDBPath())
+	err = database.InitDB(config.GetDBPath())
 	if err != nil {
 		log.Fatalf("数据库初始化错误: %v", err)
 	}
-
-	var server *web.Server
-	server = web.NewServer()
-	global.SetWebServer(server)
-	err = server.Start()
+	// 上次运行时上传的备份未来得及恢复时，在启动服务器前恢复
+	applyPendingRestore()
+	// 记录启动时的配置，作为之后修改的回滚基准
+	revisionService := service.ConfigRevisionService{}
+	_, err = revisionService.Record("system", "启动时的配置")
 	if err != nil {
-		log.Fatalf("Web服务器启动错误: %v", err)
-		return
+		logger.Warning("记录配置历史版本失败:", err)
 	}
 
-	var subServer *sub.Server
-	subServer = sub.NewServer()
-	global.SetSubServer(subServer)
-	err = subServer.Start()
+	// 后台任务监听shutdown的上下文，退出时按注册顺序执行关闭步骤
+	shutdown := service.NewShutdown(config.GetShutdownTimeout())
+	server, subServer, err := startServers(shutdown.Context())
 	if err != nil {
-		log.Fatalf("订阅服务器启动错误: %v", err)
-		return
+		log.Fatalf("%v", err)
 	}
+	err = writePIDFile()
+	if err != nil {
+		logger.Warning("写入PID文件失败:", err)
+	}
+	shutdown.Register("停止Web和订阅服务器", 0, func(ctx context.Context) error {
+		return stopServers(server, subServer)
+	})
+	// 在停止Xray前保存最后一次流量增量，Xray退出后计数器就丢失了
+	shutdown.Register("保存流量统计", 5*time.Second, func(ctx context.Context) error {
+		xrayService := service.XrayService{}
+		return xrayService.FlushTraffic()
+	})
+	shutdown.Register("停止Xray", 10*time.Second, func(ctx context.Context) error {
+		xrayService := service.XrayService{}
+		if !xrayService.IsXrayRunning() {
+			return nil
+		}
+		return xrayService.StopXray()
+	})
+	shutdown.Register("关闭数据库", 10*time.Second, func(ctx context.Context) error {
+		return database.CloseDB()
+	})
+	shutdown.Register("删除PID文件", 0, func(ctx context.Context) error {
+		return removePIDFile()
+	})
 
 	sigCh := make(chan os.Signal, 1)
 	// 捕获关闭信号
-	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGTERM)
+	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	restarts := 0
 	for {
-		sig := <-sigCh
-
-		switch sig {
-		case syscall.SIGHUP:
-			logger.Info("收到SIGHUP信号，重启服务器...")
-
-			err := server.Stop()
-			if err != nil {
-				logger.Debug("停止Web服务器时出错:", err)
-			}
-			err = subServer.Stop()
-			if err != nil {
-				logger.Debug("停止订阅服务器时出错:", err)
+		var serveErr error
+		select {
+		case sig := <-sigCh:
+			if sig != syscall.SIGHUP {
+				log.Println("服务器关闭中")
+				// 关闭过程中再次收到信号时立即退出
+				go func() {
+					<-sigCh
+					log.Println("再次收到退出信号，立即退出")
+					os.Exit(1)
+				}()
+				shutdown.Run()
+				return
 			}
+			logger.Info("收到SIGHUP信号，重启服务器并重新加载Xray配置...")
+			restarts = 0
+		case <-service.RestoreRequests():
+			logger.Info("停止服务器并恢复数据库...")
+		case serveErr = <-server.Err():
+		case serveErr = <-subServer.Err():
+		}
 
-			server = web.NewServer()
-			global.SetWebServer(server)
-			err = server.Start()
-			if err != nil {
-				log.Fatalf("重启Web服务器出错: %v", err)
-				return
+		if serveErr != nil {
+			// 运行中出错时按重启策略退出或有限次数地重启
+			if config.RestartPolicy != config.RestartPolicyRestart || restarts >= config.RestartMax {
+				logger.Error("服务器运行出错，退出:", serveErr)
+				shutdown.Run()
+				os.Exit(1)
 			}
-			log.Println("Web服务器重启成功")
+			restarts++
+			logger.Warningf("服务器运行出错，%d 秒后第 %d 次重启: %v", restarts, restarts, serveErr)
+		}
 
-			subServer = sub.NewServer()
-			global.SetSubServer(subServer)
-			err = subServer.Start()
+		err = stopServers(server, subServer)
+		if err != nil {
+			logger.Debug("停止服务器时出错:", err)
+		}
+		// 服务器和后台任务都已停止，此时可以安全地替换数据库
+		applyPendingRestore()
+		if serveErr != nil {
+			time.Sleep(time.Duration(restarts) * time.Second)
+		}
+		server, subServer, err = startServers(shutdown.Context())
+		if err != nil {
+			logger.Error("重启服务器出错:", err)
+			shutdown.Run()
+			os.Exit(1)
+		}
+		log.Println("服务器重启成功")
+		if serveErr == nil {
+			// 命令行修改入站或客户端后通过SIGHUP通知，Xray运行时重新应用配置
+			xrayService := service.XrayService{}
+			err = xrayService.ApplyConfig()
 			if err != nil {
-				log.Fatalf("重启订阅服务器出错: %v", err)
-				return
+				logger.Warning("重新应用Xray配置失败:", err)
 			}
-			log.Println("订阅服务器重启成功")
-
-		default:
-			server.Stop()
-			subServer.Stop()
-			log.Println("服务器关闭中")
-			return
 		}
 	}
 }
 
+// startServers 启动Web服务器和订阅服务器，任一启动失败时停止已启动的服务器并返回错误。
+// Web服务器的后台任务在ctx取消时退出
+func startServers(ctx context.Context) (*web.Server, *sub.Server, error) {
+	server := web.NewServer()
+	global.SetWebServer(server)
+	err := server.Start(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Web服务器启动错误: %v", err)
+	}
+
+	subServer := sub.NewServer()
+	global.SetSubServer(subServer)
+	err = subServer.Start(server.Addr(), server.UsesSocket())
+	if err != nil {
+		server.Stop(context.Background())
+		return nil, nil, fmt.Errorf("订阅服务器启动错误: %v", err)
+	}
+	return server, subServer, nil
+}
+
+// applyPendingRestore 恢复面板中上传的备份，调用前必须停止服务器和后台任务
+func applyPendingRestore() {
+	restored, err := database.ApplyPendingRestore()
+	if err != nil {
+		logger.Error("恢复数据库失败:", err)
+		return
+	}
+	if restored {
+		logger.Info("数据库已从备份恢复")
+	}
+}
+
+// stopServers 同时停止Web服务器和订阅服务器，最多等待配置的时间让进行中的请求完成
+func stopServers(server *web.Server, subServer *sub.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.GetDrainTimeout())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var webErr, subErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		webErr = server.Stop(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		subErr = subServer.Stop(ctx)
+	}()
+	wg.Wait()
+	return errors.Join(webErr, subErr)
+}
+
 func resetSetting() {
 	err := database.InitDB(config.GetDBPath())
 	if err != nil {
@@ -124,6 +211,12 @@ func resetSetting() {
 
 func showSetting(show bool) {
 	if show {
+		err := database.InitDB(config.GetDBPath())
+		if err != nil {
+			fmt.Println("数据库初始化失败:", err)
+			return
+		}
+
 		settingService := service.SettingService{}
 		port, err := settingService.GetPort()
 		if err != nil {
@@ -151,8 +244,7 @@ func showSetting(show bool) {
 		}
 
 		username := userModel.Username
-		userpasswd := userModel.Password
-		if username == "" || userpasswd == "" {
+		if username == "" || userModel.Password == "" {
 			fmt.Println("当前用户名或密码为空")
 		}
 
@@ -163,13 +255,30 @@ func showSetting(show bool) {
 			fmt.Println("面板已使用SSL进行安全保护")
 		}
 		fmt.Println("用户名:", username)
-		fmt.Println("密码:", userpasswd)
-		fmt.Println("端口:", port)
+		fmt.Println("密码: 已加密存储，如忘记请使用 setting -username -password 重新设置")
+		if config.GetWebPort() > 0 {
+			fmt.Printf("端口: %v（已被配置文件、环境变量或命令行参数覆盖，数据库中为 %v）\n", config.GetWebPort(), port)
+		} else {
+			fmt.Println("端口:", port)
+		}
 		fmt.Println("网页基础路径:", webBasePath)
+		listen := config.GetListenAddr()
+		if listen == "" {
+			listen, _ = settingService.GetListen()
+		}
+		if listen == "" {
+			listen = "所有地址"
+		}
+		fmt.Println("监听地址:", listen)
+		fmt.Println("数据目录:", config.DataDirPath)
+		fmt.Println("数据库:", config.GetDBPath())
+		if config.LoadedConfigFile != "" {
+			fmt.Println("配置文件:", config.LoadedConfigFile)
+		}
 	}
 }
 
-func updateSetting(port int, username string, password string, webBasePath string) {
+func updateSetting(port int, username string, password string, webBasePath string, listen string) {
 	err := database.InitDB(config.GetDBPath())
 	if err != nil {
 		fmt.Println("初始化数据库错误:", err)
@@ -205,68 +314,252 @@ func updateSetting(port int, username string, password string, webBasePath strin
 			fmt.Printf("网页基础路径设置为: %v\n", webBasePath)
 		}
 	}
+
+	if listen != "" {
+		err := settingService.SetListen(listen)
+		if err != nil {
+			fmt.Printf("设置监听地址错误: %v\n", err)
+		} else {
+			fmt.Printf("监听地址设置为: %v\n", listen)
+		}
+	}
+}
+
+func migrateDb(action string, to int) {
+	err := database.OpenDB(config.GetDBPath())
+	if err != nil {
+		exitErr("打开数据库错误:", err)
+	}
+
+	switch action {
+	case "status":
+		statuses, err := database.GetMigrationStatus()
+		if err != nil {
+			exitErr("获取迁移状态失败:", err)
+		}
+		current, _ := database.CurrentVersion()
+		fmt.Printf("当前版本: %d，程序支持的最新版本: %d\n", current, database.LatestVersion())
+		for _, status := range statuses {
+			if status.Applied {
+				fmt.Printf("  [已应用] %3d %-24s %s\n", status.Version, status.Name, status.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("  [未应用] %3d %s\n", status.Version, status.Name)
+			}
+		}
+	case "", "up":
+		err = database.MigrateUp()
+		if err != nil {
+			exitErr("数据库迁移失败:", err)
+		}
+		fmt.Println("数据库迁移完成")
+	case "down":
+		if to < 0 {
+			exitErr("请使用 --to 指定回滚到的版本")
+		}
+		err = database.MigrateTo(to)
+		if err != nil {
+			exitErr("数据库回滚失败:", err)
+		}
+		fmt.Println("数据库已回滚到版本", to)
+	default:
+		exitErr("未知的迁移操作:", action, "（可用: status, up, down --to N）")
+	}
 }
 
-func migrateDb() {
+func backupDb(output string) {
 	err := database.InitDB(config.GetDBPath())
 	if err != nil {
-		fmt.Println("初始化数据库错误:", err)
-		return
+		exitErr("初始化数据库错误:", err)
 	}
-	fmt.Println("数据库迁移完成")
+
+	backupService := service.BackupService{}
+	if output == "" {
+		settingService := service.SettingService{}
+		backupDir, err := settingService.GetBackupDir()
+		if err != nil {
+			exitErr("获取备份目录失败:", err)
+		}
+		output, err = backupService.CreateBackup(backupDir)
+	} else {
+		err = database.Backup(output)
+	}
+	if err != nil {
+		exitErr("备份数据库失败:", err)
+	}
+	fmt.Println("数据库已备份到:", output)
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		runWebServer()
-		return
+func restoreDb(input string) {
+	if input == "" {
+		exitErr("请使用 -file 指定备份文件")
+	}
+	// 替换数据库文件时面板不能在使用它，运行中的面板可以在网页中上传备份恢复
+	if _, err := findRunningPanel(); err == nil {
+		exitErr("面板正在运行，请先停止面板，或在面板中上传备份恢复")
+	}
+
+	err := database.InitDB(config.GetDBPath())
+	if err != nil {
+		exitErr("初始化数据库错误:", err)
+	}
+
+	backupService := service.BackupService{}
+	err = backupService.RestoreFile(input)
+	if err != nil {
+		exitErr("恢复数据库失败:", err)
 	}
+	fmt.Println("数据库恢复完成")
+}
 
+func importXUI(dbPath string, options *service.ImportOptions) {
+	if dbPath == "" {
+		exitUsage("用法: import-xui <x-ui.db路径> [-dryRun] [-portStrategy skip|overwrite|renumber] ...")
+	}
+
+	err := database.InitDB(config.GetDBPath())
+	if err != nil {
+		exitErr("初始化数据库错误:", err)
+	}
+
+	inboundService := service.InboundService{}
+	report, err := inboundService.ImportFromXUI(dbPath, options)
+	if err != nil {
+		exitErr("导入x-ui数据库失败:", err)
+	}
+
+	if report.Import.DryRun {
+		fmt.Println("试运行，以下变更不会写入数据库:")
+	}
+	fmt.Println("入站:")
+	for _, item := range report.Import.Inbounds {
+		fmt.Printf("  [%s] %s %s\n", item.Action, item.Name, item.Message)
+	}
+	fmt.Println("客户端:")
+	for _, item := range report.Import.Clients {
+		fmt.Printf("  [%s] %s %s\n", item.Action, item.Name, item.Message)
+	}
+	if len(report.Unmapped) > 0 {
+		fmt.Println("未能映射的内容:")
+		for _, note := range report.Unmapped {
+			fmt.Println("  " + note)
+		}
+	}
+}
+
+func main() {
 	var showVersion bool
 	flag.BoolVar(&showVersion, "v", false, "显示版本")
 	flag.BoolVar(&showVersion, "version", false, "显示版本")
-	
+
+	options := &config.Options{}
+	flag.StringVar(&options.ConfigFile, "config", "", "配置文件路径（.yaml 或 .toml）")
+	flag.StringVar(&options.DataDir, "data-dir", "", "数据目录")
+	flag.StringVar(&options.DBPath, "db", "", "数据库文件路径")
+	flag.StringVar(&options.LogLevel, "log-level", "", "日志级别: debug, info, notice, warn, error")
+	flag.StringVar(&options.LogFile, "log-file", "", "日志文件路径")
+	flag.StringVar(&options.Listen, "listen", "", "Web监听地址")
+	flag.IntVar(&options.WebPort, "port", 0, "Web端口，优先于数据库中的设置")
+	flag.StringVar(&options.RestartPolicy, "restart-policy", "", "服务器运行出错时的处理方式: exit, restart")
+
 	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
-	
+
 	settingCmd := flag.NewFlagSet("setting", flag.ExitOnError)
 	var port int
-	var username, password, webBasePath string
+	var username, password, webBasePath, listen string
 	var showSettingInfo bool
 	settingCmd.IntVar(&port, "port", 0, "面板端口")
 	settingCmd.StringVar(&username, "username", "", "用户名")
 	settingCmd.StringVar(&password, "password", "", "密码")
 	settingCmd.StringVar(&webBasePath, "webBasePath", "", "网页基础路径")
+	settingCmd.StringVar(&listen, "listen", "", "监听地址，可以是IP、主机名或 unix:/path/to/socket")
 	settingCmd.BoolVar(&showSettingInfo, "show", false, "显示设置信息")
-	
+
 	resetCmd := flag.NewFlagSet("reset", flag.ExitOnError)
-	
+
 	migrateCmd := flag.NewFlagSet("migrate", flag.ExitOnError)
+	var migrateTo int
+	migrateCmd.IntVar(&migrateTo, "to", -1, "回滚到的目标版本")
+
+	backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
+	var backupOutput string
+	backupCmd.StringVar(&backupOutput, "output", "", "备份文件路径，默认保存到备份目录")
+
+	restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+	var restoreInput string
+	restoreCmd.StringVar(&restoreInput, "file", "", "要恢复的备份文件")
+
+	importXUICmd := flag.NewFlagSet("import-xui", flag.ExitOnError)
+	importOptions := &service.ImportOptions{}
+	importXUICmd.StringVar(&importOptions.PortStrategy, "portStrategy", "skip", "端口冲突处理: skip, overwrite, renumber")
+	importXUICmd.StringVar(&importOptions.TagStrategy, "tagStrategy", "skip", "标签冲突处理: skip, overwrite, renumber")
+	importXUICmd.StringVar(&importOptions.EmailStrategy, "emailStrategy", "skip", "邮箱冲突处理: skip, overwrite, renumber")
+	importXUICmd.BoolVar(&importOptions.DryRun, "dryRun", false, "只显示将会发生的变化")
 
 	flag.Parse()
-	
+
 	if showVersion {
 		fmt.Printf("%v %v\n", config.GetName(), config.GetVersion())
 		return
 	}
 
-	switch os.Args[1] {
+	// 全局参数位于子命令之前，例如 mx-ui --data-dir /data run
+	err := config.Load(options)
+	if err != nil {
+		log.Fatalf("加载配置失败: %v", err)
+	}
+
+	if flag.NArg() == 0 {
+		runWebServer()
+		return
+	}
+	args := flag.Args()[1:]
+
+	switch flag.Arg(0) {
 	case "run":
-		_ = runCmd.Parse(os.Args[2:])
+		_ = runCmd.Parse(args)
 		runWebServer()
 	case "setting":
-		_ = settingCmd.Parse(os.Args[2:])
+		_ = settingCmd.Parse(args)
 		if showSettingInfo {
 			showSetting(showSettingInfo)
 		} else {
-			updateSetting(port, username, password, webBasePath)
+			updateSetting(port, username, password, webBasePath, listen)
 		}
 	case "reset":
-		_ = resetCmd.Parse(os.Args[2:])
+		_ = resetCmd.Parse(args)
 		resetSetting()
 	case "migrate":
-		_ = migrateCmd.Parse(os.Args[2:])
-		migrateDb()
+		action := ""
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			action = args[0]
+			args = args[1:]
+		}
+		_ = migrateCmd.Parse(args)
+		migrateDb(action, migrateTo)
+	case "import-xui":
+		xuiPath := ""
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			xuiPath = args[0]
+			args = args[1:]
+		}
+		_ = importXUICmd.Parse(args)
+		if xuiPath == "" && importXUICmd.NArg() > 0 {
+			xuiPath = importXUICmd.Arg(0)
+		}
+		importXUI(xuiPath, importOptions)
+	case "inbound":
+		runInboundCmd(args)
+	case "client":
+		runClientCmd(args)
+	case "sub":
+		runSubCmd(args)
+	case "backup":
+		_ = backupCmd.Parse(args)
+		backupDb(backupOutput)
+	case "restore":
+		_ = restoreCmd.Parse(args)
+		restoreDb(restoreInput)
 	default:
-		fmt.Println("未知命令:", os.Args[1])
+		fmt.Println("未知命令:", flag.Arg(0))
 	}
-} 
\ No newline at end of file
+}
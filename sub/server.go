@@ -1,17 +1,23 @@
 package sub
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"mx-ui/config"
 	"mx-ui/logger"
+	"mx-ui/web"
+	"mx-ui/web/service"
+	"net"
 	"net/http"
-	"time"
+	"strings"
 )
 
 // Server 订阅服务器
 type Server struct {
 	httpServer *http.Server
 	router     *http.ServeMux
+	errCh      chan error
 }
 
 // NewServer 创建一个新的订阅服务器
@@ -23,54 +29,122 @@ func NewServer() *Server {
 
 	return &Server{
 		router: mux,
+		errCh:  make(chan error, 1),
 	}
 }
 
-// Start 启动订阅服务器
-func (s *Server) Start() error {
-	// 使用与Web服务器相同的端口
-	port := config.GetDefaultWebPort()
+// Start 启动订阅服务器，监听失败时直接返回错误。
+// panelAddr 和 panelSocket 是Web服务器的监听地址和是否监听套接字，
+// 面板只监听套接字且未设置订阅监听地址时不启动订阅服务器，避免开放公网TCP端口
+func (s *Server) Start(panelAddr string, panelSocket bool) error {
+	settingService := service.SettingService{}
+	port, err := settingService.GetSubPort()
+	if err != nil {
+		return err
+	}
+	listen, err := settingService.GetSubListen()
+	if err != nil {
+		return err
+	}
+	if listen == "" && panelSocket {
+		service.ClearListenerState("sub")
+		logger.Info("面板监听套接字且未设置订阅监听地址，不启动订阅服务器")
+		return nil
+	}
+	if strings.HasPrefix(listen, "unix:") && listen == panelAddr {
+		return errors.New("订阅服务器不能与面板使用同一个Unix套接字")
+	}
+	socketMode, err := web.SocketMode()
+	if err != nil {
+		return err
+	}
 
+	listener, err := web.Listen(listen, port, socketMode)
+	if err != nil {
+		service.SetListenerState("sub", "", false, err)
+		return fmt.Errorf("监听失败: %v", err)
+	}
+	addr := web.ListenerAddr(listener)
+	service.SetListenerState("sub", addr, true, nil)
 	s.httpServer = &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
 		Handler: s.router,
 	}
 
 	// 启动HTTP服务器
+	logger.Info("订阅服务器已启动，监听:", addr)
 	go func() {
-		logger.Info("订阅服务器已启动")
-		err := s.httpServer.ListenAndServe()
+		err := s.httpServer.Serve(listener)
 		if err != nil && err != http.ErrServerClosed {
 			logger.Error("订阅服务器错误:", err)
+			service.SetListenerState("sub", "", false, err)
+			select {
+			case s.errCh <- err:
+			default:
+			}
 		}
 	}()
 
 	return nil
 }
 
-// Stop 停止订阅服务器
-func (s *Server) Stop() error {
-	if s.httpServer != nil {
-		logger.Info("停止订阅服务器")
-		return s.httpServer.Close()
+// Err 返回服务运行中出错的通知通道
+func (s *Server) Err() <-chan error {
+	return s.errCh
+}
+
+// Stop 停止接受新连接并等待进行中的订阅请求完成，ctx到期后强制关闭
+func (s *Server) Stop(ctx context.Context) error {
+	if s.httpServer == nil {
+		return nil
 	}
-	return nil
+	logger.Info("停止订阅服务器")
+	service.SetListenerState("sub", "", false, nil)
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		logger.Warning("等待订阅请求完成超时，强制关闭:", err)
+		s.httpServer.Close()
+	}
+	return err
 }
 
-// handleSub 处理订阅请求
+// handleSub 处理订阅请求，返回客户端分享链接的base64编码，客户端或其入站不可用时内容为空
 func handleSub(w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Path[5:] // 去除 "/sub/" 前缀
-	if token == "" {
+	subID := strings.TrimPrefix(r.URL.Path, "/sub/")
+	if subID == "" {
 		http.Error(w, "订阅令牌无效", http.StatusBadRequest)
 		return
 	}
 
-	// 在实际项目中，这里应该根据token查询数据库获取相应的节点信息
-	// 并生成对应的配置文件
-	
-	// 示例内容
-	resp := fmt.Sprintf("订阅内容示例 - 生成时间: %s", time.Now().Format("2006-01-02 15:04:05"))
-	
+	clientService := service.ClientService{}
+	client, err := clientService.GetClientBySubID(subID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var links []string
+	inboundService := service.InboundService{}
+	inbound, err := inboundService.GetInbound(client.InboundID)
+	if err == nil && inbound.Enable && clientService.IsClientValid(client) {
+		link, err := clientService.GetShareLink(client, requestHost(r))
+		if err != nil {
+			logger.Warning("生成分享链接失败:", err)
+		} else {
+			links = append(links, link)
+		}
+	}
+
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.Write([]byte(resp))
-} 
\ No newline at end of file
+	w.Header().Set("Subscription-Userinfo", fmt.Sprintf("upload=0; download=%d; total=%d; expire=%d",
+		client.Used, client.Limit, client.ExpiryTime/1000))
+	w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n")))))
+}
+
+// requestHost 获取请求中不带端口的主机名，作为分享链接中的服务器地址
+func requestHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		return strings.Trim(r.Host, "[]")
+	}
+	return host
+}
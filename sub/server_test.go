@@ -0,0 +1,128 @@
+package sub
+
+import (
+	"encoding/base64"
+	"mx-ui/config"
+	"mx-ui/database"
+	"mx-ui/web/service"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupTestDB 在临时目录中初始化数据库
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	dataDir := config.DataDirPath
+	config.DataDirPath = dir
+	err := os.MkdirAll(config.GetTempPath(), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.InitDB(filepath.Join(dir, config.DBName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		database.CloseDB()
+		config.DataDirPath = dataDir
+	})
+}
+
+// requestSub 请求订阅，返回响应和解码后的内容
+func requestSub(t *testing.T, host string, path string) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	request.Host = host
+	recorder := httptest.NewRecorder()
+	handleSub(recorder, request)
+	if recorder.Code != http.StatusOK {
+		return recorder, ""
+	}
+	content, err := base64.StdEncoding.DecodeString(recorder.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recorder, string(content)
+}
+
+func TestHandleSub(t *testing.T) {
+	setupTestDB(t)
+	inbound := &database.InboundConfig{
+		Protocol: "vless",
+		Port:     443,
+		Enable:   true,
+		Settings: `{"decryption":"none"}`,
+		StreamSettings: `{"network":"tcp","security":"reality","realitySettings":{"serverNames":["www.example.com"],` +
+			`"privateKey":"kEw1mb28BeXYbo_gC3euhi8cFZoIS_PMTC_tX8XuRWQ","publicKey":"mkl7bGbRmD30UYU1-2LWDrgB-lGJ01nWSINW6pStlFU","shortIds":["6ba85179e30d4fc2"]}}`,
+		Remark: "hk",
+	}
+	err := database.GetDB().Create(inbound).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	client := &database.ClientConfig{
+		InboundID:  inbound.ID,
+		Email:      "alice",
+		UUID:       "0b4c7a1e-8f5d-4d2a-9c3e-1f2a3b4c5d6e",
+		Enable:     true,
+		SubID:      "alicesub",
+		Used:       1024,
+		Limit:      4096,
+		ExpiryTime: expiry,
+	}
+	err = database.GetDB().Create(client).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder, content := requestSub(t, "sub.example.com:2096", "/sub/alicesub")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("状态码为 %d", recorder.Code)
+	}
+	want := "vless://0b4c7a1e-8f5d-4d2a-9c3e-1f2a3b4c5d6e@sub.example.com:443?encryption=none&fp=chrome" +
+		"&pbk=mkl7bGbRmD30UYU1-2LWDrgB-lGJ01nWSINW6pStlFU&security=reality&sid=6ba85179e30d4fc2&sni=www.example.com&type=tcp#hk-alice"
+	if content != want {
+		t.Errorf("订阅内容为 %s，期望 %s", content, want)
+	}
+	userinfo := recorder.Header().Get("Subscription-Userinfo")
+	wantUserinfo := "upload=0; download=1024; total=4096; expire=1893456000"
+	if userinfo != wantUserinfo {
+		t.Errorf("Subscription-Userinfo为 %q，期望 %q", userinfo, wantUserinfo)
+	}
+
+	// IPv6地址的Host去掉方括号后作为服务器地址
+	_, content = requestSub(t, "[2001:db8::1]:2096", "/sub/alicesub")
+	if want := strings.Replace(want, "@sub.example.com:", "@[2001:db8::1]:", 1); content != want {
+		t.Errorf("IPv6地址的订阅内容为 %s，期望 %s", content, want)
+	}
+
+	// 客户端不可用时内容为空，流量信息照常返回
+	clientService := service.ClientService{}
+	err = clientService.SetClientEnable(client.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder, content = requestSub(t, "sub.example.com", "/sub/alicesub")
+	if recorder.Code != http.StatusOK || content != "" {
+		t.Errorf("禁用的客户端返回 %d %q，期望空内容", recorder.Code, content)
+	}
+	if recorder.Header().Get("Subscription-Userinfo") != wantUserinfo {
+		t.Errorf("禁用的客户端Subscription-Userinfo为 %q", recorder.Header().Get("Subscription-Userinfo"))
+	}
+
+	if recorder, _ = requestSub(t, "sub.example.com", "/sub/unknown"); recorder.Code != http.StatusNotFound {
+		t.Errorf("不存在的订阅返回 %d，期望404", recorder.Code)
+	}
+	if recorder, _ = requestSub(t, "sub.example.com", "/sub/"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("空订阅令牌返回 %d，期望400", recorder.Code)
+	}
+}
@@ -1,9 +1,19 @@
 package controller
 
 import (
+	"fmt"
+	"io"
+	"mx-ui/config"
+	"mx-ui/database"
 	"mx-ui/logger"
 	"mx-ui/web/service"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -174,16 +184,64 @@ func (a *SettingController) GetSettings(c *gin.Context) {
 
 	port, _ := settingService.GetPort()
 	webBasePath, _ := settingService.GetBasePath()
+	webListen, _ := settingService.GetListen()
+	webSocketMode, _ := settingService.GetSocketMode()
 	certFile, _ := settingService.GetCertFile()
 	keyFile, _ := settingService.GetKeyFile()
+	subURI, _ := settingService.GetSubURI()
+	subPort, _ := settingService.GetSubPort()
+	subListen, _ := settingService.GetSubListen()
+	healthEnable, _ := settingService.GetHealthEnable()
+	xrayAPIPort, _ := settingService.GetXrayAPIPort()
+	xrayMirrorURL, _ := settingService.GetXrayMirrorURL()
+	geoMirrorURL, _ := settingService.GetGeoMirrorURL()
+	geoUpdateInterval, _ := settingService.GetGeoUpdateInterval()
+	tgBotEnable, _ := settingService.GetTgBotEnable()
+	tgBotToken, _ := settingService.GetTgBotToken()
+	tgBotAPIServer, _ := settingService.GetTgBotAPIServer()
+	tgBotChatIDs, _ := settingService.GetTgBotChatIDs()
+	backupInterval, _ := settingService.GetBackupInterval()
+	backupKeep, _ := settingService.GetBackupKeep()
+	backupDir, _ := settingService.GetBackupDir()
+	backupWebhook, _ := settingService.GetBackupWebhook()
+	backupTgEnable, _ := settingService.GetBackupTgEnable()
+	accessLogRetention, _ := settingService.GetAccessLogRetention()
+	ipLimitWindow, _ := settingService.GetIPLimitWindow()
+	ipLimitAction, _ := settingService.GetIPLimitAction()
+	ipLimitDisableMinutes, _ := settingService.GetIPLimitDisableMinutes()
+	ipLimitBanFile, _ := settingService.GetIPLimitBanFile()
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
-			"port":        port,
-			"webBasePath": webBasePath,
-			"certFile":    certFile,
-			"keyFile":     keyFile,
+			"port":                  port,
+			"webBasePath":           webBasePath,
+			"webListen":             webListen,
+			"webSocketMode":         fmt.Sprintf("%04o", webSocketMode),
+			"certFile":              certFile,
+			"keyFile":               keyFile,
+			"subURI":                subURI,
+			"subPort":               subPort,
+			"subListen":             subListen,
+			"healthEnable":          healthEnable,
+			"xrayApiPort":           xrayAPIPort,
+			"xrayMirrorURL":         xrayMirrorURL,
+			"geoMirrorURL":          geoMirrorURL,
+			"geoUpdateInterval":     geoUpdateInterval,
+			"tgBotEnable":           tgBotEnable,
+			"tgBotToken":            tgBotToken,
+			"tgBotAPIServer":        tgBotAPIServer,
+			"tgBotChatIds":          tgBotChatIDs,
+			"backupInterval":        backupInterval,
+			"backupKeep":            backupKeep,
+			"backupDir":             backupDir,
+			"backupWebhook":         backupWebhook,
+			"backupTgEnable":        backupTgEnable,
+			"accessLogRetention":    accessLogRetention,
+			"ipLimitWindow":         ipLimitWindow,
+			"ipLimitAction":         ipLimitAction,
+			"ipLimitDisableMinutes": ipLimitDisableMinutes,
+			"ipLimitBanFile":        ipLimitBanFile,
 		},
 	})
 }
@@ -191,10 +249,34 @@ func (a *SettingController) GetSettings(c *gin.Context) {
 // UpdateSettings 更新系统设置
 func (a *SettingController) UpdateSettings(c *gin.Context) {
 	var req struct {
-		Port        int    `json:"port"`
-		WebBasePath string `json:"webBasePath"`
-		CertFile    string `json:"certFile"`
-		KeyFile     string `json:"keyFile"`
+		Port                  int     `json:"port"`
+		WebBasePath           string  `json:"webBasePath"`
+		WebListen             *string `json:"webListen"`
+		WebSocketMode         *string `json:"webSocketMode"`
+		CertFile              string  `json:"certFile"`
+		KeyFile               string  `json:"keyFile"`
+		SubURI                *string `json:"subURI"`
+		SubPort               *int    `json:"subPort"`
+		SubListen             *string `json:"subListen"`
+		HealthEnable          *bool   `json:"healthEnable"`
+		XrayAPIPort           *int    `json:"xrayApiPort"`
+		XrayMirrorURL         *string `json:"xrayMirrorURL"`
+		GeoMirrorURL          *string `json:"geoMirrorURL"`
+		GeoUpdateInterval     *int    `json:"geoUpdateInterval"`
+		TgBotEnable           *bool   `json:"tgBotEnable"`
+		TgBotToken            *string `json:"tgBotToken"`
+		TgBotAPIServer        *string `json:"tgBotAPIServer"`
+		TgBotChatIDs          *string `json:"tgBotChatIds"`
+		BackupInterval        *int    `json:"backupInterval"`
+		BackupKeep            *int    `json:"backupKeep"`
+		BackupDir             *string `json:"backupDir"`
+		BackupWebhook         *string `json:"backupWebhook"`
+		BackupTgEnable        *bool   `json:"backupTgEnable"`
+		AccessLogRetention    *int    `json:"accessLogRetention"`
+		IPLimitWindow         *int    `json:"ipLimitWindow"`
+		IPLimitAction         *string `json:"ipLimitAction"`
+		IPLimitDisableMinutes *int    `json:"ipLimitDisableMinutes"`
+		IPLimitBanFile        *string `json:"ipLimitBanFile"`
 	}
 
 	err := c.ShouldBindJSON(&req)
@@ -230,6 +312,28 @@ func (a *SettingController) UpdateSettings(c *gin.Context) {
 		}
 	}
 
+	if req.WebListen != nil {
+		err = settingService.SetListen(*req.WebListen)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置监听地址失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.WebSocketMode != nil {
+		err = settingService.SetSocketMode(*req.WebSocketMode)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置套接字权限失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
 	if req.CertFile != "" {
 		err = settingService.SetCertFile(req.CertFile)
 		if err != nil {
@@ -252,154 +356,1913 @@ func (a *SettingController) UpdateSettings(c *gin.Context) {
 		}
 	}
 
+	if req.SubURI != nil {
+		err = settingService.SetSubURI(*req.SubURI)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置订阅地址失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.SubPort != nil {
+		err = settingService.SetSubPort(*req.SubPort)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置订阅端口失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.SubListen != nil {
+		err = settingService.SetSubListen(*req.SubListen)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置订阅监听地址失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.HealthEnable != nil {
+		err = settingService.SetHealthEnable(*req.HealthEnable)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置健康检查接口开关失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.XrayAPIPort != nil {
+		err = settingService.SetXrayAPIPort(*req.XrayAPIPort)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置Xray API端口失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.XrayMirrorURL != nil {
+		err = settingService.SetXrayMirrorURL(*req.XrayMirrorURL)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置Xray下载地址失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.GeoMirrorURL != nil {
+		err = settingService.SetGeoMirrorURL(*req.GeoMirrorURL)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置geo数据文件下载地址失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.GeoUpdateInterval != nil {
+		err = settingService.SetGeoUpdateInterval(*req.GeoUpdateInterval)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置geo数据文件更新间隔失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.TgBotEnable != nil {
+		err = settingService.SetTgBotEnable(*req.TgBotEnable)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置Telegram机器人开关失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.TgBotToken != nil {
+		err = settingService.SetTgBotToken(*req.TgBotToken)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置Telegram机器人令牌失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.TgBotAPIServer != nil {
+		err = settingService.SetTgBotAPIServer(*req.TgBotAPIServer)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置Telegram API地址失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.TgBotChatIDs != nil {
+		err = settingService.SetTgBotChatIDs(*req.TgBotChatIDs)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置Telegram会话ID失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.BackupInterval != nil {
+		err = settingService.SetBackupInterval(*req.BackupInterval)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置备份间隔失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.BackupKeep != nil {
+		err = settingService.SetBackupKeep(*req.BackupKeep)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置备份保留数量失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.BackupDir != nil {
+		err = settingService.SetBackupDir(*req.BackupDir)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置备份目录失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.BackupWebhook != nil {
+		err = settingService.SetBackupWebhook(*req.BackupWebhook)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置备份Webhook失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.BackupTgEnable != nil {
+		err = settingService.SetBackupTgEnable(*req.BackupTgEnable)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置备份发送到Telegram失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.AccessLogRetention != nil {
+		err = settingService.SetAccessLogRetention(*req.AccessLogRetention)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置连接记录保留时间失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.IPLimitWindow != nil {
+		err = settingService.SetIPLimitWindow(*req.IPLimitWindow)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置IP统计时间窗口失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.IPLimitAction != nil {
+		err = settingService.SetIPLimitAction(*req.IPLimitAction)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置超出IP限制的处理方式失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.IPLimitDisableMinutes != nil {
+		err = settingService.SetIPLimitDisableMinutes(*req.IPLimitDisableMinutes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置临时禁用时长失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.IPLimitBanFile != nil {
+		err = settingService.SetIPLimitBanFile(*req.IPLimitBanFile)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "设置IP封禁列表文件失败：" + err.Error(),
+			})
+			return
+		}
+	}
+	if req.XrayAPIPort != nil {
+		err = restartXray(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "设置更新成功，但应用Xray配置失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "设置更新成功",
+		"data":    nil,
+	})
+}
+
+// InboundController 入站控制器
+type InboundController struct{}
+
+// GetInbounds 获取所有入站
+func (a *InboundController) GetInbounds(c *gin.Context) {
+	inboundService := service.InboundService{}
+	inbounds, err := inboundService.GetInbounds()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取入站列表失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    inbounds,
+	})
+}
+
+// AddInbound 添加入站
+func (a *InboundController) AddInbound(c *gin.Context) {
+	inbound := &database.InboundConfig{}
+	err := c.ShouldBindJSON(inbound)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数无效",
+		})
+		return
+	}
+	inbound.ID = 0
+
+	inboundService := service.InboundService{}
+	err = inboundService.AddInbound(inbound)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "添加入站失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "添加入站成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "添加入站成功",
+		"data":    inbound,
+	})
+}
+
+// UpdateInbound 更新入站
+func (a *InboundController) UpdateInbound(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+
+	inbound := &database.InboundConfig{}
+	err = c.ShouldBindJSON(inbound)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数无效",
+		})
+		return
+	}
+	inbound.ID = id
+
+	inboundService := service.InboundService{}
+	err = inboundService.UpdateInbound(inbound)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "更新入站失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "更新入站成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "更新入站成功",
+	})
+}
+
+// CheckReality 检查入站的REALITY目标网站，结果保存到入站的RealityCheck
+func (a *InboundController) CheckReality(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+
+	realityCheckService := service.RealityCheckService{}
+	result, err := realityCheckService.CheckInbound(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "检查REALITY目标失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// DeleteInbound 删除入站
+func (a *InboundController) DeleteInbound(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+
+	inboundService := service.InboundService{}
+	err = inboundService.DelInbound(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "删除入站失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "删除入站成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "删除入站成功",
+	})
+}
+
+// ExportInbounds 导出入站及客户端
+func (a *InboundController) ExportInbounds(c *gin.Context) {
+	inboundService := service.InboundService{}
+	bundle, err := inboundService.ExportInbounds()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "导出入站失败：" + err.Error(),
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("mx-ui-inbounds-%s.json", time.Now().Format("20060102-150405"))
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.JSON(http.StatusOK, bundle)
+}
+
+// ImportInbounds 导入入站及客户端，冲突策略和试运行通过查询参数指定
+func (a *InboundController) ImportInbounds(c *gin.Context) {
+	bundle := &service.ExportBundle{}
+	err := c.ShouldBindJSON(bundle)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数无效",
+		})
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+	options := &service.ImportOptions{
+		PortStrategy:  c.Query("portStrategy"),
+		TagStrategy:   c.Query("tagStrategy"),
+		EmailStrategy: c.Query("emailStrategy"),
+		DryRun:        dryRun,
+	}
+
+	inboundService := service.InboundService{}
+	report, err := inboundService.ImportInbounds(bundle, options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "导入入站失败：" + err.Error(),
+		})
+		return
+	}
+	if !dryRun {
+		err = restartXray(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "导入入站成功，但应用Xray配置失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "导入入站成功",
+		"data":    report,
+	})
+}
+
+// ImportFromXUI 上传x-ui或3x-ui数据库并导入其中的入站和客户端
+func (a *InboundController) ImportFromXUI(c *gin.Context) {
+	fileHeader, err := c.FormFile("db")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请上传数据库文件",
+		})
+		return
+	}
+	tmpFile, err := os.CreateTemp(config.GetTempPath(), "xui-*.db")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "创建临时文件失败：" + err.Error(),
+		})
+		return
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	err = c.SaveUploadedFile(fileHeader, tmpFile.Name())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "保存上传文件失败：" + err.Error(),
+		})
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+	options := &service.ImportOptions{
+		PortStrategy:  c.Query("portStrategy"),
+		TagStrategy:   c.Query("tagStrategy"),
+		EmailStrategy: c.Query("emailStrategy"),
+		DryRun:        dryRun,
+	}
+
+	inboundService := service.InboundService{}
+	report, err := inboundService.ImportFromXUI(tmpFile.Name(), options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "导入x-ui数据库失败：" + err.Error(),
+		})
+		return
+	}
+	if !dryRun {
+		err = restartXray(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "导入x-ui数据库成功，但应用Xray配置失败：" + err.Error(),
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "导入x-ui数据库成功",
+		"data":    report,
+	})
+}
+
+// ClientController 客户端控制器
+type ClientController struct{}
+
+// GetClients 获取所有客户端，可通过inboundId参数筛选
+func (a *ClientController) GetClients(c *gin.Context) {
+	inboundID, _ := strconv.Atoi(c.Query("inboundId"))
+
+	clientService := service.ClientService{}
+	clients, err := clientService.GetClients(uint(inboundID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取客户端列表失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    clients,
+	})
+}
+
+// AddClient 添加客户端
+func (a *ClientController) AddClient(c *gin.Context) {
+	client := &database.ClientConfig{}
+	err := c.ShouldBindJSON(client)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数无效",
+		})
+		return
+	}
+	client.ID = 0
+
+	clientService := service.ClientService{}
+	err = clientService.AddClient(client)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "添加客户端失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "添加客户端成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "添加客户端成功",
+		"data":    client,
+	})
+}
+
+// UpdateClient 更新客户端
+func (a *ClientController) UpdateClient(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+
+	client := &database.ClientConfig{}
+	err = c.ShouldBindJSON(client)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数无效",
+		})
+		return
+	}
+	client.ID = id
+
+	clientService := service.ClientService{}
+	err = clientService.UpdateClient(client)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "更新客户端失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "更新客户端成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "更新客户端成功",
+	})
+}
+
+// DeleteClient 删除客户端
+func (a *ClientController) DeleteClient(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+
+	clientService := service.ClientService{}
+	err = clientService.DelClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "删除客户端失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "删除客户端成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "删除客户端成功",
+	})
+}
+
+// SetClientEnable 启用或禁用客户端
+func (a *ClientController) SetClientEnable(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+
+	var req struct {
+		Enable bool `json:"enable"`
+	}
+	err = c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数无效",
+		})
+		return
+	}
+
+	clientService := service.ClientService{}
+	err = clientService.SetClientEnable(id, req.Enable)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "设置客户端状态失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "设置客户端状态成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "设置客户端状态成功",
+	})
+}
+
+// ResetClientTraffic 重置客户端流量
+func (a *ClientController) ResetClientTraffic(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+
+	clientService := service.ClientService{}
+	err = clientService.ResetClientTraffic(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "重置客户端流量失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "重置客户端流量成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "重置客户端流量成功",
+	})
+}
+
+// GetLinks 获取客户端的分享链接和订阅链接，address参数为链接中的服务器地址，默认为访问面板时使用的地址
+func (a *ClientController) GetLinks(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+
+	clientService := service.ClientService{}
+	client, err := clientService.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "客户端不存在",
+		})
+		return
+	}
+	address := c.Query("address")
+	if address == "" {
+		address, _, err = net.SplitHostPort(c.Request.Host)
+		if err != nil {
+			address = strings.Trim(c.Request.Host, "[]")
+		}
+	}
+	link, err := clientService.GetShareLink(client, address)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "生成分享链接失败：" + err.Error(),
+		})
+		return
+	}
+	subLink, _ := clientService.GetSubLink(client)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"link":    link,
+			"subLink": subLink,
+		},
+	})
+}
+
+// GetConnections 获取客户端最近的来源IP、目标地址和连接记录
+func (a *ClientController) GetConnections(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+	minutes, ok := getMinutesQuery(c)
+	if !ok {
+		return
+	}
+
+	clientService := service.ClientService{}
+	client, err := clientService.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "客户端不存在",
+		})
+		return
+	}
+
+	connectionService := service.ConnectionService{}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    connectionService.GetClientActivity(client.Email, minutes, 100),
+	})
+}
+
+// GetActivities 获取所有客户端最近的连接概况，用于排查账号共享
+func (a *ClientController) GetActivities(c *gin.Context) {
+	minutes, ok := getMinutesQuery(c)
+	if !ok {
+		return
+	}
+
+	connectionService := service.ConnectionService{}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    connectionService.GetActivities(minutes),
+	})
+}
+
+// OutboundController 出站控制器
+type OutboundController struct{}
+
+// GetOutbounds 获取出站列表
+func (a *OutboundController) GetOutbounds(c *gin.Context) {
+	outboundService := service.OutboundService{}
+	outbounds, err := outboundService.GetOutbounds()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取出站列表失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    outbounds,
+	})
+}
+
+// AddOutbound 添加出站
+func (a *OutboundController) AddOutbound(c *gin.Context) {
+	outbound := &database.OutboundConfig{}
+	err := c.ShouldBindJSON(outbound)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数无效",
+		})
+		return
+	}
+	outbound.ID = 0
+
+	outboundService := service.OutboundService{}
+	err = outboundService.AddOutbound(outbound)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "添加出站失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "添加出站成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "添加出站成功",
+		"data":    outbound,
+	})
+}
+
+// UpdateOutbound 更新出站
+func (a *OutboundController) UpdateOutbound(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+
+	outbound := &database.OutboundConfig{}
+	err = c.ShouldBindJSON(outbound)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数无效",
+		})
+		return
+	}
+	outbound.ID = id
+
+	outboundService := service.OutboundService{}
+	err = outboundService.UpdateOutbound(outbound)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "更新出站失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "更新出站成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "更新出站成功",
+	})
+}
+
+// DeleteOutbound 删除出站
+func (a *OutboundController) DeleteOutbound(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+
+	outboundService := service.OutboundService{}
+	err = outboundService.DelOutbound(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "删除出站失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "删除出站成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "删除出站成功",
+	})
+}
+
+// RoutingController 路由规则控制器
+type RoutingController struct{}
+
+// GetRules 按顺序获取路由规则
+func (a *RoutingController) GetRules(c *gin.Context) {
+	routingService := service.RoutingService{}
+	rules, err := routingService.GetRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取路由规则失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    rules,
+	})
+}
+
+// AddRule 添加路由规则
+func (a *RoutingController) AddRule(c *gin.Context) {
+	rule := &database.RoutingRule{}
+	err := c.ShouldBindJSON(rule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数无效",
+		})
+		return
+	}
+	rule.ID = 0
+
+	routingService := service.RoutingService{}
+	err = routingService.AddRule(rule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "添加路由规则失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "添加路由规则成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "添加路由规则成功",
+		"data":    rule,
+	})
+}
+
+// UpdateRule 更新路由规则
+func (a *RoutingController) UpdateRule(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+
+	rule := &database.RoutingRule{}
+	err = c.ShouldBindJSON(rule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数无效",
+		})
+		return
+	}
+	rule.ID = id
+
+	routingService := service.RoutingService{}
+	err = routingService.UpdateRule(rule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "更新路由规则失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "更新路由规则成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "更新路由规则成功",
+	})
+}
+
+// DeleteRule 删除路由规则
+func (a *RoutingController) DeleteRule(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+
+	routingService := service.RoutingService{}
+	err = routingService.DelRule(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "删除路由规则失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "删除路由规则成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "删除路由规则成功",
+	})
+}
+
+// SetOrder 调整路由规则顺序，请求体为按新顺序排列的全部规则ID
+func (a *RoutingController) SetOrder(c *gin.Context) {
+	var req struct {
+		IDs []uint `json:"ids"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数无效",
+		})
+		return
+	}
+
+	routingService := service.RoutingService{}
+	err = routingService.SetRuleOrder(req.IDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "调整路由规则顺序失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "调整路由规则顺序成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "调整路由规则顺序成功",
+	})
+}
+
+// GetPresets 获取路由规则预设
+func (a *RoutingController) GetPresets(c *gin.Context) {
+	routingService := service.RoutingService{}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    routingService.GetPresets(),
+	})
+}
+
+// ApplyPreset 按预设添加路由规则，direct-country 预设需要查询参数 country
+func (a *RoutingController) ApplyPreset(c *gin.Context) {
+	routingService := service.RoutingService{}
+	rules, err := routingService.ApplyPreset(c.Param("name"), c.Query("country"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "应用路由预设失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "应用路由预设成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "应用路由预设成功",
+		"data":    rules,
+	})
+}
+
+// ServerController 服务器控制器
+type ServerController struct{}
+
+// GetStatus 获取服务器状态
+func (a *ServerController) GetStatus(c *gin.Context) {
+	serverService := service.ServerService{}
+	status := serverService.GetStatus(nil)
+	
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"obj": status,
+	})
+}
+
+// GetStats 获取服务器统计信息
+func (a *ServerController) GetStats(c *gin.Context) {
+	serverService := service.ServerService{}
+	stats, err := serverService.GetServerStats()
+	
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取服务器统计信息失败: " + err.Error(),
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"obj": stats,
+	})
+}
+
+// XrayController Xray控制器
+type XrayController struct{}
+
+// Restart 重启Xray
+func (a *XrayController) Restart(c *gin.Context) {
+	xrayService := service.XrayService{}
+	err := xrayService.RestartXray(true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Xray重启失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Xray重启成功",
+	})
+}
+
+// Stop 停止Xray
+func (a *XrayController) Stop(c *gin.Context) {
+	xrayService := service.XrayService{}
+	err := xrayService.StopXray()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Xray停止失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Xray停止成功",
+	})
+}
+
+// Start 启动Xray
+func (a *XrayController) Start(c *gin.Context) {
+	xrayService := service.XrayService{}
+	err := xrayService.RestartXray(false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Xray启动失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Xray启动成功",
+	})
+}
+
+// GetConfig 获取Xray配置
+func (a *XrayController) GetConfig(c *gin.Context) {
+	settingService := service.SettingService{}
+	template, _ := settingService.GetXrayConfigTemplate()
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"config": template,
+		},
+	})
+}
+
+// GetRevisions 获取配置历史版本列表
+func (a *XrayController) GetRevisions(c *gin.Context) {
+	revisionService := service.ConfigRevisionService{}
+	revisions, err := revisionService.GetRevisions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取配置历史版本失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    revisions,
+	})
+}
+
+// GetRevision 获取单个配置历史版本
+func (a *XrayController) GetRevision(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+
+	revisionService := service.ConfigRevisionService{}
+	revision, err := revisionService.GetRevision(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "配置历史版本不存在",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    revision,
+	})
+}
+
+// DiffRevisions 比较两个配置历史版本，通过from和to参数指定版本编号
+func (a *XrayController) DiffRevisions(c *gin.Context) {
+	from, err1 := strconv.ParseUint(c.Query("from"), 10, 32)
+	to, err2 := strconv.ParseUint(c.Query("to"), 10, 32)
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "from和to参数无效",
+		})
+		return
+	}
+
+	revisionService := service.ConfigRevisionService{}
+	diff, err := revisionService.Diff(uint(from), uint(to))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "比较配置历史版本失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"diff": diff,
+		},
+	})
+}
+
+// RollbackRevision 回滚到指定的配置历史版本
+func (a *XrayController) RollbackRevision(c *gin.Context) {
+	id, err := getIDParam(c)
+	if err != nil {
+		return
+	}
+
+	revisionService := service.ConfigRevisionService{}
+	revision, err := revisionService.Rollback(id, getLoginUsername(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "回滚配置失败：" + err.Error(),
+		})
+		return
+	}
+	err = restartXray(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "回滚配置成功，但应用Xray配置失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "回滚配置成功",
+		"data":    revision,
+	})
+}
+
+// GetVersion 获取当前使用的和可以回滚到的Xray版本
+func (a *XrayController) GetVersion(c *gin.Context) {
+	xrayVersionService := service.XrayVersionService{}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    xrayVersionService.GetVersionInfo(),
+	})
+}
+
+// InstallVersion 从设置的下载地址安装指定版本的Xray，sha256为空时使用发布的摘要文件校验
+func (a *XrayController) InstallVersion(c *gin.Context) {
+	var req struct {
+		Version string `json:"version"`
+		SHA256  string `json:"sha256"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数无效",
+		})
+		return
+	}
+
+	xrayVersionService := service.XrayVersionService{}
+	version, err := xrayVersionService.InstallFromMirror(req.Version, req.SHA256)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "安装Xray失败：" + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "已安装Xray " + version,
+		"data":    xrayVersionService.GetVersionInfo(),
+	})
+}
+
+// UploadVersion 上传Xray发布的zip压缩包并安装，可以通过sha256表单字段校验压缩包
+func (a *XrayController) UploadVersion(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请上传Xray压缩包",
+		})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "读取上传文件失败：" + err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	xrayVersionService := service.XrayVersionService{}
+	version, err := xrayVersionService.InstallFromArchive(file, c.PostForm("sha256"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "安装Xray失败：" + err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "设置更新成功",
-		"data":    nil,
+		"message": "已安装Xray " + version,
+		"data":    xrayVersionService.GetVersionInfo(),
 	})
 }
 
-// 以下是空的控制器实现，实际项目中需要完善
-
-// InboundController 入站控制器
-type InboundController struct{}
+// RollbackVersion 切换回安装前的Xray版本
+func (a *XrayController) RollbackVersion(c *gin.Context) {
+	xrayVersionService := service.XrayVersionService{}
+	version, err := xrayVersionService.Rollback()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "回滚Xray版本失败：" + err.Error(),
+		})
+		return
+	}
 
-// GetInbounds 获取所有入站
-func (a *InboundController) GetInbounds(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data":    []interface{}{},
+		"message": "已切换到Xray " + version,
+		"data":    xrayVersionService.GetVersionInfo(),
 	})
 }
 
-// AddInbound 添加入站
-func (a *InboundController) AddInbound(c *gin.Context) {
+// getIDParam 解析路径中的id参数，解析失败时直接返回错误响应
+func getIDParam(c *gin.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "ID无效",
+		})
+		return 0, err
+	}
+	return uint(id), nil
+}
+
+// getMinutesQuery 解析查询参数minutes，默认为60，解析失败时直接返回错误响应
+func getMinutesQuery(c *gin.Context) (int, bool) {
+	if c.Query("minutes") == "" {
+		return 60, true
+	}
+	minutes, err := strconv.Atoi(c.Query("minutes"))
+	if err != nil || minutes <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "minutes参数无效",
+		})
+		return 0, false
+	}
+	return minutes, true
+}
+
+// restartXray 配置变更后记录配置历史版本并重新应用Xray配置，返回应用配置的错误。
+// 配置已经保存，调用方需要在响应中告知应用失败
+func restartXray(c *gin.Context) error {
+	revisionService := service.ConfigRevisionService{}
+	_, err := revisionService.Record(getLoginUsername(c), "")
+	if err != nil {
+		logger.Warning("记录配置历史版本失败:", err)
+	}
+
+	xrayService := service.XrayService{}
+	err = xrayService.ApplyConfig()
+	if err != nil {
+		logger.Warning("重启Xray失败:", err)
+	}
+	return err
+}
+
+// getLoginUsername 获取当前登录的用户名
+func getLoginUsername(c *gin.Context) string {
+	username, _ := sessions.Default(c).Get("username").(string)
+	return username
+}
+
+// GeoAssetController geo数据文件控制器
+type GeoAssetController struct{}
+
+// GetAssets 获取geo数据文件列表
+func (a *GeoAssetController) GetAssets(c *gin.Context) {
+	geoAssetService := service.GeoAssetService{}
+	assets, err := geoAssetService.GetAssets()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取geo数据文件失败：" + err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "添加入站成功",
+		"data":    assets,
 	})
 }
 
-// UpdateInbound 更新入站
-func (a *InboundController) UpdateInbound(c *gin.Context) {
+// Update 从下载地址更新geoip.dat和geosite.dat
+func (a *GeoAssetController) Update(c *gin.Context) {
+	geoAssetService := service.GeoAssetService{}
+	updated, err := geoAssetService.Update()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "更新geo数据文件失败：" + err.Error(),
+		})
+		return
+	}
+
+	message := "geo数据文件已是最新"
+	if len(updated) > 0 {
+		message = "已更新 " + strings.Join(updated, "、")
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "更新入站成功",
+		"message": message,
+		"data":    updated,
 	})
 }
 
-// DeleteInbound 删除入站
-func (a *InboundController) DeleteInbound(c *gin.Context) {
+// Upload 上传geo数据文件，文件名取自name表单字段，为空时使用上传的文件名
+func (a *GeoAssetController) Upload(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请上传geo数据文件",
+		})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "读取上传文件失败：" + err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	name := c.PostForm("name")
+	if name == "" {
+		name = fileHeader.Filename
+	}
+	geoAssetService := service.GeoAssetService{}
+	asset, err := geoAssetService.Upload(name, file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "上传geo数据文件失败：" + err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "删除入站成功",
+		"message": "上传geo数据文件成功",
+		"data":    asset,
 	})
 }
 
-// ClientController 客户端控制器
-type ClientController struct{}
+// Delete 删除上传的自定义规则集
+func (a *GeoAssetController) Delete(c *gin.Context) {
+	geoAssetService := service.GeoAssetService{}
+	err := geoAssetService.Delete(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "删除geo数据文件失败：" + err.Error(),
+		})
+		return
+	}
 
-// GetClients 获取所有客户端
-func (a *ClientController) GetClients(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data":    []interface{}{},
+		"message": "删除geo数据文件成功",
 	})
 }
 
-// AddClient 添加客户端
-func (a *ClientController) AddClient(c *gin.Context) {
+// ToolController 生成密钥、shortId和UUID等工具
+type ToolController struct{}
+
+// GenerateX25519 生成REALITY使用的x25519密钥对
+func (a *ToolController) GenerateX25519(c *gin.Context) {
+	toolService := service.ToolService{}
+	privateKey, publicKey, err := toolService.GenerateX25519()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "生成密钥失败：" + err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "添加客户端成功",
+		"data": gin.H{
+			"privateKey": privateKey,
+			"publicKey":  publicKey,
+		},
 	})
 }
 
-// UpdateClient 更新客户端
-func (a *ClientController) UpdateClient(c *gin.Context) {
+// GenerateShortIDs 生成REALITY使用的shortId，count参数为数量，默认为1，最多16个
+func (a *ToolController) GenerateShortIDs(c *gin.Context) {
+	count := 1
+	if c.Query("count") != "" {
+		n, err := strconv.Atoi(c.Query("count"))
+		if err != nil || n <= 0 || n > 16 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "count参数必须在1-16之间",
+			})
+			return
+		}
+		count = n
+	}
+
+	toolService := service.ToolService{}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "更新客户端成功",
+		"data":    toolService.GenerateShortIDs(count),
 	})
 }
 
-// DeleteClient 删除客户端
-func (a *ClientController) DeleteClient(c *gin.Context) {
+// GenerateUUID 生成随机的UUID和密码，可用作vless、vmess客户端的ID或trojan、shadowsocks客户端的密码
+func (a *ToolController) GenerateUUID(c *gin.Context) {
+	toolService := service.ToolService{}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "删除客户端成功",
+		"data": gin.H{
+			"uuid":     toolService.GenerateUUID(),
+			"password": toolService.GeneratePassword(),
+		},
 	})
 }
 
-// ServerController 服务器控制器
-type ServerController struct{}
+// CheckRealityDest 检查候选的REALITY目标网站，不保存结果
+func (a *ToolController) CheckRealityDest(c *gin.Context) {
+	var req struct {
+		Dest        string   `json:"dest"`
+		ServerNames []string `json:"serverNames"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数无效",
+		})
+		return
+	}
+
+	realityCheckService := service.RealityCheckService{}
+	result, err := realityCheckService.Check(req.Dest, req.ServerNames)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "检查REALITY目标失败：" + err.Error(),
+		})
+		return
+	}
 
-// GetStatus 获取服务器状态
-func (a *ServerController) GetStatus(c *gin.Context) {
-	serverService := service.ServerService{}
-	status := serverService.GetStatus(nil)
-	
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"obj": status,
+		"data":    result,
 	})
 }
 
-// GetStats 获取服务器统计信息
-func (a *ServerController) GetStats(c *gin.Context) {
-	serverService := service.ServerService{}
-	stats, err := serverService.GetServerStats()
-	
+// BackupController 备份控制器
+type BackupController struct{}
+
+// Backup 下载数据库备份
+func (a *BackupController) Backup(c *gin.Context) {
+	backupService := service.BackupService{}
+	backupPath, err := backupService.CreateTempBackup()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"message": "获取服务器统计信息失败: " + err.Error(),
+			"message": "创建备份失败：" + err.Error(),
 		})
 		return
 	}
-	
+	defer os.Remove(backupPath)
+
+	c.FileAttachment(backupPath, filepath.Base(backupPath))
+}
+
+// Restore 上传备份文件，校验通过后面板停止服务器和后台任务再恢复数据库
+func (a *BackupController) Restore(c *gin.Context) {
+	fileHeader, err := c.FormFile("db")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请上传数据库文件",
+		})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "读取上传文件失败：" + err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	backupService := service.BackupService{}
+	err = backupService.Restore(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "恢复数据库失败：" + err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"obj": stats,
+		"message": "备份校验通过，面板将重启并恢复数据库",
 	})
 }
 
-// XrayController Xray控制器
-type XrayController struct{}
+// LogController 日志控制器
+type LogController struct{}
 
-// Restart 重启Xray
-func (a *XrayController) Restart(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Xray重启成功",
+// GetLogs 获取日志，source为panel、access或error，follow为true时以SSE持续推送新日志
+func (a *LogController) GetLogs(c *gin.Context) {
+	level := logger.LevelDebug
+	if c.Query("level") != "" {
+		var err error
+		level, err = logger.ParseLevel(c.Query("level"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	limit := 200
+	if c.Query("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(c.Query("limit"))
+		if err != nil || limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "limit参数无效",
+			})
+			return
+		}
+	}
+	source := c.Query("source")
+	keyword := c.Query("keyword")
+	logService := service.LogService{}
+
+	follow, _ := strconv.ParseBool(c.Query("follow"))
+	if !follow {
+		entries, err := logService.GetLogs(source, level, keyword, limit)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "获取日志失败：" + err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data":    entries,
+		})
+		return
+	}
+
+	// 先推送已有日志，再持续推送新日志，直到客户端断开
+	entries, ch, err := logService.FollowLogs(c.Request.Context(), source, level, keyword, limit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "获取日志失败：" + err.Error(),
+		})
+		return
+	}
+	c.Header("Cache-Control", "no-cache")
+	for _, entry := range entries {
+		c.SSEvent("log", entry)
+	}
+	c.Writer.Flush()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case entry := <-ch:
+			c.SSEvent("log", entry)
+			return true
+		}
 	})
 }
 
-// Stop 停止Xray
-func (a *XrayController) Stop(c *gin.Context) {
+// GetLevel 获取当前面板日志级别
+func (a *LogController) GetLevel(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "Xray停止成功",
+		"data": gin.H{
+			"level": strings.ToLower(logger.LevelName(logger.GetLevel())),
+		},
 	})
 }
 
-// Start 启动Xray
-func (a *XrayController) Start(c *gin.Context) {
+// SetLevel 运行时修改面板日志级别
+func (a *LogController) SetLevel(c *gin.Context) {
+	var req struct {
+		Level string `json:"level"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数无效",
+		})
+		return
+	}
+	level, err := logger.ParseLevel(req.Level)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	logger.SetLevel(level)
+	logger.Infof("日志级别已修改为 %s", logger.LevelName(level))
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "Xray启动成功",
+		"message": "修改日志级别成功",
 	})
 }
 
-// GetConfig 获取Xray配置
-func (a *XrayController) GetConfig(c *gin.Context) {
-	settingService := service.SettingService{}
-	template, _ := settingService.GetXrayConfigTemplate()
+// HealthController 健康检查控制器，接口无需登录
+type HealthController struct{}
 
+// Healthz 进程存活检查
+func (a *HealthController) Healthz(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"data": gin.H{
-			"config": template,
-		},
+		"status": service.CheckStatusOK,
 	})
-} 
\ No newline at end of file
+}
+
+// Readyz 就绪检查，返回每一项检查的结果，任一检查失败时返回503
+func (a *HealthController) Readyz(c *gin.Context) {
+	healthService := service.HealthService{}
+	report := healthService.CheckReadiness()
+	status := http.StatusOK
+	if report.Status != service.CheckStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
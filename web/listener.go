@@ -0,0 +1,125 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"mx-ui/config"
+	"mx-ui/web/service"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Unix套接字监听地址的前缀，例如 unix:/run/mx-ui.sock
+const unixListenPrefix = "unix:"
+
+// systemd传入的第一个套接字的文件描述符，测试中改为其他监听器的文件描述符
+var systemdListenFdsStart = 3
+
+var (
+	systemdOnce sync.Once
+	systemdFile *os.File
+	systemdErr  error
+)
+
+// systemdSocket 获取systemd套接字激活传入的套接字，未使用套接字激活时返回nil。
+// 环境变量只读取一次并随后清除，避免被Xray等子进程继承
+func systemdSocket() (*os.File, error) {
+	systemdOnce.Do(func() {
+		defer func() {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+		}()
+
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			return
+		}
+		fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || fds < 1 {
+			return
+		}
+		if fds > 1 {
+			systemdErr = fmt.Errorf("systemd传入了%d个套接字，只支持1个", fds)
+			return
+		}
+		systemdFile = os.NewFile(uintptr(systemdListenFdsStart), "LISTEN_FD_3")
+	})
+	return systemdFile, systemdErr
+}
+
+// newListener 创建Web服务器的监听器，优先使用systemd传入的套接字，
+// 其次是 unix: 开头的Unix套接字，最后是TCP地址
+func newListener(listen string, port int, socketMode os.FileMode) (net.Listener, error) {
+	file, err := systemdSocket()
+	if err != nil {
+		return nil, err
+	}
+	if file != nil {
+		// FileListener 会复制文件描述符，关闭监听器后仍可再次创建
+		return net.FileListener(file)
+	}
+	return Listen(listen, port, socketMode)
+}
+
+// Listen 监听 unix: 开头的Unix套接字或TCP地址，订阅服务器也使用它
+func Listen(listen string, port int, socketMode os.FileMode) (net.Listener, error) {
+	if strings.HasPrefix(listen, unixListenPrefix) {
+		return listenUnix(strings.TrimPrefix(listen, unixListenPrefix), socketMode)
+	}
+	return net.Listen("tcp", net.JoinHostPort(listen, strconv.Itoa(port)))
+}
+
+// SocketMode 获取Unix套接字文件的权限，配置文件、环境变量中的设置优先于数据库中的设置
+func SocketMode() (os.FileMode, error) {
+	socketMode, err := config.GetSocketMode()
+	if err == nil && socketMode == 0 {
+		settingService := service.SettingService{}
+		socketMode, err = settingService.GetSocketMode()
+	}
+	return socketMode, err
+}
+
+// listenUnix 监听Unix套接字并设置权限，会删除上次未清理的套接字文件
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("Unix套接字路径不能为空")
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s 已存在且不是套接字文件", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, mode)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// ListenerAddr 获取监听器的可读地址，用于日志和健康检查
+func ListenerAddr(listener net.Listener) string {
+	addr := listener.Addr()
+	if addr.Network() == "unix" {
+		return unixListenPrefix + addr.String()
+	}
+	return addr.String()
+}
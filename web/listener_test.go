@@ -0,0 +1,159 @@
+package web
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestListenTCP(t *testing.T) {
+	listener, err := Listen("127.0.0.1", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if addr := ListenerAddr(listener); !strings.HasPrefix(addr, "127.0.0.1:") || addr == "127.0.0.1:0" {
+		t.Errorf("监听地址为 %q", addr)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "mx-ui.sock")
+	listener, err := Listen("unix:"+path, 54321, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := ListenerAddr(listener); addr != "unix:"+path {
+		t.Errorf("监听地址为 %q", addr)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0660 {
+		t.Errorf("套接字文件的权限为 %v", info.Mode())
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// 上次异常退出未清理的套接字文件被替换
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	listener, err = Listen("unix:"+path, 0, 0600)
+	if err != nil {
+		t.Fatalf("未清理的套接字文件应被替换: %v", err)
+	}
+	listener.Close()
+
+	regular := filepath.Join(t.TempDir(), "mx-ui.sock")
+	err = os.WriteFile(regular, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Listen("unix:"+regular, 0, 0660)
+	if err == nil || err.Error() != regular+" 已存在且不是套接字文件" {
+		t.Errorf("路径为普通文件时错误为 %v", err)
+	}
+	_, err = Listen("unix:", 0, 0660)
+	if err == nil || err.Error() != "Unix套接字路径不能为空" {
+		t.Errorf("路径为空时错误为 %v", err)
+	}
+}
+
+// activateSystemdSocket 模拟systemd套接字激活：复制监听器的文件描述符作为传入的套接字并设置环境变量。
+// 测试结束时恢复
+func activateSystemdSocket(t *testing.T, pid int, fds string) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fdsStart := systemdListenFdsStart
+	systemdListenFdsStart = fd
+	systemdOnce = sync.Once{}
+	t.Setenv("LISTEN_PID", strconv.Itoa(pid))
+	t.Setenv("LISTEN_FDS", fds)
+	t.Cleanup(func() {
+		// 读取传入的套接字后文件描述符归 systemdFile 所有
+		if systemdFile != nil {
+			systemdFile.Close()
+		} else {
+			syscall.Close(fd)
+		}
+		systemdOnce = sync.Once{}
+		systemdFile, systemdErr = nil, nil
+		systemdListenFdsStart = fdsStart
+		listener.Close()
+	})
+	return listener
+}
+
+func TestSystemdSocketActivation(t *testing.T) {
+	activated := activateSystemdSocket(t, os.Getpid(), "1")
+
+	// 传入的套接字优先于设置的监听地址
+	listener, err := newListener("unix:"+filepath.Join(t.TempDir(), "mx-ui.sock"), 0, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ListenerAddr(listener) != ListenerAddr(activated) {
+		t.Errorf("监听地址为 %q，期望systemd传入的 %q", ListenerAddr(listener), ListenerAddr(activated))
+	}
+	conn, err := net.Dial("tcp", ListenerAddr(listener))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if os.Getenv("LISTEN_FDS") != "" || os.Getenv("LISTEN_PID") != "" {
+		t.Error("读取后应清除环境变量，避免子进程继承")
+	}
+
+	// 关闭后可以再次创建，用于重启Web服务器
+	listener.Close()
+	listener, err = newListener("127.0.0.1", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if ListenerAddr(listener) != ListenerAddr(activated) {
+		t.Errorf("再次创建的监听地址为 %q", ListenerAddr(listener))
+	}
+}
+
+func TestSystemdSocketIgnored(t *testing.T) {
+	// LISTEN_PID 不是当前进程时，环境变量属于其他进程，使用设置的监听地址
+	activated := activateSystemdSocket(t, os.Getpid()+1, "1")
+	listener, err := newListener("127.0.0.1", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if ListenerAddr(listener) == ListenerAddr(activated) {
+		t.Error("LISTEN_PID不匹配时不应使用传入的套接字")
+	}
+}
+
+func TestSystemdSocketTooMany(t *testing.T) {
+	activateSystemdSocket(t, os.Getpid(), "2")
+	_, err := newListener("127.0.0.1", 0, 0)
+	if err == nil || err.Error() != "systemd传入了2个套接字，只支持1个" {
+		t.Errorf("传入多个套接字时错误为 %v", err)
+	}
+}
@@ -0,0 +1,405 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"mx-ui/logger"
+	"net"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 每个客户端最多保留的连接记录数
+const maxConnectionsPerClient = 1000
+
+// 启动时从访问日志末尾导入历史记录的最大字节数
+const accessLogSeedSize = 4 * 1024 * 1024
+
+// Xray访问日志格式：
+// 2024/01/02 15:04:05.123456 from 1.2.3.4:5678 accepted tcp:example.com:443 [inbound-443 -> direct] email: user@example.com
+var accessLogPattern = regexp.MustCompile(
+	`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?) (?:from )?(\S+) accepted (\S+)(?: \[([^\]]*)\])?(?: email: (\S+))?`)
+
+// ClientConnection 客户端的一次连接记录
+type ClientConnection struct {
+	Time        time.Time `json:"time"`
+	Email       string    `json:"email"`
+	SourceIP    string    `json:"sourceIp"`
+	Network     string    `json:"network"`
+	Destination string    `json:"destination"`
+	InboundTag  string    `json:"inboundTag"`
+	OutboundTag string    `json:"outboundTag"`
+}
+
+// AddressStat 来源IP或目标地址的统计
+type AddressStat struct {
+	Address  string    `json:"address"`
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// ClientActivity 客户端在一段时间内的连接情况
+type ClientActivity struct {
+	Email        string              `json:"email"`
+	Minutes      int                 `json:"minutes"`
+	DistinctIPs  int                 `json:"distinctIps"`
+	Connections  int                 `json:"connections"`
+	LastSeen     time.Time           `json:"lastSeen"`
+	SourceIPs    []*AddressStat      `json:"sourceIps,omitempty"`
+	Destinations []*AddressStat      `json:"destinations,omitempty"`
+	Recent       []*ClientConnection `json:"recent,omitempty"`
+}
+
+// connectionStore 按客户端邮箱保存最近的连接记录
+type connectionStore struct {
+	lock      sync.RWMutex
+	retention time.Duration
+	byEmail   map[string][]*ClientConnection
+}
+
+var connections = &connectionStore{
+	retention: 24 * time.Hour,
+	byEmail:   map[string][]*ClientConnection{},
+}
+
+// add 添加一条连接记录，同时丢弃该客户端过期或超出数量的记录
+func (s *connectionStore) add(conn *ClientConnection) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// 记录按时间排序，since和清理过期记录依赖这一顺序。访问日志基本按时间顺序写入，
+	// 个别乱序的记录插入到对应位置
+	list := s.byEmail[conn.Email]
+	i := sort.Search(len(list), func(i int) bool {
+		return list[i].Time.After(conn.Time)
+	})
+	list = slices.Insert(list, i, conn)
+	cutoff := time.Now().Add(-s.retention)
+	start := 0
+	for start < len(list) && list[start].Time.Before(cutoff) {
+		start++
+	}
+	if len(list)-start > maxConnectionsPerClient {
+		start = len(list) - maxConnectionsPerClient
+	}
+	if start > 0 {
+		list = append([]*ClientConnection{}, list[start:]...)
+	}
+	if len(list) == 0 {
+		delete(s.byEmail, conn.Email)
+		return
+	}
+	s.byEmail[conn.Email] = list
+}
+
+// prune 删除所有客户端的过期记录
+func (s *connectionStore) prune() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	cutoff := time.Now().Add(-s.retention)
+	for email, list := range s.byEmail {
+		start := 0
+		for start < len(list) && list[start].Time.Before(cutoff) {
+			start++
+		}
+		if start == len(list) {
+			delete(s.byEmail, email)
+		} else if start > 0 {
+			s.byEmail[email] = append([]*ClientConnection{}, list[start:]...)
+		}
+	}
+}
+
+// since 获取客户端在since之后的连接记录
+func (s *connectionStore) since(email string, since time.Time) []*ClientConnection {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	list := s.byEmail[email]
+	i := sort.Search(len(list), func(i int) bool {
+		return !list[i].Time.Before(since)
+	})
+	return append([]*ClientConnection{}, list[i:]...)
+}
+
+// emails 获取有连接记录的客户端邮箱
+func (s *connectionStore) emails() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	emails := make([]string, 0, len(s.byEmail))
+	for email := range s.byEmail {
+		emails = append(emails, email)
+	}
+	return emails
+}
+
+// parseAccessLogLine 解析一行Xray访问日志，没有邮箱或不是accepted的行返回false
+func parseAccessLogLine(line string) (*ClientConnection, bool) {
+	match := accessLogPattern.FindStringSubmatch(line)
+	if match == nil || match[5] == "" {
+		return nil, false
+	}
+	t, err := time.ParseInLocation("2006/01/02 15:04:05", match[1], time.Local)
+	if err != nil {
+		return nil, false
+	}
+
+	conn := &ClientConnection{
+		Time:  t,
+		Email: match[5],
+	}
+
+	source := trimNetwork(match[2])
+	host, _, err := net.SplitHostPort(source)
+	if err != nil {
+		host = source
+	}
+	conn.SourceIP = host
+
+	destination := match[3]
+	if network, address, ok := strings.Cut(destination, ":"); ok && (network == "tcp" || network == "udp") {
+		conn.Network = network
+		destination = address
+	}
+	conn.Destination = destination
+
+	// 路由信息为 “入站 -> 出站” 或 “入站 >> 出站”
+	route := match[4]
+	for _, sep := range []string{" -> ", " >> "} {
+		if inbound, outbound, ok := strings.Cut(route, sep); ok {
+			conn.InboundTag = inbound
+			conn.OutboundTag = outbound
+			route = ""
+			break
+		}
+	}
+	if route != "" {
+		conn.InboundTag = route
+	}
+	return conn, true
+}
+
+// trimNetwork 去掉地址前的 tcp: 或 udp:
+func trimNetwork(address string) string {
+	if strings.HasPrefix(address, "tcp:") || strings.HasPrefix(address, "udp:") {
+		return address[4:]
+	}
+	return address
+}
+
+// ConnectionService 客户端连接记录服务
+type ConnectionService struct{}
+
+// GetClientActivity 获取客户端最近minutes分钟内的来源IP、目标地址和最近的连接
+func (s *ConnectionService) GetClientActivity(email string, minutes int, recent int) *ClientActivity {
+	list := connections.since(email, time.Now().Add(-time.Duration(minutes)*time.Minute))
+	activity := summarizeConnections(email, minutes, list)
+	activity.SourceIPs = countAddresses(list, func(conn *ClientConnection) string {
+		return conn.SourceIP
+	})
+	activity.Destinations = countAddresses(list, func(conn *ClientConnection) string {
+		return conn.Destination
+	})
+	activity.Recent = []*ClientConnection{}
+	for i := len(list) - 1; i >= 0 && len(activity.Recent) < recent; i-- {
+		activity.Recent = append(activity.Recent, list[i])
+	}
+	return activity
+}
+
+// GetActivities 获取所有客户端最近minutes分钟内的连接概况，按不同IP数量从多到少排序
+func (s *ConnectionService) GetActivities(minutes int) []*ClientActivity {
+	since := time.Now().Add(-time.Duration(minutes) * time.Minute)
+	activities := []*ClientActivity{}
+	for _, email := range connections.emails() {
+		list := connections.since(email, since)
+		if len(list) == 0 {
+			continue
+		}
+		activities = append(activities, summarizeConnections(email, minutes, list))
+	}
+	sort.Slice(activities, func(i, j int) bool {
+		if activities[i].DistinctIPs != activities[j].DistinctIPs {
+			return activities[i].DistinctIPs > activities[j].DistinctIPs
+		}
+		return activities[i].Email < activities[j].Email
+	})
+	return activities
+}
+
+// GetDistinctIPs 获取客户端最近minutes分钟内使用过的不同来源IP
+func (s *ConnectionService) GetDistinctIPs(email string, minutes int) []string {
+	list := connections.since(email, time.Now().Add(-time.Duration(minutes)*time.Minute))
+	ips := []string{}
+	for _, stat := range countAddresses(list, func(conn *ClientConnection) string {
+		return conn.SourceIP
+	}) {
+		ips = append(ips, stat.Address)
+	}
+	return ips
+}
+
+// summarizeConnections 统计连接数、不同IP数量和最后连接时间
+func summarizeConnections(email string, minutes int, list []*ClientConnection) *ClientActivity {
+	activity := &ClientActivity{
+		Email:       email,
+		Minutes:     minutes,
+		Connections: len(list),
+	}
+	ips := map[string]struct{}{}
+	for _, conn := range list {
+		ips[conn.SourceIP] = struct{}{}
+		if conn.Time.After(activity.LastSeen) {
+			activity.LastSeen = conn.Time
+		}
+	}
+	activity.DistinctIPs = len(ips)
+	return activity
+}
+
+// countAddresses 按地址统计次数和最后出现时间，按最后出现时间从新到旧排序
+func countAddresses(list []*ClientConnection, address func(*ClientConnection) string) []*AddressStat {
+	statMap := map[string]*AddressStat{}
+	for _, conn := range list {
+		key := address(conn)
+		stat := statMap[key]
+		if stat == nil {
+			stat = &AddressStat{Address: key}
+			statMap[key] = stat
+		}
+		stat.Count++
+		if conn.Time.After(stat.LastSeen) {
+			stat.LastSeen = conn.Time
+		}
+	}
+	stats := make([]*AddressStat, 0, len(statMap))
+	for _, stat := range statMap {
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if !stats[i].LastSeen.Equal(stats[j].LastSeen) {
+			return stats[i].LastSeen.After(stats[j].LastSeen)
+		}
+		return stats[i].Address < stats[j].Address
+	})
+	return stats
+}
+
+// accessLogPosition 访问日志已读取到的位置。连接记录在进程内一直保留，
+// 服务器重启后从上次的位置继续跟踪，不再重复导入历史记录
+var accessLogPosition struct {
+	lock   sync.Mutex
+	path   string
+	offset int64
+}
+
+// AccessLogTailer 跟踪Xray访问日志并记录客户端连接
+type AccessLogTailer struct {
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewAccessLogTailer 创建访问日志跟踪任务
+func NewAccessLogTailer() *AccessLogTailer {
+	return &AccessLogTailer{}
+}
+
+// Start 开始跟踪Xray配置模板中设置的访问日志，未设置访问日志时返回错误，ctx取消时停止跟踪
+func (t *AccessLogTailer) Start(ctx context.Context) error {
+	logService := LogService{}
+	path, err := logService.GetXrayLogPath(LogSourceXrayAccess)
+	if err != nil {
+		return err
+	}
+	settingService := SettingService{}
+	hours, err := settingService.GetAccessLogRetention()
+	if err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.cancel != nil {
+		return errors.New("访问日志跟踪已启动")
+	}
+
+	connections.lock.Lock()
+	connections.retention = time.Duration(hours) * time.Hour
+	connections.lock.Unlock()
+
+	// 首次跟踪该文件时先导入末尾的历史记录，再跟踪新增内容
+	accessLogPosition.lock.Lock()
+	offset := accessLogPosition.offset
+	if accessLogPosition.path != path {
+		var lines []string
+		lines, offset, err = readTailLines(path, accessLogSeedSize)
+		if err != nil {
+			logger.Warning("读取访问日志失败:", err)
+			offset = -1
+		}
+		for _, line := range lines {
+			if conn, ok := parseAccessLogLine(line); ok {
+				connections.add(conn)
+			}
+		}
+		accessLogPosition.path = path
+		accessLogPosition.offset = offset
+	}
+	accessLogPosition.lock.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	t.cancel = cancel
+	t.done = make(chan struct{})
+	go t.run(ctx, t.done, path, offset)
+	logger.Infof("开始跟踪访问日志 %s", path)
+	return nil
+}
+
+// Stop 停止跟踪访问日志
+func (t *AccessLogTailer) Stop() {
+	t.lock.Lock()
+	cancel, done := t.cancel, t.done
+	t.cancel = nil
+	t.lock.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (t *AccessLogTailer) run(ctx context.Context, done chan struct{}, path string, offset int64) {
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				connections.prune()
+			}
+		}
+	}()
+
+	offset = tailFile(ctx, path, offset, func(line string) {
+		if conn, ok := parseAccessLogLine(line); ok {
+			connections.add(conn)
+		}
+	})
+	accessLogPosition.lock.Lock()
+	if accessLogPosition.path == path {
+		accessLogPosition.offset = offset
+	}
+	accessLogPosition.lock.Unlock()
+}
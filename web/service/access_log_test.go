@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// resetConnections 清空进程内的连接记录和访问日志位置
+func resetConnections(t *testing.T) {
+	t.Helper()
+	connections.lock.Lock()
+	connections.byEmail = map[string][]*ClientConnection{}
+	connections.lock.Unlock()
+	accessLogPosition.lock.Lock()
+	accessLogPosition.path = ""
+	accessLogPosition.offset = 0
+	accessLogPosition.lock.Unlock()
+}
+
+// accessLogLine 生成一行Xray访问日志
+func accessLogLine(t time.Time, ip string, email string) string {
+	return fmt.Sprintf("%s from %s:50000 accepted tcp:example.com:443 [inbound-443 -> direct] email: %s\n",
+		t.Format("2006/01/02 15:04:05.000000"), ip, email)
+}
+
+func TestConnectionStoreOrder(t *testing.T) {
+	resetConnections(t)
+	now := time.Now().Truncate(time.Second)
+	for _, offset := range []int{-5, -1, -3, -4, -2} {
+		connections.add(&ClientConnection{Time: now.Add(time.Duration(offset) * time.Minute), Email: "alice", SourceIP: "1.1.1.1"})
+	}
+	list := connections.since("alice", now.Add(-3*time.Minute))
+	if len(list) != 3 {
+		t.Fatalf("最近3分钟应有3条记录，实际 %d 条", len(list))
+	}
+	for i := 1; i < len(list); i++ {
+		if list[i].Time.Before(list[i-1].Time) {
+			t.Fatal("连接记录未按时间排序")
+		}
+	}
+}
+
+// 服务器重启时重新启动跟踪，不能重复导入已经导入过的历史记录
+func TestAccessLogTailerRestart(t *testing.T) {
+	dir := setupTestDB(t)
+	resetConnections(t)
+	logPath := filepath.Join(dir, "access.log")
+	settingService := SettingService{}
+	err := settingService.SetXrayConfigTemplate(fmt.Sprintf(`{"log":{"access":%q},"inbounds":[],"outbounds":[]}`, logPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	err = os.WriteFile(logPath, []byte(
+		accessLogLine(now.Add(-2*time.Minute), "1.1.1.1", "alice")+
+			accessLogLine(now.Add(-time.Minute), "2.2.2.2", "alice")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		tailer := NewAccessLogTailer()
+		err = tailer.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tailer.Stop()
+	}
+	if list := connections.since("alice", now.Add(-time.Hour)); len(list) != 2 {
+		t.Fatalf("重启跟踪后应有2条记录，实际 %d 条", len(list))
+	}
+
+	// 停止期间写入的日志在下次启动后读取
+	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(accessLogLine(now, "3.3.3.3", "alice"))
+	file.Close()
+
+	tailer := NewAccessLogTailer()
+	err = tailer.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tailer.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list := connections.since("alice", now.Add(-time.Hour))
+		if len(list) == 3 && list[2].SourceIP == "3.3.3.3" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("记录应为3条且最后一条来自3.3.3.3，实际 %d 条", len(list))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"mx-ui/config"
+	"mx-ui/database"
+	"mx-ui/logger"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 备份文件名前缀和后缀
+const (
+	backupFilePrefix = "mx-ui-"
+	backupFileSuffix = ".db"
+)
+
+// BackupService 数据库备份相关服务
+type BackupService struct{}
+
+// CreateBackup 在dir目录下创建一份带时间戳的数据库备份，返回备份文件路径
+func (s *BackupService) CreateBackup(dir string) (string, error) {
+	name := backupFilePrefix + time.Now().Format("20060102-150405") + backupFileSuffix
+	backupPath := filepath.Join(dir, name)
+	// 同一秒内重复备份时删除旧文件，VACUUM INTO 要求目标不存在
+	os.Remove(backupPath)
+	err := database.Backup(backupPath)
+	if err != nil {
+		return "", err
+	}
+	return backupPath, nil
+}
+
+// CreateTempBackup 在临时目录创建一份备份，用于下载
+func (s *BackupService) CreateTempBackup() (string, error) {
+	return s.CreateBackup(config.GetTempPath())
+}
+
+// restoreCh 通知主循环恢复已暂存的备份
+var restoreCh = make(chan struct{}, 1)
+
+// RestoreRequests 返回恢复数据库的通知通道。收到通知后主循环停止服务器和后台任务，
+// 调用 database.ApplyPendingRestore 替换数据库，再重新启动
+func RestoreRequests() <-chan struct{} {
+	return restoreCh
+}
+
+// Restore 从reader读取备份文件，校验后暂存，并通知主循环在停止服务器和后台任务后替换当前数据库
+func (s *BackupService) Restore(reader io.Reader) error {
+	tmpFile, err := os.CreateTemp(config.GetTempPath(), "restore-*.db")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	_, err = io.Copy(tmpFile, reader)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	err = database.StageRestore(tmpPath)
+	if err != nil {
+		return err
+	}
+	logger.Info("备份已校验，将在停止服务器后恢复数据库")
+	select {
+	case restoreCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// RestoreFile 使用指定的备份文件替换当前数据库，只能在面板未运行时调用
+func (s *BackupService) RestoreFile(backupPath string) error {
+	err := database.Restore(backupPath)
+	if err != nil {
+		return err
+	}
+	logger.Info("数据库已从备份恢复")
+	return nil
+}
+
+// LastBackupTime 获取dir目录下最近一次备份的时间，没有备份时返回零值
+func (s *BackupService) LastBackupTime(dir string) time.Time {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return time.Time{}
+	}
+	var last time.Time
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupFilePrefix) || !strings.HasSuffix(name, backupFileSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last
+}
+
+// RunScheduledBackup 执行一次定时备份：保存到本地、清理旧备份并按设置发送到外部
+func (s *BackupService) RunScheduledBackup() error {
+	settingService := SettingService{}
+	backupDir, err := settingService.GetBackupDir()
+	if err != nil {
+		return err
+	}
+	keep, err := settingService.GetBackupKeep()
+	if err != nil {
+		return err
+	}
+
+	backupPath, err := s.CreateBackup(backupDir)
+	if err != nil {
+		return err
+	}
+	logger.Info("已创建数据库备份:", backupPath)
+
+	err = s.cleanBackups(backupDir, keep)
+	if err != nil {
+		logger.Warning("清理旧备份失败:", err)
+	}
+
+	webhook, err := settingService.GetBackupWebhook()
+	if err == nil && webhook != "" {
+		err = s.sendToWebhook(webhook, backupPath)
+		if err != nil {
+			logger.Warning("发送备份到Webhook失败:", err)
+		}
+	}
+
+	tgEnable, err := settingService.GetBackupTgEnable()
+	if err == nil && tgEnable {
+		err = s.sendToTelegram(backupPath)
+		if err != nil {
+			logger.Warning("发送备份到Telegram失败:", err)
+		}
+	}
+	return nil
+}
+
+// cleanBackups 只保留最近的keep个备份
+func (s *BackupService) cleanBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupFilePrefix) || !strings.HasSuffix(name, backupFileSuffix) {
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) <= keep {
+		return nil
+	}
+
+	// 文件名中的时间戳保证按名称排序即按时间排序
+	sort.Strings(names)
+	for _, name := range names[:len(names)-keep] {
+		err = os.Remove(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendToWebhook 以multipart表单的file字段将备份上传到webhook
+func (s *BackupService) sendToWebhook(webhook string, backupPath string) error {
+	file, err := os.Open(backupPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filepath.Base(backupPath))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sendToTelegram 将备份发送到所有白名单会话
+func (s *BackupService) sendToTelegram(backupPath string) error {
+	tgBot, err := NewTgbot()
+	if err != nil {
+		return err
+	}
+	caption := fmt.Sprintf("%s 数据库备份 %s", config.GetName(), time.Now().Format("2006-01-02 15:04:05"))
+	for _, chatID := range tgBot.GetChatIDs() {
+		err = tgBot.SendDocument(chatID, backupPath, caption)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BackupJob 定时备份任务
+type BackupJob struct {
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewBackupJob 创建定时备份任务
+func NewBackupJob() *BackupJob {
+	return &BackupJob{}
+}
+
+// Start 按设置的间隔启动定时备份，间隔为0时不启动，ctx取消时停止。
+// 下一次备份的时间根据备份目录中最近一次备份计算，重启服务器不会推迟定时备份
+func (j *BackupJob) Start(ctx context.Context) error {
+	settingService := SettingService{}
+	hours, err := settingService.GetBackupInterval()
+	if err != nil {
+		return err
+	}
+	if hours <= 0 {
+		return nil
+	}
+	backupDir, err := settingService.GetBackupDir()
+	if err != nil {
+		return err
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.cancel != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	j.cancel = cancel
+	j.done = make(chan struct{})
+	go j.run(ctx, j.done, backupDir, time.Duration(hours)*time.Hour)
+	logger.Infof("定时备份已启动，间隔 %d 小时", hours)
+	return nil
+}
+
+// Stop 停止定时备份
+func (j *BackupJob) Stop() {
+	j.lock.Lock()
+	cancel, done := j.cancel, j.done
+	j.cancel = nil
+	j.lock.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (j *BackupJob) run(ctx context.Context, done chan struct{}, backupDir string, interval time.Duration) {
+	defer close(done)
+
+	backupService := BackupService{}
+	for {
+		wait := time.Until(backupService.LastBackupTime(backupDir).Add(interval))
+		if wait < 0 {
+			wait = 0
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		err := backupService.RunScheduledBackup()
+		if err != nil {
+			logger.Error("定时备份失败:", err)
+			// 失败时等待一个间隔后重试，避免持续重试
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"mx-ui/database"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// backupNames 获取dir目录下的文件名
+func backupNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestCleanBackups(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"mx-ui-20240101-000000.db",
+		"mx-ui-20240103-000000.db",
+		"mx-ui-20240102-000000.db",
+		"mx-ui-20240104-000000.db",
+		"notes.txt",
+		"mx-ui-20240101-000000.db.bak",
+	}
+	for _, name := range files {
+		err := os.WriteFile(filepath.Join(dir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	backupService := BackupService{}
+
+	// 按文件名中的时间保留最近的备份，不处理其他文件
+	err := backupService.cleanBackups(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"mx-ui-20240101-000000.db.bak", "mx-ui-20240103-000000.db", "mx-ui-20240104-000000.db", "notes.txt"}
+	if got := backupNames(t, dir); !slices.Equal(got, want) {
+		t.Errorf("清理后的文件为 %q，期望 %q", got, want)
+	}
+	err = backupService.cleanBackups(dir, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := backupNames(t, dir); len(got) != len(want) {
+		t.Errorf("备份数量未超过保留数量时不应删除，实际为 %q", got)
+	}
+}
+
+func TestRunScheduledBackup(t *testing.T) {
+	setupTestDB(t)
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		received, _ = io.ReadAll(file)
+	}))
+	defer server.Close()
+
+	backupDir := filepath.Join(t.TempDir(), "backups")
+	settingService := SettingService{}
+	for _, err := range []error{
+		settingService.SetBackupDir(backupDir),
+		settingService.SetBackupKeep(1),
+		settingService.SetBackupWebhook(server.URL),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.MkdirAll(backupDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(backupDir, "mx-ui-20000101-000000.db"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backupService := BackupService{}
+	err = backupService.RunScheduledBackup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := backupNames(t, backupDir)
+	if len(names) != 1 || names[0] == "mx-ui-20000101-000000.db" {
+		t.Fatalf("备份目录中的文件为 %q，应只保留新的备份", names)
+	}
+	backupPath := filepath.Join(backupDir, names[0])
+	err = database.CheckBackup(backupPath)
+	if err != nil {
+		t.Errorf("备份应通过检查: %v", err)
+	}
+	saved, err := os.ReadFile(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, saved) {
+		t.Errorf("Webhook收到 %d 字节，备份为 %d 字节", len(received), len(saved))
+	}
+	if last := backupService.LastBackupTime(backupDir); last.IsZero() {
+		t.Error("应能获取最近一次备份的时间")
+	}
+}
+
+func TestRestoreFromUpload(t *testing.T) {
+	setupTestDB(t)
+	createTestInbound(t, &database.InboundConfig{Protocol: "vless", Tag: "a", Port: 443})
+	backupService := BackupService{}
+	backupPath, err := backupService.CreateTempBackup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTestInbound(t, &database.InboundConfig{Protocol: "trojan", Tag: "b", Port: 8443})
+	for len(restoreCh) > 0 {
+		<-restoreCh
+	}
+
+	// 无效的文件不暂存，也不通知主循环
+	err = backupService.Restore(strings.NewReader(strings.Repeat("not a database", 100)))
+	if err == nil {
+		t.Fatal("无效的备份应返回错误")
+	}
+	if len(restoreCh) != 0 {
+		t.Error("备份无效时不应通知恢复")
+	}
+
+	backup, err := os.Open(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	err = backupService.Restore(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-RestoreRequests():
+	default:
+		t.Fatal("备份暂存后应通知主循环恢复")
+	}
+
+	// 主循环停止服务后应用暂存的备份
+	restored, err := database.ApplyPendingRestore()
+	if err != nil || !restored {
+		t.Fatalf("应用暂存的备份时结果为 %v %v", restored, err)
+	}
+	inboundService := InboundService{}
+	inbounds, err := inboundService.GetInbounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbounds) != 1 || inbounds[0].Tag != "a" {
+		t.Errorf("恢复后的入站为 %+v", inbounds)
+	}
+}
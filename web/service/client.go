@@ -0,0 +1,237 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"mx-ui/database"
+	"strings"
+	"time"
+)
+
+// 客户端带有邮箱、可以按用户匹配路由规则的入站协议
+var userRoutingProtocols = map[string]bool{
+	"vmess":       true,
+	"vless":       true,
+	"trojan":      true,
+	"shadowsocks": true,
+}
+
+// ClientService 客户端相关服务
+type ClientService struct{}
+
+// GetClients 获取客户端列表，inboundID为0时返回全部
+func (s *ClientService) GetClients(inboundID uint) ([]*database.ClientConfig, error) {
+	var clients []*database.ClientConfig
+	db := database.GetDB().Order("id ASC")
+	if inboundID > 0 {
+		db = db.Where("inbound_id = ?", inboundID)
+	}
+	err := db.Find(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// GetClient 根据ID获取客户端
+func (s *ClientService) GetClient(id uint) (*database.ClientConfig, error) {
+	client := &database.ClientConfig{}
+	err := database.GetDB().First(client, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// GetClientByEmail 根据邮箱获取客户端
+func (s *ClientService) GetClientByEmail(email string) (*database.ClientConfig, error) {
+	client := &database.ClientConfig{}
+	err := database.GetDB().Where("email = ?", email).First(client).Error
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// GetClientBySubID 根据订阅ID获取客户端
+func (s *ClientService) GetClientBySubID(subID string) (*database.ClientConfig, error) {
+	client := &database.ClientConfig{}
+	err := database.GetDB().Where("sub_id = ?", subID).First(client).Error
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// AddClient 添加客户端，生成的Xray配置检查不通过时拒绝
+func (s *ClientService) AddClient(client *database.ClientConfig) error {
+	err := s.checkClient(client)
+	if err != nil {
+		return err
+	}
+	if client.UUID == "" {
+		client.UUID = randomUUID()
+	}
+	if client.SubID == "" {
+		client.SubID = randomString(16)
+	}
+	err = checkConfigChange(func(source *configSource) {
+		source.setClient(client)
+	})
+	if err != nil {
+		return err
+	}
+	return database.GetDB().Create(client).Error
+}
+
+// UpdateClient 更新客户端，生成的Xray配置检查不通过时拒绝
+func (s *ClientService) UpdateClient(client *database.ClientConfig) error {
+	old, err := s.GetClient(client.ID)
+	if err != nil {
+		return err
+	}
+	err = s.checkClient(client)
+	if err != nil {
+		return err
+	}
+
+	old.InboundID = client.InboundID
+	old.Email = client.Email
+	if client.UUID != "" {
+		old.UUID = client.UUID
+	}
+	old.Enable = client.Enable
+	old.ExpiryTime = client.ExpiryTime
+	old.Limit = client.Limit
+	old.Remark = client.Remark
+	old.LimitIP = client.LimitIP
+	old.OutboundTag = client.OutboundTag
+	err = checkConfigChange(func(source *configSource) {
+		source.setClient(old)
+	})
+	if err != nil {
+		return err
+	}
+	return database.GetDB().Save(old).Error
+}
+
+// DelClient 删除客户端
+func (s *ClientService) DelClient(id uint) error {
+	return database.GetDB().Delete(&database.ClientConfig{}, id).Error
+}
+
+// SetClientEnable 启用或禁用客户端
+func (s *ClientService) SetClientEnable(id uint, enable bool) error {
+	updates := map[string]interface{}{"enable": enable}
+	if enable {
+		// 手动启用时同时解除因超出IP限制的临时禁用
+		updates["disabled_until"] = 0
+	}
+	return database.GetDB().Model(&database.ClientConfig{}).
+		Where("id = ?", id).
+		Updates(updates).Error
+}
+
+// ResetClientTraffic 重置客户端已用流量
+func (s *ClientService) ResetClientTraffic(id uint) error {
+	return database.GetDB().Model(&database.ClientConfig{}).
+		Where("id = ?", id).
+		Update("used", 0).Error
+}
+
+// ExtendClient 将客户端的到期时间延长days天，已过期时从当前时间开始计算，返回新的到期时间（毫秒）
+func (s *ClientService) ExtendClient(id uint, days int) (int64, error) {
+	if days <= 0 {
+		return 0, errors.New("延长天数必须大于0")
+	}
+	client, err := s.GetClient(id)
+	if err != nil {
+		return 0, err
+	}
+	if client.ExpiryTime <= 0 {
+		return 0, errors.New("客户端未设置到期时间")
+	}
+
+	start := client.ExpiryTime
+	if now := time.Now().UnixMilli(); start < now {
+		start = now
+	}
+	expiry := start + int64(days)*24*int64(time.Hour/time.Millisecond)
+	err = database.GetDB().Model(&database.ClientConfig{}).
+		Where("id = ?", id).
+		Update("expiry_time", expiry).Error
+	if err != nil {
+		return 0, err
+	}
+	return expiry, nil
+}
+
+// GetSubLink 获取客户端的订阅链接
+func (s *ClientService) GetSubLink(client *database.ClientConfig) (string, error) {
+	settingService := SettingService{}
+	subURI, err := settingService.GetSubURI()
+	if err != nil {
+		return "", err
+	}
+	if subURI == "" {
+		return "", errors.New("未设置订阅地址")
+	}
+	if !strings.HasSuffix(subURI, "/") {
+		subURI += "/"
+	}
+	return subURI + client.SubID, nil
+}
+
+// IsClientValid 判断客户端当前是否可用（已启用、未过期、未超出流量限制且未被临时禁用）
+func (s *ClientService) IsClientValid(client *database.ClientConfig) bool {
+	if !client.Enable {
+		return false
+	}
+	if client.ExpiryTime > 0 && client.ExpiryTime <= time.Now().UnixMilli() {
+		return false
+	}
+	if client.Limit > 0 && client.Used >= client.Limit {
+		return false
+	}
+	if client.DisabledUntil > time.Now().UnixMilli() {
+		return false
+	}
+	return true
+}
+
+// checkClient 检查客户端参数及邮箱是否冲突
+func (s *ClientService) checkClient(client *database.ClientConfig) error {
+	client.Email = strings.TrimSpace(client.Email)
+	if client.Email == "" {
+		return errors.New("邮箱不能为空")
+	}
+	if client.LimitIP < 0 {
+		return errors.New("IP数量限制不能为负数")
+	}
+
+	inboundService := InboundService{}
+	inbound, err := inboundService.GetInbound(client.InboundID)
+	if err != nil {
+		return errors.New("入站不存在")
+	}
+
+	var count int64
+	err = database.GetDB().Model(&database.ClientConfig{}).
+		Where("id <> ? AND email = ?", client.ID, client.Email).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("邮箱已被其他客户端使用")
+	}
+
+	client.OutboundTag = strings.TrimSpace(client.OutboundTag)
+	if client.OutboundTag == "" {
+		return nil
+	}
+	if !userRoutingProtocols[inbound.Protocol] {
+		return fmt.Errorf("%s 入站的客户端没有邮箱，不能指定出站", inbound.Protocol)
+	}
+	return nil
+}
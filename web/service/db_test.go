@@ -0,0 +1,53 @@
+package service
+
+import (
+	"mx-ui/config"
+	"mx-ui/database"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupTestDB 在临时目录中初始化数据库，并把工作目录和数据目录切换到该目录，
+// Xray二进制文件路径 bin/ 因此也位于临时目录中
+func setupTestDB(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	dataDir := config.DataDirPath
+	config.DataDirPath = dir
+	err := os.MkdirAll(config.GetTempPath(), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.InitDB(filepath.Join(dir, config.DBName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		database.CloseDB()
+		config.DataDirPath = dataDir
+	})
+	return dir
+}
+
+// createTestInbound 直接在数据库中创建入站
+func createTestInbound(t *testing.T, inbound *database.InboundConfig) *database.InboundConfig {
+	t.Helper()
+	err := database.GetDB().Create(inbound).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inbound
+}
+
+// createTestClient 直接在数据库中创建客户端
+func createTestClient(t *testing.T, client *database.ClientConfig) *database.ClientConfig {
+	t.Helper()
+	err := database.GetDB().Create(client).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
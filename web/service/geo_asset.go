@@ -0,0 +1,498 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mx-ui/config"
+	"mx-ui/logger"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/app/router"
+	"google.golang.org/protobuf/proto"
+)
+
+// 上传或下载的geo数据文件大小上限
+const maxGeoAssetSize = 100 << 20
+
+// 下载geo数据文件的超时时间
+const geoDownloadTimeout = 10 * time.Minute
+
+// 可以从下载地址更新的geo数据文件，其他 .dat 文件为上传的自定义规则集
+var geoAssetNames = []string{"geoip.dat", "geosite.dat"}
+
+var geoAssetNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+\.dat$`)
+
+var geoAssetLock sync.Mutex
+
+// GeoAsset Xray使用的geo数据文件信息
+type GeoAsset struct {
+	Name    string    `json:"name"`
+	Exists  bool      `json:"exists"`
+	Custom  bool      `json:"custom"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// GeoAssetService geo数据文件管理相关服务。文件放在Xray所在的目录，Xray默认从这里读取
+type GeoAssetService struct{}
+
+// GetAssets 获取geoip.dat、geosite.dat和上传的自定义规则集，geoip.dat和geosite.dat不存在时也会列出
+func (s *GeoAssetService) GetAssets() ([]*GeoAsset, error) {
+	names := map[string]bool{}
+	for _, name := range geoAssetNames {
+		names[name] = true
+	}
+	entries, err := os.ReadDir(config.GetBinFolderPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && geoAssetNamePattern.MatchString(entry.Name()) {
+			names[entry.Name()] = true
+		}
+	}
+
+	assets := make([]*GeoAsset, 0, len(names))
+	for name := range names {
+		asset, err := getGeoAsset(name)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		if assets[i].Custom != assets[j].Custom {
+			return !assets[i].Custom
+		}
+		return assets[i].Name < assets[j].Name
+	})
+	return assets, nil
+}
+
+// Update 从设置的下载地址更新geoip.dat和geosite.dat，每个文件用下载地址加 .sha256sum 的摘要文件校验。
+// 有文件变化时重启Xray，返回变化的文件名
+func (s *GeoAssetService) Update() ([]string, error) {
+	geoAssetLock.Lock()
+	defer geoAssetLock.Unlock()
+
+	settingService := SettingService{}
+	mirror, err := settingService.GetGeoMirrorURL()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), geoDownloadTimeout)
+	defer cancel()
+
+	files := map[string]string{}
+	defer func() {
+		for _, newPath := range files {
+			os.Remove(newPath)
+		}
+	}()
+	for _, name := range geoAssetNames {
+		url := strings.ReplaceAll(mirror, "{name}", name)
+		newPath, changed, err := downloadGeoAsset(ctx, url, name)
+		if err != nil {
+			return nil, fmt.Errorf("更新 %s 失败: %v", name, err)
+		}
+		if changed {
+			files[name] = newPath
+		}
+	}
+	if len(files) == 0 {
+		logger.Info("geo数据文件已是最新")
+		return []string{}, nil
+	}
+
+	err = replaceGeoAssets(files)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	logger.Info("已更新geo数据文件:", strings.Join(names, ", "))
+	return names, nil
+}
+
+// Upload 上传自定义的 .dat 规则集，在路由规则中以 ext:<文件名>:<代码> 引用。
+// 也可以上传geoip.dat和geosite.dat替换当前的文件
+func (s *GeoAssetService) Upload(name string, reader io.Reader) (*GeoAsset, error) {
+	name = filepath.Base(strings.TrimSpace(name))
+	if !geoAssetNamePattern.MatchString(name) {
+		return nil, errors.New("文件名只能包含字母、数字、点、下划线和横线，并以 .dat 结尾")
+	}
+
+	geoAssetLock.Lock()
+	defer geoAssetLock.Unlock()
+
+	newPath := geoAssetPath(name) + ".new"
+	_, err := saveGeoAsset(reader, newPath)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(newPath)
+	err = checkGeoAsset(newPath, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = replaceGeoAssets(map[string]string{name: newPath})
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("已上传geo数据文件:", name)
+	return getGeoAsset(name)
+}
+
+// Delete 删除上传的自定义规则集，仍被路由规则引用时Xray配置检查不通过，不会删除
+func (s *GeoAssetService) Delete(name string) error {
+	name = filepath.Base(name)
+	if isBuiltinGeoAsset(name) {
+		return fmt.Errorf("不能删除 %s", name)
+	}
+	if !geoAssetNamePattern.MatchString(name) {
+		return errors.New("文件名无效")
+	}
+
+	geoAssetLock.Lock()
+	defer geoAssetLock.Unlock()
+
+	if _, err := os.Stat(geoAssetPath(name)); err != nil {
+		return fmt.Errorf("%s 不存在", name)
+	}
+	err := replaceGeoAssets(map[string]string{name: ""})
+	if err != nil {
+		return err
+	}
+	logger.Info("已删除geo数据文件:", name)
+	return nil
+}
+
+// replaceGeoAssets 用新文件替换geo数据文件，路径为空表示删除，然后重启Xray。
+// Xray未运行时只检查配置。检查或启动失败时恢复原来的文件
+func replaceGeoAssets(files map[string]string) error {
+	replaced := []string{}
+	restore := func() {
+		for _, name := range replaced {
+			path := geoAssetPath(name)
+			os.Remove(path)
+			os.Rename(path+".bak", path)
+		}
+	}
+
+	for name, newPath := range files {
+		path := geoAssetPath(name)
+		if _, err := os.Stat(path); err == nil {
+			err = os.Rename(path, path+".bak")
+			if err != nil {
+				restore()
+				return err
+			}
+		}
+		replaced = append(replaced, name)
+		if newPath == "" {
+			continue
+		}
+		err := os.Rename(newPath, path)
+		if err != nil {
+			restore()
+			return err
+		}
+	}
+
+	xrayService := XrayService{}
+	running := xrayService.IsXrayRunning()
+	var err error
+	if running {
+		err = xrayService.RestartXray(true)
+	} else {
+		err = checkConfigChange(func(source *configSource) {})
+	}
+	if err != nil {
+		restore()
+		if running && !xrayService.IsXrayRunning() {
+			xrayService.RestartXray(true)
+		}
+		return err
+	}
+
+	for _, name := range replaced {
+		os.Remove(geoAssetPath(name) + ".bak")
+	}
+	return nil
+}
+
+// downloadGeoAsset 下载geo数据文件到临时路径并校验SHA256，与当前文件相同时删除临时文件并返回changed为false
+func downloadGeoAsset(ctx context.Context, url string, name string) (string, bool, error) {
+	checksum, err := fetchSHA256Sum(ctx, url+".sha256sum")
+	if err != nil {
+		return "", false, fmt.Errorf("获取SHA256摘要失败: %v", err)
+	}
+	current, err := getGeoAsset(name)
+	if err != nil {
+		return "", false, err
+	}
+	if current.Exists && current.SHA256 == checksum {
+		return "", false, nil
+	}
+
+	body, err := httpGet(ctx, url)
+	if err != nil {
+		return "", false, err
+	}
+	defer body.Close()
+	newPath := geoAssetPath(name) + ".new"
+	sum, err := saveGeoAsset(body, newPath)
+	if err != nil {
+		return "", false, err
+	}
+	err = verifyChecksum(sum, checksum)
+	if err == nil {
+		err = checkGeoAsset(newPath, name)
+	}
+	if err != nil {
+		os.Remove(newPath)
+		return "", false, err
+	}
+	return newPath, true, nil
+}
+
+// fetchSHA256Sum 下载sha256sum格式的摘要文件，返回第一行的SHA256
+func fetchSHA256Sum(ctx context.Context, url string) (string, error) {
+	body, err := httpGet(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	scanner := bufio.NewScanner(io.LimitReader(body, 64<<10))
+	if scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && len(fields[0]) == sha256.Size*2 {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("摘要文件格式无效")
+}
+
+// saveGeoAsset 保存geo数据文件，返回SHA256
+func saveGeoAsset(reader io.Reader, path string) (string, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(reader, maxGeoAssetSize+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > maxGeoAssetSize {
+		err = fmt.Errorf("文件超过 %d MB", maxGeoAssetSize>>20)
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// checkGeoAsset 检查文件的格式：geoip.dat必须为geoip格式，geosite.dat必须为geosite格式，
+// 自定义规则集可以是两者之一
+func checkGeoAsset(path string, name string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch name {
+	case "geoip.dat":
+		if !isGeoIPList(data) {
+			return errors.New("不是有效的geoip数据文件")
+		}
+	case "geosite.dat":
+		if !isGeoSiteList(data) {
+			return errors.New("不是有效的geosite数据文件")
+		}
+	default:
+		if !isGeoIPList(data) && !isGeoSiteList(data) {
+			return errors.New("不是有效的geo数据文件")
+		}
+	}
+	return nil
+}
+
+// isGeoIPList 数据是否为geoip格式。两种格式的条目都以代码开头，
+// 按另一种格式解析时字段类型不符，IP地址或域名会为空，以此区分
+func isGeoIPList(data []byte) bool {
+	list := &router.GeoIPList{}
+	err := proto.Unmarshal(data, list)
+	if err != nil || len(list.Entry) == 0 {
+		return false
+	}
+	for _, entry := range list.Entry {
+		if entry.CountryCode == "" {
+			return false
+		}
+		for _, cidr := range entry.Cidr {
+			if len(cidr.Ip) != 4 && len(cidr.Ip) != 16 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isGeoSiteList 数据是否为geosite格式
+func isGeoSiteList(data []byte) bool {
+	list := &router.GeoSiteList{}
+	err := proto.Unmarshal(data, list)
+	if err != nil || len(list.Entry) == 0 {
+		return false
+	}
+	for _, entry := range list.Entry {
+		if entry.CountryCode == "" {
+			return false
+		}
+		for _, domain := range entry.Domain {
+			if domain.Value == "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// getGeoAsset 获取geo数据文件的信息
+func getGeoAsset(name string) (*GeoAsset, error) {
+	asset := &GeoAsset{
+		Name:   name,
+		Custom: !isBuiltinGeoAsset(name),
+	}
+	file, err := os.Open(geoAssetPath(name))
+	if os.IsNotExist(err) {
+		return asset, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return nil, err
+	}
+	asset.Exists = true
+	asset.Size = info.Size()
+	asset.ModTime = info.ModTime()
+	asset.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return asset, nil
+}
+
+// isBuiltinGeoAsset 是否为可以从下载地址更新的geoip.dat或geosite.dat
+func isBuiltinGeoAsset(name string) bool {
+	for _, builtin := range geoAssetNames {
+		if name == builtin {
+			return true
+		}
+	}
+	return false
+}
+
+// geoAssetPath geo数据文件的路径
+func geoAssetPath(name string) string {
+	return filepath.Join(config.GetBinFolderPath(), name)
+}
+
+// GeoAssetJob 定时更新geo数据文件
+type GeoAssetJob struct {
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewGeoAssetJob 创建geo数据文件定时更新任务
+func NewGeoAssetJob() *GeoAssetJob {
+	return &GeoAssetJob{}
+}
+
+// Start 按设置的间隔启动定时更新，间隔为0时不启动，ctx取消时停止
+func (j *GeoAssetJob) Start(ctx context.Context) error {
+	settingService := SettingService{}
+	hours, err := settingService.GetGeoUpdateInterval()
+	if err != nil {
+		return err
+	}
+	if hours <= 0 {
+		return nil
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.cancel != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	j.cancel = cancel
+	j.done = make(chan struct{})
+	go j.run(ctx, j.done, time.Duration(hours)*time.Hour)
+	logger.Infof("geo数据文件定时更新已启动，间隔 %d 小时", hours)
+	return nil
+}
+
+// Stop 停止定时更新
+func (j *GeoAssetJob) Stop() {
+	j.lock.Lock()
+	cancel, done := j.cancel, j.done
+	j.cancel = nil
+	j.lock.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (j *GeoAssetJob) run(ctx context.Context, done chan struct{}, interval time.Duration) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			geoAssetService := GeoAssetService{}
+			_, err := geoAssetService.Update()
+			if err != nil {
+				logger.Error("定时更新geo数据文件失败:", err)
+			}
+		}
+	}
+}
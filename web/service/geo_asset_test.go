@@ -0,0 +1,310 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/xtls/xray-core/app/router"
+	"google.golang.org/protobuf/proto"
+)
+
+// geoIPData 生成包含一个代码的geoip数据文件
+func geoIPData(t *testing.T, code string) []byte {
+	t.Helper()
+	data, err := proto.Marshal(&router.GeoIPList{Entry: []*router.GeoIP{{
+		CountryCode: code,
+		Cidr:        []*router.CIDR{{Ip: []byte{10, 0, 0, 0}, Prefix: 8}, {Ip: make([]byte, 16), Prefix: 128}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// geoSiteData 生成包含一个代码的geosite数据文件
+func geoSiteData(t *testing.T, code string) []byte {
+	t.Helper()
+	data, err := proto.Marshal(&router.GeoSiteList{Entry: []*router.GeoSite{{
+		CountryCode: code,
+		Domain: []*router.Domain{
+			{Type: router.Domain_Plain, Value: "ads"},
+			{Type: router.Domain_Domain, Value: "example.com"},
+		},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// readGeoAsset 读取geo数据文件的内容，不存在时返回nil
+func readGeoAsset(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(geoAssetPath(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// assertNoTempGeoAssets 检查没有遗留的 .new 和 .bak 文件
+func assertNoTempGeoAssets(t *testing.T) {
+	t.Helper()
+	for _, pattern := range []string{"*.new", "*.bak"} {
+		matches, err := filepath.Glob(geoAssetPath(pattern))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) > 0 {
+			t.Errorf("遗留的临时文件: %q", matches)
+		}
+	}
+}
+
+func TestCheckGeoAsset(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		file string
+		data []byte
+		err  string
+	}{
+		{name: "geoip", file: "geoip.dat", data: geoIPData(t, "CN")},
+		{name: "geosite", file: "geosite.dat", data: geoSiteData(t, "CN")},
+		{name: "geosite格式的geoip.dat", file: "geoip.dat", data: geoSiteData(t, "CN"), err: "不是有效的geoip数据文件"},
+		{name: "geoip格式的geosite.dat", file: "geosite.dat", data: geoIPData(t, "CN"), err: "不是有效的geosite数据文件"},
+		{name: "geoip格式的自定义规则集", file: "ip.dat", data: geoIPData(t, "CN")},
+		{name: "geosite格式的自定义规则集", file: "h2y.dat", data: geoSiteData(t, "GFW")},
+		{name: "不是protobuf", file: "ip.dat", data: []byte("not a dat file"), err: "不是有效的geo数据文件"},
+		{name: "空文件", file: "ip.dat", data: nil, err: "不是有效的geo数据文件"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, test.file)
+			err := os.WriteFile(path, test.data, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = checkGeoAsset(path, test.file)
+			if test.err == "" && err != nil {
+				t.Fatalf("检查应通过，错误为 %v", err)
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Fatalf("错误为 %v，期望 %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestUploadAndDeleteGeoAsset(t *testing.T) {
+	setupTestDB(t)
+	geoAssetService := GeoAssetService{}
+
+	asset, err := geoAssetService.Upload("h2y.dat", bytes.NewReader(geoSiteData(t, "GFW")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !asset.Exists || !asset.Custom || asset.Name != "h2y.dat" {
+		t.Errorf("上传的文件为 %+v", asset)
+	}
+	_, err = geoAssetService.Upload("geoip.dat", bytes.NewReader(geoIPData(t, "CN")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = geoAssetService.Upload("geosite.dat", bytes.NewReader(geoIPData(t, "CN")))
+	if err == nil || err.Error() != "不是有效的geosite数据文件" {
+		t.Errorf("上传格式不符的geosite.dat时错误为 %v", err)
+	}
+	_, err = geoAssetService.Upload("rules.txt", strings.NewReader("x"))
+	if err == nil || !strings.HasPrefix(err.Error(), "文件名只能包含") {
+		t.Errorf("文件名无效时错误为 %v", err)
+	}
+	assertNoTempGeoAssets(t)
+
+	assets, err := geoAssetService.GetAssets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, asset := range assets {
+		got = append(got, asset.Name)
+		if asset.Name == "geosite.dat" && asset.Exists {
+			t.Error("格式不符的geosite.dat不应保存")
+		}
+	}
+	if want := []string{"geoip.dat", "geosite.dat", "h2y.dat"}; !slices.Equal(got, want) {
+		t.Errorf("文件列表为 %q，期望 %q", got, want)
+	}
+
+	err = geoAssetService.Delete("geoip.dat")
+	if err == nil || err.Error() != "不能删除 geoip.dat" {
+		t.Errorf("删除geoip.dat时错误为 %v", err)
+	}
+	err = geoAssetService.Delete("missing.dat")
+	if err == nil || err.Error() != "missing.dat 不存在" {
+		t.Errorf("删除不存在的文件时错误为 %v", err)
+	}
+	err = geoAssetService.Delete("h2y.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readGeoAsset(t, "h2y.dat") != nil {
+		t.Error("h2y.dat应已删除")
+	}
+	assertNoTempGeoAssets(t)
+}
+
+func TestReplaceGeoAssetsRestoresOnFailure(t *testing.T) {
+	dir := setupTestDB(t)
+	failTests := installStubXray(t, dir)
+	geoAssetService := GeoAssetService{}
+	original := geoSiteData(t, "GFW")
+	_, err := geoAssetService.Upload("h2y.dat", bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Xray未运行时配置检查不通过，恢复原来的文件
+	failTests()
+	_, err = geoAssetService.Upload("h2y.dat", bytes.NewReader(geoSiteData(t, "ADS")))
+	if err == nil || !strings.HasPrefix(err.Error(), "xray配置检查未通过") {
+		t.Fatalf("配置检查失败时错误为 %v", err)
+	}
+	if !bytes.Equal(readGeoAsset(t, "h2y.dat"), original) {
+		t.Error("上传失败后应恢复原来的文件")
+	}
+	err = geoAssetService.Delete("h2y.dat")
+	if err == nil {
+		t.Fatal("配置检查失败时删除应返回错误")
+	}
+	if !bytes.Equal(readGeoAsset(t, "h2y.dat"), original) {
+		t.Error("删除失败后应恢复原来的文件")
+	}
+	assertNoTempGeoAssets(t)
+
+	// Xray运行时重启失败，恢复文件后Xray保持运行
+	err = os.Remove(filepath.Join(dir, "fail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	startStubXray(t)
+	failTests()
+	_, err = geoAssetService.Upload("h2y.dat", bytes.NewReader(geoSiteData(t, "ADS")))
+	if err == nil {
+		t.Fatal("重启失败时上传应返回错误")
+	}
+	if !bytes.Equal(readGeoAsset(t, "h2y.dat"), original) {
+		t.Error("重启失败后应恢复原来的文件")
+	}
+	xrayService := XrayService{}
+	if !xrayService.IsXrayRunning() {
+		t.Error("恢复文件后Xray应保持运行")
+	}
+	assertNoTempGeoAssets(t)
+}
+
+// fakeGeoMirror 模拟geo数据文件的下载地址，files的键为文件名。files中没有 .sha256sum 摘要文件时按文件内容生成
+func fakeGeoMirror(t *testing.T, files map[string][]byte) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		content, ok := files[name]
+		if !ok && strings.HasSuffix(name, ".sha256sum") {
+			content, ok = files[strings.TrimSuffix(name, ".sha256sum")]
+			sum := sha256.Sum256(content)
+			content = []byte(hex.EncodeToString(sum[:]) + "  " + strings.TrimSuffix(name, ".sha256sum") + "\n")
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+
+	settingService := SettingService{}
+	err := settingService.SetGeoMirrorURL(server.URL + "/{name}")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateGeoAssets(t *testing.T) {
+	setupTestDB(t)
+	geoIP := geoIPData(t, "CN")
+	geoSite := geoSiteData(t, "CN")
+	files := map[string][]byte{"geoip.dat": geoIP, "geosite.dat": geoSite}
+	fakeGeoMirror(t, files)
+	geoAssetService := GeoAssetService{}
+
+	updated, err := geoAssetService.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"geoip.dat", "geosite.dat"}; !slices.Equal(updated, want) {
+		t.Errorf("更新的文件为 %q，期望 %q", updated, want)
+	}
+	if !bytes.Equal(readGeoAsset(t, "geoip.dat"), geoIP) || !bytes.Equal(readGeoAsset(t, "geosite.dat"), geoSite) {
+		t.Error("下载的文件内容不一致")
+	}
+
+	// 摘要与当前文件相同时不下载
+	updated, err = geoAssetService.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != 0 {
+		t.Errorf("文件未变化时更新的文件为 %q", updated)
+	}
+
+	files["geosite.dat"] = geoSiteData(t, "GOOGLE")
+	updated, err = geoAssetService.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"geosite.dat"}; !slices.Equal(updated, want) {
+		t.Errorf("更新的文件为 %q，期望 %q", updated, want)
+	}
+	geoSite = files["geosite.dat"]
+
+	// 下载的文件格式不符时不替换任何文件
+	files["geoip.dat"] = geoSiteData(t, "CN")
+	_, err = geoAssetService.Update()
+	if err == nil || err.Error() != "更新 geoip.dat 失败: 不是有效的geoip数据文件" {
+		t.Errorf("格式不符时错误为 %v", err)
+	}
+	if !bytes.Equal(readGeoAsset(t, "geoip.dat"), geoIP) || !bytes.Equal(readGeoAsset(t, "geosite.dat"), geoSite) {
+		t.Error("更新失败后文件不应变化")
+	}
+	assertNoTempGeoAssets(t)
+
+	files["geoip.dat"] = geoIPData(t, "US")
+	files["geoip.dat.sha256sum"] = []byte(strings.Repeat("0", 64) + "  geoip.dat\n")
+	_, err = geoAssetService.Update()
+	if err == nil || !strings.HasPrefix(err.Error(), "更新 geoip.dat 失败: SHA256校验失败") {
+		t.Errorf("摘要不符时错误为 %v", err)
+	}
+	if !bytes.Equal(readGeoAsset(t, "geoip.dat"), geoIP) {
+		t.Error("校验失败后文件不应变化")
+	}
+	assertNoTempGeoAssets(t)
+
+	delete(files, "geoip.dat")
+	delete(files, "geoip.dat.sha256sum")
+	_, err = geoAssetService.Update()
+	if err == nil || !strings.HasPrefix(err.Error(), "更新 geoip.dat 失败: 获取SHA256摘要失败") {
+		t.Errorf("摘要文件不存在时错误为 %v", err)
+	}
+}
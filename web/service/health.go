@@ -0,0 +1,190 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"mx-ui/database"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 就绪检查的结果状态
+const (
+	CheckStatusOK   = "ok"
+	CheckStatusFail = "fail"
+	CheckStatusSkip = "skip"
+)
+
+// 证书剩余有效期少于该时间时在检查结果中提示
+const certExpireWarning = 7 * 24 * time.Hour
+
+// ListenerState 监听器的运行状态
+type ListenerState struct {
+	Addr string
+	Up   bool
+	Err  error
+}
+
+var (
+	listenerLock   sync.Mutex
+	listenerStates = map[string]*ListenerState{}
+)
+
+// SetListenerState 记录名为name的监听器状态，由Web服务器和订阅服务器在启动、停止和出错时调用
+func SetListenerState(name string, addr string, up bool, err error) {
+	listenerLock.Lock()
+	defer listenerLock.Unlock()
+	listenerStates[name] = &ListenerState{
+		Addr: addr,
+		Up:   up,
+		Err:  err,
+	}
+}
+
+// ClearListenerState 删除名为name的监听器状态，用于按设置不启动的监听器
+func ClearListenerState(name string) {
+	listenerLock.Lock()
+	defer listenerLock.Unlock()
+	delete(listenerStates, name)
+}
+
+// CheckResult 单项检查结果
+type CheckResult struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReadinessReport 就绪检查报告
+type ReadinessReport struct {
+	Status string                  `json:"status"`
+	Checks map[string]*CheckResult `json:"checks"`
+}
+
+// HealthService 健康和就绪检查服务
+type HealthService struct{}
+
+// CheckReadiness 检查数据库、监听器、Xray和证书，任一检查失败时整体为fail
+func (s *HealthService) CheckReadiness() *ReadinessReport {
+	report := &ReadinessReport{
+		Status: CheckStatusOK,
+		Checks: map[string]*CheckResult{},
+	}
+	report.Checks["db"] = s.checkDB()
+	for name, result := range s.checkListeners() {
+		report.Checks[name] = result
+	}
+	report.Checks["xray"] = s.checkXray()
+	report.Checks["cert"] = s.checkCert()
+
+	for _, result := range report.Checks {
+		if result.Status == CheckStatusFail {
+			report.Status = CheckStatusFail
+		}
+	}
+	return report
+}
+
+// checkDB 检查数据库是否可以访问
+func (s *HealthService) checkDB() *CheckResult {
+	db := database.GetDB()
+	if db == nil {
+		return &CheckResult{Status: CheckStatusFail, Message: "数据库未初始化"}
+	}
+	sqlDB, err := db.DB()
+	if err == nil {
+		err = sqlDB.Ping()
+	}
+	if err == nil {
+		var one int
+		err = db.Raw("SELECT 1").Scan(&one).Error
+	}
+	if err != nil {
+		return &CheckResult{Status: CheckStatusFail, Message: err.Error()}
+	}
+	return &CheckResult{Status: CheckStatusOK}
+}
+
+// checkListeners 检查已注册的监听器是否在运行
+func (s *HealthService) checkListeners() map[string]*CheckResult {
+	listenerLock.Lock()
+	defer listenerLock.Unlock()
+
+	names := make([]string, 0, len(listenerStates))
+	for name := range listenerStates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := map[string]*CheckResult{}
+	for _, name := range names {
+		state := listenerStates[name]
+		switch {
+		case state.Up:
+			results[name] = &CheckResult{Status: CheckStatusOK, Message: state.Addr}
+		case state.Err != nil:
+			results[name] = &CheckResult{Status: CheckStatusFail, Message: state.Err.Error()}
+		default:
+			results[name] = &CheckResult{Status: CheckStatusFail, Message: "未在监听"}
+		}
+	}
+	return results
+}
+
+// checkXray 检查Xray进程，未启动过或被手动停止时跳过，启动后异常退出时失败
+func (s *HealthService) checkXray() *CheckResult {
+	xrayService := XrayService{}
+	if xrayService.IsXrayRunning() {
+		return &CheckResult{Status: CheckStatusOK}
+	}
+	if xrayService.IsXrayStopped() {
+		return &CheckResult{Status: CheckStatusSkip, Message: "Xray已停止"}
+	}
+	if err := xrayService.GetXrayErr(); err != nil {
+		return &CheckResult{Status: CheckStatusFail, Message: err.Error()}
+	}
+	return &CheckResult{Status: CheckStatusSkip, Message: "Xray未运行"}
+}
+
+// checkCert 检查面板证书是否可以加载且在有效期内，未配置证书时跳过
+func (s *HealthService) checkCert() *CheckResult {
+	settingService := SettingService{}
+	// 与Web服务器一致，读取设置出错时视为未配置证书
+	certFile, err := settingService.GetCertFile()
+	keyFile, err2 := settingService.GetKeyFile()
+	if certFile == "" || keyFile == "" || err != nil || err2 != nil {
+		return &CheckResult{Status: CheckStatusSkip, Message: "未配置证书"}
+	}
+
+	notAfter, err := loadCertExpiry(certFile, keyFile)
+	if err != nil {
+		return &CheckResult{Status: CheckStatusFail, Message: err.Error()}
+	}
+	remaining := time.Until(notAfter)
+	if remaining <= 0 {
+		return &CheckResult{Status: CheckStatusFail, Message: "证书已于 " + notAfter.Format(time.RFC3339) + " 过期"}
+	}
+	message := "有效期至 " + notAfter.Format(time.RFC3339)
+	if remaining < certExpireWarning {
+		message = fmt.Sprintf("%s，剩余不足%d天", message, int(certExpireWarning.Hours()/24))
+	}
+	return &CheckResult{Status: CheckStatusOK, Message: message}
+}
+
+// loadCertExpiry 加载证书和私钥并返回证书的过期时间
+func loadCertExpiry(certFile string, keyFile string) (time.Time, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(pair.Certificate) == 0 {
+		return time.Time{}, errors.New("证书文件中没有证书")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"mx-ui/config"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate 生成在notAfter过期、包含dnsNames和127.0.0.1的自签名证书
+func testCertificate(t *testing.T, notAfter time.Time, dnsNames ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    notAfter.Add(-30 * 24 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeTestCertFiles 把证书和私钥写成PEM文件并设置为面板证书
+func writeTestCertFiles(t *testing.T, dir string, cert tls.Certificate) {
+	t.Helper()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settingService := SettingService{}
+	if err := settingService.SetCertFile(certFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := settingService.SetKeyFile(keyFile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// resetXrayProcess 测试结束时停止并清除Xray进程
+func resetXrayProcess(t *testing.T) {
+	t.Cleanup(func() {
+		xrayLock.Lock()
+		defer xrayLock.Unlock()
+		if xrayProcess != nil && xrayProcess.IsRunning() {
+			xrayProcess.Stop()
+		}
+		xrayProcess = nil
+	})
+}
+
+// writeXrayBinary 把执行script的Xray二进制文件写到当前目录下的Xray路径，run -test 总是通过
+func writeXrayBinary(t *testing.T, script string) {
+	t.Helper()
+	err := os.MkdirAll(config.GetBinFolderPath(), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "#!/bin/sh\nif [ \"$2\" = \"-test\" ]; then\n\texit 0\nfi\n" + script + "\n"
+	err = os.WriteFile(config.GetXrayBinaryPath(), []byte(content), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadinessListenersAndCert(t *testing.T) {
+	dir := setupTestDB(t)
+	t.Cleanup(func() {
+		ClearListenerState("web")
+		ClearListenerState("sub")
+	})
+	healthService := HealthService{}
+
+	report := healthService.CheckReadiness()
+	if report.Status != CheckStatusOK {
+		t.Fatalf("没有监听器和证书时应就绪，结果为 %+v", report.Checks)
+	}
+	if report.Checks["db"].Status != CheckStatusOK {
+		t.Errorf("数据库检查为 %+v", report.Checks["db"])
+	}
+	if report.Checks["xray"].Status != CheckStatusSkip || report.Checks["cert"].Status != CheckStatusSkip {
+		t.Errorf("未启动Xray、未配置证书时应跳过，结果为 %+v %+v", report.Checks["xray"], report.Checks["cert"])
+	}
+
+	SetListenerState("web", "unix:/run/mx-ui.sock", true, nil)
+	SetListenerState("sub", "", false, errors.New("address already in use"))
+	report = healthService.CheckReadiness()
+	if report.Status != CheckStatusFail {
+		t.Error("监听器出错时应为fail")
+	}
+	if web := report.Checks["web"]; web.Status != CheckStatusOK || web.Message != "unix:/run/mx-ui.sock" {
+		t.Errorf("web检查为 %+v", web)
+	}
+	if sub := report.Checks["sub"]; sub.Status != CheckStatusFail || sub.Message != "address already in use" {
+		t.Errorf("sub检查为 %+v", sub)
+	}
+	ClearListenerState("sub")
+	if report = healthService.CheckReadiness(); report.Status != CheckStatusOK {
+		t.Errorf("删除出错的监听器后应就绪，结果为 %+v", report.Checks)
+	}
+
+	writeTestCertFiles(t, dir, testCertificate(t, time.Now().Add(3*24*time.Hour), "panel.example.com"))
+	cert := healthService.CheckReadiness().Checks["cert"]
+	if cert.Status != CheckStatusOK || !strings.Contains(cert.Message, "剩余不足7天") {
+		t.Errorf("即将过期的证书检查为 %+v", cert)
+	}
+	writeTestCertFiles(t, dir, testCertificate(t, time.Now().Add(-time.Hour), "panel.example.com"))
+	report = healthService.CheckReadiness()
+	if report.Status != CheckStatusFail || !strings.Contains(report.Checks["cert"].Message, "过期") {
+		t.Errorf("过期的证书检查为 %+v", report.Checks["cert"])
+	}
+}
+
+func TestReadinessXray(t *testing.T) {
+	setupTestDB(t)
+	resetXrayProcess(t)
+	healthService := HealthService{}
+	xrayService := XrayService{}
+
+	writeXrayBinary(t, "exec sleep 60")
+	err := xrayService.RestartXray(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := healthService.checkXray(); result.Status != CheckStatusOK {
+		t.Errorf("Xray运行时检查为 %+v", result)
+	}
+
+	// 手动停止不算失败
+	err = xrayService.StopXray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := healthService.CheckReadiness()
+	if result := report.Checks["xray"]; result.Status != CheckStatusSkip || result.Message != "Xray已停止" {
+		t.Errorf("手动停止后检查为 %+v", result)
+	}
+	if report.Status != CheckStatusOK {
+		t.Error("手动停止Xray后仍应就绪")
+	}
+
+	// 启动后异常退出时失败
+	writeXrayBinary(t, "echo boom; exit 23")
+	err = xrayService.RestartXray(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for xrayService.IsXrayRunning() || xrayService.GetXrayErr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("等待Xray退出超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	report = healthService.CheckReadiness()
+	if result := report.Checks["xray"]; result.Status != CheckStatusFail || result.Message != "exit status 23" {
+		t.Errorf("异常退出后检查为 %+v", result)
+	}
+	if report.Status != CheckStatusFail {
+		t.Error("Xray异常退出时不应就绪")
+	}
+}
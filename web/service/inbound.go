@@ -0,0 +1,217 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"mx-ui/database"
+	"mx-ui/xray"
+)
+
+// InboundService 入站相关服务
+type InboundService struct{}
+
+// GetInbounds 获取所有入站
+func (s *InboundService) GetInbounds() ([]*database.InboundConfig, error) {
+	var inbounds []*database.InboundConfig
+	err := database.GetDB().Order("id ASC").Find(&inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	return inbounds, nil
+}
+
+// GetInbound 根据ID获取入站
+func (s *InboundService) GetInbound(id uint) (*database.InboundConfig, error) {
+	inbound := &database.InboundConfig{}
+	err := database.GetDB().First(inbound, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return inbound, nil
+}
+
+// AddInbound 添加入站，生成的Xray配置检查不通过时拒绝
+func (s *InboundService) AddInbound(inbound *database.InboundConfig) error {
+	err := s.checkInbound(inbound)
+	if err != nil {
+		return err
+	}
+	err = fillRealitySettings(inbound)
+	if err != nil {
+		return err
+	}
+	inbound.RealityCheck = ""
+	err = checkConfigChange(func(source *configSource) {
+		source.setInbound(inbound)
+	})
+	if err != nil {
+		return err
+	}
+	return database.GetDB().Create(inbound).Error
+}
+
+// UpdateInbound 更新入站，生成的Xray配置检查不通过时拒绝
+func (s *InboundService) UpdateInbound(inbound *database.InboundConfig) error {
+	old, err := s.GetInbound(inbound.ID)
+	if err != nil {
+		return err
+	}
+	err = s.checkInbound(inbound)
+	if err != nil {
+		return err
+	}
+	err = fillRealitySettings(inbound)
+	if err != nil {
+		return err
+	}
+
+	old.Protocol = inbound.Protocol
+	old.Tag = inbound.Tag
+	old.Port = inbound.Port
+	old.Enable = inbound.Enable
+	old.Settings = inbound.Settings
+	old.StreamSettings = inbound.StreamSettings
+	old.Remark = inbound.Remark
+	err = checkConfigChange(func(source *configSource) {
+		source.setInbound(old)
+	})
+	if err != nil {
+		return err
+	}
+	return database.GetDB().Save(old).Error
+}
+
+// SetInboundEnable 启用或禁用入站，启用后生成的Xray配置检查不通过时拒绝
+func (s *InboundService) SetInboundEnable(id uint, enable bool) error {
+	inbound, err := s.GetInbound(id)
+	if err != nil {
+		return err
+	}
+	if enable && !inbound.Enable {
+		inbound.Enable = true
+		err = checkConfigChange(func(source *configSource) {
+			source.setInbound(inbound)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return database.GetDB().Model(&database.InboundConfig{}).
+		Where("id = ?", id).
+		Update("enable", enable).Error
+}
+
+// DelInbound 删除入站及其客户端
+func (s *InboundService) DelInbound(id uint) error {
+	db := database.GetDB()
+	err := db.Where("inbound_id = ?", id).Delete(&database.ClientConfig{}).Error
+	if err != nil {
+		return err
+	}
+	return db.Delete(&database.InboundConfig{}, id).Error
+}
+
+// checkInbound 检查入站参数及端口、标签是否冲突
+func (s *InboundService) checkInbound(inbound *database.InboundConfig) error {
+	err := s.validateInbound(inbound)
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	err = database.GetDB().Model(&database.InboundConfig{}).
+		Where("id <> ? AND port = ?", inbound.ID, inbound.Port).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("端口已被其他入站使用")
+	}
+	settingService := SettingService{}
+	apiPort, err := settingService.GetXrayAPIPort()
+	if err != nil {
+		return err
+	}
+	if inbound.Port == apiPort {
+		return errors.New("端口已被Xray内部API使用")
+	}
+	if inbound.Tag == xray.APITag {
+		return errors.New("标签已被Xray内部API使用")
+	}
+
+	if inbound.Tag != "" {
+		err = database.GetDB().Model(&database.InboundConfig{}).
+			Where("id <> ? AND tag = ?", inbound.ID, inbound.Tag).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("标签已被其他入站使用")
+		}
+	}
+	return nil
+}
+
+// fillRealitySettings 入站使用REALITY时补全缺少的privateKey和shortIds，
+// 并保存privateKey对应的publicKey，生成分享链接时作为pbk。Xray服务端会忽略publicKey
+func fillRealitySettings(inbound *database.InboundConfig) error {
+	if inbound.StreamSettings == "" {
+		return nil
+	}
+	stream := map[string]interface{}{}
+	err := json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+	if err != nil {
+		return err
+	}
+	if stream["security"] != "reality" {
+		return nil
+	}
+	reality, _ := stream["realitySettings"].(map[string]interface{})
+	if reality == nil {
+		reality = map[string]interface{}{}
+		stream["realitySettings"] = reality
+	}
+
+	privateKey, _ := reality["privateKey"].(string)
+	if privateKey == "" {
+		privateKey, _, err = xray.GenerateX25519()
+		if err != nil {
+			return err
+		}
+		reality["privateKey"] = privateKey
+	}
+	publicKey, err := xray.X25519PublicKey(privateKey)
+	if err != nil {
+		return errors.New("REALITY的privateKey无效")
+	}
+	reality["publicKey"] = publicKey
+	if shortIDs, _ := reality["shortIds"].([]interface{}); len(shortIDs) == 0 {
+		reality["shortIds"] = []string{randomShortID()}
+	}
+
+	data, err := json.Marshal(stream)
+	if err != nil {
+		return err
+	}
+	inbound.StreamSettings = string(data)
+	return nil
+}
+
+// validateInbound 检查入站自身的参数
+func (s *InboundService) validateInbound(inbound *database.InboundConfig) error {
+	if inbound.Protocol == "" {
+		return errors.New("协议不能为空")
+	}
+	if inbound.Port <= 0 || inbound.Port > 65535 {
+		return errors.New("端口范围必须在1-65535之间")
+	}
+	if inbound.Settings != "" && !json.Valid([]byte(inbound.Settings)) {
+		return errors.New("入站设置不是有效的JSON")
+	}
+	if inbound.StreamSettings != "" && !json.Valid([]byte(inbound.StreamSettings)) {
+		return errors.New("传输设置不是有效的JSON")
+	}
+	return nil
+}
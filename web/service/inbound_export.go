@@ -0,0 +1,490 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"mx-ui/config"
+	"mx-ui/database"
+	"mx-ui/xray"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 导出文件格式版本
+const exportBundleVersion = 1
+
+// 导入冲突处理策略
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictRenumber  = "renumber"
+)
+
+// 导入计划中的操作
+const (
+	importActionCreate    = "create"
+	importActionOverwrite = "overwrite"
+	importActionSkip      = "skip"
+)
+
+// ExportBundle 入站及客户端的可移植导出数据
+type ExportBundle struct {
+	Version    int              `json:"version"`
+	Source     string           `json:"source"`
+	ExportedAt int64            `json:"exportedAt"`
+	Inbounds   []*ExportInbound `json:"inbounds"`
+}
+
+// ExportInbound 导出的入站
+type ExportInbound struct {
+	Protocol       string          `json:"protocol"`
+	Tag            string          `json:"tag"`
+	Port           int             `json:"port"`
+	Enable         bool            `json:"enable"`
+	Settings       string          `json:"settings"`
+	StreamSettings string          `json:"streamSettings"`
+	Remark         string          `json:"remark"`
+	Clients        []*ExportClient `json:"clients"`
+}
+
+// ExportClient 导出的客户端，包含流量和到期时间
+type ExportClient struct {
+	Email      string `json:"email"`
+	UUID       string `json:"uuid"`
+	Enable     bool   `json:"enable"`
+	ExpiryTime int64  `json:"expiryTime"`
+	Limit      int64  `json:"limit"`
+	Used       int64  `json:"used"`
+	SubID      string `json:"subId"`
+	Remark     string `json:"remark"`
+	LimitIP    int    `json:"limitIp"`
+}
+
+// ImportOptions 导入选项
+type ImportOptions struct {
+	PortStrategy  string `json:"portStrategy"`
+	TagStrategy   string `json:"tagStrategy"`
+	EmailStrategy string `json:"emailStrategy"`
+	DryRun        bool   `json:"dryRun"`
+}
+
+// ImportItem 导入报告中的一项
+type ImportItem struct {
+	Name    string `json:"name"`
+	Action  string `json:"action"`
+	Message string `json:"message,omitempty"`
+}
+
+// ImportReport 导入报告，试运行时描述将会发生的变化
+type ImportReport struct {
+	DryRun   bool          `json:"dryRun"`
+	Inbounds []*ImportItem `json:"inbounds"`
+	Clients  []*ImportItem `json:"clients"`
+}
+
+// importPlanInbound 导入计划中的入站
+type importPlanInbound struct {
+	inbound *database.InboundConfig
+	clients []*database.ClientConfig
+}
+
+// ExportInbounds 导出所有入站及其客户端
+func (s *InboundService) ExportInbounds() (*ExportBundle, error) {
+	inbounds, err := s.GetInbounds()
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &ExportBundle{
+		Version:    exportBundleVersion,
+		Source:     config.GetName() + " " + config.GetVersion(),
+		ExportedAt: time.Now().UnixMilli(),
+		Inbounds:   []*ExportInbound{},
+	}
+
+	clientService := ClientService{}
+	for _, inbound := range inbounds {
+		clients, err := clientService.GetClients(inbound.ID)
+		if err != nil {
+			return nil, err
+		}
+		exportInbound := &ExportInbound{
+			Protocol:       inbound.Protocol,
+			Tag:            inbound.Tag,
+			Port:           inbound.Port,
+			Enable:         inbound.Enable,
+			Settings:       inbound.Settings,
+			StreamSettings: inbound.StreamSettings,
+			Remark:         inbound.Remark,
+			Clients:        []*ExportClient{},
+		}
+		for _, client := range clients {
+			exportInbound.Clients = append(exportInbound.Clients, &ExportClient{
+				Email:      client.Email,
+				UUID:       client.UUID,
+				Enable:     client.Enable,
+				ExpiryTime: client.ExpiryTime,
+				Limit:      client.Limit,
+				Used:       client.Used,
+				SubID:      client.SubID,
+				Remark:     client.Remark,
+				LimitIP:    client.LimitIP,
+			})
+		}
+		bundle.Inbounds = append(bundle.Inbounds, exportInbound)
+	}
+	return bundle, nil
+}
+
+// ImportInbounds 导入入站及客户端，按选项处理端口、标签和邮箱冲突，导入后的Xray配置检查不通过时拒绝
+func (s *InboundService) ImportInbounds(bundle *ExportBundle, options *ImportOptions) (*ImportReport, error) {
+	if bundle == nil || bundle.Version != exportBundleVersion {
+		return nil, errors.New("不支持的导入文件版本")
+	}
+	for _, strategy := range []*string{&options.PortStrategy, &options.TagStrategy, &options.EmailStrategy} {
+		if *strategy == "" {
+			*strategy = ConflictSkip
+		}
+		if *strategy != ConflictSkip && *strategy != ConflictOverwrite && *strategy != ConflictRenumber {
+			return nil, fmt.Errorf("未知的冲突处理策略: %s", *strategy)
+		}
+	}
+
+	plan, report, err := s.planImport(bundle, options)
+	if err != nil {
+		return nil, err
+	}
+	err = checkImportPlan(plan)
+	if err != nil {
+		return nil, err
+	}
+	report.DryRun = options.DryRun
+	if options.DryRun {
+		return report, nil
+	}
+
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		for _, item := range plan {
+			err := tx.Save(item.inbound).Error
+			if err != nil {
+				return err
+			}
+			for _, client := range item.clients {
+				client.InboundID = item.inbound.ID
+				err = tx.Save(client).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// checkImportPlan 在当前配置上应用导入计划，生成Xray配置并用Xray检查。
+// 新入站还没有ID，临时分配一个不与现有入站重复的ID，使其客户端能关联到入站
+func checkImportPlan(plan []*importPlanInbound) error {
+	return checkConfigChange(func(source *configSource) {
+		var nextID uint
+		for _, inbound := range source.inbounds {
+			nextID = max(nextID, inbound.ID)
+		}
+		for _, item := range plan {
+			inbound := *item.inbound
+			if inbound.ID == 0 {
+				nextID++
+				inbound.ID = nextID
+			}
+			source.setInbound(&inbound)
+			for _, client := range item.clients {
+				client := *client
+				client.InboundID = inbound.ID
+				source.setClient(&client)
+			}
+		}
+	})
+}
+
+// planImport 根据当前数据计算导入计划，不修改数据库
+func (s *InboundService) planImport(bundle *ExportBundle, options *ImportOptions) ([]*importPlanInbound, *ImportReport, error) {
+	inbounds, err := s.GetInbounds()
+	if err != nil {
+		return nil, nil, err
+	}
+	clientService := ClientService{}
+	clients, err := clientService.GetClients(0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	settingService := SettingService{}
+	apiPort, err := settingService.GetXrayAPIPort()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 记录端口、标签、邮箱和订阅ID的占用情况，导入过程中同步更新。
+	// Xray内部API占用的端口和标签由apiOwner表示，不能被覆盖
+	apiOwner := &database.InboundConfig{Port: apiPort, Tag: xray.APITag}
+	portOwner := map[int]*database.InboundConfig{apiPort: apiOwner}
+	tagOwner := map[string]*database.InboundConfig{xray.APITag: apiOwner}
+	for _, inbound := range inbounds {
+		portOwner[inbound.Port] = inbound
+		if inbound.Tag != "" {
+			tagOwner[inbound.Tag] = inbound
+		}
+	}
+	emailOwner := map[string]*database.ClientConfig{}
+	subIDs := map[string]bool{}
+	for _, client := range clients {
+		emailOwner[client.Email] = client
+		subIDs[client.SubID] = true
+	}
+
+	report := &ImportReport{
+		Inbounds: []*ImportItem{},
+		Clients:  []*ImportItem{},
+	}
+	var plan []*importPlanInbound
+
+	for _, imported := range bundle.Inbounds {
+		name := fmt.Sprintf("%s:%d %s", imported.Protocol, imported.Port, imported.Remark)
+		item := &ImportItem{Name: name}
+		report.Inbounds = append(report.Inbounds, item)
+
+		candidate := &database.InboundConfig{
+			Protocol:       imported.Protocol,
+			Tag:            imported.Tag,
+			Port:           imported.Port,
+			Enable:         imported.Enable,
+			Settings:       imported.Settings,
+			StreamSettings: imported.StreamSettings,
+			Remark:         imported.Remark,
+		}
+		err := s.validateInbound(candidate)
+		if err == nil {
+			err = fillRealitySettings(candidate)
+		}
+		if err != nil {
+			item.Action = importActionSkip
+			item.Message = err.Error()
+			s.skipClients(report, imported.Clients, "所属入站未导入")
+			continue
+		}
+
+		var target *database.InboundConfig
+		var messages []string
+
+		if owner := portOwner[candidate.Port]; owner != nil {
+			switch options.PortStrategy {
+			case ConflictSkip:
+				item.Action = importActionSkip
+				item.Message = fmt.Sprintf("端口 %d 已被使用", candidate.Port)
+			case ConflictOverwrite:
+				if owner == apiOwner {
+					item.Action = importActionSkip
+					item.Message = fmt.Sprintf("端口 %d 已被Xray内部API使用", candidate.Port)
+					break
+				}
+				if owner.ID == 0 {
+					item.Action = importActionSkip
+					item.Message = fmt.Sprintf("导入文件中存在重复的端口 %d", candidate.Port)
+					break
+				}
+				target = owner
+				messages = append(messages, fmt.Sprintf("覆盖端口 %d 上的入站", candidate.Port))
+			case ConflictRenumber:
+				port := nextFreePort(portOwner, candidate.Port)
+				if port == 0 {
+					item.Action = importActionSkip
+					item.Message = "没有可用端口"
+					break
+				}
+				messages = append(messages, fmt.Sprintf("端口 %d 改为 %d", candidate.Port, port))
+				candidate.Port = port
+			}
+		}
+		if item.Action == importActionSkip {
+			s.skipClients(report, imported.Clients, "所属入站未导入")
+			continue
+		}
+
+		if owner := tagOwner[candidate.Tag]; candidate.Tag != "" && owner != nil && owner != target {
+			switch options.TagStrategy {
+			case ConflictSkip:
+				item.Action = importActionSkip
+				item.Message = fmt.Sprintf("标签 %s 已被使用", candidate.Tag)
+			case ConflictOverwrite:
+				if owner == apiOwner {
+					item.Action = importActionSkip
+					item.Message = fmt.Sprintf("标签 %s 已被Xray内部API使用", candidate.Tag)
+					break
+				}
+				if owner.ID == 0 {
+					item.Action = importActionSkip
+					item.Message = fmt.Sprintf("导入文件中存在重复的标签 %s", candidate.Tag)
+					break
+				}
+				if target != nil {
+					item.Action = importActionSkip
+					item.Message = fmt.Sprintf("端口和标签分别属于不同的入站，无法覆盖标签 %s", candidate.Tag)
+					break
+				}
+				target = owner
+				messages = append(messages, fmt.Sprintf("覆盖标签为 %s 的入站", candidate.Tag))
+			case ConflictRenumber:
+				tag := nextFreeTag(tagOwner, candidate.Tag)
+				messages = append(messages, fmt.Sprintf("标签 %s 改为 %s", candidate.Tag, tag))
+				candidate.Tag = tag
+			}
+		}
+		if item.Action == importActionSkip {
+			s.skipClients(report, imported.Clients, "所属入站未导入")
+			continue
+		}
+
+		if target != nil {
+			// 覆盖时保留原入站的ID，释放其原有的端口和标签
+			delete(portOwner, target.Port)
+			if target.Tag != "" {
+				delete(tagOwner, target.Tag)
+			}
+			candidate.ID = target.ID
+			candidate.CreatedAt = target.CreatedAt
+			item.Action = importActionOverwrite
+		} else {
+			item.Action = importActionCreate
+		}
+		portOwner[candidate.Port] = candidate
+		if candidate.Tag != "" {
+			tagOwner[candidate.Tag] = candidate
+		}
+		item.Message = strings.Join(messages, "；")
+
+		planItem := &importPlanInbound{inbound: candidate}
+		for _, importedClient := range imported.Clients {
+			client := s.planClient(report, importedClient, options, emailOwner, subIDs)
+			if client != nil {
+				planItem.clients = append(planItem.clients, client)
+			}
+		}
+		plan = append(plan, planItem)
+	}
+	return plan, report, nil
+}
+
+// planClient 计划导入单个客户端，跳过时返回nil
+func (s *InboundService) planClient(report *ImportReport, imported *ExportClient, options *ImportOptions,
+	emailOwner map[string]*database.ClientConfig, subIDs map[string]bool) *database.ClientConfig {
+	item := &ImportItem{Name: imported.Email}
+	report.Clients = append(report.Clients, item)
+
+	if imported.Email == "" {
+		item.Action = importActionSkip
+		item.Message = "邮箱为空"
+		return nil
+	}
+
+	client := &database.ClientConfig{
+		Email:      imported.Email,
+		UUID:       imported.UUID,
+		Enable:     imported.Enable,
+		ExpiryTime: imported.ExpiryTime,
+		Limit:      imported.Limit,
+		Used:       imported.Used,
+		SubID:      imported.SubID,
+		Remark:     imported.Remark,
+		LimitIP:    imported.LimitIP,
+	}
+	if client.UUID == "" {
+		client.UUID = randomUUID()
+	}
+
+	var messages []string
+	item.Action = importActionCreate
+	if owner := emailOwner[client.Email]; owner != nil {
+		switch options.EmailStrategy {
+		case ConflictSkip:
+			item.Action = importActionSkip
+			item.Message = "邮箱已被使用"
+			return nil
+		case ConflictOverwrite:
+			if owner.ID == 0 {
+				item.Action = importActionSkip
+				item.Message = "导入文件中存在重复的邮箱"
+				return nil
+			}
+			client.ID = owner.ID
+			client.CreatedAt = owner.CreatedAt
+			delete(subIDs, owner.SubID)
+			item.Action = importActionOverwrite
+		case ConflictRenumber:
+			email := nextFreeEmail(emailOwner, client.Email)
+			messages = append(messages, fmt.Sprintf("邮箱改为 %s", email))
+			client.Email = email
+		}
+	}
+
+	if client.SubID == "" || subIDs[client.SubID] {
+		client.SubID = randomString(16)
+		if imported.SubID != "" {
+			messages = append(messages, "订阅ID冲突，已重新生成")
+		}
+	}
+	subIDs[client.SubID] = true
+	emailOwner[client.Email] = client
+	item.Message = strings.Join(messages, "；")
+	return client
+}
+
+// skipClients 将入站下的客户端全部记为跳过
+func (s *InboundService) skipClients(report *ImportReport, clients []*ExportClient, message string) {
+	for _, client := range clients {
+		report.Clients = append(report.Clients, &ImportItem{
+			Name:    client.Email,
+			Action:  importActionSkip,
+			Message: message,
+		})
+	}
+}
+
+// nextFreePort 从port开始向上查找未被占用的端口
+func nextFreePort(portOwner map[int]*database.InboundConfig, port int) int {
+	for p := port + 1; p <= 65535; p++ {
+		if portOwner[p] == nil {
+			return p
+		}
+	}
+	for p := 1; p < port; p++ {
+		if portOwner[p] == nil {
+			return p
+		}
+	}
+	return 0
+}
+
+// nextFreeTag 为标签添加数字后缀直到不冲突
+func nextFreeTag(tagOwner map[string]*database.InboundConfig, tag string) string {
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s-%d", tag, i)
+		if tagOwner[candidate] == nil {
+			return candidate
+		}
+	}
+}
+
+// nextFreeEmail 为邮箱添加数字后缀直到不冲突
+func nextFreeEmail(emailOwner map[string]*database.ClientConfig, email string) string {
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s_%d", email, i)
+		if emailOwner[candidate] == nil {
+			return candidate
+		}
+	}
+}
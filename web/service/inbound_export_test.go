@@ -0,0 +1,239 @@
+package service
+
+import (
+	"fmt"
+	"mx-ui/database"
+	"slices"
+	"testing"
+)
+
+// reportSummary 将报告中的各项整理为 操作 说明
+func reportSummary(items []*ImportItem) []string {
+	var summary []string
+	for _, item := range items {
+		summary = append(summary, fmt.Sprintf("%s %s", item.Action, item.Message))
+	}
+	return summary
+}
+
+func TestPlanImport(t *testing.T) {
+	setupTestDB(t)
+	inboundA := createTestInbound(t, &database.InboundConfig{Protocol: "vless", Tag: "a", Port: 443})
+	inboundB := createTestInbound(t, &database.InboundConfig{Protocol: "vless", Tag: "b", Port: 8443})
+	bob := createTestClient(t, &database.ClientConfig{InboundID: inboundA.ID, Email: "bob", UUID: "bob-uuid", SubID: "bobsub"})
+
+	vless := func(port int, tag string, clients ...*ExportClient) *ExportInbound {
+		return &ExportInbound{Protocol: "vless", Port: port, Tag: tag, Clients: clients}
+	}
+	client := func(email string, subID string) *ExportClient {
+		return &ExportClient{Email: email, UUID: email + "-uuid", SubID: subID}
+	}
+	tests := []struct {
+		name     string
+		strategy string
+		inbounds []*ExportInbound
+		// wantInbounds 为每个入站的 操作 说明，wantPlan 为计划写入的 ID:端口:标签
+		wantInbounds []string
+		wantPlan     []string
+		wantClients  []string
+	}{
+		{
+			name:         "端口冲突时跳过，客户端一起跳过",
+			strategy:     ConflictSkip,
+			inbounds:     []*ExportInbound{vless(443, "x", client("carol", ""))},
+			wantInbounds: []string{"skip 端口 443 已被使用"},
+			wantClients:  []string{"skip 所属入站未导入"},
+		},
+		{
+			name:         "端口冲突时覆盖",
+			strategy:     ConflictOverwrite,
+			inbounds:     []*ExportInbound{vless(443, "x")},
+			wantInbounds: []string{"overwrite 覆盖端口 443 上的入站"},
+			wantPlan:     []string{fmt.Sprintf("%d:443:x", inboundA.ID)},
+		},
+		{
+			name:         "端口冲突时改用下一个空闲端口",
+			strategy:     ConflictRenumber,
+			inbounds:     []*ExportInbound{vless(443, "x"), vless(443, "y")},
+			wantInbounds: []string{"create 端口 443 改为 444", "create 端口 443 改为 445"},
+			wantPlan:     []string{"0:444:x", "0:445:y"},
+		},
+		{
+			name:         "标签冲突时跳过",
+			strategy:     ConflictSkip,
+			inbounds:     []*ExportInbound{vless(9000, "a")},
+			wantInbounds: []string{"skip 标签 a 已被使用"},
+		},
+		{
+			name:         "标签冲突时覆盖",
+			strategy:     ConflictOverwrite,
+			inbounds:     []*ExportInbound{vless(9000, "a")},
+			wantInbounds: []string{"overwrite 覆盖标签为 a 的入站"},
+			wantPlan:     []string{fmt.Sprintf("%d:9000:a", inboundA.ID)},
+		},
+		{
+			name:         "标签冲突时添加后缀",
+			strategy:     ConflictRenumber,
+			inbounds:     []*ExportInbound{vless(9000, "a"), vless(9001, "a")},
+			wantInbounds: []string{"create 标签 a 改为 a-2", "create 标签 a 改为 a-3"},
+			wantPlan:     []string{"0:9000:a-2", "0:9001:a-3"},
+		},
+		{
+			name:         "端口和标签属于同一个入站时覆盖该入站",
+			strategy:     ConflictOverwrite,
+			inbounds:     []*ExportInbound{vless(8443, "b")},
+			wantInbounds: []string{"overwrite 覆盖端口 8443 上的入站"},
+			wantPlan:     []string{fmt.Sprintf("%d:8443:b", inboundB.ID)},
+		},
+		{
+			name:         "端口和标签分别属于不同的入站",
+			strategy:     ConflictOverwrite,
+			inbounds:     []*ExportInbound{vless(443, "b")},
+			wantInbounds: []string{"skip 端口和标签分别属于不同的入站，无法覆盖标签 b"},
+		},
+		{
+			name:         "导入文件中重复的端口和标签不能互相覆盖",
+			strategy:     ConflictOverwrite,
+			inbounds:     []*ExportInbound{vless(9000, "x"), vless(9000, "y"), vless(9001, "x")},
+			wantInbounds: []string{"create ", "skip 导入文件中存在重复的端口 9000", "skip 导入文件中存在重复的标签 x"},
+			wantPlan:     []string{"0:9000:x"},
+		},
+		{
+			name:         "无效的入站",
+			strategy:     ConflictSkip,
+			inbounds:     []*ExportInbound{vless(0, "x"), {Port: 9000}},
+			wantInbounds: []string{"skip 端口范围必须在1-65535之间", "skip 协议不能为空"},
+		},
+		{
+			name:         "邮箱冲突时跳过",
+			strategy:     ConflictSkip,
+			inbounds:     []*ExportInbound{vless(9000, "x", client("bob", ""), client("", ""), client("carol", "carolsub"))},
+			wantInbounds: []string{"create "},
+			wantPlan:     []string{"0:9000:x"},
+			wantClients:  []string{"skip 邮箱已被使用", "skip 邮箱为空", "create "},
+		},
+		{
+			name:         "邮箱冲突时覆盖，保留原客户端的订阅ID",
+			strategy:     ConflictOverwrite,
+			inbounds:     []*ExportInbound{vless(9000, "x", client("bob", "bobsub"), client("carol", ""), client("carol", ""))},
+			wantInbounds: []string{"create "},
+			wantPlan:     []string{"0:9000:x"},
+			wantClients:  []string{"overwrite ", "create ", "skip 导入文件中存在重复的邮箱"},
+		},
+		{
+			name:         "邮箱冲突时改名，订阅ID冲突时重新生成",
+			strategy:     ConflictRenumber,
+			inbounds:     []*ExportInbound{vless(9000, "x", client("bob", "bobsub"), client("bob", ""))},
+			wantInbounds: []string{"create "},
+			wantPlan:     []string{"0:9000:x"},
+			wantClients:  []string{"create 邮箱改为 bob_2；订阅ID冲突，已重新生成", "create 邮箱改为 bob_3"},
+		},
+	}
+
+	inboundService := InboundService{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bundle := &ExportBundle{Version: exportBundleVersion, Inbounds: test.inbounds}
+			options := &ImportOptions{PortStrategy: test.strategy, TagStrategy: test.strategy, EmailStrategy: test.strategy}
+			plan, report, err := inboundService.planImport(bundle, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := reportSummary(report.Inbounds); !slices.Equal(got, test.wantInbounds) {
+				t.Errorf("入站报告为 %q，期望 %q", got, test.wantInbounds)
+			}
+			if got := reportSummary(report.Clients); !slices.Equal(got, test.wantClients) {
+				t.Errorf("客户端报告为 %q，期望 %q", got, test.wantClients)
+			}
+			var got []string
+			for _, item := range plan {
+				got = append(got, fmt.Sprintf("%d:%d:%s", item.inbound.ID, item.inbound.Port, item.inbound.Tag))
+			}
+			if !slices.Equal(got, test.wantPlan) {
+				t.Errorf("导入计划为 %q，期望 %q", got, test.wantPlan)
+			}
+		})
+	}
+
+	// 覆盖的客户端保留ID，订阅ID不再与原客户端冲突；改名的客户端使用新的订阅ID
+	bundle := &ExportBundle{Version: exportBundleVersion, Inbounds: []*ExportInbound{vless(9000, "x", client("bob", "bobsub"))}}
+	plan, _, err := inboundService.planImport(bundle, &ImportOptions{PortStrategy: ConflictSkip, TagStrategy: ConflictSkip, EmailStrategy: ConflictOverwrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if overwritten := plan[0].clients[0]; overwritten.ID != bob.ID || overwritten.SubID != "bobsub" || overwritten.UUID != "bob-uuid" {
+		t.Errorf("覆盖的客户端为 %+v", overwritten)
+	}
+	plan, _, err = inboundService.planImport(bundle, &ImportOptions{PortStrategy: ConflictSkip, TagStrategy: ConflictSkip, EmailStrategy: ConflictRenumber})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed := plan[0].clients[0]; renamed.ID != 0 || renamed.SubID == "bobsub" || len(renamed.SubID) != 16 {
+		t.Errorf("改名的客户端为 %+v", renamed)
+	}
+}
+
+func TestImportInboundsDryRun(t *testing.T) {
+	setupTestDB(t)
+	createTestInbound(t, &database.InboundConfig{Protocol: "vless", Tag: "a", Port: 443})
+	bundle := &ExportBundle{
+		Version: exportBundleVersion,
+		Inbounds: []*ExportInbound{
+			{Protocol: "vless", Port: 443, Tag: "x"},
+			{Protocol: "trojan", Port: 8443, Tag: "y", Clients: []*ExportClient{{Email: "carol", UUID: "secret"}}},
+		},
+	}
+	inboundService := InboundService{}
+
+	// 未指定策略时按跳过处理，试运行不写入数据库
+	report, err := inboundService.ImportInbounds(bundle, &ImportOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun {
+		t.Error("报告应标记为试运行")
+	}
+	want := []string{"skip 端口 443 已被使用", "create "}
+	if got := reportSummary(report.Inbounds); !slices.Equal(got, want) {
+		t.Errorf("入站报告为 %q，期望 %q", got, want)
+	}
+	inbounds, err := inboundService.GetInbounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbounds) != 1 {
+		t.Fatalf("试运行后入站数量为 %d，应为 1", len(inbounds))
+	}
+
+	report, err = inboundService.ImportInbounds(bundle, &ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DryRun {
+		t.Error("报告不应标记为试运行")
+	}
+	inbounds, err = inboundService.GetInbounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbounds) != 2 || inbounds[1].Port != 8443 {
+		t.Fatalf("导入后的入站为 %v", inbounds)
+	}
+	clientService := ClientService{}
+	carol, err := clientService.GetClientByEmail("carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if carol.InboundID != inbounds[1].ID || carol.UUID != "secret" {
+		t.Errorf("导入的客户端为 %+v", carol)
+	}
+
+	_, err = inboundService.ImportInbounds(bundle, &ImportOptions{PortStrategy: "merge"})
+	if err == nil || err.Error() != "未知的冲突处理策略: merge" {
+		t.Errorf("未知策略时错误为 %v", err)
+	}
+	_, err = inboundService.ImportInbounds(&ExportBundle{Version: exportBundleVersion + 1}, &ImportOptions{})
+	if err == nil {
+		t.Error("不支持的版本应返回错误")
+	}
+}
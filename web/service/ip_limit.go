@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"mx-ui/database"
+	"mx-ui/logger"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 客户端超出IP限制时的处理方式
+const (
+	IPLimitActionLog     = "log"
+	IPLimitActionDisable = "disable"
+	IPLimitActionBanList = "banlist"
+)
+
+// IPLimitViolation 客户端超出IP限制的情况
+type IPLimitViolation struct {
+	ClientID uint     `json:"clientId"`
+	Email    string   `json:"email"`
+	Limit    int      `json:"limit"`
+	IPs      []string `json:"ips"`
+	Excess   []string `json:"excess"`
+}
+
+// checkIPLimits 统计客户端在since之后的不同来源IP，返回超出限制的客户端。
+// 按首次出现顺序保留前Limit个IP，之后出现的IP视为多出的IP
+func checkIPLimits(store *connectionStore, clients []*database.ClientConfig, since time.Time) []*IPLimitViolation {
+	var violations []*IPLimitViolation
+	for _, client := range clients {
+		if client.LimitIP <= 0 {
+			continue
+		}
+		// 临时禁用结束前的连接不再计入，避免解除后立即再次被禁用
+		start := since
+		if released := time.UnixMilli(client.DisabledUntil); client.DisabledUntil > 0 && released.After(start) {
+			start = released
+		}
+		list := store.since(client.Email, start)
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Time.Before(list[j].Time)
+		})
+
+		var ips []string
+		seen := map[string]struct{}{}
+		for _, conn := range list {
+			if _, ok := seen[conn.SourceIP]; ok {
+				continue
+			}
+			seen[conn.SourceIP] = struct{}{}
+			ips = append(ips, conn.SourceIP)
+		}
+		if len(ips) <= client.LimitIP {
+			continue
+		}
+		violations = append(violations, &IPLimitViolation{
+			ClientID: client.ID,
+			Email:    client.Email,
+			Limit:    client.LimitIP,
+			IPs:      ips,
+			Excess:   ips[client.LimitIP:],
+		})
+	}
+	return violations
+}
+
+// writeBanList 将需要封禁的IP写入文件，每行一个，通过重命名原子地替换旧文件
+func writeBanList(path string, violations []*IPLimitViolation) error {
+	ipSet := map[string]struct{}{}
+	for _, violation := range violations {
+		for _, ip := range violation.Excess {
+			ipSet[ip] = struct{}{}
+		}
+	}
+	ips := make([]string, 0, len(ipSet))
+	for ip := range ipSet {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	content := strings.Join(ips, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, []byte(content), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// IPLimitJob 定期检查客户端的来源IP数量并按设置处理超出限制的客户端
+type IPLimitJob struct {
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// lastCheck 上次检查的时间（毫秒），用于发现到期的临时禁用
+	lastCheck int64
+}
+
+// NewIPLimitJob 创建IP限制检查任务
+func NewIPLimitJob() *IPLimitJob {
+	return &IPLimitJob{}
+}
+
+// Start 每分钟检查一次客户端的来源IP数量，ctx取消时停止检查
+func (j *IPLimitJob) Start(ctx context.Context) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	j.cancel = cancel
+	j.done = make(chan struct{})
+	go j.run(ctx, j.done)
+}
+
+// Stop 停止IP限制检查
+func (j *IPLimitJob) Stop() {
+	j.lock.Lock()
+	cancel, done := j.cancel, j.done
+	j.cancel = nil
+	j.lock.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (j *IPLimitJob) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := j.Check()
+			if err != nil {
+				logger.Warning("检查客户端IP限制失败:", err)
+			}
+		}
+	}
+}
+
+// Check 检查一次所有设置了IP限制的客户端，并按设置的方式处理超出限制的客户端
+func (j *IPLimitJob) Check() error {
+	settingService := SettingService{}
+	window, err := settingService.GetIPLimitWindow()
+	if err != nil {
+		return err
+	}
+	action, err := settingService.GetIPLimitAction()
+	if err != nil {
+		return err
+	}
+	disableMinutes, err := settingService.GetIPLimitDisableMinutes()
+	if err != nil {
+		return err
+	}
+
+	clientService := ClientService{}
+	clients, err := clientService.GetClients(0)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	nowMilli := now.UnixMilli()
+	lastCheck := j.lastCheck
+	j.lastCheck = nowMilli
+
+	changed := false
+	var validClients []*database.ClientConfig
+	for _, client := range clients {
+		// 临时禁用到期后需要重新生成配置
+		if client.DisabledUntil > lastCheck && client.DisabledUntil <= nowMilli {
+			logger.Infow("客户端临时禁用已到期", "email", client.Email)
+			changed = true
+		}
+		if clientService.IsClientValid(client) {
+			validClients = append(validClients, client)
+		}
+	}
+
+	violations := checkIPLimits(connections, validClients, now.Add(-time.Duration(window)*time.Minute))
+	for _, violation := range violations {
+		logger.Warningw("客户端来源IP数量超出限制",
+			"email", violation.Email,
+			"limit", violation.Limit,
+			"ips", strings.Join(violation.IPs, ","),
+			"action", action)
+
+		if action == IPLimitActionDisable {
+			until := now.Add(time.Duration(disableMinutes) * time.Minute).UnixMilli()
+			err = database.GetDB().Model(&database.ClientConfig{}).
+				Where("id = ?", violation.ClientID).
+				Update("disabled_until", until).Error
+			if err != nil {
+				return err
+			}
+			changed = true
+		}
+	}
+
+	if action == IPLimitActionBanList {
+		banFile, err := settingService.GetIPLimitBanFile()
+		if err != nil {
+			return err
+		}
+		err = writeBanList(banFile, violations)
+		if err != nil {
+			return err
+		}
+	}
+
+	if changed {
+		xrayService := XrayService{}
+		return xrayService.ApplyConfig()
+	}
+	return nil
+}
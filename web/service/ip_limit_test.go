@@ -0,0 +1,224 @@
+package service
+
+import (
+	"mx-ui/database"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseAccessLogLine(t *testing.T) {
+	tests := []struct {
+		line string
+		ok   bool
+		want ClientConnection
+	}{
+		{
+			line: "2024/01/02 15:04:05.123456 from 1.2.3.4:5678 accepted tcp:example.com:443 [inbound-443 -> direct] email: alice",
+			ok:   true,
+			want: ClientConnection{Email: "alice", SourceIP: "1.2.3.4", Network: "tcp", Destination: "example.com:443", InboundTag: "inbound-443", OutboundTag: "direct"},
+		},
+		{
+			line: "2024/01/02 15:04:05 tcp:[2001:db8::1]:5678 accepted udp:8.8.8.8:53 [inbound-443 >> proxy] email: bob",
+			ok:   true,
+			want: ClientConnection{Email: "bob", SourceIP: "2001:db8::1", Network: "udp", Destination: "8.8.8.8:53", InboundTag: "inbound-443", OutboundTag: "proxy"},
+		},
+		{
+			line: "2024/01/02 15:04:05 from 1.2.3.4:5678 accepted tcp:example.com:443 [api]",
+		},
+		{
+			line: "2024/01/02 15:04:05 from 1.2.3.4:5678 rejected  proxy/vless/encoding: invalid request user id",
+		},
+	}
+	for _, test := range tests {
+		conn, ok := parseAccessLogLine(test.line)
+		if ok != test.ok {
+			t.Fatalf("%q: ok = %v，应为 %v", test.line, ok, test.ok)
+		}
+		if !ok {
+			continue
+		}
+		test.want.Time = conn.Time
+		if *conn != test.want {
+			t.Fatalf("%q 解析为 %+v，应为 %+v", test.line, *conn, test.want)
+		}
+		if conn.Time.Format("2006-01-02 15:04:05") != "2024-01-02 15:04:05" {
+			t.Fatalf("%q: 时间解析错误 %v", test.line, conn.Time)
+		}
+	}
+}
+
+// feedAccessLog 将访问日志行解析后加入连接记录
+func feedAccessLog(t *testing.T, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		conn, ok := parseAccessLogLine(line)
+		if !ok {
+			t.Fatalf("无法解析访问日志: %q", line)
+		}
+		connections.add(conn)
+	}
+}
+
+// setupIPLimit 创建客户端并写入访问日志：alice限制2个IP，最近用了3个；
+// bob限制2个IP，只用了2个；carol不限制；dave限制1个IP，但超出的连接在统计窗口之外
+func setupIPLimit(t *testing.T) map[string]*database.ClientConfig {
+	t.Helper()
+	setupTestDB(t)
+	resetConnections(t)
+	inbound := createTestInbound(t, &database.InboundConfig{Protocol: "vless", Port: 443, Tag: "inbound-443", Enable: true})
+	clients := map[string]*database.ClientConfig{}
+	for email, limit := range map[string]int{"alice": 2, "bob": 2, "carol": 0, "dave": 1} {
+		clients[email] = createTestClient(t, &database.ClientConfig{
+			InboundID: inbound.ID,
+			Email:     email,
+			UUID:      randomUUID(),
+			Enable:    true,
+			LimitIP:   limit,
+			SubID:     randomString(16),
+		})
+	}
+
+	now := time.Now()
+	feedAccessLog(t,
+		accessLogLine(now.Add(-20*time.Minute), "9.9.9.9", "dave"),
+		accessLogLine(now.Add(-4*time.Minute), "10.0.0.1", "alice"),
+		accessLogLine(now.Add(-4*time.Minute), "10.0.0.1", "dave"),
+		accessLogLine(now.Add(-3*time.Minute), "10.0.0.2", "alice"),
+		accessLogLine(now.Add(-3*time.Minute), "10.0.1.1", "bob"),
+		accessLogLine(now.Add(-2*time.Minute), "10.0.0.1", "alice"),
+		accessLogLine(now.Add(-2*time.Minute), "10.0.1.2", "bob"),
+		accessLogLine(now.Add(-time.Minute), "10.0.0.3", "alice"),
+		accessLogLine(now.Add(-time.Minute), "10.0.1.1", "bob"),
+		accessLogLine(now.Add(-time.Minute), "10.0.2.1", "carol"),
+		accessLogLine(now.Add(-time.Minute), "10.0.2.2", "carol"),
+		accessLogLine(now.Add(-time.Minute), "10.0.2.3", "carol"),
+	)
+	return clients
+}
+
+func TestCheckIPLimits(t *testing.T) {
+	clients := setupIPLimit(t)
+
+	connectionService := ConnectionService{}
+	for email, want := range map[string]int{"alice": 3, "bob": 2, "carol": 3, "dave": 1} {
+		if ips := connectionService.GetDistinctIPs(email, 5); len(ips) != want {
+			t.Errorf("%s 最近5分钟的不同IP: %v，应为 %d 个", email, ips, want)
+		}
+	}
+
+	list := []*database.ClientConfig{clients["alice"], clients["bob"], clients["carol"], clients["dave"]}
+	violations := checkIPLimits(connections, list, time.Now().Add(-5*time.Minute))
+	if len(violations) != 1 {
+		t.Fatalf("应只有alice超出限制，实际: %+v", violations)
+	}
+	violation := violations[0]
+	if violation.Email != "alice" || violation.Limit != 2 || len(violation.IPs) != 3 ||
+		len(violation.Excess) != 1 || violation.Excess[0] != "10.0.0.3" {
+		t.Fatalf("alice的超出情况错误: %+v", violation)
+	}
+
+	// 统计窗口扩大到30分钟后dave也超出限制
+	violations = checkIPLimits(connections, list, time.Now().Add(-30*time.Minute))
+	if len(violations) != 2 || violations[1].Email != "dave" || violations[1].Excess[0] != "10.0.0.1" {
+		t.Fatalf("30分钟窗口的超出情况错误: %+v", violations)
+	}
+}
+
+func TestIPLimitActionLog(t *testing.T) {
+	clients := setupIPLimit(t)
+	settingService := SettingService{}
+	banFile, _ := settingService.GetIPLimitBanFile()
+
+	job := NewIPLimitJob()
+	err := job.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientService := ClientService{}
+	alice, _ := clientService.GetClient(clients["alice"].ID)
+	if alice.DisabledUntil != 0 {
+		t.Fatal("只记录日志时不应禁用客户端")
+	}
+	if _, err := os.Stat(banFile); !os.IsNotExist(err) {
+		t.Fatal("只记录日志时不应写入封禁列表")
+	}
+}
+
+func TestIPLimitActionDisable(t *testing.T) {
+	clients := setupIPLimit(t)
+	settingService := SettingService{}
+	if err := settingService.SetIPLimitAction(IPLimitActionDisable); err != nil {
+		t.Fatal(err)
+	}
+	if err := settingService.SetIPLimitDisableMinutes(30); err != nil {
+		t.Fatal(err)
+	}
+
+	job := NewIPLimitJob()
+	start := time.Now().Truncate(time.Millisecond)
+	err := job.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientService := ClientService{}
+	alice, _ := clientService.GetClient(clients["alice"].ID)
+	until := time.UnixMilli(alice.DisabledUntil)
+	if until.Before(start.Add(30*time.Minute)) || until.After(time.Now().Add(30*time.Minute)) {
+		t.Fatalf("alice应临时禁用30分钟，DisabledUntil为 %v", until)
+	}
+	if clientService.IsClientValid(alice) {
+		t.Fatal("临时禁用期间alice不应有效")
+	}
+	bob, _ := clientService.GetClient(clients["bob"].ID)
+	if bob.DisabledUntil != 0 || !clientService.IsClientValid(bob) {
+		t.Fatal("bob未超出限制，不应被禁用")
+	}
+
+	// 禁用期间再次检查不会延长禁用时间
+	err = job.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := clientService.GetClient(clients["alice"].ID)
+	if again.DisabledUntil != alice.DisabledUntil {
+		t.Fatal("禁用期间再次检查不应修改禁用时间")
+	}
+}
+
+func TestIPLimitActionBanList(t *testing.T) {
+	setupIPLimit(t)
+	settingService := SettingService{}
+	if err := settingService.SetIPLimitAction(IPLimitActionBanList); err != nil {
+		t.Fatal(err)
+	}
+	banFile := filepath.Join(t.TempDir(), "firewall", "banned.txt")
+	if err := settingService.SetIPLimitBanFile(banFile); err != nil {
+		t.Fatal(err)
+	}
+
+	job := NewIPLimitJob()
+	err := job.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(banFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "10.0.0.3\n" {
+		t.Fatalf("封禁列表内容为 %q，应为 \"10.0.0.3\\n\"", data)
+	}
+
+	// 没有超出限制的客户端时清空封禁列表
+	resetConnections(t)
+	err = job.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(banFile)
+	if len(data) != 0 {
+		t.Fatalf("封禁列表应为空，实际为 %q", data)
+	}
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mx-ui/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 日志来源
+const (
+	LogSourcePanel      = "panel"
+	LogSourceXrayAccess = "access"
+	LogSourceXrayError  = "error"
+)
+
+// 读取Xray日志时最多读取文件末尾的字节数
+const xrayLogTailSize = 1024 * 1024
+
+// Xray日志的时间格式，新版本带微秒
+var xrayLogTimeLayouts = []string{
+	"2006/01/02 15:04:05.000000",
+	"2006/01/02 15:04:05",
+}
+
+// Xray日志中的级别标记
+var xrayLogLevels = map[string]slog.Level{
+	"debug":   logger.LevelDebug,
+	"info":    logger.LevelInfo,
+	"warning": logger.LevelWarning,
+	"error":   logger.LevelError,
+}
+
+// LogService 日志服务
+type LogService struct{}
+
+// GetLogs 获取指定来源的最近日志
+func (s *LogService) GetLogs(source string, level slog.Level, keyword string, limit int) ([]logger.Entry, error) {
+	if source == "" || source == LogSourcePanel {
+		return logger.GetLogs(level, keyword, limit), nil
+	}
+	path, err := s.GetXrayLogPath(source)
+	if err != nil {
+		return nil, err
+	}
+	return s.readXrayLogs(path, level, keyword, limit)
+}
+
+// FollowLogs 获取指定来源的最近日志，并通过返回的通道持续推送之后的新日志，直到ctx结束。
+// 先开始跟踪再读取最近日志，两者之间产生的日志不会遗漏或重复
+func (s *LogService) FollowLogs(ctx context.Context, source string, level slog.Level, keyword string, limit int) ([]logger.Entry, <-chan logger.Entry, error) {
+	if source == "" || source == LogSourcePanel {
+		entries, ch, cancel := logger.Follow(level, keyword, limit)
+		context.AfterFunc(ctx, cancel)
+		return entries, ch, nil
+	}
+	path, err := s.GetXrayLogPath(source)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines, offset, err := readTailLines(path, xrayLogTailSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries := filterXrayLogs(lines, level, keyword, limit)
+	ch := make(chan logger.Entry, 100)
+	go tailFile(ctx, path, offset, func(line string) {
+		entry := parseXrayLogLine(line)
+		if !entry.Match(level, keyword) {
+			return
+		}
+		select {
+		case ch <- entry:
+		case <-ctx.Done():
+		}
+	})
+	return entries, ch, nil
+}
+
+// GetXrayLogPath 从Xray配置模板中获取访问日志或错误日志的路径
+func (s *LogService) GetXrayLogPath(source string) (string, error) {
+	if source != LogSourceXrayAccess && source != LogSourceXrayError {
+		return "", fmt.Errorf("未知日志来源: %s", source)
+	}
+	settingService := SettingService{}
+	template, err := settingService.GetXrayConfigTemplate()
+	if err != nil {
+		return "", err
+	}
+	var xrayConfig struct {
+		Log struct {
+			Access string `json:"access"`
+			Error  string `json:"error"`
+		} `json:"log"`
+	}
+	err = json.Unmarshal([]byte(template), &xrayConfig)
+	if err != nil {
+		return "", fmt.Errorf("Xray配置模板无效: %v", err)
+	}
+	path := xrayConfig.Log.Access
+	if source == LogSourceXrayError {
+		path = xrayConfig.Log.Error
+	}
+	if path == "" || path == "none" {
+		return "", fmt.Errorf("Xray配置模板中未设置%s日志文件", source)
+	}
+	// Xray进程与面板使用相同的工作目录，相对路径按面板工作目录解析
+	return filepath.Abs(path)
+}
+
+// readXrayLogs 读取Xray日志文件末尾的日志
+func (s *LogService) readXrayLogs(path string, level slog.Level, keyword string, limit int) ([]logger.Entry, error) {
+	lines, _, err := readTailLines(path, xrayLogTailSize)
+	if err != nil {
+		return nil, err
+	}
+	return filterXrayLogs(lines, level, keyword, limit), nil
+}
+
+// filterXrayLogs 解析并筛选Xray日志，limit大于0时只保留最后limit条
+func filterXrayLogs(lines []string, level slog.Level, keyword string, limit int) []logger.Entry {
+	entries := []logger.Entry{}
+	for _, line := range lines {
+		entry := parseXrayLogLine(line)
+		if entry.Match(level, keyword) {
+			entries = append(entries, entry)
+		}
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries
+}
+
+// readTailLines 读取文件末尾最多maxSize字节中的完整行，同时返回已读取到的位置，
+// 从该位置继续跟踪文件不会遗漏或重复。文件不存在时返回空，位置为0
+func readTailLines(path string, maxSize int64) ([]string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	offset := info.Size() - maxSize
+	if offset < 0 {
+		offset = 0
+	}
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, 0, err
+	}
+	// 只读取到Stat时的大小，之后写入的内容由跟踪读取
+	data, err := io.ReadAll(io.LimitReader(file, info.Size()-offset))
+	if err != nil {
+		return nil, 0, err
+	}
+	end := offset + int64(len(data))
+	if offset > 0 {
+		// 丢弃被截断的第一行
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+	// 最后不完整的一行留给跟踪读取
+	if i := bytes.LastIndexByte(data, '\n'); i+1 < len(data) {
+		end -= int64(len(data) - (i + 1))
+		data = data[:i+1]
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), int(maxSize)+1)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, end, scanner.Err()
+}
+
+// tailFile 从offset开始每秒轮询新增的行，offset小于0时从文件末尾开始，
+// 文件被截断或轮转时从头读取，直到ctx结束。返回结束时已读取到的位置
+func tailFile(ctx context.Context, path string, offset int64, handle func(line string)) int64 {
+	var pending []byte
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		info, err := os.Stat(path)
+		if err == nil {
+			if offset < 0 {
+				offset = info.Size()
+			} else if info.Size() < offset {
+				offset = 0
+				pending = nil
+			}
+			if info.Size() > offset {
+				data, err := readFileRange(path, offset, info.Size())
+				if err == nil {
+					offset += int64(len(data))
+					pending = append(pending, data...)
+					for {
+						i := bytes.IndexByte(pending, '\n')
+						if i < 0 {
+							break
+						}
+						line := strings.TrimRight(string(pending[:i]), "\r")
+						pending = pending[i+1:]
+						if line != "" {
+							handle(line)
+						}
+					}
+				}
+			}
+		} else if os.IsNotExist(err) && offset < 0 {
+			// 文件尚未创建，创建后从头读取
+			offset = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			// 未读完的半行下次从头读取
+			return offset - int64(len(pending))
+		case <-ticker.C:
+		}
+	}
+}
+
+// readFileRange 读取文件中[start, end)范围的内容
+func readFileRange(path string, start int64, end int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data := make([]byte, end-start)
+	n, err := file.ReadAt(data, start)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+// parseXrayLogLine 解析一行Xray日志，格式为“时间 [级别] 内容”，访问日志没有级别
+func parseXrayLogLine(line string) logger.Entry {
+	t := time.Time{}
+	message := line
+	for _, layout := range xrayLogTimeLayouts {
+		if len(line) < len(layout) {
+			continue
+		}
+		parsed, err := time.ParseInLocation(layout, line[:len(layout)], time.Local)
+		if err == nil {
+			t = parsed
+			message = strings.TrimSpace(line[len(layout):])
+			break
+		}
+	}
+
+	level := logger.LevelInfo
+	if strings.HasPrefix(message, "[") {
+		if i := strings.IndexByte(message, ']'); i > 0 {
+			if parsed, ok := xrayLogLevels[strings.ToLower(message[1:i])]; ok {
+				level = parsed
+				message = strings.TrimSpace(message[i+1:])
+			}
+		}
+	}
+	return logger.NewEntry(t, level, message)
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mx-ui/database"
+	"mx-ui/xray"
+	"strings"
+)
+
+// outboundRequiredSettings 各出站协议支持情况及settings中必须包含的字段
+var outboundRequiredSettings = map[string][]string{
+	"freedom":     nil,
+	"blackhole":   nil,
+	"dns":         nil,
+	"socks":       {"servers"},
+	"http":        {"servers"},
+	"vmess":       {"vnext"},
+	"vless":       {"vnext"},
+	"trojan":      {"servers"},
+	"shadowsocks": {"servers"},
+	"wireguard":   {"secretKey", "peers"},
+}
+
+// OutboundService 出站相关服务
+type OutboundService struct{}
+
+// GetOutbounds 获取所有出站
+func (s *OutboundService) GetOutbounds() ([]*database.OutboundConfig, error) {
+	var outbounds []*database.OutboundConfig
+	err := database.GetDB().Order("id ASC").Find(&outbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	return outbounds, nil
+}
+
+// GetOutbound 根据ID获取出站
+func (s *OutboundService) GetOutbound(id uint) (*database.OutboundConfig, error) {
+	outbound := &database.OutboundConfig{}
+	err := database.GetDB().First(outbound, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return outbound, nil
+}
+
+// AddOutbound 添加出站，生成的Xray配置检查不通过时拒绝
+func (s *OutboundService) AddOutbound(outbound *database.OutboundConfig) error {
+	err := s.checkOutbound(outbound)
+	if err != nil {
+		return err
+	}
+	err = checkConfigChange(func(source *configSource) {
+		source.setOutbound(outbound)
+	})
+	if err != nil {
+		return err
+	}
+	return database.GetDB().Create(outbound).Error
+}
+
+// UpdateOutbound 更新出站，修改标签或禁用后仍被路由规则或客户端引用、或生成的Xray配置检查不通过时拒绝
+func (s *OutboundService) UpdateOutbound(outbound *database.OutboundConfig) error {
+	old, err := s.GetOutbound(outbound.ID)
+	if err != nil {
+		return err
+	}
+	err = s.checkOutbound(outbound)
+	if err != nil {
+		return err
+	}
+
+	old.Tag = outbound.Tag
+	old.Protocol = outbound.Protocol
+	old.Enable = outbound.Enable
+	old.SendThrough = outbound.SendThrough
+	old.Settings = outbound.Settings
+	old.StreamSettings = outbound.StreamSettings
+	old.Mux = outbound.Mux
+	old.Remark = outbound.Remark
+	err = checkConfigChange(func(source *configSource) {
+		source.setOutbound(old)
+	})
+	if err != nil {
+		return err
+	}
+	return database.GetDB().Save(old).Error
+}
+
+// DelOutbound 删除出站，仍被路由规则或客户端引用时拒绝
+func (s *OutboundService) DelOutbound(id uint) error {
+	_, err := s.GetOutbound(id)
+	if err != nil {
+		return err
+	}
+	err = checkConfigChange(func(source *configSource) {
+		source.delOutbound(id)
+	})
+	if err != nil {
+		return err
+	}
+	return database.GetDB().Delete(&database.OutboundConfig{}, id).Error
+}
+
+// checkOutbound 检查出站参数及标签是否冲突
+func (s *OutboundService) checkOutbound(outbound *database.OutboundConfig) error {
+	outbound.Tag = strings.TrimSpace(outbound.Tag)
+	if outbound.Tag == "" {
+		return errors.New("标签不能为空")
+	}
+	required, ok := outboundRequiredSettings[outbound.Protocol]
+	if !ok {
+		return fmt.Errorf("不支持的出站协议: %v", outbound.Protocol)
+	}
+
+	settings := map[string]json.RawMessage{}
+	if outbound.Settings != "" {
+		err := json.Unmarshal([]byte(outbound.Settings), &settings)
+		if err != nil {
+			return errors.New("出站设置不是有效的JSON对象")
+		}
+	}
+	for _, key := range required {
+		if _, ok := settings[key]; !ok {
+			return fmt.Errorf("%s 出站设置中缺少 %s", outbound.Protocol, key)
+		}
+	}
+	if outbound.StreamSettings != "" && !json.Valid([]byte(outbound.StreamSettings)) {
+		return errors.New("传输设置不是有效的JSON")
+	}
+	if outbound.Mux != "" && !json.Valid([]byte(outbound.Mux)) {
+		return errors.New("Mux设置不是有效的JSON")
+	}
+
+	var count int64
+	err := database.GetDB().Model(&database.OutboundConfig{}).
+		Where("id <> ? AND tag = ?", outbound.ID, outbound.Tag).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("标签已被其他出站使用")
+	}
+	return nil
+}
+
+// mergeOutbounds 将启用的出站合并到模板的出站中，标签相同时替换模板中的出站，其余依次追加
+func mergeOutbounds(template []xray.OutboundConfig, outbounds []*database.OutboundConfig) []xray.OutboundConfig {
+	result := append([]xray.OutboundConfig{}, template...)
+	index := map[string]int{}
+	for i, outbound := range result {
+		if outbound.Tag != "" {
+			index[outbound.Tag] = i
+		}
+	}
+	for _, outbound := range outbounds {
+		if !outbound.Enable {
+			continue
+		}
+		config := genOutboundConfig(outbound)
+		if i, ok := index[config.Tag]; ok {
+			result[i] = config
+			continue
+		}
+		index[config.Tag] = len(result)
+		result = append(result, config)
+	}
+	return result
+}
+
+// genOutboundConfig 生成单个出站的Xray配置
+func genOutboundConfig(outbound *database.OutboundConfig) xray.OutboundConfig {
+	config := xray.OutboundConfig{
+		SendThrough: outbound.SendThrough,
+		Protocol:    outbound.Protocol,
+		Tag:         outbound.Tag,
+	}
+	if outbound.Settings != "" {
+		config.Settings = json.RawMessage(outbound.Settings)
+	}
+	if outbound.StreamSettings != "" {
+		config.StreamSettings = json.RawMessage(outbound.StreamSettings)
+	}
+	if outbound.Mux != "" {
+		config.Mux = json.RawMessage(outbound.Mux)
+	}
+	return config
+}
+
+// checkRoutingTags 检查路由规则引用的出站标签和负载均衡器都存在，负载均衡器的选择器至少匹配一个出站
+func checkRoutingTags(xrayConfig *xray.Config) error {
+	if len(xrayConfig.RouterConfig) == 0 {
+		return nil
+	}
+	var routing struct {
+		Rules []struct {
+			OutboundTag string `json:"outboundTag"`
+			BalancerTag string `json:"balancerTag"`
+		} `json:"rules"`
+		Balancers []struct {
+			Tag      string   `json:"tag"`
+			Selector []string `json:"selector"`
+		} `json:"balancers"`
+	}
+	err := json.Unmarshal(xrayConfig.RouterConfig, &routing)
+	if err != nil {
+		return fmt.Errorf("解析路由配置失败: %v", err)
+	}
+
+	outboundTags := map[string]bool{}
+	for _, outbound := range xrayConfig.OutboundConfigs {
+		if outbound.Tag != "" {
+			outboundTags[outbound.Tag] = true
+		}
+	}
+	// 路由规则可以把流量交给API处理
+	var api struct {
+		Tag string `json:"tag"`
+	}
+	if len(xrayConfig.API) > 0 && json.Unmarshal(xrayConfig.API, &api) == nil && api.Tag != "" {
+		outboundTags[api.Tag] = true
+	}
+
+	balancerTags := map[string]bool{}
+	for _, balancer := range routing.Balancers {
+		balancerTags[balancer.Tag] = true
+		for _, selector := range balancer.Selector {
+			matched := false
+			for tag := range outboundTags {
+				if strings.HasPrefix(tag, selector) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return fmt.Errorf("负载均衡器 %s 的选择器 %s 没有匹配的出站", balancer.Tag, selector)
+			}
+		}
+	}
+
+	for i, rule := range routing.Rules {
+		if rule.OutboundTag != "" && !outboundTags[rule.OutboundTag] {
+			return fmt.Errorf("第 %d 条路由规则指向不存在的出站: %s", i+1, rule.OutboundTag)
+		}
+		if rule.BalancerTag != "" && !balancerTags[rule.BalancerTag] {
+			return fmt.Errorf("第 %d 条路由规则指向不存在的负载均衡器: %s", i+1, rule.BalancerTag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"mx-ui/database"
+	"mx-ui/xray"
+	"slices"
+	"testing"
+)
+
+func TestCheckOutbound(t *testing.T) {
+	setupTestDB(t)
+	existing := &database.OutboundConfig{Tag: "wg", Protocol: "wireguard", Enable: true, Settings: `{"secretKey":"k","peers":[]}`}
+	err := database.GetDB().Create(existing).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		outbound database.OutboundConfig
+		err      string
+	}{
+		{name: "freedom不需要设置", outbound: database.OutboundConfig{Tag: " direct ", Protocol: "freedom"}},
+		{name: "vless包含vnext", outbound: database.OutboundConfig{Tag: "proxy", Protocol: "vless", Settings: `{"vnext":[]}`, Mux: `{"enabled":true}`}},
+		{name: "修改自身时标签不冲突", outbound: database.OutboundConfig{Model: existing.Model, Tag: "wg", Protocol: "wireguard", Settings: existing.Settings}},
+		{name: "标签为空", outbound: database.OutboundConfig{Tag: " ", Protocol: "freedom"}, err: "标签不能为空"},
+		{name: "不支持的协议", outbound: database.OutboundConfig{Tag: "x", Protocol: "hysteria"}, err: "不支持的出站协议: hysteria"},
+		{name: "缺少必需的字段", outbound: database.OutboundConfig{Tag: "x", Protocol: "wireguard", Settings: `{"secretKey":"k"}`}, err: "wireguard 出站设置中缺少 peers"},
+		{name: "设置不是对象", outbound: database.OutboundConfig{Tag: "x", Protocol: "socks", Settings: `[]`}, err: "出站设置不是有效的JSON对象"},
+		{name: "传输设置无效", outbound: database.OutboundConfig{Tag: "x", Protocol: "freedom", StreamSettings: `{`}, err: "传输设置不是有效的JSON"},
+		{name: "Mux设置无效", outbound: database.OutboundConfig{Tag: "x", Protocol: "freedom", Mux: `{`}, err: "Mux设置不是有效的JSON"},
+		{name: "标签冲突", outbound: database.OutboundConfig{Tag: "wg", Protocol: "freedom"}, err: "标签已被其他出站使用"},
+	}
+	outboundService := OutboundService{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := outboundService.checkOutbound(&test.outbound)
+			if test.err == "" && err != nil {
+				t.Fatalf("检查应通过，错误为 %v", err)
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Fatalf("错误为 %v，期望 %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestMergeOutbounds(t *testing.T) {
+	template := []xray.OutboundConfig{
+		{Tag: "direct", Protocol: "freedom"},
+		{Tag: "blocked", Protocol: "blackhole"},
+	}
+	outbounds := []*database.OutboundConfig{
+		{Tag: "wg", Protocol: "wireguard", Enable: true, Settings: `{"secretKey":"k","peers":[]}`},
+		{Tag: "direct", Protocol: "freedom", Enable: true, Settings: `{"domainStrategy":"UseIPv4"}`, SendThrough: "10.0.0.2"},
+		{Tag: "off", Protocol: "freedom", Enable: false},
+		{Tag: "proxy", Protocol: "vless", Enable: true, Settings: `{"vnext":[]}`, StreamSettings: `{"network":"ws"}`, Mux: `{"enabled":true}`},
+	}
+
+	// 标签相同时替换模板中的出站并保持位置，禁用的出站不生成，其余依次追加
+	merged := mergeOutbounds(template, outbounds)
+	var tags []string
+	for _, outbound := range merged {
+		tags = append(tags, outbound.Tag)
+	}
+	if want := []string{"direct", "blocked", "wg", "proxy"}; !slices.Equal(tags, want) {
+		t.Fatalf("合并后的出站为 %q，期望 %q", tags, want)
+	}
+	if direct := merged[0]; string(direct.Settings) != `{"domainStrategy":"UseIPv4"}` || direct.SendThrough != "10.0.0.2" {
+		t.Errorf("模板中的direct应被替换，实际为 %+v", direct)
+	}
+	if proxy := merged[3]; string(proxy.StreamSettings) != `{"network":"ws"}` || string(proxy.Mux) != `{"enabled":true}` {
+		t.Errorf("proxy出站为 %+v", proxy)
+	}
+	if merged[2].StreamSettings != nil || merged[2].Mux != nil {
+		t.Error("未设置的传输和Mux设置不应生成")
+	}
+	if template[0].Settings != nil {
+		t.Error("合并不应修改模板")
+	}
+}
+
+func TestOutboundInUse(t *testing.T) {
+	setupTestDB(t)
+	settingService := SettingService{}
+	err := settingService.SetXrayConfigTemplate(`{
+		"outbounds": [{"tag": "direct", "protocol": "freedom"}],
+		"routing": {"rules": [{"type": "field", "domain": ["example.com"], "outboundTag": "wg"}]}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outboundService := OutboundService{}
+	outbound := &database.OutboundConfig{Tag: "wg", Protocol: "wireguard", Enable: true, Settings: `{"secretKey":"k","peers":[]}`}
+	err = outboundService.AddOutbound(outbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 被路由规则引用的出站不能删除、禁用或改名
+	want := "第 1 条路由规则指向不存在的出站: wg"
+	err = outboundService.DelOutbound(outbound.ID)
+	if err == nil || err.Error() != want {
+		t.Errorf("删除被引用的出站时错误为 %v", err)
+	}
+	update := *outbound
+	update.Enable = false
+	err = outboundService.UpdateOutbound(&update)
+	if err == nil || err.Error() != want {
+		t.Errorf("禁用被引用的出站时错误为 %v", err)
+	}
+	update = *outbound
+	update.Tag = "wg2"
+	err = outboundService.UpdateOutbound(&update)
+	if err == nil || err.Error() != want {
+		t.Errorf("修改被引用的出站的标签时错误为 %v", err)
+	}
+	saved, err := outboundService.GetOutbound(outbound.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Tag != "wg" || !saved.Enable {
+		t.Errorf("操作失败后出站为 %+v", saved)
+	}
+
+	// 不再被引用后可以删除
+	err = settingService.SetXrayConfigTemplate(`{"outbounds": [{"tag": "direct", "protocol": "freedom"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = outboundService.DelOutbound(outbound.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"mx-ui/xray"
+)
+
+const randomLetters = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// randomUUID 生成随机的UUID（版本4）
+func randomUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// randomString 生成指定长度的随机字符串
+func randomString(n int) string {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(randomLetters)))
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			continue
+		}
+		b[i] = randomLetters[idx.Int64()]
+	}
+	return string(b)
+}
+
+// randomShortID 生成REALITY使用的shortId，为16位十六进制字符串
+func randomShortID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ToolService 生成REALITY密钥、shortId、UUID等随机值
+type ToolService struct{}
+
+// GenerateX25519 生成REALITY使用的x25519密钥对
+func (s *ToolService) GenerateX25519() (string, string, error) {
+	return xray.GenerateX25519()
+}
+
+// GenerateShortIDs 生成count个不重复的REALITY shortId
+func (s *ToolService) GenerateShortIDs(count int) []string {
+	shortIDs := make([]string, 0, count)
+	seen := map[string]bool{}
+	for len(shortIDs) < count {
+		shortID := randomShortID()
+		if !seen[shortID] {
+			seen[shortID] = true
+			shortIDs = append(shortIDs, shortID)
+		}
+	}
+	return shortIDs
+}
+
+// GenerateUUID 生成随机的UUID
+func (s *ToolService) GenerateUUID() string {
+	return randomUUID()
+}
+
+// GeneratePassword 生成随机密码
+func (s *ToolService) GeneratePassword() string {
+	return randomString(24)
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"mx-ui/database"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 检查REALITY目标网站的超时时间
+const realityCheckTimeout = 10 * time.Second
+
+// RealityCheckResult 检查REALITY目标网站的结果。REALITY要求目标支持TLS 1.3，
+// 最好支持h2，证书需要包含serverNames中的所有域名
+type RealityCheckResult struct {
+	Dest        string   `json:"dest"`
+	ServerNames []string `json:"serverNames"`
+	// ServerName 握手时使用的SNI
+	ServerName string `json:"serverName"`
+	OK         bool   `json:"ok"`
+	Error      string `json:"error"`
+	TLSVersion string `json:"tlsVersion"`
+	TLS13      bool   `json:"tls13"`
+	ALPN       string `json:"alpn"`
+	H2         bool   `json:"h2"`
+	// SANs 证书中的域名
+	SANs []string `json:"sans"`
+	// MissingServerNames 证书未覆盖的serverNames
+	MissingServerNames []string `json:"missingServerNames"`
+	// Latency 建立连接和TLS握手的耗时（毫秒）
+	Latency   int64 `json:"latency"`
+	CheckedAt int64 `json:"checkedAt"`
+}
+
+// RealityCheckService REALITY目标网站检查相关服务
+type RealityCheckService struct{}
+
+// Check 连接dest并检查TLS 1.3、h2支持和证书是否覆盖serverNames。dest格式为 域名:端口，
+// 省略端口时为443。无法连接或握手失败时返回的结果中包含错误原因
+func (s *RealityCheckService) Check(dest string, serverNames []string) (*RealityCheckResult, error) {
+	address, host, err := parseRealityDest(dest)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, name := range serverNames {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	serverName := host
+	if len(names) > 0 {
+		serverName = names[0]
+	}
+
+	result := &RealityCheckResult{
+		Dest:               dest,
+		ServerNames:        names,
+		ServerName:         serverName,
+		SANs:               []string{},
+		MissingServerNames: []string{},
+		CheckedAt:          time.Now().UnixMilli(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), realityCheckTimeout)
+	defer cancel()
+	dialer := &tls.Dialer{
+		Config: &tls.Config{
+			ServerName: serverName,
+			NextProtos: []string{"h2", "http/1.1"},
+			// 只检查证书中的域名，不验证证书链
+			InsecureSkipVerify: true,
+		},
+	}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	result.Latency = time.Since(start).Milliseconds()
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	result.TLSVersion = tls.VersionName(state.Version)
+	result.TLS13 = state.Version == tls.VersionTLS13
+	result.ALPN = state.NegotiatedProtocol
+	result.H2 = state.NegotiatedProtocol == "h2"
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		result.SANs = append(result.SANs, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			result.SANs = append(result.SANs, ip.String())
+		}
+		for _, name := range names {
+			if cert.VerifyHostname(name) != nil {
+				result.MissingServerNames = append(result.MissingServerNames, name)
+			}
+		}
+	} else {
+		result.MissingServerNames = append(result.MissingServerNames, names...)
+	}
+
+	switch {
+	case !result.TLS13:
+		result.Error = "目标网站不支持TLS 1.3"
+	case len(result.MissingServerNames) > 0:
+		result.Error = "证书不包含 " + strings.Join(result.MissingServerNames, ", ")
+	default:
+		result.OK = true
+	}
+	return result, nil
+}
+
+// CheckInbound 检查入站realitySettings中的dest和serverNames，结果保存到入站
+func (s *RealityCheckService) CheckInbound(id uint) (*RealityCheckResult, error) {
+	inboundService := InboundService{}
+	inbound, err := inboundService.GetInbound(id)
+	if err != nil {
+		return nil, err
+	}
+	stream := map[string]interface{}{}
+	if inbound.StreamSettings != "" {
+		err = json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if jsonString(stream, "security") != "reality" {
+		return nil, errors.New("入站未使用REALITY")
+	}
+	reality := jsonMap(stream, "realitySettings")
+	// target是dest的新名称，两者含义相同
+	dest := realityDestString(reality["target"])
+	if dest == "" {
+		dest = realityDestString(reality["dest"])
+	}
+
+	result, err := s.Check(dest, jsonStrings(reality, "serverNames"))
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	err = database.GetDB().Model(&database.InboundConfig{}).
+		Where("id = ?", id).
+		Update("reality_check", string(data)).Error
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// realityDestString 将dest转为字符串，dest可以只写端口号
+func realityDestString(value interface{}) string {
+	switch dest := value.(type) {
+	case string:
+		return dest
+	case float64:
+		return strconv.Itoa(int(dest))
+	}
+	return ""
+}
+
+// parseRealityDest 解析dest，返回连接地址和主机名。只写端口号时连接本机
+func parseRealityDest(dest string) (string, string, error) {
+	dest = strings.TrimSpace(dest)
+	if dest == "" {
+		return "", "", errors.New("dest不能为空")
+	}
+	if strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "@") {
+		return "", "", errors.New("不支持检查Unix套接字")
+	}
+	if port, err := strconv.Atoi(dest); err == nil {
+		dest = net.JoinHostPort("localhost", strconv.Itoa(port))
+	}
+	host, port, err := net.SplitHostPort(dest)
+	if err != nil {
+		host, port = strings.Trim(dest, "[]"), "443"
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil || portNum <= 0 || portNum > 65535 {
+		return "", "", errors.New("dest的端口无效")
+	}
+	if host == "" {
+		return "", "", errors.New("dest的地址无效")
+	}
+	return net.JoinHostPort(host, port), host, nil
+}
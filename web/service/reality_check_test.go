@@ -0,0 +1,127 @@
+package service
+
+import (
+	"crypto/tls"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTLSListener 在本机随机端口监听TLS，完成握手后关闭连接，返回监听地址
+func startTLSListener(t *testing.T, tlsConfig *tls.Config) string {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestRealityCheck(t *testing.T) {
+	cert := testCertificate(t, time.Now().Add(time.Hour), "example.com", "*.cdn.example.com")
+	wantSANs := []string{"example.com", "*.cdn.example.com", "127.0.0.1"}
+	modern := startTLSListener(t, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+		NextProtos:   []string{"h2", "http/1.1"},
+	})
+	legacy := startTLSListener(t, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MaxVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"http/1.1"},
+	})
+
+	tests := []struct {
+		name        string
+		dest        string
+		serverNames []string
+		tls13       bool
+		h2          bool
+		missing     []string
+		ok          bool
+		err         string
+	}{
+		{
+			name:        "TLS 1.3和h2，证书覆盖所有域名",
+			dest:        modern,
+			serverNames: []string{"example.com", " img.cdn.example.com ", ""},
+			tls13:       true,
+			h2:          true,
+			missing:     []string{},
+			ok:          true,
+		},
+		{
+			name:        "证书缺少域名",
+			dest:        modern,
+			serverNames: []string{"example.com", "example.org", "a.b.cdn.example.com"},
+			tls13:       true,
+			h2:          true,
+			missing:     []string{"example.org", "a.b.cdn.example.com"},
+			err:         "证书不包含 example.org, a.b.cdn.example.com",
+		},
+		{
+			name:        "只支持TLS 1.2",
+			dest:        legacy,
+			serverNames: []string{"example.com"},
+			missing:     []string{},
+			err:         "目标网站不支持TLS 1.3",
+		},
+	}
+	realityService := RealityCheckService{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := realityService.Check(test.dest, test.serverNames)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.TLS13 != test.tls13 {
+				t.Errorf("TLS13为 %v（%s），期望 %v", result.TLS13, result.TLSVersion, test.tls13)
+			}
+			if result.H2 != test.h2 {
+				t.Errorf("H2为 %v（ALPN %q），期望 %v", result.H2, result.ALPN, test.h2)
+			}
+			if !slices.Equal(result.SANs, wantSANs) {
+				t.Errorf("SANs为 %q，期望 %q", result.SANs, wantSANs)
+			}
+			if !slices.Equal(result.MissingServerNames, test.missing) {
+				t.Errorf("MissingServerNames为 %q，期望 %q", result.MissingServerNames, test.missing)
+			}
+			if result.OK != test.ok || result.Error != test.err {
+				t.Errorf("OK为 %v，错误为 %q，期望 %v 和 %q", result.OK, result.Error, test.ok, test.err)
+			}
+			if result.ServerName != strings.TrimSpace(test.serverNames[0]) {
+				t.Errorf("SNI为 %q，期望 %q", result.ServerName, test.serverNames[0])
+			}
+		})
+	}
+
+	// 无法连接时结果中包含错误原因，未指定serverNames时使用dest的主机名作为SNI
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+	result, err := realityService.Check(closed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OK || result.Error == "" || result.ServerName != "127.0.0.1" {
+		t.Errorf("无法连接时的结果为 %+v", result)
+	}
+}
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mx-ui/database"
+	"mx-ui/logger"
+	"sync"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"gorm.io/gorm"
+)
+
+// 最多保留的配置历史版本数量，超出时删除最早的版本
+const maxConfigRevisions = 100
+
+// revisionLock 保证记录版本和回滚依次进行，回滚的检查、写回和记录新版本之间不会插入其他版本
+var revisionLock sync.Mutex
+
+// revisionSnapshot 生成某个配置版本的数据，回滚时写回数据库
+type revisionSnapshot struct {
+	Template  string
+	Inbounds  []*database.InboundConfig
+	Clients   []*database.ClientConfig
+	Outbounds []*database.OutboundConfig
+	Rules     []*database.RoutingRule
+}
+
+// ConfigRevisionService Xray配置历史版本相关服务
+type ConfigRevisionService struct{}
+
+// GetRevisions 获取所有历史版本，最新的在前，不包含配置内容
+func (s *ConfigRevisionService) GetRevisions() ([]*database.ConfigRevision, error) {
+	var revisions []*database.ConfigRevision
+	err := database.GetDB().Select("id", "created_at", "updated_at", "author", "remark").
+		Order("id DESC").Find(&revisions).Error
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// GetRevision 根据编号获取历史版本
+func (s *ConfigRevisionService) GetRevision(id uint) (*database.ConfigRevision, error) {
+	revision := &database.ConfigRevision{}
+	err := database.GetDB().First(revision, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+// Record 生成当前的Xray配置，与最新的历史版本不同时保存为新版本，author为修改者。
+// 配置未变化时返回nil
+func (s *ConfigRevisionService) Record(author string, remark string) (*database.ConfigRevision, error) {
+	revisionLock.Lock()
+	defer revisionLock.Unlock()
+	return s.record(author, remark)
+}
+
+// record 记录版本，调用方需要持有revisionLock
+func (s *ConfigRevisionService) record(author string, remark string) (*database.ConfigRevision, error) {
+	source, err := loadConfigSource()
+	if err != nil {
+		return nil, err
+	}
+	xrayService := XrayService{}
+	xrayConfig, err := xrayService.genXrayConfig(source)
+	if err != nil {
+		return nil, err
+	}
+	config, err := json.MarshalIndent(xrayConfig, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var latest []*database.ConfigRevision
+	err = database.GetDB().Order("id DESC").Limit(1).Find(&latest).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(latest) > 0 && latest[0].Config == string(config) {
+		return nil, nil
+	}
+
+	settingService := SettingService{}
+	template, err := settingService.getString("xrayConfigTemplate", "")
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := json.Marshal(&revisionSnapshot{
+		Template:  template,
+		Inbounds:  source.inbounds,
+		Clients:   source.clients,
+		Outbounds: source.outbounds,
+		Rules:     source.rules,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	revision := &database.ConfigRevision{
+		Author:   author,
+		Remark:   remark,
+		Config:   string(config),
+		Snapshot: string(snapshot),
+	}
+	err = database.GetDB().Create(revision).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// 只保留最近的版本
+	err = database.GetDB().Unscoped().
+		Where("id <= ?", int(revision.ID)-maxConfigRevisions).
+		Delete(&database.ConfigRevision{}).Error
+	if err != nil {
+		logger.Warning("清理配置历史版本失败:", err)
+	}
+	return revision, nil
+}
+
+// Diff 生成从版本fromID到版本toID的配置差异，格式为unified diff
+func (s *ConfigRevisionService) Diff(fromID uint, toID uint) (string, error) {
+	from, err := s.GetRevision(fromID)
+	if err != nil {
+		return "", fmt.Errorf("版本 %d 不存在", fromID)
+	}
+	to, err := s.GetRevision(toID)
+	if err != nil {
+		return "", fmt.Errorf("版本 %d 不存在", toID)
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(from.Config + "\n"),
+		B:        difflib.SplitLines(to.Config + "\n"),
+		FromFile: fmt.Sprintf("revision-%d", from.ID),
+		ToFile:   fmt.Sprintf("revision-%d", to.ID),
+		Context:  3,
+	})
+}
+
+// Rollback 将配置模板、入站、客户端、出站和路由规则恢复为生成版本id时的数据，并记录为新版本。
+// 客户端的已用流量和临时禁用状态保持当前值，生成的Xray配置检查不通过时不做任何修改
+func (s *ConfigRevisionService) Rollback(id uint, author string) (*database.ConfigRevision, error) {
+	// 整个回滚过程持有锁，避免检查后、记录前有其他版本被记录
+	revisionLock.Lock()
+	defer revisionLock.Unlock()
+
+	revision, err := s.GetRevision(id)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &revisionSnapshot{}
+	err = json.Unmarshal([]byte(revision.Snapshot), snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("解析版本 %d 的数据失败: %v", id, err)
+	}
+
+	clientService := ClientService{}
+	clients, err := clientService.GetClients(0)
+	if err != nil {
+		return nil, err
+	}
+	current := map[uint]*database.ClientConfig{}
+	for _, client := range clients {
+		current[client.ID] = client
+	}
+	for _, client := range snapshot.Clients {
+		if old, ok := current[client.ID]; ok {
+			client.Used = old.Used
+			client.DisabledUntil = old.DisabledUntil
+		}
+	}
+
+	xrayService := XrayService{}
+	xrayConfig, err := xrayService.genXrayConfig(&configSource{
+		template:  snapshot.Template,
+		inbounds:  snapshot.Inbounds,
+		clients:   snapshot.Clients,
+		outbounds: snapshot.Outbounds,
+		rules:     snapshot.Rules,
+	})
+	if err != nil {
+		return nil, err
+	}
+	currentConfig, err := xrayService.GetXrayConfig()
+	if err != nil {
+		return nil, err
+	}
+	if currentConfig.Equals(xrayConfig) {
+		return nil, errors.New("配置与当前相同，无需回滚")
+	}
+	err = xrayService.testConfig(xrayConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		// 检查配置期间流量统计可能已经更新，在事务中重新读取已用流量
+		var latest []*database.ClientConfig
+		err := tx.Find(&latest).Error
+		if err != nil {
+			return err
+		}
+		for _, old := range latest {
+			current[old.ID] = old
+		}
+		for _, client := range snapshot.Clients {
+			if old, ok := current[client.ID]; ok {
+				client.Used = old.Used
+				client.DisabledUntil = old.DisabledUntil
+			}
+		}
+
+		tables := []interface{}{
+			&database.ClientConfig{},
+			&database.InboundConfig{},
+			&database.OutboundConfig{},
+			&database.RoutingRule{},
+		}
+		for _, table := range tables {
+			err := tx.Unscoped().Where("1 = 1").Delete(table).Error
+			if err != nil {
+				return err
+			}
+		}
+		rows := []interface{}{snapshot.Inbounds, snapshot.Clients, snapshot.Outbounds, snapshot.Rules}
+		lengths := []int{len(snapshot.Inbounds), len(snapshot.Clients), len(snapshot.Outbounds), len(snapshot.Rules)}
+		for i, row := range rows {
+			if lengths[i] == 0 {
+				continue
+			}
+			err := tx.Create(row).Error
+			if err != nil {
+				return err
+			}
+		}
+		return saveSettingTx(tx, "xrayConfigTemplate", snapshot.Template)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.record(author, fmt.Sprintf("回滚到版本 %d", id))
+}
+
+// saveSettingTx 在事务中保存设置
+func saveSettingTx(tx *gorm.DB, key string, value string) error {
+	var settings []database.Setting
+	err := tx.Where("key = ?", key).Limit(1).Find(&settings).Error
+	if err != nil {
+		return err
+	}
+	if len(settings) == 0 {
+		return tx.Create(&database.Setting{Key: key, Value: value}).Error
+	}
+	settings[0].Value = value
+	return tx.Save(&settings[0]).Error
+}
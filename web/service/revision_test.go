@@ -0,0 +1,204 @@
+package service
+
+import (
+	"mx-ui/database"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hasDiffLine 判断diff中是否有以prefix开头且包含text的行
+func hasDiffLine(diff string, prefix string, text string) bool {
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, prefix) && strings.Contains(line, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRevisionRecordAndDiff(t *testing.T) {
+	setupTestDB(t)
+	inbound := createTestInbound(t, &database.InboundConfig{Protocol: "vless", Tag: "in-8", Port: 8, Enable: true, Settings: `{"decryption":"none"}`})
+	revisionService := ConfigRevisionService{}
+
+	first, err := revisionService.Record("admin", "初始配置")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == nil || first.Author != "admin" || first.Remark != "初始配置" {
+		t.Fatalf("记录的版本为 %+v", first)
+	}
+	unchanged, err := revisionService.Record("admin", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged != nil {
+		t.Fatal("配置未变化时不应记录新版本")
+	}
+
+	err = database.GetDB().Model(inbound).Update("port", 9).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := revisionService.Record("cli", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := revisionService.Diff(first.ID, second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(diff, "--- revision-1\n+++ revision-2\n") {
+		t.Errorf("diff的文件头错误:\n%s", diff)
+	}
+	if !hasDiffLine(diff, "-", `"port": 8`) || !hasDiffLine(diff, "+", `"port": 9`) {
+		t.Errorf("diff中应包含端口的变化:\n%s", diff)
+	}
+	if hasDiffLine(diff, "-", `"protocol"`) || hasDiffLine(diff, "+", `"protocol"`) {
+		t.Errorf("diff中不应包含未变化的内容:\n%s", diff)
+	}
+	_, err = revisionService.Diff(first.ID, 99)
+	if err == nil || err.Error() != "版本 99 不存在" {
+		t.Errorf("版本不存在时错误为 %v", err)
+	}
+
+	revisions, err := revisionService.GetRevisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].ID != second.ID || revisions[0].Config != "" {
+		t.Errorf("版本列表应按最新在前排列且不包含配置内容: %+v", revisions)
+	}
+}
+
+func TestRevisionPruning(t *testing.T) {
+	setupTestDB(t)
+	inbound := createTestInbound(t, &database.InboundConfig{Protocol: "vless", Tag: "in", Port: 10000, Enable: true, Settings: `{"decryption":"none"}`})
+	revisionService := ConfigRevisionService{}
+	total := maxConfigRevisions + 5
+	for i := 0; i < total; i++ {
+		err := database.GetDB().Model(inbound).Update("port", 10000+i).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = revisionService.Record("admin", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revisions, err := revisionService.GetRevisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != maxConfigRevisions {
+		t.Fatalf("保留的版本数量为 %d，期望 %d", len(revisions), maxConfigRevisions)
+	}
+	if oldest := revisions[len(revisions)-1].ID; oldest != uint(total-maxConfigRevisions+1) {
+		t.Errorf("最早保留的版本为 %d，期望 %d", oldest, total-maxConfigRevisions+1)
+	}
+	var count int64
+	database.GetDB().Unscoped().Model(&database.ConfigRevision{}).Count(&count)
+	if count != int64(maxConfigRevisions) {
+		t.Errorf("清理后数据库中还有 %d 个版本", count)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	dir := setupTestDB(t)
+	failTests := installStubXray(t, dir)
+	inbound := createTestInbound(t, &database.InboundConfig{Protocol: "vless", Tag: "in-8", Port: 8, Enable: true, Settings: `{"decryption":"none"}`})
+	alice := createTestClient(t, &database.ClientConfig{InboundID: inbound.ID, Email: "alice", UUID: "11111111-1111-1111-1111-111111111111", Enable: true})
+	revisionService := ConfigRevisionService{}
+	first, err := revisionService.Record("admin", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 修改入站端口、添加客户端，期间alice产生流量并被临时禁用
+	err = database.GetDB().Model(inbound).Update("port", 9).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTestClient(t, &database.ClientConfig{InboundID: inbound.ID, Email: "bob", UUID: "22222222-2222-2222-2222-222222222222", Enable: true})
+	disabledUntil := time.Now().Add(time.Hour).UnixMilli()
+	err = database.GetDB().Model(alice).Updates(map[string]interface{}{"used": 500, "disabled_until": disabledUntil}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = revisionService.Record("admin", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assertState 检查入站端口和客户端邮箱
+	assertState := func(port int, emails ...string) {
+		t.Helper()
+		inboundService := InboundService{}
+		saved, err := inboundService.GetInbound(inbound.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Port != port {
+			t.Errorf("入站端口为 %d，期望 %d", saved.Port, port)
+		}
+		clientService := ClientService{}
+		clients, err := clientService.GetClients(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, client := range clients {
+			got = append(got, client.Email)
+		}
+		if strings.Join(got, ",") != strings.Join(emails, ",") {
+			t.Errorf("客户端为 %q，期望 %q", got, emails)
+		}
+	}
+
+	// Xray检查不通过时不修改数据库，也不记录新版本
+	failTests()
+	_, err = revisionService.Rollback(first.ID, "admin")
+	if err == nil || !strings.HasPrefix(err.Error(), "xray配置检查未通过") {
+		t.Fatalf("配置检查失败时错误为 %v", err)
+	}
+	assertState(9, "alice", "bob")
+	revisions, err := revisionService.GetRevisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 {
+		t.Errorf("回滚失败后版本数量为 %d，期望 2", len(revisions))
+	}
+
+	err = os.Remove(filepath.Join(dir, "fail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revision, err := revisionService.Rollback(first.ID, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision.Remark != "回滚到版本 1" || strings.Contains(revision.Config, "bob") {
+		t.Errorf("回滚后记录的版本为 %d %q", revision.ID, revision.Remark)
+	}
+	assertState(8, "alice")
+
+	// 已用流量和临时禁用状态保持回滚前的值
+	clientService := ClientService{}
+	saved, err := clientService.GetClient(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Used != 500 || saved.DisabledUntil != disabledUntil {
+		t.Errorf("回滚后alice的已用流量为 %d，临时禁用到 %d，期望 500 和 %d", saved.Used, saved.DisabledUntil, disabledUntil)
+	}
+
+	_, err = revisionService.Rollback(revision.ID, "admin")
+	if err == nil || err.Error() != "配置与当前相同，无需回滚" {
+		t.Errorf("回滚到当前配置时错误为 %v", err)
+	}
+}
@@ -0,0 +1,504 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mx-ui/database"
+	"mx-ui/xray"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 路由规则可以匹配的流量探测协议
+var routingProtocols = map[string]bool{
+	"http":       true,
+	"tls":        true,
+	"quic":       true,
+	"bittorrent": true,
+}
+
+// 域名条件支持的前缀，没有前缀时按子域名匹配
+var routingDomainPrefixes = []string{"domain:", "full:", "regexp:", "keyword:", "dotless:", "geosite:", "ext:"}
+
+// geosite和geoip的代码，可以带 @属性 或以 ! 取反
+var geoCodePattern = regexp.MustCompile(`^!?[a-z0-9][a-z0-9_.@!-]*$`)
+
+// RoutingPreset 路由规则预设
+type RoutingPreset struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	NeedCountry bool   `json:"needCountry"`
+}
+
+// 预设使用的出站，不存在时自动添加
+const (
+	presetBlockedTag = "blocked"
+	presetDirectTag  = "direct"
+)
+
+var routingPresets = []*RoutingPreset{
+	{Name: "block-bittorrent", Description: "阻止BitTorrent流量，需要入站开启流量探测"},
+	{Name: "block-private", Description: "阻止访问私有IP地址"},
+	{Name: "direct-country", Description: "指定国家或地区的域名和IP直连", NeedCountry: true},
+	{Name: "block-ads", Description: "阻止广告域名"},
+}
+
+// RoutingService 路由规则相关服务
+type RoutingService struct{}
+
+// GetRules 按顺序获取所有路由规则
+func (s *RoutingService) GetRules() ([]*database.RoutingRule, error) {
+	var rules []*database.RoutingRule
+	err := database.GetDB().Order("sort ASC, id ASC").Find(&rules).Error
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// GetRule 根据ID获取路由规则
+func (s *RoutingService) GetRule(id uint) (*database.RoutingRule, error) {
+	rule := &database.RoutingRule{}
+	err := database.GetDB().First(rule, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// AddRule 添加路由规则，排在已有规则之后，生成的Xray配置检查不通过时拒绝
+func (s *RoutingService) AddRule(rule *database.RoutingRule) error {
+	err := s.checkRule(rule)
+	if err != nil {
+		return err
+	}
+	err = checkConfigChange(func(source *configSource) {
+		source.setRule(rule)
+	})
+	if err != nil {
+		return err
+	}
+
+	var maxSort int
+	err = database.GetDB().Model(&database.RoutingRule{}).
+		Select("COALESCE(MAX(sort), 0)").Scan(&maxSort).Error
+	if err != nil {
+		return err
+	}
+	rule.Sort = maxSort + 1
+	return database.GetDB().Create(rule).Error
+}
+
+// UpdateRule 更新路由规则，不改变规则的顺序，生成的Xray配置检查不通过时拒绝
+func (s *RoutingService) UpdateRule(rule *database.RoutingRule) error {
+	old, err := s.GetRule(rule.ID)
+	if err != nil {
+		return err
+	}
+	err = s.checkRule(rule)
+	if err != nil {
+		return err
+	}
+
+	old.Enable = rule.Enable
+	old.Domain = rule.Domain
+	old.IP = rule.IP
+	old.Geosite = rule.Geosite
+	old.GeoIP = rule.GeoIP
+	old.Port = rule.Port
+	old.Network = rule.Network
+	old.Protocol = rule.Protocol
+	old.InboundTag = rule.InboundTag
+	old.User = rule.User
+	old.OutboundTag = rule.OutboundTag
+	old.BalancerTag = rule.BalancerTag
+	old.Remark = rule.Remark
+	err = checkConfigChange(func(source *configSource) {
+		source.setRule(old)
+	})
+	if err != nil {
+		return err
+	}
+	return database.GetDB().Save(old).Error
+}
+
+// DelRule 删除路由规则
+func (s *RoutingService) DelRule(id uint) error {
+	return database.GetDB().Delete(&database.RoutingRule{}, id).Error
+}
+
+// SetRuleOrder 按ids的顺序重新排列路由规则，ids必须包含全部规则且不能重复
+func (s *RoutingService) SetRuleOrder(ids []uint) error {
+	rules, err := s.GetRules()
+	if err != nil {
+		return err
+	}
+	if len(ids) != len(rules) {
+		return fmt.Errorf("需要提供全部 %d 条规则的顺序", len(rules))
+	}
+	exists := map[uint]bool{}
+	for _, rule := range rules {
+		exists[rule.ID] = true
+	}
+	for _, id := range ids {
+		if !exists[id] {
+			return fmt.Errorf("规则 %d 不存在或重复", id)
+		}
+		delete(exists, id)
+	}
+
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		for i, id := range ids {
+			err := tx.Model(&database.RoutingRule{}).Where("id = ?", id).Update("sort", i+1).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetPresets 获取可用的路由规则预设
+func (s *RoutingService) GetPresets() []*RoutingPreset {
+	return routingPresets
+}
+
+// ApplyPreset 按预设添加路由规则，country为国家或地区代码，例如 cn。
+// 预设使用的 blocked 或 direct 出站不存在时自动添加，生成的Xray配置检查不通过时不做任何修改
+func (s *RoutingService) ApplyPreset(name string, country string) ([]*database.RoutingRule, error) {
+	var rules []*database.RoutingRule
+	switch name {
+	case "block-bittorrent":
+		rules = []*database.RoutingRule{
+			{Protocol: []string{"bittorrent"}, OutboundTag: presetBlockedTag, Remark: "阻止BitTorrent"},
+		}
+	case "block-private":
+		rules = []*database.RoutingRule{
+			{GeoIP: []string{"private"}, OutboundTag: presetBlockedTag, Remark: "阻止私有IP"},
+		}
+	case "direct-country":
+		country = strings.ToLower(strings.TrimSpace(country))
+		if !geoCodePattern.MatchString(country) || strings.HasPrefix(country, "!") {
+			return nil, errors.New("国家或地区代码无效")
+		}
+		// 同一条规则中的条件需要同时满足，域名和IP分为两条规则
+		rules = []*database.RoutingRule{
+			{Geosite: []string{country}, OutboundTag: presetDirectTag, Remark: "直连 " + country + " 域名"},
+			{GeoIP: []string{country}, OutboundTag: presetDirectTag, Remark: "直连 " + country + " IP"},
+		}
+	case "block-ads":
+		rules = []*database.RoutingRule{
+			{Geosite: []string{"category-ads-all"}, OutboundTag: presetBlockedTag, Remark: "阻止广告"},
+		}
+	default:
+		return nil, fmt.Errorf("未知的预设: %v", name)
+	}
+
+	outbound, err := s.presetOutbound(rules[0].OutboundTag)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		rule.Enable = true
+		err = s.checkRule(rule)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = checkConfigChange(func(source *configSource) {
+		if outbound != nil {
+			source.setOutbound(outbound)
+		}
+		for _, rule := range rules {
+			source.setRule(rule)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if outbound != nil {
+			err := tx.Create(outbound).Error
+			if err != nil {
+				return err
+			}
+		}
+		var maxSort int
+		err := tx.Model(&database.RoutingRule{}).
+			Select("COALESCE(MAX(sort), 0)").Scan(&maxSort).Error
+		if err != nil {
+			return err
+		}
+		for i, rule := range rules {
+			rule.Sort = maxSort + i + 1
+			err = tx.Create(rule).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// presetOutbound 预设使用的出站不存在时返回需要添加的出站，已存在时返回nil
+func (s *RoutingService) presetOutbound(tag string) (*database.OutboundConfig, error) {
+	xrayService := XrayService{}
+	xrayConfig, err := xrayService.getConfigTemplate()
+	if err != nil {
+		return nil, err
+	}
+	outboundService := OutboundService{}
+	outbounds, err := outboundService.GetOutbounds()
+	if err != nil {
+		return nil, err
+	}
+	for _, outbound := range mergeOutbounds(xrayConfig.OutboundConfigs, outbounds) {
+		if outbound.Tag == tag {
+			return nil, nil
+		}
+	}
+
+	protocol := "blackhole"
+	if tag == presetDirectTag {
+		protocol = "freedom"
+	}
+	outbound := &database.OutboundConfig{
+		Tag:      tag,
+		Protocol: protocol,
+		Enable:   true,
+		Remark:   "路由预设自动添加",
+	}
+	err = outboundService.checkOutbound(outbound)
+	if err != nil {
+		return nil, err
+	}
+	return outbound, nil
+}
+
+// checkRule 检查并整理路由规则的条件和目标
+func (s *RoutingService) checkRule(rule *database.RoutingRule) error {
+	rule.Domain = cleanList(rule.Domain)
+	rule.IP = cleanList(rule.IP)
+	rule.Geosite = cleanGeoCodes(rule.Geosite, "geosite:")
+	rule.GeoIP = cleanGeoCodes(rule.GeoIP, "geoip:")
+	rule.Protocol = cleanList(rule.Protocol)
+	rule.InboundTag = cleanList(rule.InboundTag)
+	rule.User = cleanList(rule.User)
+	rule.Port = strings.ReplaceAll(rule.Port, " ", "")
+	rule.Network = strings.ToLower(strings.ReplaceAll(rule.Network, " ", ""))
+	rule.OutboundTag = strings.TrimSpace(rule.OutboundTag)
+	rule.BalancerTag = strings.TrimSpace(rule.BalancerTag)
+
+	if len(rule.Domain) == 0 && len(rule.IP) == 0 && len(rule.Geosite) == 0 && len(rule.GeoIP) == 0 &&
+		rule.Port == "" && rule.Network == "" && len(rule.Protocol) == 0 &&
+		len(rule.InboundTag) == 0 && len(rule.User) == 0 {
+		return errors.New("至少需要一个匹配条件")
+	}
+	if (rule.OutboundTag == "") == (rule.BalancerTag == "") {
+		return errors.New("需要指定出站标签或负载均衡器标签中的一个")
+	}
+
+	for _, domain := range rule.Domain {
+		if strings.Contains(domain, ":") && !hasAnyPrefix(domain, routingDomainPrefixes) {
+			return fmt.Errorf("无效的域名条件: %v", domain)
+		}
+	}
+	for _, ip := range rule.IP {
+		if hasAnyPrefix(ip, []string{"geoip:", "ext:"}) {
+			continue
+		}
+		if net.ParseIP(ip) == nil {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return fmt.Errorf("无效的IP条件: %v", ip)
+			}
+		}
+	}
+	for _, code := range append(append([]string{}, rule.Geosite...), rule.GeoIP...) {
+		if !geoCodePattern.MatchString(code) {
+			return fmt.Errorf("无效的geosite或geoip代码: %v", code)
+		}
+	}
+	if rule.Port != "" {
+		err := checkPortList(rule.Port)
+		if err != nil {
+			return err
+		}
+	}
+	if rule.Network != "" {
+		for _, network := range strings.Split(rule.Network, ",") {
+			if network != "tcp" && network != "udp" {
+				return fmt.Errorf("无效的网络类型: %v", network)
+			}
+		}
+	}
+	for _, protocol := range rule.Protocol {
+		if !routingProtocols[protocol] {
+			return fmt.Errorf("无效的协议: %v（可用: http, tls, quic, bittorrent）", protocol)
+		}
+	}
+	return nil
+}
+
+// mergeRouting 将出站、路由规则和客户端的出站规则合并到配置中，并检查引用的出站和负载均衡器都存在。
+// 客户端的规则放在路由规则之后、模板的路由规则之前，因此屏蔽、直连等规则对这些客户端同样生效
+func mergeRouting(xrayConfig *xray.Config, outbounds []*database.OutboundConfig, rules []*database.RoutingRule, clients []*database.ClientConfig) error {
+	xrayConfig.OutboundConfigs = mergeOutbounds(xrayConfig.OutboundConfigs, outbounds)
+
+	tags := map[string]bool{}
+	for _, outbound := range xrayConfig.OutboundConfigs {
+		tags[outbound.Tag] = true
+	}
+	for _, client := range clients {
+		if client.OutboundTag != "" && !tags[client.OutboundTag] {
+			return fmt.Errorf("客户端 %s 指定的出站不存在: %s", client.Email, client.OutboundTag)
+		}
+	}
+
+	merged := append([]*database.RoutingRule{}, rules...)
+	merged = append(merged, genClientRoutingRules(clients)...)
+	err := mergeRoutingRules(xrayConfig, merged)
+	if err != nil {
+		return err
+	}
+	return checkRoutingTags(xrayConfig)
+}
+
+// genClientRoutingRules 为指定了出站的客户端生成按邮箱匹配的路由规则，出站相同的客户端合并为一条
+func genClientRoutingRules(clients []*database.ClientConfig) []*database.RoutingRule {
+	var rules []*database.RoutingRule
+	index := map[string]*database.RoutingRule{}
+	for _, client := range clients {
+		if client.OutboundTag == "" {
+			continue
+		}
+		rule, ok := index[client.OutboundTag]
+		if !ok {
+			rule = &database.RoutingRule{
+				Enable:      true,
+				OutboundTag: client.OutboundTag,
+			}
+			index[client.OutboundTag] = rule
+			rules = append(rules, rule)
+		}
+		rule.User = append(rule.User, client.Email)
+	}
+	return rules
+}
+
+// mergeRoutingRules 将启用的路由规则按顺序放在模板的路由规则之前
+func mergeRoutingRules(xrayConfig *xray.Config, rules []*database.RoutingRule) error {
+	var generated []interface{}
+	for _, rule := range rules {
+		if rule.Enable {
+			generated = append(generated, genRoutingRule(rule))
+		}
+	}
+	if len(generated) == 0 {
+		return nil
+	}
+
+	routing := map[string]json.RawMessage{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		err := json.Unmarshal(xrayConfig.RouterConfig, &routing)
+		if err != nil {
+			return fmt.Errorf("解析路由配置失败: %v", err)
+		}
+	}
+	var templateRules []json.RawMessage
+	if len(routing["rules"]) > 0 {
+		err := json.Unmarshal(routing["rules"], &templateRules)
+		if err != nil {
+			return fmt.Errorf("解析路由规则失败: %v", err)
+		}
+	}
+	for _, rule := range templateRules {
+		generated = append(generated, rule)
+	}
+
+	data, err := json.Marshal(generated)
+	if err != nil {
+		return err
+	}
+	routing["rules"] = data
+	xrayConfig.RouterConfig, err = json.Marshal(routing)
+	return err
+}
+
+// genRoutingRule 生成单条路由规则的Xray配置，geosite和geoip代码合并到domain和ip中
+func genRoutingRule(rule *database.RoutingRule) xray.RoutingRule {
+	config := xray.RoutingRule{
+		Type:        "field",
+		Port:        rule.Port,
+		Network:     rule.Network,
+		Protocol:    rule.Protocol,
+		InboundTag:  rule.InboundTag,
+		User:        rule.User,
+		OutboundTag: rule.OutboundTag,
+		BalancerTag: rule.BalancerTag,
+	}
+	config.Domain = append(config.Domain, rule.Domain...)
+	for _, code := range rule.Geosite {
+		config.Domain = append(config.Domain, "geosite:"+code)
+	}
+	config.IP = append(config.IP, rule.IP...)
+	for _, code := range rule.GeoIP {
+		config.IP = append(config.IP, "geoip:"+code)
+	}
+	return config
+}
+
+// checkPortList 检查端口列表，例如 53,443,1000-2000
+func checkPortList(ports string) error {
+	for _, item := range strings.Split(ports, ",") {
+		from, to, isRange := strings.Cut(item, "-")
+		start, err := strconv.Atoi(from)
+		end := start
+		if err == nil && isRange {
+			end, err = strconv.Atoi(to)
+		}
+		if err != nil || start < 1 || end > 65535 || start > end {
+			return fmt.Errorf("无效的端口: %v", item)
+		}
+	}
+	return nil
+}
+
+// cleanList 去掉列表中的空白项
+func cleanList(items []string) []string {
+	var result []string
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// cleanGeoCodes 去掉空白项和 geosite: 或 geoip: 前缀，并转为小写
+func cleanGeoCodes(codes []string, prefix string) []string {
+	var result []string
+	for _, code := range cleanList(codes) {
+		result = append(result, strings.ToLower(strings.TrimPrefix(code, prefix)))
+	}
+	return result
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"encoding/json"
+	"mx-ui/database"
+	"mx-ui/xray"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheckRule(t *testing.T) {
+	tests := []struct {
+		name string
+		rule database.RoutingRule
+		err  string
+	}{
+		{name: "域名前缀", rule: database.RoutingRule{Domain: []string{"example.com", "domain:a.com", "full:b.com", "regexp:^c\\.", "keyword:ads", "dotless:", "geosite:cn", "ext:h2y.dat:gfw"}, OutboundTag: "direct"}},
+		{name: "未知的域名前缀", rule: database.RoutingRule{Domain: []string{"host:a.com"}, OutboundTag: "direct"}, err: "无效的域名条件: host:a.com"},
+		{name: "IP和CIDR", rule: database.RoutingRule{IP: []string{"1.1.1.1", "10.0.0.0/8", "2001:db8::/32", "::1", "geoip:private", "ext:ip.dat:cn"}, OutboundTag: "direct"}},
+		{name: "无效的IP", rule: database.RoutingRule{IP: []string{"10.0.0.0/33"}, OutboundTag: "direct"}, err: "无效的IP条件: 10.0.0.0/33"},
+		{name: "无效的IP地址", rule: database.RoutingRule{IP: []string{"example.com"}, OutboundTag: "direct"}, err: "无效的IP条件: example.com"},
+		{name: "geo代码", rule: database.RoutingRule{Geosite: []string{"category-ads-all", "geolocation-!cn", "google@cn"}, GeoIP: []string{"!cn"}, OutboundTag: "direct"}},
+		{name: "无效的geo代码", rule: database.RoutingRule{Geosite: []string{"c n"}, OutboundTag: "direct"}, err: "无效的geosite或geoip代码: c n"},
+		{name: "端口和端口范围", rule: database.RoutingRule{Port: "53, 443,1000-2000", OutboundTag: "direct"}},
+		{name: "端口范围颠倒", rule: database.RoutingRule{Port: "2000-1000", OutboundTag: "direct"}, err: "无效的端口: 2000-1000"},
+		{name: "端口超出范围", rule: database.RoutingRule{Port: "0-80", OutboundTag: "direct"}, err: "无效的端口: 0-80"},
+		{name: "端口不是数字", rule: database.RoutingRule{Port: "http", OutboundTag: "direct"}, err: "无效的端口: http"},
+		{name: "网络类型", rule: database.RoutingRule{Network: "TCP, udp", OutboundTag: "direct"}},
+		{name: "无效的网络类型", rule: database.RoutingRule{Network: "tcp,icmp", OutboundTag: "direct"}, err: "无效的网络类型: icmp"},
+		{name: "协议", rule: database.RoutingRule{Protocol: []string{"http", "tls", "quic", "bittorrent"}, OutboundTag: "blocked"}},
+		{name: "无效的协议", rule: database.RoutingRule{Protocol: []string{"ssh"}, OutboundTag: "blocked"}, err: "无效的协议: ssh（可用: http, tls, quic, bittorrent）"},
+		{name: "没有条件", rule: database.RoutingRule{Domain: []string{" "}, OutboundTag: "direct"}, err: "至少需要一个匹配条件"},
+		{name: "没有目标", rule: database.RoutingRule{User: []string{"alice"}}, err: "需要指定出站标签或负载均衡器标签中的一个"},
+		{name: "同时指定出站和负载均衡器", rule: database.RoutingRule{User: []string{"alice"}, OutboundTag: "direct", BalancerTag: "lb"}, err: "需要指定出站标签或负载均衡器标签中的一个"},
+	}
+	routingService := RoutingService{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := routingService.checkRule(&test.rule)
+			if test.err == "" && err != nil {
+				t.Fatalf("检查应通过，错误为 %v", err)
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Fatalf("错误为 %v，期望 %q", err, test.err)
+			}
+		})
+	}
+
+	// 检查时整理条件：去掉空白、geo代码去掉前缀并转为小写
+	rule := &database.RoutingRule{
+		Domain:      []string{" example.com ", ""},
+		Geosite:     []string{"geosite:CN", " Google "},
+		GeoIP:       []string{"geoip:Private"},
+		Port:        "80, 443",
+		Network:     "TCP, UDP",
+		OutboundTag: " direct ",
+	}
+	err := routingService.checkRule(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(rule.Domain, []string{"example.com"}) || !slices.Equal(rule.Geosite, []string{"cn", "google"}) ||
+		!slices.Equal(rule.GeoIP, []string{"private"}) || rule.Port != "80,443" || rule.Network != "tcp,udp" || rule.OutboundTag != "direct" {
+		t.Errorf("整理后的规则为 %+v", rule)
+	}
+}
+
+func TestApplyPreset(t *testing.T) {
+	setupTestDB(t)
+	settingService := SettingService{}
+	err := settingService.SetXrayConfigTemplate(`{"outbounds":[{"tag":"proxy","protocol":"freedom"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routingService := RoutingService{}
+	outboundService := OutboundService{}
+
+	// 缺少预设使用的出站时自动添加
+	rules, err := routingService.ApplyPreset("block-bittorrent", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].OutboundTag != "blocked" || !slices.Equal(rules[0].Protocol, []string{"bittorrent"}) || rules[0].Sort != 1 {
+		t.Errorf("添加的规则为 %+v", rules[0])
+	}
+	rules, err = routingService.ApplyPreset("direct-country", " CN ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || !slices.Equal(rules[0].Geosite, []string{"cn"}) || !slices.Equal(rules[1].GeoIP, []string{"cn"}) ||
+		rules[0].Sort != 2 || rules[1].Sort != 3 {
+		t.Errorf("添加的规则为 %+v %+v", rules[0], rules[1])
+	}
+	outbounds, err := outboundService.GetOutbounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, outbound := range outbounds {
+		got = append(got, outbound.Tag+":"+outbound.Protocol)
+	}
+	if want := []string{"blocked:blackhole", "direct:freedom"}; !slices.Equal(got, want) {
+		t.Errorf("自动添加的出站为 %q，期望 %q", got, want)
+	}
+
+	// 出站已存在时不重复添加
+	_, err = routingService.ApplyPreset("block-private", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbounds, err = outboundService.GetOutbounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outbounds) != 2 {
+		t.Errorf("出站数量为 %d，已存在的出站不应重复添加", len(outbounds))
+	}
+
+	_, err = routingService.ApplyPreset("direct-country", "!cn")
+	if err == nil || err.Error() != "国家或地区代码无效" {
+		t.Errorf("无效的国家代码时错误为 %v", err)
+	}
+	_, err = routingService.ApplyPreset("block-everything", "")
+	if err == nil || err.Error() != "未知的预设: block-everything" {
+		t.Errorf("未知的预设时错误为 %v", err)
+	}
+	all, err := routingService.GetRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Errorf("规则数量为 %d，失败的预设不应添加规则", len(all))
+	}
+}
+
+// routingRulesOf 整理配置中的路由规则为 条件->目标，便于比较顺序
+func routingRulesOf(t *testing.T, xrayConfig *xray.Config) []string {
+	t.Helper()
+	var routing struct {
+		Rules []xray.RoutingRule `json:"rules"`
+	}
+	err := json.Unmarshal(xrayConfig.RouterConfig, &routing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rules []string
+	for _, rule := range routing.Rules {
+		conditions := append(append(append([]string{}, rule.Domain...), rule.IP...), rule.User...)
+		rules = append(rules, strings.Join(conditions, ",")+"->"+rule.OutboundTag+rule.BalancerTag)
+	}
+	return rules
+}
+
+func TestMergeRouting(t *testing.T) {
+	template := `{
+		"outbounds": [{"tag": "direct", "protocol": "freedom"}, {"tag": "blocked", "protocol": "blackhole"}],
+		"routing": {"rules": [{"type": "field", "ip": ["geoip:private"], "outboundTag": "blocked"}]}
+	}`
+	outbounds := []*database.OutboundConfig{
+		{Tag: "wg", Protocol: "wireguard", Enable: true},
+		{Tag: "off", Protocol: "freedom", Enable: false},
+	}
+	rules := []*database.RoutingRule{
+		{Enable: true, Geosite: []string{"category-ads-all"}, OutboundTag: "blocked"},
+		{Enable: false, Domain: []string{"example.com"}, OutboundTag: "direct"},
+		{Enable: true, Domain: []string{"domain:example.org"}, OutboundTag: "wg"},
+	}
+	clients := []*database.ClientConfig{
+		{Email: "alice", OutboundTag: "wg"},
+		{Email: "bob"},
+		{Email: "carol", OutboundTag: "direct"},
+		{Email: "dave", OutboundTag: "wg"},
+	}
+
+	// 路由规则在前，之后是按出站合并的客户端规则，最后是模板的规则
+	xrayConfig, err := parseConfigTemplate(template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mergeRouting(xrayConfig, outbounds, rules, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"geosite:category-ads-all->blocked",
+		"domain:example.org->wg",
+		"alice,dave->wg",
+		"carol->direct",
+		"geoip:private->blocked",
+	}
+	if got := routingRulesOf(t, xrayConfig); !slices.Equal(got, want) {
+		t.Errorf("路由规则为 %q，期望 %q", got, want)
+	}
+
+	tests := []struct {
+		name    string
+		rules   []*database.RoutingRule
+		clients []*database.ClientConfig
+		err     string
+	}{
+		{
+			name:    "客户端指定的出站不存在",
+			clients: []*database.ClientConfig{{Email: "eve", OutboundTag: "nope"}},
+			err:     "客户端 eve 指定的出站不存在: nope",
+		},
+		{
+			name:    "客户端指定的出站已禁用",
+			clients: []*database.ClientConfig{{Email: "eve", OutboundTag: "off"}},
+			err:     "客户端 eve 指定的出站不存在: off",
+		},
+		{
+			name:  "路由规则指向不存在的出站",
+			rules: []*database.RoutingRule{{Enable: true, Domain: []string{"a.com"}, OutboundTag: "nope"}},
+			err:   "第 1 条路由规则指向不存在的出站: nope",
+		},
+		{
+			name:  "路由规则指向不存在的负载均衡器",
+			rules: []*database.RoutingRule{{Enable: true, Domain: []string{"a.com"}, BalancerTag: "lb"}},
+			err:   "第 1 条路由规则指向不存在的负载均衡器: lb",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			xrayConfig, err := parseConfigTemplate(template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = mergeRouting(xrayConfig, outbounds, test.rules, test.clients)
+			if err == nil || err.Error() != test.err {
+				t.Errorf("错误为 %v，期望 %q", err, test.err)
+			}
+		})
+	}
+}
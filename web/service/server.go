@@ -3,6 +3,7 @@ package service
 import (
 	"mx-ui/database"
 	"mx-ui/logger"
+	"mx-ui/xray"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
@@ -11,7 +12,6 @@ import (
 	"github.com/shirou/gopsutil/v3/load"
 	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/shirou/gopsutil/v3/net"
-	"github.com/shirou/gopsutil/v3/process"
 )
 
 type ProcessState string
@@ -194,18 +194,15 @@ func (s *ServerService) GetStatus(lastStatus *Status) *Status {
 	}
 
 	// Xray状态
-	// 这里应该检查xray进程状态，现在仅作为示例
-	p, err := process.NewProcess(int32(0))
-	if err != nil {
-		status.Xray.State = Stop
+	xrayService := XrayService{}
+	status.Xray.Version, _ = xray.GetVersion()
+	if xrayService.IsXrayRunning() {
+		status.Xray.State = Running
+	} else if err := xrayService.GetXrayErr(); err != nil {
+		status.Xray.State = Error
+		status.Xray.ErrorMsg = xrayService.GetXrayResult()
 	} else {
-		name, err := p.Name()
-		if err != nil || name != "xray" {
-			status.Xray.State = Stop
-		} else {
-			status.Xray.State = Running
-			status.Xray.Version = "1.8.0" // 这里应该获取实际xray版本
-		}
+		status.Xray.State = Stop
 	}
 
 	return status
@@ -5,9 +5,24 @@ import (
 	"mx-ui/config"
 	"mx-ui/database"
 	"mx-ui/logger"
+	"net"
+	"os"
 	"strconv"
+	"strings"
 )
 
+const defaultTgBotAPIServer = "https://api.telegram.org"
+
+const defaultSubPort = 2096
+
+const defaultXrayAPIPort = 62789
+
+// 默认的Xray下载地址，{version}为版本号，{asset}为当前系统对应的发布文件名
+const defaultXrayMirrorURL = "https://github.com/XTLS/Xray-core/releases/download/v{version}/{asset}.zip"
+
+// 默认的geo数据文件下载地址，{name}为 geoip.dat 或 geosite.dat
+const defaultGeoMirrorURL = "https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/{name}"
+
 // SettingService 系统设置相关服务
 type SettingService struct{}
 
@@ -33,6 +48,44 @@ func (s *SettingService) SetPort(port int) error {
 	return s.saveSetting("webPort", strconv.Itoa(port))
 }
 
+// GetListen 获取Web监听地址，为空时监听所有地址，unix: 开头时监听Unix套接字
+func (s *SettingService) GetListen() (string, error) {
+	return s.getString("webListen", "")
+}
+
+// SetListen 设置Web监听地址，可以是IP、主机名或 unix:/path/to/socket
+func (s *SettingService) SetListen(listen string) error {
+	listen = strings.TrimSpace(listen)
+	if strings.HasPrefix(listen, "unix:") {
+		if strings.TrimPrefix(listen, "unix:") == "" {
+			return errors.New("Unix套接字路径不能为空")
+		}
+	} else if listen != "" && net.ParseIP(listen) == nil {
+		if strings.ContainsAny(listen, " :/") {
+			return errors.New("无效的监听地址: " + listen)
+		}
+	}
+	return s.saveSetting("webListen", listen)
+}
+
+// GetSocketMode 获取Unix套接字文件的权限
+func (s *SettingService) GetSocketMode() (os.FileMode, error) {
+	mode, err := s.getString("webSocketMode", "0660")
+	if err != nil {
+		return 0, err
+	}
+	return config.ParseSocketMode(mode)
+}
+
+// SetSocketMode 设置Unix套接字文件的权限（八进制），例如 0660
+func (s *SettingService) SetSocketMode(mode string) error {
+	_, err := config.ParseSocketMode(mode)
+	if err != nil {
+		return err
+	}
+	return s.saveSetting("webSocketMode", mode)
+}
+
 // GetCertFile 获取证书文件
 func (s *SettingService) GetCertFile() (string, error) {
 	certFile := ""
@@ -106,10 +159,107 @@ func (s *SettingService) SetBasePath(basePath string) error {
 	return s.saveSetting("webBasePath", basePath)
 }
 
+// GetHealthEnable 获取是否开放无需登录的 healthz 和 readyz 接口
+func (s *SettingService) GetHealthEnable() (bool, error) {
+	return s.getBool("healthEnable", true)
+}
+
+// SetHealthEnable 设置是否开放 healthz 和 readyz 接口
+func (s *SettingService) SetHealthEnable(enable bool) error {
+	return s.saveSetting("healthEnable", strconv.FormatBool(enable))
+}
+
+// GetXrayAPIPort 获取Xray内部API监听的本机端口，面板通过它热更新客户端
+func (s *SettingService) GetXrayAPIPort() (int, error) {
+	return s.getInt("xrayApiPort", defaultXrayAPIPort)
+}
+
+// SetXrayAPIPort 设置Xray内部API端口，不能与入站、面板和订阅服务器的端口冲突
+func (s *SettingService) SetXrayAPIPort(port int) error {
+	if port <= 0 || port > 65535 {
+		return errors.New("端口范围必须在1-65535之间")
+	}
+	webPort, err := s.GetPort()
+	if err != nil {
+		return err
+	}
+	if port == webPort {
+		return errors.New("端口已被面板使用")
+	}
+	subPort, err := s.GetSubPort()
+	if err != nil {
+		return err
+	}
+	if port == subPort {
+		return errors.New("端口已被订阅服务器使用")
+	}
+	var count int64
+	err = database.GetDB().Model(&database.InboundConfig{}).Where("port = ?", port).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("端口已被入站使用")
+	}
+	return s.saveSetting("xrayApiPort", strconv.Itoa(port))
+}
+
+// GetXrayMirrorURL 获取下载Xray的地址模板
+func (s *SettingService) GetXrayMirrorURL() (string, error) {
+	return s.getString("xrayMirrorURL", defaultXrayMirrorURL)
+}
+
+// SetXrayMirrorURL 设置下载Xray的地址模板，必须是http或https地址并包含{version}，为空时恢复默认
+func (s *SettingService) SetXrayMirrorURL(mirrorURL string) error {
+	mirrorURL = strings.TrimSpace(mirrorURL)
+	if mirrorURL == "" {
+		mirrorURL = defaultXrayMirrorURL
+	}
+	if !strings.HasPrefix(mirrorURL, "http://") && !strings.HasPrefix(mirrorURL, "https://") {
+		return errors.New("下载地址必须以http://或https://开头")
+	}
+	if !strings.Contains(mirrorURL, "{version}") {
+		return errors.New("下载地址必须包含{version}")
+	}
+	return s.saveSetting("xrayMirrorURL", mirrorURL)
+}
+
+// GetGeoMirrorURL 获取下载geo数据文件的地址模板
+func (s *SettingService) GetGeoMirrorURL() (string, error) {
+	return s.getString("geoMirrorURL", defaultGeoMirrorURL)
+}
+
+// SetGeoMirrorURL 设置下载geo数据文件的地址模板，必须是http或https地址并包含{name}，为空时恢复默认
+func (s *SettingService) SetGeoMirrorURL(mirrorURL string) error {
+	mirrorURL = strings.TrimSpace(mirrorURL)
+	if mirrorURL == "" {
+		mirrorURL = defaultGeoMirrorURL
+	}
+	if !strings.HasPrefix(mirrorURL, "http://") && !strings.HasPrefix(mirrorURL, "https://") {
+		return errors.New("下载地址必须以http://或https://开头")
+	}
+	if !strings.Contains(mirrorURL, "{name}") {
+		return errors.New("下载地址必须包含{name}")
+	}
+	return s.saveSetting("geoMirrorURL", mirrorURL)
+}
+
+// GetGeoUpdateInterval 获取geo数据文件定时更新间隔（小时），0表示不定时更新
+func (s *SettingService) GetGeoUpdateInterval() (int, error) {
+	return s.getInt("geoUpdateInterval", 0)
+}
+
+// SetGeoUpdateInterval 设置geo数据文件定时更新间隔（小时）
+func (s *SettingService) SetGeoUpdateInterval(hours int) error {
+	if hours < 0 {
+		return errors.New("更新间隔不能为负数")
+	}
+	return s.saveSetting("geoUpdateInterval", strconv.Itoa(hours))
+}
+
 // GetXrayConfigTemplate 获取Xray配置模板
 func (s *SettingService) GetXrayConfigTemplate() (string, error) {
-	template := ""
-	err := s.getSetting("xrayConfigTemplate", &template)
+	template, err := s.getString("xrayConfigTemplate", "")
 	if err != nil {
 		return "", err
 	}
@@ -124,6 +274,254 @@ func (s *SettingService) SetXrayConfigTemplate(template string) error {
 	return s.saveSetting("xrayConfigTemplate", template)
 }
 
+// GetSubURI 获取订阅地址前缀
+func (s *SettingService) GetSubURI() (string, error) {
+	return s.getString("subURI", "")
+}
+
+// SetSubURI 设置订阅地址前缀
+func (s *SettingService) SetSubURI(subURI string) error {
+	return s.saveSetting("subURI", subURI)
+}
+
+// GetSubPort 获取订阅服务器端口
+func (s *SettingService) GetSubPort() (int, error) {
+	return s.getInt("subPort", defaultSubPort)
+}
+
+// SetSubPort 设置订阅服务器端口
+func (s *SettingService) SetSubPort(port int) error {
+	if port <= 0 || port > 65535 {
+		return errors.New("端口范围必须在1-65535之间")
+	}
+	return s.saveSetting("subPort", strconv.Itoa(port))
+}
+
+// GetSubListen 获取订阅服务器监听地址，为空时监听所有地址，unix: 开头时监听Unix套接字。
+// 面板监听套接字时，为空表示不启动订阅服务器
+func (s *SettingService) GetSubListen() (string, error) {
+	return s.getString("subListen", "")
+}
+
+// SetSubListen 设置订阅服务器监听地址，可以是IP、主机名或 unix:/path/to/socket
+func (s *SettingService) SetSubListen(listen string) error {
+	listen = strings.TrimSpace(listen)
+	if strings.HasPrefix(listen, "unix:") {
+		if strings.TrimPrefix(listen, "unix:") == "" {
+			return errors.New("Unix套接字路径不能为空")
+		}
+	} else if listen != "" && net.ParseIP(listen) == nil && strings.ContainsAny(listen, " :/") {
+		return errors.New("无效的监听地址: " + listen)
+	}
+	return s.saveSetting("subListen", listen)
+}
+
+// GetTgBotEnable 获取是否启用Telegram机器人
+func (s *SettingService) GetTgBotEnable() (bool, error) {
+	return s.getBool("tgBotEnable", false)
+}
+
+// SetTgBotEnable 设置是否启用Telegram机器人
+func (s *SettingService) SetTgBotEnable(enable bool) error {
+	return s.saveSetting("tgBotEnable", strconv.FormatBool(enable))
+}
+
+// GetTgBotToken 获取Telegram机器人令牌
+func (s *SettingService) GetTgBotToken() (string, error) {
+	return s.getString("tgBotToken", "")
+}
+
+// SetTgBotToken 设置Telegram机器人令牌
+func (s *SettingService) SetTgBotToken(token string) error {
+	return s.saveSetting("tgBotToken", token)
+}
+
+// GetTgBotAPIServer 获取Telegram Bot API地址
+func (s *SettingService) GetTgBotAPIServer() (string, error) {
+	apiServer, err := s.getString("tgBotAPIServer", "")
+	if err != nil {
+		return "", err
+	}
+	if apiServer == "" {
+		return defaultTgBotAPIServer, nil
+	}
+	return strings.TrimRight(apiServer, "/"), nil
+}
+
+// SetTgBotAPIServer 设置Telegram Bot API地址
+func (s *SettingService) SetTgBotAPIServer(apiServer string) error {
+	return s.saveSetting("tgBotAPIServer", apiServer)
+}
+
+// GetTgBotChatIDs 获取允许使用机器人的Telegram会话ID
+func (s *SettingService) GetTgBotChatIDs() ([]int64, error) {
+	value, err := s.getString("tgBotChatIds", "")
+	if err != nil {
+		return nil, err
+	}
+	var chatIDs []int64
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		chatID, err := strconv.ParseInt(item, 10, 64)
+		if err != nil {
+			return nil, errors.New("无效的会话ID: " + item)
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+	return chatIDs, nil
+}
+
+// SetTgBotChatIDs 设置允许使用机器人的Telegram会话ID，多个ID以逗号分隔
+func (s *SettingService) SetTgBotChatIDs(chatIDs string) error {
+	for _, item := range strings.Split(chatIDs, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if _, err := strconv.ParseInt(item, 10, 64); err != nil {
+			return errors.New("无效的会话ID: " + item)
+		}
+	}
+	return s.saveSetting("tgBotChatIds", chatIDs)
+}
+
+// GetBackupInterval 获取定时备份间隔（小时），0表示不定时备份
+func (s *SettingService) GetBackupInterval() (int, error) {
+	return s.getInt("backupInterval", 0)
+}
+
+// SetBackupInterval 设置定时备份间隔（小时）
+func (s *SettingService) SetBackupInterval(hours int) error {
+	if hours < 0 {
+		return errors.New("备份间隔不能为负数")
+	}
+	return s.saveSetting("backupInterval", strconv.Itoa(hours))
+}
+
+// GetBackupKeep 获取本地保留的备份数量
+func (s *SettingService) GetBackupKeep() (int, error) {
+	return s.getInt("backupKeep", 7)
+}
+
+// SetBackupKeep 设置本地保留的备份数量
+func (s *SettingService) SetBackupKeep(keep int) error {
+	if keep < 1 {
+		return errors.New("保留的备份数量至少为1")
+	}
+	return s.saveSetting("backupKeep", strconv.Itoa(keep))
+}
+
+// GetBackupDir 获取本地备份目录
+func (s *SettingService) GetBackupDir() (string, error) {
+	backupDir, err := s.getString("backupDir", "")
+	if err != nil {
+		return "", err
+	}
+	if backupDir == "" {
+		return config.GetBackupPath(), nil
+	}
+	return backupDir, nil
+}
+
+// SetBackupDir 设置本地备份目录
+func (s *SettingService) SetBackupDir(backupDir string) error {
+	return s.saveSetting("backupDir", backupDir)
+}
+
+// GetBackupWebhook 获取接收备份文件的Webhook地址
+func (s *SettingService) GetBackupWebhook() (string, error) {
+	return s.getString("backupWebhook", "")
+}
+
+// SetBackupWebhook 设置接收备份文件的Webhook地址
+func (s *SettingService) SetBackupWebhook(webhook string) error {
+	return s.saveSetting("backupWebhook", webhook)
+}
+
+// GetBackupTgEnable 获取是否将定时备份发送到Telegram
+func (s *SettingService) GetBackupTgEnable() (bool, error) {
+	return s.getBool("backupTgEnable", false)
+}
+
+// SetBackupTgEnable 设置是否将定时备份发送到Telegram
+func (s *SettingService) SetBackupTgEnable(enable bool) error {
+	return s.saveSetting("backupTgEnable", strconv.FormatBool(enable))
+}
+
+// GetAccessLogRetention 获取客户端连接记录的保留时间（小时）
+func (s *SettingService) GetAccessLogRetention() (int, error) {
+	return s.getInt("accessLogRetention", 24)
+}
+
+// SetAccessLogRetention 设置客户端连接记录的保留时间（小时）
+func (s *SettingService) SetAccessLogRetention(hours int) error {
+	if hours < 1 {
+		return errors.New("连接记录保留时间至少为1小时")
+	}
+	return s.saveSetting("accessLogRetention", strconv.Itoa(hours))
+}
+
+// GetIPLimitWindow 获取统计客户端来源IP的时间窗口（分钟）
+func (s *SettingService) GetIPLimitWindow() (int, error) {
+	return s.getInt("ipLimitWindow", 5)
+}
+
+// SetIPLimitWindow 设置统计客户端来源IP的时间窗口（分钟）
+func (s *SettingService) SetIPLimitWindow(minutes int) error {
+	if minutes < 1 {
+		return errors.New("IP统计时间窗口至少为1分钟")
+	}
+	return s.saveSetting("ipLimitWindow", strconv.Itoa(minutes))
+}
+
+// GetIPLimitAction 获取客户端超出IP限制时的处理方式
+func (s *SettingService) GetIPLimitAction() (string, error) {
+	return s.getString("ipLimitAction", IPLimitActionLog)
+}
+
+// SetIPLimitAction 设置客户端超出IP限制时的处理方式
+func (s *SettingService) SetIPLimitAction(action string) error {
+	switch action {
+	case IPLimitActionLog, IPLimitActionDisable, IPLimitActionBanList:
+	default:
+		return errors.New("未知的处理方式: " + action)
+	}
+	return s.saveSetting("ipLimitAction", action)
+}
+
+// GetIPLimitDisableMinutes 获取超出IP限制时临时禁用客户端的时长（分钟）
+func (s *SettingService) GetIPLimitDisableMinutes() (int, error) {
+	return s.getInt("ipLimitDisableMinutes", 10)
+}
+
+// SetIPLimitDisableMinutes 设置超出IP限制时临时禁用客户端的时长（分钟）
+func (s *SettingService) SetIPLimitDisableMinutes(minutes int) error {
+	if minutes < 1 {
+		return errors.New("临时禁用时长至少为1分钟")
+	}
+	return s.saveSetting("ipLimitDisableMinutes", strconv.Itoa(minutes))
+}
+
+// GetIPLimitBanFile 获取供外部防火墙读取的IP封禁列表文件路径
+func (s *SettingService) GetIPLimitBanFile() (string, error) {
+	banFile, err := s.getString("ipLimitBanFile", "")
+	if err != nil {
+		return "", err
+	}
+	if banFile == "" {
+		return config.GetBanListPath(), nil
+	}
+	return banFile, nil
+}
+
+// SetIPLimitBanFile 设置IP封禁列表文件路径
+func (s *SettingService) SetIPLimitBanFile(banFile string) error {
+	return s.saveSetting("ipLimitBanFile", banFile)
+}
+
 // ResetSettings 重置所有设置
 func (s *SettingService) ResetSettings() error {
 	return database.GetDB().Where("1 = 1").Delete(&database.Setting{}).Error
@@ -140,6 +538,37 @@ func (s *SettingService) getSetting(key string, value *string) error {
 	return nil
 }
 
+// getString 获取字符串设置，不存在时返回默认值
+func (s *SettingService) getString(key string, defaultValue string) (string, error) {
+	var settings []database.Setting
+	err := database.GetDB().Where("key = ?", key).Limit(1).Find(&settings).Error
+	if err != nil {
+		return "", err
+	}
+	if len(settings) == 0 {
+		return defaultValue, nil
+	}
+	return settings[0].Value, nil
+}
+
+// getBool 获取布尔设置，不存在时返回默认值
+func (s *SettingService) getBool(key string, defaultValue bool) (bool, error) {
+	value, err := s.getString(key, strconv.FormatBool(defaultValue))
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(value)
+}
+
+// getInt 获取整数设置，不存在时返回默认值
+func (s *SettingService) getInt(key string, defaultValue int) (int, error) {
+	value, err := s.getString(key, strconv.Itoa(defaultValue))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
 // saveSetting 保存设置值
 func (s *SettingService) saveSetting(key string, value string) error {
 	setting := &database.Setting{}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mx-ui/database"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// GetShareLink 生成客户端的分享链接，address为客户端连接的服务器地址。
+// 支持vless、vmess、trojan和shadowsocks入站，使用REALITY时带上pbk和sid
+func (s *ClientService) GetShareLink(client *database.ClientConfig, address string) (string, error) {
+	inboundService := InboundService{}
+	inbound, err := inboundService.GetInbound(client.InboundID)
+	if err != nil {
+		return "", err
+	}
+
+	settings := map[string]interface{}{}
+	if inbound.Settings != "" {
+		err = json.Unmarshal([]byte(inbound.Settings), &settings)
+		if err != nil {
+			return "", err
+		}
+	}
+	stream := map[string]interface{}{}
+	if inbound.StreamSettings != "" {
+		err = json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	remark := client.Email
+	if inbound.Remark != "" {
+		remark = inbound.Remark + "-" + client.Email
+	}
+	host := net.JoinHostPort(address, strconv.Itoa(inbound.Port))
+
+	switch inbound.Protocol {
+	case "vless":
+		params := streamParams(stream)
+		params.Set("encryption", "none")
+		if flow := jsonString(settings, "flow"); flow != "" && flow != "none" {
+			params.Set("flow", flow)
+		}
+		return fmt.Sprintf("vless://%s@%s?%s#%s", client.UUID, host, params.Encode(), url.PathEscape(remark)), nil
+	case "trojan":
+		params := streamParams(stream)
+		return fmt.Sprintf("trojan://%s@%s?%s#%s", url.PathEscape(client.UUID), host, params.Encode(), url.PathEscape(remark)), nil
+	case "vmess":
+		return vmessLink(client, address, inbound.Port, stream, remark)
+	case "shadowsocks":
+		method := jsonString(settings, "method")
+		password := client.UUID
+		// 2022系列加密的多用户模式，客户端密码为 服务端密码:用户密码
+		if strings.HasPrefix(method, "2022-") {
+			password = jsonString(settings, "password") + ":" + client.UUID
+		}
+		userInfo := base64.RawURLEncoding.EncodeToString([]byte(method + ":" + password))
+		return fmt.Sprintf("ss://%s@%s#%s", userInfo, host, url.PathEscape(remark)), nil
+	default:
+		return "", fmt.Errorf("%s 入站不支持生成分享链接", inbound.Protocol)
+	}
+}
+
+// vmessLink 生成v2rayN格式的vmess分享链接
+func vmessLink(client *database.ClientConfig, address string, port int, stream map[string]interface{}, remark string) (string, error) {
+	params := streamParams(stream)
+	link := map[string]string{
+		"v":    "2",
+		"ps":   remark,
+		"add":  address,
+		"port": strconv.Itoa(port),
+		"id":   client.UUID,
+		"aid":  "0",
+		"scy":  "auto",
+		"net":  params.Get("type"),
+		"type": params.Get("headerType"),
+		"host": params.Get("host"),
+		"path": params.Get("path"),
+		"tls":  params.Get("security"),
+		"sni":  params.Get("sni"),
+		"alpn": params.Get("alpn"),
+		"fp":   params.Get("fp"),
+	}
+	if link["type"] == "" {
+		link["type"] = "none"
+	}
+	if link["net"] == "grpc" {
+		link["path"] = params.Get("serviceName")
+	}
+	data, err := json.Marshal(link)
+	if err != nil {
+		return "", err
+	}
+	return "vmess://" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// streamParams 根据入站的streamSettings生成分享链接中的传输和安全参数
+func streamParams(stream map[string]interface{}) url.Values {
+	params := url.Values{}
+	network := jsonString(stream, "network")
+	if network == "" {
+		network = "tcp"
+	}
+	params.Set("type", network)
+
+	switch network {
+	case "tcp":
+		header := jsonMap(jsonMap(stream, "tcpSettings"), "header")
+		if jsonString(header, "type") == "http" {
+			params.Set("headerType", "http")
+			request := jsonMap(header, "request")
+			if paths := jsonStrings(request, "path"); len(paths) > 0 {
+				params.Set("path", paths[0])
+			}
+			if hosts := jsonStrings(jsonMap(request, "headers"), "Host"); len(hosts) > 0 {
+				params.Set("host", hosts[0])
+			}
+		}
+	case "kcp":
+		kcp := jsonMap(stream, "kcpSettings")
+		if headerType := jsonString(jsonMap(kcp, "header"), "type"); headerType != "" {
+			params.Set("headerType", headerType)
+		}
+		if seed := jsonString(kcp, "seed"); seed != "" {
+			params.Set("seed", seed)
+		}
+	case "ws", "httpupgrade":
+		settings := jsonMap(stream, network+"Settings")
+		setNonEmpty(params, "path", jsonString(settings, "path"))
+		host := jsonString(settings, "host")
+		if host == "" {
+			host = jsonString(jsonMap(settings, "headers"), "Host")
+		}
+		setNonEmpty(params, "host", host)
+	case "xhttp", "splithttp":
+		settings := jsonMap(stream, network+"Settings")
+		setNonEmpty(params, "path", jsonString(settings, "path"))
+		setNonEmpty(params, "host", jsonString(settings, "host"))
+		setNonEmpty(params, "mode", jsonString(settings, "mode"))
+	case "grpc":
+		settings := jsonMap(stream, "grpcSettings")
+		setNonEmpty(params, "serviceName", jsonString(settings, "serviceName"))
+		if multi, _ := settings["multiMode"].(bool); multi {
+			params.Set("mode", "multi")
+		}
+	}
+
+	switch jsonString(stream, "security") {
+	case "tls":
+		tls := jsonMap(stream, "tlsSettings")
+		params.Set("security", "tls")
+		setNonEmpty(params, "sni", jsonString(tls, "serverName"))
+		setNonEmpty(params, "fp", jsonString(tls, "fingerprint"))
+		setNonEmpty(params, "alpn", strings.Join(jsonStrings(tls, "alpn"), ","))
+	case "reality":
+		reality := jsonMap(stream, "realitySettings")
+		params.Set("security", "reality")
+		setNonEmpty(params, "pbk", jsonString(reality, "publicKey"))
+		if shortIDs := jsonStrings(reality, "shortIds"); len(shortIDs) > 0 {
+			params.Set("sid", shortIDs[0])
+		}
+		if serverNames := jsonStrings(reality, "serverNames"); len(serverNames) > 0 {
+			params.Set("sni", serverNames[0])
+		}
+		fingerprint := jsonString(reality, "fingerprint")
+		if fingerprint == "" {
+			fingerprint = "chrome"
+		}
+		params.Set("fp", fingerprint)
+		setNonEmpty(params, "spx", jsonString(reality, "spiderX"))
+	default:
+		params.Set("security", "none")
+	}
+	return params
+}
+
+func setNonEmpty(params url.Values, key string, value string) {
+	if value != "" {
+		params.Set(key, value)
+	}
+}
+
+func jsonMap(m map[string]interface{}, key string) map[string]interface{} {
+	value, _ := m[key].(map[string]interface{})
+	return value
+}
+
+func jsonString(m map[string]interface{}, key string) string {
+	value, _ := m[key].(string)
+	return value
+}
+
+// jsonStrings 获取字符串数组，值为单个字符串时也当作数组
+func jsonStrings(m map[string]interface{}, key string) []string {
+	switch value := m[key].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var result []string
+		for _, item := range value {
+			if str, ok := item.(string); ok && str != "" {
+				result = append(result, str)
+			}
+		}
+		return result
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"mx-ui/database"
+	"mx-ui/xray"
+	"strings"
+	"testing"
+)
+
+func TestImportFillsRealityKeys(t *testing.T) {
+	setupTestDB(t)
+	bundle := &ExportBundle{
+		Version: exportBundleVersion,
+		Inbounds: []*ExportInbound{{
+			Protocol:       "vless",
+			Tag:            "reality",
+			Port:           443,
+			Enable:         true,
+			Settings:       `{"decryption":"none","flow":"xtls-rprx-vision"}`,
+			StreamSettings: `{"network":"tcp","security":"reality","realitySettings":{"dest":"www.example.com:443","serverNames":["www.example.com"]}}`,
+			Remark:         "hk",
+			Clients: []*ExportClient{{
+				Email:  "alice",
+				UUID:   "0b4c7a1e-8f5d-4d2a-9c3e-1f2a3b4c5d6e",
+				Enable: true,
+			}},
+		}},
+	}
+	inboundService := InboundService{}
+	_, err := inboundService.ImportInbounds(bundle, &ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inbounds, err := inboundService.GetInbounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := map[string]interface{}{}
+	err = json.Unmarshal([]byte(inbounds[0].StreamSettings), &stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reality := jsonMap(stream, "realitySettings")
+	publicKey, err := xray.X25519PublicKey(jsonString(reality, "privateKey"))
+	if err != nil {
+		t.Fatalf("导入时应生成privateKey: %v", err)
+	}
+	if jsonString(reality, "publicKey") != publicKey {
+		t.Errorf("publicKey为 %q，期望 %q", jsonString(reality, "publicKey"), publicKey)
+	}
+	shortIDs := jsonStrings(reality, "shortIds")
+	if len(shortIDs) != 1 || shortIDs[0] == "" {
+		t.Fatalf("导入时应生成shortIds，实际为 %q", shortIDs)
+	}
+
+	clientService := ClientService{}
+	alice, err := clientService.GetClientByEmail("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := clientService.GetShareLink(alice, "2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "vless://0b4c7a1e-8f5d-4d2a-9c3e-1f2a3b4c5d6e@[2001:db8::1]:443?encryption=none&flow=xtls-rprx-vision" +
+		"&fp=chrome&pbk=" + publicKey + "&security=reality&sid=" + shortIDs[0] + "&sni=www.example.com&type=tcp#hk-alice"
+	if link != want {
+		t.Errorf("分享链接为 %s，期望 %s", link, want)
+	}
+}
+
+func TestShareLink(t *testing.T) {
+	setupTestDB(t)
+	tests := []struct {
+		name    string
+		inbound *database.InboundConfig
+		want    string
+	}{
+		{
+			name: "vless ws tls",
+			inbound: &database.InboundConfig{
+				Protocol:       "vless",
+				Port:           8443,
+				Settings:       `{"decryption":"none","flow":"none"}`,
+				StreamSettings: `{"network":"ws","security":"tls","wsSettings":{"path":"/ws","headers":{"Host":"cdn.example.com"}},"tlsSettings":{"serverName":"example.com","alpn":["h2","http/1.1"]}}`,
+			},
+			want: "vless://11111111-2222-4333-8444-555555555555@example.org:8443?alpn=h2%2Chttp%2F1.1&encryption=none" +
+				"&host=cdn.example.com&path=%2Fws&security=tls&sni=example.com&type=ws#alice",
+		},
+		{
+			name: "trojan grpc",
+			inbound: &database.InboundConfig{
+				Protocol:       "trojan",
+				Port:           2083,
+				StreamSettings: `{"network":"grpc","grpcSettings":{"serviceName":"svc","multiMode":true}}`,
+				Remark:         "jp",
+			},
+			want: "trojan://11111111-2222-4333-8444-555555555555@example.org:2083?mode=multi&security=none&serviceName=svc&type=grpc#jp-alice",
+		},
+		{
+			name: "shadowsocks 2022",
+			inbound: &database.InboundConfig{
+				Protocol: "shadowsocks",
+				Port:     8388,
+				Settings: `{"method":"2022-blake3-aes-128-gcm","password":"c2VydmVy"}`,
+			},
+			want: "ss://" + base64.RawURLEncoding.EncodeToString([]byte("2022-blake3-aes-128-gcm:c2VydmVy:11111111-2222-4333-8444-555555555555")) +
+				"@example.org:8388#alice",
+		},
+	}
+	clientService := ClientService{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inbound := createTestInbound(t, test.inbound)
+			client := &database.ClientConfig{
+				InboundID: inbound.ID,
+				Email:     "alice",
+				UUID:      "11111111-2222-4333-8444-555555555555",
+			}
+			link, err := clientService.GetShareLink(client, "example.org")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if link != test.want {
+				t.Errorf("分享链接为 %s，期望 %s", link, test.want)
+			}
+		})
+	}
+
+	// vmess为v2rayN格式的base64编码JSON
+	inbound := createTestInbound(t, &database.InboundConfig{
+		Protocol:       "vmess",
+		Port:           10086,
+		StreamSettings: `{"network":"grpc","security":"tls","grpcSettings":{"serviceName":"svc"},"tlsSettings":{"serverName":"example.com","fingerprint":"safari"}}`,
+	})
+	link, err := clientService.GetShareLink(&database.ClientConfig{InboundID: inbound.ID, Email: "bob", UUID: "22222222-2222-4222-8222-222222222222"}, "example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(link, "vmess://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmess := map[string]string{}
+	err = json.Unmarshal(data, &vmess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"add": "example.org", "port": "10086", "id": "22222222-2222-4222-8222-222222222222", "ps": "bob",
+		"net": "grpc", "path": "svc", "type": "none", "tls": "tls", "sni": "example.com", "fp": "safari",
+	} {
+		if vmess[key] != want {
+			t.Errorf("vmess链接的 %s 为 %q，期望 %q", key, vmess[key], want)
+		}
+	}
+
+	inbound = createTestInbound(t, &database.InboundConfig{Protocol: "socks", Port: 1080})
+	_, err = clientService.GetShareLink(&database.ClientConfig{InboundID: inbound.ID, Email: "carol"}, "example.org")
+	if err == nil {
+		t.Error("不支持的协议应返回错误")
+	}
+}
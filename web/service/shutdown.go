@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"mx-ui/logger"
+	"sync"
+	"time"
+)
+
+// shutdownHook 关闭时执行的一个步骤
+type shutdownHook struct {
+	name    string
+	timeout time.Duration
+	run     func(ctx context.Context) error
+}
+
+// Shutdown 关闭协调器。后台任务监听Context()，退出时先取消该上下文，
+// 再按注册顺序执行关闭步骤，每一步都有各自的超时并记录日志
+type Shutdown struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+
+	lock  sync.Mutex
+	hooks []*shutdownHook
+	once  sync.Once
+}
+
+// NewShutdown 创建关闭协调器，timeout为执行全部关闭步骤的总时间
+func NewShutdown(timeout time.Duration) *Shutdown {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Shutdown{
+		ctx:     ctx,
+		cancel:  cancel,
+		timeout: timeout,
+	}
+}
+
+// Context 返回后台任务监听的上下文，开始关闭时取消
+func (s *Shutdown) Context() context.Context {
+	return s.ctx
+}
+
+// Register 注册关闭步骤，按注册顺序执行。timeout为0时只受总时间限制
+func (s *Shutdown) Register(name string, timeout time.Duration, run func(ctx context.Context) error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.hooks = append(s.hooks, &shutdownHook{
+		name:    name,
+		timeout: timeout,
+		run:     run,
+	})
+}
+
+// Run 取消后台任务的上下文并依次执行关闭步骤，多次调用时只执行一次。
+// 单个步骤出错或超时不影响后续步骤，超过总时间后跳过剩余步骤
+func (s *Shutdown) Run() {
+	s.once.Do(func() {
+		s.lock.Lock()
+		hooks := s.hooks
+		s.lock.Unlock()
+
+		start := time.Now()
+		logger.Infof("开始关闭，共 %d 个步骤，总超时 %v", len(hooks), s.timeout)
+		s.cancel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+		for i, hook := range hooks {
+			if ctx.Err() != nil {
+				logger.Warningf("关闭步骤 %d/%d %s: 已超过总超时，跳过", i+1, len(hooks), hook.name)
+				continue
+			}
+			s.runHook(ctx, hook, i+1, len(hooks))
+		}
+		logger.Infof("关闭完成，耗时 %v", time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// runHook 执行单个关闭步骤，超时后不再等待它返回
+func (s *Shutdown) runHook(ctx context.Context, hook *shutdownHook, index int, total int) {
+	if hook.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hook.timeout)
+		defer cancel()
+	}
+
+	logger.Infof("关闭步骤 %d/%d: %s", index, total, hook.name)
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- hook.run(ctx)
+	}()
+
+	select {
+	case err := <-errCh:
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			logger.Warningf("关闭步骤 %d/%d %s 出错（耗时 %v）: %v", index, total, hook.name, elapsed, err)
+		} else {
+			logger.Infof("关闭步骤 %d/%d %s 完成，耗时 %v", index, total, hook.name, elapsed)
+		}
+	case <-ctx.Done():
+		logger.Warningf("关闭步骤 %d/%d %s 超时: %v", index, total, hook.name, ctx.Err())
+	}
+}
@@ -0,0 +1,521 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"mx-ui/database"
+	"mx-ui/logger"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Telegram长轮询等待时间（秒）
+const tgBotPollTimeout = 30
+
+// Tgbot Telegram机器人，通过长轮询接收命令并调用面板服务
+type Tgbot struct {
+	token     string
+	apiServer string
+	chatIDs   map[int64]bool
+	client    *http.Client
+
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// tgResponse Telegram Bot API通用响应
+type tgResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	Description string          `json:"description"`
+}
+
+// tgUpdate Telegram更新
+type tgUpdate struct {
+	UpdateID int64      `json:"update_id"`
+	Message  *tgMessage `json:"message"`
+}
+
+// tgMessage Telegram消息
+type tgMessage struct {
+	MessageID int64  `json:"message_id"`
+	Text      string `json:"text"`
+	Chat      struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+}
+
+// NewTgbot 根据设置创建Telegram机器人
+func NewTgbot() (*Tgbot, error) {
+	settingService := SettingService{}
+	token, err := settingService.GetTgBotToken()
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, errors.New("未设置Telegram机器人令牌")
+	}
+	apiServer, err := settingService.GetTgBotAPIServer()
+	if err != nil {
+		return nil, err
+	}
+	chatIDs, err := settingService.GetTgBotChatIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tgbot{
+		token:     token,
+		apiServer: apiServer,
+		chatIDs:   map[int64]bool{},
+		client: &http.Client{
+			Timeout: (tgBotPollTimeout + 10) * time.Second,
+		},
+	}
+	for _, chatID := range chatIDs {
+		t.chatIDs[chatID] = true
+	}
+	return t, nil
+}
+
+// Start 启动长轮询，ctx取消时停止
+func (t *Tgbot) Start(ctx context.Context) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	t.cancel = cancel
+	t.done = make(chan struct{})
+	go t.poll(ctx, t.done)
+	logger.Info("Telegram机器人已启动")
+}
+
+// Stop 停止长轮询并等待其退出
+func (t *Tgbot) Stop() {
+	t.lock.Lock()
+	cancel, done := t.cancel, t.done
+	t.cancel = nil
+	t.lock.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+	logger.Info("Telegram机器人已停止")
+}
+
+// poll 循环获取更新并处理
+func (t *Tgbot) poll(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	var offset int64
+	for {
+		updates, err := t.getUpdates(ctx, offset)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Warning("获取Telegram更新失败:", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			if update.Message != nil {
+				t.handleMessage(update.Message)
+			}
+		}
+	}
+}
+
+// call 调用Telegram Bot API
+func (t *Tgbot) call(ctx context.Context, method string, body interface{}) (json.RawMessage, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	apiURL := fmt.Sprintf("%s/bot%s/%s", t.apiServer, url.PathEscape(t.token), method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return t.do(req, method)
+}
+
+// do 发送请求并解析Telegram Bot API响应
+func (t *Tgbot) do(req *http.Request, method string) (json.RawMessage, error) {
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &tgResponse{}
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return nil, fmt.Errorf("解析响应失败: %v", err)
+	}
+	if !result.OK {
+		return nil, fmt.Errorf("%s: %s", method, result.Description)
+	}
+	return result.Result, nil
+}
+
+// getUpdates 长轮询获取更新
+func (t *Tgbot) getUpdates(ctx context.Context, offset int64) ([]tgUpdate, error) {
+	result, err := t.call(ctx, "getUpdates", map[string]interface{}{
+		"offset":          offset,
+		"timeout":         tgBotPollTimeout,
+		"allowed_updates": []string{"message"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var updates []tgUpdate
+	err = json.Unmarshal(result, &updates)
+	if err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// SendMessage 向指定会话发送文本消息
+func (t *Tgbot) SendMessage(chatID int64, text string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := t.call(ctx, "sendMessage", map[string]interface{}{
+		"chat_id": chatID,
+		"text":    text,
+	})
+	return err
+}
+
+// SendDocument 向指定会话发送文件
+func (t *Tgbot) SendDocument(chatID int64, filePath string, caption string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	_ = writer.WriteField("chat_id", strconv.FormatInt(chatID, 10))
+	_ = writer.WriteField("caption", caption)
+	part, err := writer.CreateFormFile("document", filepath.Base(filePath))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	apiURL := fmt.Sprintf("%s/bot%s/sendDocument", t.apiServer, url.PathEscape(t.token))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	_, err = t.do(req, "sendDocument")
+	return err
+}
+
+// GetChatIDs 获取白名单内的会话ID
+func (t *Tgbot) GetChatIDs() []int64 {
+	chatIDs := make([]int64, 0, len(t.chatIDs))
+	for chatID := range t.chatIDs {
+		chatIDs = append(chatIDs, chatID)
+	}
+	sort.Slice(chatIDs, func(i, j int) bool { return chatIDs[i] < chatIDs[j] })
+	return chatIDs
+}
+
+// handleMessage 处理一条消息，只响应白名单内的会话
+func (t *Tgbot) handleMessage(msg *tgMessage) {
+	chatID := msg.Chat.ID
+	if !t.chatIDs[chatID] {
+		logger.Warning("拒绝未授权的Telegram会话:", chatID)
+		t.reply(chatID, fmt.Sprintf("未授权的会话，会话ID: %d", chatID))
+		return
+	}
+
+	fields := strings.Fields(msg.Text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return
+	}
+	// 去除群组中命令附带的 @机器人名
+	command := strings.SplitN(fields[0], "@", 2)[0]
+	args := fields[1:]
+	logger.Info("收到Telegram命令:", command, "会话:", chatID)
+
+	var text string
+	switch command {
+	case "/start", "/help":
+		text = t.helpText()
+	case "/status":
+		text = t.statusText()
+	case "/inbounds":
+		text = t.inboundsText()
+	case "/clients":
+		text = t.clientsText(args)
+	case "/client":
+		text = t.withClient(args, t.clientText)
+	case "/enable":
+		text = t.withClient(args, func(client *database.ClientConfig) string {
+			return t.setClientEnable(client, true)
+		})
+	case "/disable":
+		text = t.withClient(args, func(client *database.ClientConfig) string {
+			return t.setClientEnable(client, false)
+		})
+	case "/reset":
+		text = t.withClient(args, t.resetClientTraffic)
+	case "/sublink":
+		text = t.withClient(args, t.subLinkText)
+	case "/restartxray":
+		text = t.restartXray()
+	default:
+		text = "未知命令，发送 /help 查看可用命令"
+	}
+	t.reply(chatID, text)
+}
+
+// reply 发送回复，失败时只记录日志
+func (t *Tgbot) reply(chatID int64, text string) {
+	err := t.SendMessage(chatID, text)
+	if err != nil {
+		logger.Warning("发送Telegram消息失败:", err)
+	}
+}
+
+// withClient 根据第一个参数（邮箱）查找客户端并执行操作
+func (t *Tgbot) withClient(args []string, fn func(client *database.ClientConfig) string) string {
+	if len(args) < 1 {
+		return "请提供客户端邮箱"
+	}
+	clientService := ClientService{}
+	client, err := clientService.GetClientByEmail(args[0])
+	if err != nil {
+		return "客户端不存在: " + args[0]
+	}
+	return fn(client)
+}
+
+func (t *Tgbot) helpText() string {
+	return strings.Join([]string{
+		"/status - 服务器状态",
+		"/inbounds - 入站列表",
+		"/clients [入站ID] - 客户端列表",
+		"/client <邮箱> - 客户端详情",
+		"/enable <邮箱> - 启用客户端",
+		"/disable <邮箱> - 禁用客户端",
+		"/reset <邮箱> - 重置客户端流量",
+		"/sublink <邮箱> - 获取订阅链接",
+		"/restartxray - 重启Xray",
+	}, "\n")
+}
+
+func (t *Tgbot) statusText() string {
+	serverService := ServerService{}
+	status := serverService.GetStatus(nil)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CPU: %.2f%%\n", status.Cpu)
+	fmt.Fprintf(&b, "内存: %s / %s\n", FormatTraffic(int64(status.Mem.Current)), FormatTraffic(int64(status.Mem.Total)))
+	fmt.Fprintf(&b, "磁盘: %s / %s\n", FormatTraffic(int64(status.Disk.Current)), FormatTraffic(int64(status.Disk.Total)))
+	if len(status.Loads) == 3 {
+		fmt.Fprintf(&b, "负载: %.2f %.2f %.2f\n", status.Loads[0], status.Loads[1], status.Loads[2])
+	}
+	fmt.Fprintf(&b, "运行时间: %s\n", (time.Duration(status.Uptime) * time.Second).String())
+	fmt.Fprintf(&b, "TCP/UDP连接数: %d / %d\n", status.TcpCount, status.UdpCount)
+	fmt.Fprintf(&b, "Xray状态: %s", status.Xray.State)
+	return b.String()
+}
+
+func (t *Tgbot) inboundsText() string {
+	inboundService := InboundService{}
+	inbounds, err := inboundService.GetInbounds()
+	if err != nil {
+		return "获取入站列表失败: " + err.Error()
+	}
+	if len(inbounds) == 0 {
+		return "暂无入站"
+	}
+
+	clientService := ClientService{}
+	var b strings.Builder
+	for _, inbound := range inbounds {
+		clients, _ := clientService.GetClients(inbound.ID)
+		var used int64
+		for _, client := range clients {
+			used += client.Used
+		}
+		fmt.Fprintf(&b, "[%d] %s %s:%d %s 客户端: %d 已用: %s\n",
+			inbound.ID, inbound.Remark, inbound.Protocol, inbound.Port,
+			enableText(inbound.Enable), len(clients), FormatTraffic(used))
+	}
+	return b.String()
+}
+
+func (t *Tgbot) clientsText(args []string) string {
+	var inboundID uint64
+	if len(args) > 0 {
+		var err error
+		inboundID, err = strconv.ParseUint(args[0], 10, 32)
+		if err != nil {
+			return "入站ID无效: " + args[0]
+		}
+	}
+
+	clientService := ClientService{}
+	clients, err := clientService.GetClients(uint(inboundID))
+	if err != nil {
+		return "获取客户端列表失败: " + err.Error()
+	}
+	if len(clients) == 0 {
+		return "暂无客户端"
+	}
+
+	var b strings.Builder
+	for _, client := range clients {
+		fmt.Fprintf(&b, "%s [入站%d] %s 已用: %s / %s\n",
+			client.Email, client.InboundID, enableText(client.Enable),
+			FormatTraffic(client.Used), FormatLimit(client.Limit))
+	}
+	return b.String()
+}
+
+func (t *Tgbot) clientText(client *database.ClientConfig) string {
+	expiry := "永不过期"
+	if client.ExpiryTime > 0 {
+		expiry = time.UnixMilli(client.ExpiryTime).Format("2006-01-02 15:04:05")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "邮箱: %s\n", client.Email)
+	fmt.Fprintf(&b, "入站: %d\n", client.InboundID)
+	fmt.Fprintf(&b, "状态: %s\n", enableText(client.Enable))
+	fmt.Fprintf(&b, "已用流量: %s / %s\n", FormatTraffic(client.Used), FormatLimit(client.Limit))
+	fmt.Fprintf(&b, "到期时间: %s", expiry)
+	if client.Remark != "" {
+		fmt.Fprintf(&b, "\n备注: %s", client.Remark)
+	}
+	return b.String()
+}
+
+func (t *Tgbot) setClientEnable(client *database.ClientConfig, enable bool) string {
+	clientService := ClientService{}
+	err := clientService.SetClientEnable(client.ID, enable)
+	if err != nil {
+		return "设置客户端状态失败: " + err.Error()
+	}
+	message := fmt.Sprintf("客户端 %s 已%s", client.Email, enableText(enable))
+	if err := t.applyXrayConfig(); err != nil {
+		return message + "，但应用Xray配置失败: " + err.Error()
+	}
+	return message
+}
+
+func (t *Tgbot) resetClientTraffic(client *database.ClientConfig) string {
+	clientService := ClientService{}
+	err := clientService.ResetClientTraffic(client.ID)
+	if err != nil {
+		return "重置流量失败: " + err.Error()
+	}
+	message := fmt.Sprintf("客户端 %s 流量已重置", client.Email)
+	if err := t.applyXrayConfig(); err != nil {
+		return message + "，但应用Xray配置失败: " + err.Error()
+	}
+	return message
+}
+
+func (t *Tgbot) subLinkText(client *database.ClientConfig) string {
+	clientService := ClientService{}
+	link, err := clientService.GetSubLink(client)
+	if err != nil {
+		return "获取订阅链接失败: " + err.Error()
+	}
+	return link
+}
+
+func (t *Tgbot) restartXray() string {
+	xrayService := XrayService{}
+	err := xrayService.RestartXray(true)
+	if err != nil {
+		return "Xray重启失败: " + err.Error()
+	}
+	return "Xray重启成功"
+}
+
+// applyXrayConfig 客户端变更后记录配置历史版本并重新应用Xray配置，返回应用配置的错误
+func (t *Tgbot) applyXrayConfig() error {
+	revisionService := ConfigRevisionService{}
+	_, err := revisionService.Record("tgbot", "")
+	if err != nil {
+		logger.Warning("记录配置历史版本失败:", err)
+	}
+
+	xrayService := XrayService{}
+	err = xrayService.ApplyConfig()
+	if err != nil {
+		logger.Warning("重启Xray失败:", err)
+	}
+	return err
+}
+
+func enableText(enable bool) string {
+	if enable {
+		return "启用"
+	}
+	return "禁用"
+}
+
+// FormatLimit 格式化流量限制，0表示不限
+func FormatLimit(limit int64) string {
+	if limit <= 0 {
+		return "不限"
+	}
+	return FormatTraffic(limit)
+}
+
+// FormatTraffic 将字节数格式化为易读的形式
+func FormatTraffic(bytes int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB", "PB"}
+	size := float64(bytes)
+	i := 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.2f %s", size, units[i])
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"mx-ui/database"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTelegram 模拟Telegram Bot API：getUpdates依次返回updates，之后等待到请求取消；
+// sendMessage发送的消息写入sent
+type fakeTelegram struct {
+	t       *testing.T
+	token   string
+	updates chan tgUpdate
+	sent    chan map[string]interface{}
+}
+
+func newFakeTelegram(t *testing.T, token string) (*fakeTelegram, *httptest.Server) {
+	f := &fakeTelegram{
+		t:       t,
+		token:   token,
+		updates: make(chan tgUpdate, 10),
+		sent:    make(chan map[string]interface{}, 10),
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := "/bot" + f.token + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "description": "Unauthorized"})
+		return
+	}
+	body := map[string]interface{}{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	var result interface{}
+	switch strings.TrimPrefix(r.URL.Path, prefix) {
+	case "getUpdates":
+		select {
+		case update := <-f.updates:
+			result = []tgUpdate{update}
+		case <-r.Context().Done():
+			return
+		}
+	case "sendMessage":
+		f.sent <- body
+		result = map[string]interface{}{"message_id": 1}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "description": "Not Found"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+// send 模拟会话chatID发送一条消息，返回机器人的回复
+func (f *fakeTelegram) send(updateID int64, chatID int64, text string) map[string]interface{} {
+	f.t.Helper()
+	update := tgUpdate{UpdateID: updateID, Message: &tgMessage{MessageID: updateID, Text: text}}
+	update.Message.Chat.ID = chatID
+	f.updates <- update
+	select {
+	case reply := <-f.sent:
+		return reply
+	case <-time.After(5 * time.Second):
+		f.t.Fatalf("等待 %q 的回复超时", text)
+		return nil
+	}
+}
+
+func TestTgbotCommands(t *testing.T) {
+	setupTestDB(t)
+	const token = "123456:test-token"
+	fake, server := newFakeTelegram(t, token)
+
+	settingService := SettingService{}
+	if err := settingService.SetTgBotToken(token); err != nil {
+		t.Fatal(err)
+	}
+	if err := settingService.SetTgBotAPIServer(server.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := settingService.SetTgBotChatIDs("1001"); err != nil {
+		t.Fatal(err)
+	}
+	inbound := createTestInbound(t, &database.InboundConfig{Protocol: "vless", Port: 10001, Enable: true, Remark: "test"})
+	client := createTestClient(t, &database.ClientConfig{
+		InboundID: inbound.ID,
+		Email:     "alice",
+		UUID:      "a3482e88-686a-4a58-8126-99c9df64b7bf",
+		Enable:    true,
+		Used:      1024,
+	})
+
+	bot, err := NewTgbot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot.Start(context.Background())
+	defer bot.Stop()
+
+	reply := fake.send(1, 1001, "/client alice")
+	if reply["chat_id"] != float64(1001) {
+		t.Fatalf("回复的会话为 %v，应为 1001", reply["chat_id"])
+	}
+	text, _ := reply["text"].(string)
+	if !strings.Contains(text, "邮箱: alice") || !strings.Contains(text, "状态: 启用") {
+		t.Fatalf("/client 回复错误: %q", text)
+	}
+
+	reply = fake.send(2, 1001, "/disable@test_bot alice")
+	if text, _ := reply["text"].(string); text != "客户端 alice 已禁用" {
+		t.Fatalf("/disable 回复错误: %q", text)
+	}
+	clientService := ClientService{}
+	updated, err := clientService.GetClient(client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Enable {
+		t.Fatal("/disable 后客户端仍为启用状态")
+	}
+
+	reply = fake.send(3, 2002, "/client alice")
+	if reply["chat_id"] != float64(2002) {
+		t.Fatalf("回复的会话为 %v，应为 2002", reply["chat_id"])
+	}
+	if text, _ := reply["text"].(string); !strings.Contains(text, "未授权") {
+		t.Fatalf("未授权会话的回复错误: %q", text)
+	}
+}
+
+func TestTgbotReportsApplyError(t *testing.T) {
+	dir := setupTestDB(t)
+	const token = "123456:test-token"
+	fake, server := newFakeTelegram(t, token)
+
+	settingService := SettingService{}
+	if err := settingService.SetTgBotToken(token); err != nil {
+		t.Fatal(err)
+	}
+	if err := settingService.SetTgBotAPIServer(server.URL + "/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := settingService.SetTgBotChatIDs("1001"); err != nil {
+		t.Fatal(err)
+	}
+	inbound := createTestInbound(t, &database.InboundConfig{Protocol: "vless", Tag: "in-10001", Port: 10001, Enable: true})
+	createTestClient(t, &database.ClientConfig{
+		InboundID: inbound.ID,
+		Email:     "alice",
+		UUID:      "a3482e88-686a-4a58-8126-99c9df64b7bf",
+		Enable:    true,
+	})
+	// API端口上没有服务，禁用客户端时回退为重启Xray，此时配置检查失败
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	if err := settingService.SetXrayAPIPort(listener.Addr().(*net.TCPAddr).Port); err != nil {
+		t.Fatal(err)
+	}
+	failTests := installStubXray(t, dir)
+	startStubXray(t)
+	failTests()
+
+	bot, err := NewTgbot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot.Start(context.Background())
+	defer bot.Stop()
+
+	reply := fake.send(1, 1001, "/disable alice")
+	text, _ := reply["text"].(string)
+	if !strings.HasPrefix(text, "客户端 alice 已禁用，但应用Xray配置失败: xray配置检查未通过") {
+		t.Fatalf("应用配置失败时的回复错误: %q", text)
+	}
+}
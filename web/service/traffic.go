@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"mx-ui/logger"
+	"sync"
+	"time"
+)
+
+// 从Xray读取客户端流量的间隔
+const trafficFlushInterval = 10 * time.Second
+
+// TrafficJob 定期把Xray中客户端的流量增量累加到数据库
+type TrafficJob struct {
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewTrafficJob 创建流量统计任务
+func NewTrafficJob() *TrafficJob {
+	return &TrafficJob{}
+}
+
+// Start 每10秒保存一次客户端流量，ctx取消时停止
+func (j *TrafficJob) Start(ctx context.Context) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	j.cancel = cancel
+	j.done = make(chan struct{})
+	go j.run(ctx, j.done)
+}
+
+// Stop 停止流量统计，未保存的增量留在Xray的计数器中，由关闭步骤或下一次启动的任务保存
+func (j *TrafficJob) Stop() {
+	j.lock.Lock()
+	cancel, done := j.cancel, j.done
+	j.cancel = nil
+	j.lock.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (j *TrafficJob) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(trafficFlushInterval)
+	defer ticker.Stop()
+	xrayService := XrayService{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := xrayService.FlushTraffic()
+			if err != nil {
+				logger.Warning("保存客户端流量失败:", err)
+			}
+		}
+	}
+}
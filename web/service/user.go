@@ -21,8 +21,8 @@ func (s *UserService) GetFirstUser() (*database.User, error) {
 // CheckLogin 检查用户登录
 func (s *UserService) CheckLogin(username string, password string) (*database.User, error) {
 	user := &database.User{}
-	err := database.GetDB().Where("username = ? AND password = ?", username, password).First(user).Error
-	if err != nil {
+	err := database.GetDB().Where("username = ?", username).First(user).Error
+	if err != nil || !database.CheckPassword(user.Password, password) {
 		return nil, errors.New("用户名或密码错误")
 	}
 	return user, nil
@@ -39,7 +39,11 @@ func (s *UserService) UpdateFirstUser(username string, password string) error {
 		user.Username = username
 	}
 	if password != "" {
-		user.Password = password
+		hashed, err := database.HashPassword(password)
+		if err != nil {
+			return err
+		}
+		user.Password = hashed
 	}
 
 	return database.GetDB().Save(user).Error
@@ -0,0 +1,635 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"mx-ui/database"
+	"mx-ui/logger"
+	"mx-ui/xray"
+	"slices"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+var (
+	xrayProcess *xray.Process
+	xrayLock    sync.Mutex
+)
+
+// XrayService Xray相关服务
+type XrayService struct{}
+
+// IsXrayRunning 判断Xray是否在运行
+func (s *XrayService) IsXrayRunning() bool {
+	xrayLock.Lock()
+	defer xrayLock.Unlock()
+	return xrayProcess != nil && xrayProcess.IsRunning()
+}
+
+// IsXrayStopped 判断Xray是否是被手动停止的，停止后未再启动
+func (s *XrayService) IsXrayStopped() bool {
+	xrayLock.Lock()
+	defer xrayLock.Unlock()
+	return xrayProcess != nil && xrayProcess.IsStopped()
+}
+
+// GetXrayErr 获取Xray最近一次退出时的错误
+func (s *XrayService) GetXrayErr() error {
+	xrayLock.Lock()
+	defer xrayLock.Unlock()
+	if xrayProcess == nil {
+		return nil
+	}
+	return xrayProcess.GetErr()
+}
+
+// GetXrayResult 获取Xray进程输出
+func (s *XrayService) GetXrayResult() string {
+	xrayLock.Lock()
+	defer xrayLock.Unlock()
+	if xrayProcess == nil {
+		return ""
+	}
+	return xrayProcess.GetResult()
+}
+
+// getConfigTemplate 解析Xray配置模板
+func (s *XrayService) getConfigTemplate() (*xray.Config, error) {
+	settingService := SettingService{}
+	template, err := settingService.GetXrayConfigTemplate()
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigTemplate(template)
+}
+
+// parseConfigTemplate 解析Xray配置模板，为空时使用默认模板
+func parseConfigTemplate(template string) (*xray.Config, error) {
+	if template == "" {
+		template = database.GetDefaultXrayConfigTemplate()
+	}
+	xrayConfig := &xray.Config{}
+	err := json.Unmarshal([]byte(template), xrayConfig)
+	if err != nil {
+		return nil, fmt.Errorf("解析Xray配置模板失败: %v", err)
+	}
+	return xrayConfig, nil
+}
+
+// configSource 生成Xray配置使用的配置模板、入站、客户端、出站和路由规则
+type configSource struct {
+	template  string
+	inbounds  []*database.InboundConfig
+	clients   []*database.ClientConfig
+	outbounds []*database.OutboundConfig
+	rules     []*database.RoutingRule
+}
+
+// loadConfigSource 从数据库读取生成Xray配置使用的数据
+func loadConfigSource() (*configSource, error) {
+	source := &configSource{}
+	settingService := SettingService{}
+	template, err := settingService.GetXrayConfigTemplate()
+	if err != nil {
+		return nil, err
+	}
+	source.template = template
+	inboundService := InboundService{}
+	inbounds, err := inboundService.GetInbounds()
+	if err != nil {
+		return nil, err
+	}
+	source.inbounds = inbounds
+	clientService := ClientService{}
+	clients, err := clientService.GetClients(0)
+	if err != nil {
+		return nil, err
+	}
+	source.clients = clients
+	outboundService := OutboundService{}
+	outbounds, err := outboundService.GetOutbounds()
+	if err != nil {
+		return nil, err
+	}
+	source.outbounds = outbounds
+	routingService := RoutingService{}
+	rules, err := routingService.GetRules()
+	if err != nil {
+		return nil, err
+	}
+	source.rules = rules
+	return source, nil
+}
+
+// setInbound 替换ID相同的入站，不存在时追加
+func (c *configSource) setInbound(inbound *database.InboundConfig) {
+	for i, item := range c.inbounds {
+		if item.ID == inbound.ID && inbound.ID != 0 {
+			c.inbounds[i] = inbound
+			return
+		}
+	}
+	c.inbounds = append(c.inbounds, inbound)
+}
+
+// setClient 替换ID相同的客户端，不存在时追加
+func (c *configSource) setClient(client *database.ClientConfig) {
+	for i, item := range c.clients {
+		if item.ID == client.ID && client.ID != 0 {
+			c.clients[i] = client
+			return
+		}
+	}
+	c.clients = append(c.clients, client)
+}
+
+// setOutbound 替换ID相同的出站，不存在时追加
+func (c *configSource) setOutbound(outbound *database.OutboundConfig) {
+	for i, item := range c.outbounds {
+		if item.ID == outbound.ID && outbound.ID != 0 {
+			c.outbounds[i] = outbound
+			return
+		}
+	}
+	c.outbounds = append(c.outbounds, outbound)
+}
+
+// delOutbound 移除指定ID的出站
+func (c *configSource) delOutbound(id uint) {
+	var outbounds []*database.OutboundConfig
+	for _, item := range c.outbounds {
+		if item.ID != id {
+			outbounds = append(outbounds, item)
+		}
+	}
+	c.outbounds = outbounds
+}
+
+// setRule 替换ID相同的路由规则，不存在时追加到最后
+func (c *configSource) setRule(rule *database.RoutingRule) {
+	for i, item := range c.rules {
+		if item.ID == rule.ID && rule.ID != 0 {
+			c.rules[i] = rule
+			return
+		}
+	}
+	c.rules = append(c.rules, rule)
+}
+
+// checkConfigChange 在数据库中的配置数据上应用change，生成Xray配置并用Xray检查，保存改动前调用。
+// 未安装Xray时只检查配置能否生成
+func checkConfigChange(change func(source *configSource)) error {
+	source, err := loadConfigSource()
+	if err != nil {
+		return err
+	}
+	change(source)
+
+	xrayService := XrayService{}
+	xrayConfig, err := xrayService.genXrayConfig(source)
+	if err != nil {
+		return err
+	}
+	return xrayService.testConfig(xrayConfig)
+}
+
+// GetXrayConfig 根据配置模板、入站、客户端、出站和路由规则生成Xray配置，路由规则或客户端引用不存在的出站时返回错误
+func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
+	source, err := loadConfigSource()
+	if err != nil {
+		return nil, err
+	}
+	return s.genXrayConfig(source)
+}
+
+// genXrayConfig 根据source生成Xray配置
+func (s *XrayService) genXrayConfig(source *configSource) (*xray.Config, error) {
+	xrayConfig, err := parseConfigTemplate(source.template)
+	if err != nil {
+		return nil, err
+	}
+	err = mergeRouting(xrayConfig, source.outbounds, source.rules, source.clients)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := map[uint][]*database.ClientConfig{}
+	for _, client := range source.clients {
+		clients[client.InboundID] = append(clients[client.InboundID], client)
+	}
+	for _, inbound := range source.inbounds {
+		if !inbound.Enable {
+			continue
+		}
+		inboundConfig, err := s.genInboundConfig(inbound, clients[inbound.ID])
+		if err != nil {
+			return nil, fmt.Errorf("生成入站 %v 配置失败: %v", inbound.ID, err)
+		}
+		xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, *inboundConfig)
+	}
+
+	err = injectAPI(xrayConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = injectStats(xrayConfig)
+	if err != nil {
+		return nil, err
+	}
+	return xrayConfig, nil
+}
+
+// injectAPI 注入面板热更新客户端和统计流量使用的内部API：开启HandlerService和StatsService，
+// 添加只监听本机的dokodemo-door入站，并把API的路由规则放在最前面
+func injectAPI(xrayConfig *xray.Config) error {
+	settingService := SettingService{}
+	port, err := settingService.GetXrayAPIPort()
+	if err != nil {
+		return err
+	}
+
+	api := map[string]interface{}{}
+	if len(xrayConfig.API) > 0 {
+		err = json.Unmarshal(xrayConfig.API, &api)
+		if err != nil {
+			return fmt.Errorf("解析API配置失败: %v", err)
+		}
+	}
+	tag, _ := api["tag"].(string)
+	if tag == "" {
+		tag = xray.APITag
+		api["tag"] = tag
+	}
+	services, _ := api["services"].([]interface{})
+	for _, name := range []string{"HandlerService", "StatsService"} {
+		if !slices.Contains(services, interface{}(name)) {
+			services = append(services, name)
+		}
+	}
+	api["services"] = services
+	xrayConfig.API, err = json.Marshal(api)
+	if err != nil {
+		return err
+	}
+
+	hasInbound := false
+	for _, inbound := range xrayConfig.InboundConfigs {
+		if inbound.Tag == tag {
+			hasInbound = true
+		}
+	}
+	if !hasInbound {
+		xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, xray.InboundConfig{
+			Listen:   json.RawMessage(`"127.0.0.1"`),
+			Port:     port,
+			Protocol: "dokodemo-door",
+			Settings: json.RawMessage(`{"address":"127.0.0.1"}`),
+			Tag:      tag,
+		})
+	}
+
+	routing := map[string]json.RawMessage{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		err = json.Unmarshal(xrayConfig.RouterConfig, &routing)
+		if err != nil {
+			return fmt.Errorf("解析路由配置失败: %v", err)
+		}
+	}
+	var rules []json.RawMessage
+	if len(routing["rules"]) > 0 {
+		err = json.Unmarshal(routing["rules"], &rules)
+		if err != nil {
+			return fmt.Errorf("解析路由规则失败: %v", err)
+		}
+	}
+	for _, rule := range rules {
+		var parsed xray.RoutingRule
+		if json.Unmarshal(rule, &parsed) == nil && slices.Contains(parsed.InboundTag, tag) {
+			return nil
+		}
+	}
+	apiRule, err := json.Marshal(xray.RoutingRule{
+		Type:        "field",
+		InboundTag:  []string{tag},
+		OutboundTag: tag,
+	})
+	if err != nil {
+		return err
+	}
+	routing["rules"], err = json.Marshal(append([]json.RawMessage{apiRule}, rules...))
+	if err != nil {
+		return err
+	}
+	xrayConfig.RouterConfig, err = json.Marshal(routing)
+	return err
+}
+
+// injectStats 开启流量统计，并为默认等级0的用户开启上传和下载计数器，模板中的其他策略保持不变
+func injectStats(xrayConfig *xray.Config) error {
+	if len(xrayConfig.Stats) == 0 {
+		xrayConfig.Stats = json.RawMessage(`{}`)
+	}
+
+	policy := map[string]json.RawMessage{}
+	if len(xrayConfig.Policy) > 0 {
+		err := json.Unmarshal(xrayConfig.Policy, &policy)
+		if err != nil {
+			return fmt.Errorf("解析策略配置失败: %v", err)
+		}
+	}
+	levels := map[string]map[string]interface{}{}
+	if len(policy["levels"]) > 0 {
+		err := json.Unmarshal(policy["levels"], &levels)
+		if err != nil {
+			return fmt.Errorf("解析策略等级失败: %v", err)
+		}
+	}
+	if levels["0"] == nil {
+		levels["0"] = map[string]interface{}{}
+	}
+	levels["0"]["statsUserUplink"] = true
+	levels["0"]["statsUserDownlink"] = true
+
+	var err error
+	policy["levels"], err = json.Marshal(levels)
+	if err != nil {
+		return err
+	}
+	xrayConfig.Policy, err = json.Marshal(policy)
+	return err
+}
+
+// apiAddress 获取配置中内部API入站的本机地址
+func apiAddress(xrayConfig *xray.Config) (string, error) {
+	var api struct {
+		Tag string `json:"tag"`
+	}
+	err := json.Unmarshal(xrayConfig.API, &api)
+	if err != nil {
+		return "", err
+	}
+	for _, inbound := range xrayConfig.InboundConfigs {
+		if inbound.Tag == api.Tag {
+			return fmt.Sprintf("127.0.0.1:%d", inbound.Port), nil
+		}
+	}
+	return "", errors.New("配置中没有API入站")
+}
+
+// testConfig 用Xray检查配置，未安装Xray时跳过
+func (s *XrayService) testConfig(xrayConfig *xray.Config) error {
+	err := xray.TestConfig(xrayConfig)
+	if errors.Is(err, xray.ErrBinaryNotFound) {
+		logger.Debug("未找到Xray，跳过配置检查")
+		return nil
+	}
+	return err
+}
+
+// genInboundConfig 生成单个入站的Xray配置，并将有效客户端写入settings.clients
+func (s *XrayService) genInboundConfig(inbound *database.InboundConfig, clients []*database.ClientConfig) (*xray.InboundConfig, error) {
+	settings := map[string]interface{}{}
+	if inbound.Settings != "" {
+		err := json.Unmarshal([]byte(inbound.Settings), &settings)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	clientService := ClientService{}
+	var xrayClients []map[string]interface{}
+	for _, client := range clients {
+		if !clientService.IsClientValid(client) {
+			continue
+		}
+		xrayClient := map[string]interface{}{
+			"email": client.Email,
+		}
+		switch inbound.Protocol {
+		case "trojan", "shadowsocks":
+			xrayClient["password"] = client.UUID
+		default:
+			xrayClient["id"] = client.UUID
+		}
+		xrayClients = append(xrayClients, xrayClient)
+	}
+	if len(clients) > 0 {
+		if xrayClients == nil {
+			xrayClients = []map[string]interface{}{}
+		}
+		settings["clients"] = xrayClients
+	}
+
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := inbound.Tag
+	if tag == "" {
+		tag = fmt.Sprintf("inbound-%d", inbound.Port)
+	}
+
+	inboundConfig := &xray.InboundConfig{
+		Port:     inbound.Port,
+		Protocol: inbound.Protocol,
+		Settings: settingsJSON,
+		Tag:      tag,
+	}
+	if inbound.StreamSettings != "" {
+		inboundConfig.StreamSettings = json.RawMessage(inbound.StreamSettings)
+	}
+	return inboundConfig, nil
+}
+
+// RestartXray 重新生成配置，检查通过后重启Xray，force为false时配置未变化则不重启
+func (s *XrayService) RestartXray(force bool) error {
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		return err
+	}
+
+	xrayLock.Lock()
+	defer xrayLock.Unlock()
+
+	running := xrayProcess != nil && xrayProcess.IsRunning()
+	if running && !force && xrayProcess.GetConfig().Equals(xrayConfig) {
+		logger.Debug("Xray配置未变化，无需重启")
+		return nil
+	}
+	// 新配置检查不通过时保持当前进程和配置文件不变
+	err = s.testConfig(xrayConfig)
+	if err != nil {
+		return err
+	}
+
+	if running {
+		// 重启后计数器会清零，先保存上次统计以来的流量
+		_, err = saveTraffic(xrayProcess.GetConfig())
+		if err != nil {
+			logger.Warning("重启Xray前保存流量统计失败:", err)
+		}
+		err = xrayProcess.Stop()
+		if err != nil {
+			logger.Warning("停止Xray失败:", err)
+		}
+	}
+
+	xrayProcess = xray.NewProcess(xrayConfig)
+	logger.Info("启动Xray")
+	return xrayProcess.Start()
+}
+
+// ApplyConfig Xray运行时重新生成配置。只有客户端变化时通过API增删用户，不影响其他用户的连接；
+// 入站、出站、路由等有变化或API调用失败时重启Xray
+func (s *XrayService) ApplyConfig() error {
+	if !s.IsXrayRunning() {
+		return nil
+	}
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		return err
+	}
+	if s.applyUsers(xrayConfig) {
+		return nil
+	}
+	return s.RestartXray(false)
+}
+
+// applyUsers 通过API将运行中的Xray更新为xrayConfig，只有入站的用户变化时才能热更新，成功时返回true
+func (s *XrayService) applyUsers(xrayConfig *xray.Config) bool {
+	xrayLock.Lock()
+	defer xrayLock.Unlock()
+
+	if xrayProcess == nil || !xrayProcess.IsRunning() {
+		return false
+	}
+	changes, ok := xray.DiffUsers(xrayProcess.GetConfig(), xrayConfig)
+	if !ok {
+		return false
+	}
+	if len(changes) == 0 {
+		return true
+	}
+
+	addr, err := apiAddress(xrayConfig)
+	if err != nil {
+		logger.Warning("热更新客户端失败，将重启Xray:", err)
+		return false
+	}
+	api, err := xray.NewAPI(addr)
+	if err != nil {
+		logger.Warning("连接Xray API失败，将重启Xray:", err)
+		return false
+	}
+	defer api.Close()
+
+	for _, change := range changes {
+		for _, user := range change.Removed {
+			err = api.RemoveUser(change.Tag, user.Email)
+			if err != nil {
+				logger.Warningf("从入站 %s 删除用户 %s 失败，将重启Xray: %v", change.Tag, user.Email, err)
+				return false
+			}
+		}
+		for _, user := range change.Added {
+			err = api.AddUser(change.Tag, change.Protocol, change.Flow, user)
+			if err != nil {
+				logger.Warningf("向入站 %s 添加用户 %s 失败，将重启Xray: %v", change.Tag, user.Email, err)
+				return false
+			}
+		}
+		logger.Infof("已热更新入站 %s 的用户：添加 %d 个，删除 %d 个", change.Tag, len(change.Added), len(change.Removed))
+	}
+
+	err = xrayProcess.UpdateConfig(xrayConfig)
+	if err != nil {
+		logger.Warning("写入Xray配置文件失败:", err)
+	}
+	return true
+}
+
+// FlushTraffic 从运行中的Xray读取并清零客户端的流量计数器，累加到数据库中客户端的已用流量。
+// 有客户端因此超出流量限制时重新应用配置，将其从Xray中删除
+func (s *XrayService) FlushTraffic() error {
+	xrayLock.Lock()
+	var xrayConfig *xray.Config
+	if xrayProcess != nil && xrayProcess.IsRunning() {
+		xrayConfig = xrayProcess.GetConfig()
+	}
+	xrayLock.Unlock()
+	if xrayConfig == nil {
+		return nil
+	}
+
+	exceeded, err := saveTraffic(xrayConfig)
+	if err != nil {
+		return err
+	}
+	if exceeded {
+		return s.ApplyConfig()
+	}
+	return nil
+}
+
+// saveTraffic 通过xrayConfig中的API读取并清零流量计数器后保存到数据库，返回是否有客户端超出流量限制
+func saveTraffic(xrayConfig *xray.Config) (bool, error) {
+	addr, err := apiAddress(xrayConfig)
+	if err != nil {
+		return false, err
+	}
+	api, err := xray.NewAPI(addr)
+	if err != nil {
+		return false, err
+	}
+	defer api.Close()
+	traffic, err := api.QueryUserTraffic(true)
+	if err != nil {
+		return false, err
+	}
+	if len(traffic) == 0 {
+		return false, nil
+	}
+
+	exceeded := false
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		for email, bytes := range traffic {
+			err := tx.Model(&database.ClientConfig{}).
+				Where("email = ?", email).
+				Update("used", gorm.Expr("used + ?", bytes)).Error
+			if err != nil {
+				return err
+			}
+		}
+		var count int64
+		err := tx.Model(&database.ClientConfig{}).
+			Where("email IN ? AND `limit` > 0 AND used >= `limit`", slices.Collect(maps.Keys(traffic))).
+			Count(&count).Error
+		exceeded = count > 0
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("保存流量统计失败: %v", err)
+	}
+	logger.Debugf("已保存 %d 个客户端的流量统计", len(traffic))
+	return exceeded, nil
+}
+
+// StopXray 停止Xray
+func (s *XrayService) StopXray() error {
+	xrayLock.Lock()
+	defer xrayLock.Unlock()
+
+	if xrayProcess == nil || !xrayProcess.IsRunning() {
+		return errors.New("xray未在运行")
+	}
+	_, err := saveTraffic(xrayProcess.GetConfig())
+	if err != nil {
+		logger.Warning("停止Xray前保存流量统计失败:", err)
+	}
+	logger.Info("停止Xray")
+	return xrayProcess.Stop()
+}
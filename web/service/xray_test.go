@@ -0,0 +1,465 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mx-ui/config"
+	"mx-ui/database"
+	"mx-ui/xray"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xtls/xray-core/app/proxyman/command"
+	"github.com/xtls/xray-core/proxy/vless"
+	"google.golang.org/grpc"
+)
+
+// stubXrayScript 模拟Xray二进制文件：run -test 把检查的配置复制到数据目录下的 tested.json，
+// 存在 fail 文件时按Xray的格式输出错误并退出1，否则通过；run 时一直运行到被停止
+const stubXrayScript = `#!/bin/sh
+if [ "$2" = "-test" ]; then
+	cp "$4" "{dir}/tested.json"
+	if [ -f "{dir}/fail" ]; then
+		echo "Xray 1.8.0 (Xray, Penetrates Everything.)"
+		echo "Failed to start: main: failed to load config files: [$4] > infra/conf: failed to build inbound config with tag in-9 > infra/conf: port 9 is reserved" >&2
+		exit 1
+	fi
+	exit 0
+fi
+exec sleep 60
+`
+
+// installStubXray 把模拟的Xray二进制文件写到dir下的Xray路径，返回让配置检查失败的函数
+func installStubXray(t *testing.T, dir string) (failTests func()) {
+	t.Helper()
+	binaryPath := filepath.Join(dir, config.GetXrayBinaryPath())
+	err := os.MkdirAll(filepath.Dir(binaryPath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(binaryPath, []byte(strings.ReplaceAll(stubXrayScript, "{dir}", dir)), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		err := os.WriteFile(filepath.Join(dir, "fail"), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// startStubXray 启动模拟的Xray进程，测试结束时停止
+func startStubXray(t *testing.T) {
+	t.Helper()
+	xrayService := XrayService{}
+	err := xrayService.RestartXray(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		xrayService.StopXray()
+		xrayLock.Lock()
+		xrayProcess = nil
+		xrayLock.Unlock()
+	})
+	if !xrayService.IsXrayRunning() {
+		t.Fatal("Xray未运行")
+	}
+}
+
+func TestInboundChangeRejectedByXrayTest(t *testing.T) {
+	dir := setupTestDB(t)
+	failTests := installStubXray(t, dir)
+	existing := createTestInbound(t, &database.InboundConfig{
+		Protocol: "vless",
+		Tag:      "in-8",
+		Port:     8,
+		Enable:   true,
+		Settings: `{"decryption":"none"}`,
+	})
+	startStubXray(t)
+
+	xrayLock.Lock()
+	runningConfig := xrayProcess.GetConfig()
+	xrayLock.Unlock()
+	configFile, err := os.ReadFile(config.GetXrayConfigPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	failTests()
+
+	// assertUnchanged 检查运行中的Xray仍在使用原来的配置和配置文件
+	assertUnchanged := func() {
+		t.Helper()
+		xrayService := XrayService{}
+		if !xrayService.IsXrayRunning() {
+			t.Error("配置检查失败后Xray不应停止")
+		}
+		xrayLock.Lock()
+		current := xrayProcess.GetConfig()
+		xrayLock.Unlock()
+		if current != runningConfig {
+			t.Error("配置检查失败后运行中的配置不应改变")
+		}
+		data, err := os.ReadFile(config.GetXrayConfigPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, configFile) {
+			t.Error("配置检查失败后配置文件不应改变")
+		}
+	}
+	// assertTestError 检查返回的是整理后的Xray错误原因
+	assertTestError := func(err error) {
+		t.Helper()
+		if err == nil {
+			t.Fatal("配置检查失败时应返回错误")
+		}
+		want := "xray配置检查未通过: failed to build inbound config with tag in-9 > port 9 is reserved"
+		if err.Error() != want {
+			t.Errorf("错误为 %q，期望 %q", err, want)
+		}
+	}
+
+	inboundService := InboundService{}
+	err = inboundService.AddInbound(&database.InboundConfig{
+		Protocol: "vless",
+		Tag:      "in-9",
+		Port:     9,
+		Enable:   true,
+		Settings: `{"decryption":"none"}`,
+	})
+	assertTestError(err)
+	var count int64
+	database.GetDB().Model(&database.InboundConfig{}).Count(&count)
+	if count != 1 {
+		t.Errorf("入站数量为 %d，添加失败时不应写入数据库", count)
+	}
+	assertUnchanged()
+
+	err = inboundService.UpdateInbound(&database.InboundConfig{
+		Model:    existing.Model,
+		Protocol: "vless",
+		Tag:      "in-9",
+		Port:     9,
+		Enable:   true,
+		Settings: `{"decryption":"none"}`,
+	})
+	assertTestError(err)
+	saved, err := inboundService.GetInbound(existing.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Port != 8 || saved.Tag != "in-8" {
+		t.Errorf("入站为 %s:%d，更新失败时不应写入数据库", saved.Tag, saved.Port)
+	}
+	assertUnchanged()
+}
+
+func TestImportRejectedByXrayTest(t *testing.T) {
+	dir := setupTestDB(t)
+	failTests := installStubXray(t, dir)
+	existing := createTestInbound(t, &database.InboundConfig{
+		Protocol: "vless",
+		Tag:      "in-8",
+		Port:     8,
+		Enable:   true,
+		Settings: `{"decryption":"none"}`,
+	})
+	bundle := &ExportBundle{
+		Version: exportBundleVersion,
+		Inbounds: []*ExportInbound{{
+			Protocol: "vless",
+			Tag:      "in-9",
+			Port:     9,
+			Enable:   true,
+			Settings: `{"decryption":"none"}`,
+			Clients: []*ExportClient{{
+				Email:  "a@example.com",
+				UUID:   "11111111-1111-1111-1111-111111111111",
+				Enable: true,
+			}},
+		}},
+	}
+	inboundService := InboundService{}
+
+	// 试运行也检查配置，新入站的客户端写入检查的配置中
+	_, err := inboundService.ImportInbounds(bundle, &ImportOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "tested.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tested := &xray.Config{}
+	err = json.Unmarshal(data, tested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imported *xray.InboundConfig
+	for i := range tested.InboundConfigs {
+		if tested.InboundConfigs[i].Tag == "in-9" {
+			imported = &tested.InboundConfigs[i]
+		}
+	}
+	if imported == nil {
+		t.Fatal("检查的配置中没有导入的入站")
+	}
+	if !strings.Contains(string(imported.Settings), "a@example.com") {
+		t.Errorf("导入入站的客户端未写入检查的配置: %s", imported.Settings)
+	}
+
+	failTests()
+	_, err = inboundService.ImportInbounds(bundle, &ImportOptions{})
+	want := "xray配置检查未通过: failed to build inbound config with tag in-9 > port 9 is reserved"
+	if err == nil || err.Error() != want {
+		t.Fatalf("错误为 %v，期望 %q", err, want)
+	}
+	inbounds, err := inboundService.GetInbounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbounds) != 1 || inbounds[0].ID != existing.ID {
+		t.Errorf("入站数量为 %d，配置检查失败时不应写入数据库", len(inbounds))
+	}
+	var count int64
+	database.GetDB().Model(&database.ClientConfig{}).Count(&count)
+	if count != 0 {
+		t.Errorf("客户端数量为 %d，配置检查失败时不应写入数据库", count)
+	}
+}
+
+// fakeHandlerService 模拟Xray的HandlerService，记录收到的增删用户操作，err不为nil时返回该错误
+type fakeHandlerService struct {
+	command.UnimplementedHandlerServiceServer
+
+	lock sync.Mutex
+	ops  []string
+	err  error
+}
+
+func (f *fakeHandlerService) AlterInbound(ctx context.Context, req *command.AlterInboundRequest) (*command.AlterInboundResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	operation, err := req.GetOperation().GetInstance()
+	if err != nil {
+		return nil, err
+	}
+	switch op := operation.(type) {
+	case *command.AddUserOperation:
+		account, err := op.GetUser().GetAccount().GetInstance()
+		if err != nil {
+			return nil, err
+		}
+		f.ops = append(f.ops, fmt.Sprintf("add %s %s %s", req.GetTag(), op.GetUser().GetEmail(), account.(*vless.Account).GetId()))
+	case *command.RemoveUserOperation:
+		f.ops = append(f.ops, fmt.Sprintf("remove %s %s", req.GetTag(), op.GetEmail()))
+	default:
+		return nil, fmt.Errorf("未知操作 %T", operation)
+	}
+	return &command.AlterInboundResponse{}, nil
+}
+
+// takeOps 返回并清空记录的操作
+func (f *fakeHandlerService) takeOps() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	ops := f.ops
+	f.ops = nil
+	return ops
+}
+
+func (f *fakeHandlerService) setErr(err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.err = err
+}
+
+// startFakeXrayAPI 在本机随机端口启动模拟的Xray API，并设置为面板使用的API端口
+func startFakeXrayAPI(t *testing.T) *fakeHandlerService {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	settingService := SettingService{}
+	err = settingService.SetXrayAPIPort(listener.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &fakeHandlerService{}
+	server := grpc.NewServer()
+	command.RegisterHandlerServiceServer(server, handler)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return handler
+}
+
+func TestXrayAPIPortConflicts(t *testing.T) {
+	setupTestDB(t)
+	createTestInbound(t, &database.InboundConfig{Protocol: "vless", Tag: "in-8", Port: 8, Enable: true})
+	settingService := SettingService{}
+	err := settingService.SetSubPort(2097)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webPort, err := settingService.GetPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for port, want := range map[int]string{
+		8:       "端口已被入站使用",
+		webPort: "端口已被面板使用",
+		2097:    "端口已被订阅服务器使用",
+	} {
+		err = settingService.SetXrayAPIPort(port)
+		if err == nil || err.Error() != want {
+			t.Errorf("API端口设为 %d 时错误为 %v，期望 %q", port, err, want)
+		}
+	}
+	err = settingService.SetXrayAPIPort(10086)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 导入时API端口和标签视为已被占用，且不能被覆盖
+	bundle := &ExportBundle{
+		Version: exportBundleVersion,
+		Inbounds: []*ExportInbound{
+			{Protocol: "vless", Tag: "in-api-port", Port: 10086, Remark: "port"},
+			{Protocol: "vless", Tag: xray.APITag, Port: 10087, Remark: "tag"},
+		},
+	}
+	inboundService := InboundService{}
+	tests := []struct {
+		strategy string
+		port     string
+		tag      string
+	}{
+		{ConflictSkip, "端口 10086 已被使用", "标签 api 已被使用"},
+		{ConflictOverwrite, "端口 10086 已被Xray内部API使用", "标签 api 已被Xray内部API使用"},
+		{ConflictRenumber, "端口 10086 改为 10087", "标签 api 改为 api-2"},
+	}
+	for _, test := range tests {
+		report, err := inboundService.ImportInbounds(bundle, &ImportOptions{
+			PortStrategy: test.strategy,
+			TagStrategy:  test.strategy,
+			DryRun:       true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range map[string]string{"vless:10086 port": test.port, "vless:10087 tag": test.tag} {
+			item := findImportItem(t, report.Inbounds, name)
+			if !strings.Contains(item.Message, want) {
+				t.Errorf("策略 %s 时 %s 的结果为 %s %q，期望包含 %q", test.strategy, name, item.Action, item.Message, want)
+			}
+		}
+	}
+}
+
+// runningXray 返回当前的Xray进程
+func runningXray() *xray.Process {
+	xrayLock.Lock()
+	defer xrayLock.Unlock()
+	return xrayProcess
+}
+
+func TestApplyConfigHotUpdatesUsers(t *testing.T) {
+	dir := setupTestDB(t)
+	installStubXray(t, dir)
+	handler := startFakeXrayAPI(t)
+	inbound := createTestInbound(t, &database.InboundConfig{
+		Protocol: "vless",
+		Tag:      "in-8",
+		Port:     8,
+		Enable:   true,
+		Settings: `{"decryption":"none"}`,
+	})
+	clientA := createTestClient(t, &database.ClientConfig{
+		InboundID: inbound.ID,
+		Email:     "a@example.com",
+		UUID:      "11111111-1111-1111-1111-111111111111",
+		Enable:    true,
+	})
+	startStubXray(t)
+	process := runningXray()
+
+	// 删除a并添加b，只有用户变化，通过API热更新
+	err := database.GetDB().Delete(clientA).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTestClient(t, &database.ClientConfig{
+		InboundID: inbound.ID,
+		Email:     "b@example.com",
+		UUID:      "22222222-2222-2222-2222-222222222222",
+		Enable:    true,
+	})
+	xrayService := XrayService{}
+	err = xrayService.ApplyConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"remove in-8 a@example.com",
+		"add in-8 b@example.com 22222222-2222-2222-2222-222222222222",
+	}
+	if ops := handler.takeOps(); !slices.Equal(ops, want) {
+		t.Errorf("API操作为 %q，期望 %q", ops, want)
+	}
+	if runningXray() != process {
+		t.Error("热更新用户时不应重启Xray")
+	}
+	expected, err := xrayService.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !process.GetConfig().Equals(expected) {
+		t.Error("热更新后进程记录的配置应为新配置")
+	}
+
+	// API返回错误时回退为重启Xray
+	handler.setErr(errors.New("inbound not found"))
+	createTestClient(t, &database.ClientConfig{
+		InboundID: inbound.ID,
+		Email:     "c@example.com",
+		UUID:      "33333333-3333-3333-3333-333333333333",
+		Enable:    true,
+	})
+	err = xrayService.ApplyConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ops := handler.takeOps(); len(ops) != 0 {
+		t.Errorf("API出错时不应记录操作，实际为 %q", ops)
+	}
+	restarted := runningXray()
+	if restarted == process {
+		t.Fatal("API出错时应重启Xray")
+	}
+	if !xrayService.IsXrayRunning() {
+		t.Error("重启后Xray应在运行")
+	}
+	expected, err = xrayService.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.GetConfig().Equals(expected) {
+		t.Error("重启后进程应使用新配置")
+	}
+}
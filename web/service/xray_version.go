@@ -0,0 +1,364 @@
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mx-ui/config"
+	"mx-ui/logger"
+	"mx-ui/xray"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 上传或下载的Xray压缩包大小上限
+const maxXrayArchiveSize = 200 << 20
+
+// 下载Xray压缩包的超时时间
+const xrayDownloadTimeout = 10 * time.Minute
+
+// 切换二进制文件并重启后，Xray需要保持运行的时间，测试中缩短
+var xrayStartupCheckDelay = 2 * time.Second
+
+var xrayVersionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+var xrayInstallLock sync.Mutex
+
+// 各系统和架构对应的Xray发布文件名
+var xrayReleaseAssets = map[string]string{
+	"linux/amd64":   "Xray-linux-64",
+	"linux/386":     "Xray-linux-32",
+	"linux/arm64":   "Xray-linux-arm64-v8a",
+	"linux/arm":     "Xray-linux-arm32-v7a",
+	"linux/riscv64": "Xray-linux-riscv64",
+	"linux/s390x":   "Xray-linux-s390x",
+	"darwin/amd64":  "Xray-macos-64",
+	"darwin/arm64":  "Xray-macos-arm64-v8a",
+	"freebsd/amd64": "Xray-freebsd-64",
+	"windows/amd64": "Xray-windows-64",
+	"windows/386":   "Xray-windows-32",
+	"windows/arm64": "Xray-windows-arm64-v8a",
+}
+
+// XrayVersionInfo 当前使用的和可以回滚到的Xray版本，文件不存在时为空
+type XrayVersionInfo struct {
+	Version         string `json:"version"`
+	PreviousVersion string `json:"previousVersion"`
+}
+
+// XrayVersionService Xray版本管理相关服务
+type XrayVersionService struct{}
+
+// GetVersionInfo 获取当前和上一个Xray版本
+func (s *XrayVersionService) GetVersionInfo() *XrayVersionInfo {
+	info := &XrayVersionInfo{}
+	info.Version, _ = xray.GetVersion()
+	if _, err := os.Stat(previousBinaryPath()); err == nil {
+		info.PreviousVersion, _ = xray.BinaryVersion(previousBinaryPath())
+	}
+	return info
+}
+
+// InstallFromArchive 从上传的zip压缩包安装Xray，checksum不为空时校验压缩包的SHA256，返回安装的版本
+func (s *XrayVersionService) InstallFromArchive(reader io.Reader, checksum string) (string, error) {
+	archivePath, sum, err := saveXrayArchive(reader)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(archivePath)
+
+	if checksum != "" {
+		err = verifyChecksum(sum, checksum)
+		if err != nil {
+			return "", err
+		}
+	}
+	return s.install(archivePath)
+}
+
+// InstallFromMirror 从设置的下载地址安装指定版本的Xray，返回安装的版本。
+// checksum为空时从压缩包地址加 .dgst 的摘要文件中读取SHA256，两者都没有时拒绝安装
+func (s *XrayVersionService) InstallFromMirror(version string, checksum string) (string, error) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if !xrayVersionPattern.MatchString(version) {
+		return "", errors.New("版本号格式无效，例如 1.8.24")
+	}
+	asset, ok := xrayReleaseAssets[runtime.GOOS+"/"+runtime.GOARCH]
+	if !ok {
+		return "", fmt.Errorf("没有适用于 %s/%s 的Xray发布文件", runtime.GOOS, runtime.GOARCH)
+	}
+	settingService := SettingService{}
+	mirror, err := settingService.GetXrayMirrorURL()
+	if err != nil {
+		return "", err
+	}
+	url := strings.NewReplacer("{version}", version, "{asset}", asset).Replace(mirror)
+
+	ctx, cancel := context.WithTimeout(context.Background(), xrayDownloadTimeout)
+	defer cancel()
+	if checksum == "" {
+		checksum, err = fetchChecksum(ctx, url+".dgst")
+		if err != nil {
+			return "", fmt.Errorf("获取SHA256摘要失败: %v", err)
+		}
+	}
+
+	logger.Info("下载Xray:", url)
+	body, err := httpGet(ctx, url)
+	if err != nil {
+		return "", fmt.Errorf("下载Xray失败: %v", err)
+	}
+	defer body.Close()
+	archivePath, sum, err := saveXrayArchive(body)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(archivePath)
+
+	err = verifyChecksum(sum, checksum)
+	if err != nil {
+		return "", err
+	}
+	return s.install(archivePath)
+}
+
+// Rollback 切换回安装前的Xray，当前的版本保留为下一次回滚的目标，返回切换后的版本。
+// Xray运行中时重启它，启动失败则换回当前的版本
+func (s *XrayVersionService) Rollback() (string, error) {
+	xrayInstallLock.Lock()
+	defer xrayInstallLock.Unlock()
+
+	binaryPath := config.GetXrayBinaryPath()
+	previousPath := previousBinaryPath()
+	if _, err := os.Stat(previousPath); err != nil {
+		return "", errors.New("没有可以回滚的Xray版本")
+	}
+	err := swapBinary(binaryPath, previousPath)
+	if err != nil {
+		return "", err
+	}
+	err = restartAfterSwitch()
+	if err != nil {
+		logger.Warning("回滚后的Xray启动失败，换回当前的版本:", err)
+		restoreErr := swapBinary(binaryPath, previousPath)
+		if restoreErr != nil {
+			return "", fmt.Errorf("回滚后的Xray启动失败，换回当前的版本失败: %v", restoreErr)
+		}
+		xrayService := XrayService{}
+		xrayService.RestartXray(true)
+		return "", fmt.Errorf("回滚后的Xray启动失败，已换回当前的版本: %v", err)
+	}
+	return xray.GetVersion()
+}
+
+// install 从zip压缩包中取出xray并替换当前的二进制文件，原文件保留用于回滚。
+// Xray运行中时重启它，启动失败则恢复原文件
+func (s *XrayVersionService) install(archivePath string) (string, error) {
+	xrayInstallLock.Lock()
+	defer xrayInstallLock.Unlock()
+
+	binaryPath := config.GetXrayBinaryPath()
+	newPath := binaryPath + ".new"
+	err := extractXrayBinary(archivePath, newPath)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(newPath)
+	version, err := xray.BinaryVersion(newPath)
+	if err != nil {
+		return "", fmt.Errorf("新的Xray无法运行: %v", err)
+	}
+
+	previousPath := previousBinaryPath()
+	if _, err := os.Stat(binaryPath); err == nil {
+		err = os.Rename(binaryPath, previousPath)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = os.Rename(newPath, binaryPath)
+	if err != nil {
+		return "", err
+	}
+	logger.Info("已安装Xray", version)
+
+	err = restartAfterSwitch()
+	if err != nil {
+		if _, statErr := os.Stat(previousPath); statErr != nil {
+			return "", fmt.Errorf("Xray %s 启动失败: %v", version, err)
+		}
+		// 丢弃无法启动的新版本，避免之后回滚到它
+		logger.Warning("新版本Xray启动失败，恢复原来的版本:", err)
+		os.Remove(binaryPath)
+		restoreErr := os.Rename(previousPath, binaryPath)
+		if restoreErr != nil {
+			return "", fmt.Errorf("Xray %s 启动失败，恢复原来的版本失败: %v", version, restoreErr)
+		}
+		xrayService := XrayService{}
+		xrayService.RestartXray(true)
+		return "", fmt.Errorf("Xray %s 启动失败，已恢复原来的版本: %v", version, err)
+	}
+	return version, nil
+}
+
+// restartAfterSwitch 切换二进制文件后，Xray运行中时重启它，重启后立即退出也视为失败
+func restartAfterSwitch() error {
+	xrayService := XrayService{}
+	if !xrayService.IsXrayRunning() {
+		return nil
+	}
+	err := xrayService.RestartXray(true)
+	if err != nil {
+		return err
+	}
+	time.Sleep(xrayStartupCheckDelay)
+	if !xrayService.IsXrayRunning() {
+		result := strings.TrimSpace(xrayService.GetXrayResult())
+		if result == "" && xrayService.GetXrayErr() != nil {
+			result = xrayService.GetXrayErr().Error()
+		}
+		return fmt.Errorf("Xray启动后退出: %s", result)
+	}
+	return nil
+}
+
+// previousBinaryPath 安装新版本前保留的Xray二进制文件路径
+func previousBinaryPath() string {
+	return config.GetXrayBinaryPath() + ".bak"
+}
+
+// swapBinary 交换两个二进制文件
+func swapBinary(a string, b string) error {
+	tmp := a + ".swap"
+	err := os.Rename(a, tmp)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(b, a)
+	if err != nil {
+		os.Rename(tmp, a)
+		return err
+	}
+	return os.Rename(tmp, b)
+}
+
+// saveXrayArchive 将压缩包保存到临时目录，返回文件路径和SHA256
+func saveXrayArchive(reader io.Reader) (string, string, error) {
+	err := os.MkdirAll(config.GetTempPath(), 0755)
+	if err != nil {
+		return "", "", err
+	}
+	file, err := os.CreateTemp(config.GetTempPath(), "xray-*.zip")
+	if err != nil {
+		return "", "", err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(reader, maxXrayArchiveSize+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > maxXrayArchiveSize {
+		err = fmt.Errorf("压缩包超过 %d MB", maxXrayArchiveSize>>20)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", "", err
+	}
+	return file.Name(), hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// verifyChecksum 比较SHA256
+func verifyChecksum(actual string, expected string) error {
+	expected = strings.ToLower(strings.TrimSpace(expected))
+	if actual != expected {
+		return fmt.Errorf("SHA256校验失败: 期望 %s，实际 %s", expected, actual)
+	}
+	return nil
+}
+
+// extractXrayBinary 从zip压缩包中取出xray可执行文件，写入dst
+func extractXrayBinary(archivePath string, dst string) error {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("不是有效的zip压缩包: %v", err)
+	}
+	defer reader.Close()
+
+	name := "xray"
+	if runtime.GOOS == "windows" {
+		name = "xray.exe"
+	}
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() || filepath.Base(file.Name) != name {
+			continue
+		}
+		src, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		err = os.MkdirAll(filepath.Dir(dst), 0755)
+		if err != nil {
+			return err
+		}
+		out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, io.LimitReader(src, maxXrayArchiveSize))
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	return fmt.Errorf("压缩包中没有 %s", name)
+}
+
+// fetchChecksum 下载Xray发布的 .dgst 摘要文件，读取其中的 SHA2-256
+func fetchChecksum(ctx context.Context, url string) (string, error) {
+	body, err := httpGet(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	scanner := bufio.NewScanner(io.LimitReader(body, 64<<10))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok && strings.TrimSpace(key) == "SHA2-256" {
+			return strings.TrimSpace(value), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("摘要文件中没有SHA2-256")
+}
+
+// httpGet 发送GET请求，状态码不是200时返回错误
+func httpGet(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s 返回状态码 %d", url, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"mx-ui/config"
+	"mx-ui/xray"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// versionedXrayScript 模拟指定版本的Xray二进制文件，broken为true时 run 启动后立即退出
+func versionedXrayScript(version string, broken bool) []byte {
+	run := "exec sleep 60"
+	if broken {
+		run = `echo "Failed to start: main: failed to create server > app/router: not enough memory" >&2
+exit 23`
+	}
+	return []byte(`#!/bin/sh
+if [ "$1" = "version" ]; then
+	echo "Xray ` + version + ` (Xray, Penetrates Everything.) Custom (go1.25 linux/amd64)"
+	exit 0
+fi
+if [ "$2" = "-test" ]; then
+	exit 0
+fi
+` + run + "\n")
+}
+
+// zipXray 把Xray二进制文件打包成发布文件的格式，返回压缩包和其SHA256
+func zipXray(t *testing.T, binary []byte) ([]byte, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, content := range map[string][]byte{"README.md": []byte("xray"), "xray": binary} {
+		file, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = file.Write(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return buf.Bytes(), hex.EncodeToString(sum[:])
+}
+
+// fakeXrayMirror 模拟Xray的发布下载地址，files的键为请求路径
+func fakeXrayMirror(t *testing.T, files map[string][]byte) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+
+	settingService := SettingService{}
+	err := settingService.SetXrayMirrorURL(server.URL + "/v{version}/{asset}.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// binaryVersionAt 获取path处Xray二进制文件的版本
+func binaryVersionAt(t *testing.T, path string) string {
+	t.Helper()
+	version, err := xray.BinaryVersion(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestInstallFromMirrorAndRollback(t *testing.T) {
+	asset, ok := xrayReleaseAssets[runtime.GOOS+"/"+runtime.GOARCH]
+	if !ok || runtime.GOOS == "windows" {
+		t.Skip("没有适用于当前系统的Xray发布文件")
+	}
+	setupTestDB(t)
+	checkDelay := xrayStartupCheckDelay
+	xrayStartupCheckDelay = 300 * time.Millisecond
+	t.Cleanup(func() {
+		xrayStartupCheckDelay = checkDelay
+	})
+
+	binaryPath := config.GetXrayBinaryPath()
+	err := os.MkdirAll(config.GetBinFolderPath(), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(binaryPath, versionedXrayScript("1.8.1", false), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startStubXray(t)
+
+	goodArchive, goodSum := zipXray(t, versionedXrayScript("1.12.0", false))
+	badArchive, _ := zipXray(t, versionedXrayScript("1.13.0", false))
+	fakeXrayMirror(t, map[string][]byte{
+		"/v1.12.0/" + asset + ".zip":      goodArchive,
+		"/v1.12.0/" + asset + ".zip.dgst": []byte("MD5= 00\nSHA2-256= " + goodSum + "\n"),
+		"/v1.13.0/" + asset + ".zip":      badArchive,
+		"/v1.13.0/" + asset + ".zip.dgst": []byte("SHA2-256= " + strings.Repeat("0", 64) + "\n"),
+	})
+	xrayService := XrayService{}
+	versionService := XrayVersionService{}
+
+	// 摘要文件中的SHA256正确时安装并重启，原来的版本保留用于回滚
+	version, err := versionService.InstallFromMirror("v1.12.0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "1.12.0" || binaryVersionAt(t, binaryPath) != "1.12.0" {
+		t.Errorf("安装的版本为 %s，期望 1.12.0", version)
+	}
+	if got := binaryVersionAt(t, previousBinaryPath()); got != "1.8.1" {
+		t.Errorf("保留的版本为 %s，期望 1.8.1", got)
+	}
+	if !xrayService.IsXrayRunning() {
+		t.Error("安装后Xray应在运行")
+	}
+
+	// SHA256不匹配时拒绝安装，不改动当前的二进制文件
+	_, err = versionService.InstallFromMirror("1.13.0", "")
+	if err == nil || !strings.Contains(err.Error(), "SHA256校验失败") {
+		t.Fatalf("SHA256不匹配时应返回校验失败，实际为 %v", err)
+	}
+	if got := binaryVersionAt(t, binaryPath); got != "1.12.0" {
+		t.Errorf("校验失败后当前版本为 %s，期望 1.12.0", got)
+	}
+	if got := binaryVersionAt(t, previousBinaryPath()); got != "1.8.1" {
+		t.Errorf("校验失败后保留的版本为 %s，期望 1.8.1", got)
+	}
+
+	// 回滚后两个版本互换
+	version, err = versionService.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "1.8.1" {
+		t.Errorf("回滚后的版本为 %s，期望 1.8.1", version)
+	}
+	if got := binaryVersionAt(t, previousBinaryPath()); got != "1.12.0" {
+		t.Errorf("回滚后保留的版本为 %s，期望 1.12.0", got)
+	}
+	if !xrayService.IsXrayRunning() {
+		t.Error("回滚后Xray应在运行")
+	}
+
+	// 回滚目标无法启动时换回当前的版本并重新启动
+	err = os.WriteFile(previousBinaryPath(), versionedXrayScript("25.1.100", true), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = versionService.Rollback()
+	if err == nil || !strings.Contains(err.Error(), "已换回当前的版本") {
+		t.Fatalf("回滚目标无法启动时应换回当前版本，实际为 %v", err)
+	}
+	if got := binaryVersionAt(t, binaryPath); got != "1.8.1" {
+		t.Errorf("回滚失败后当前版本为 %s，期望 1.8.1", got)
+	}
+	if got := binaryVersionAt(t, previousBinaryPath()); got != "25.1.100" {
+		t.Errorf("回滚失败后保留的版本为 %s，期望 25.1.100", got)
+	}
+	if !xrayService.IsXrayRunning() {
+		t.Error("回滚失败后Xray应使用当前的版本重新运行")
+	}
+}
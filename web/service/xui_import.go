@@ -0,0 +1,277 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// xuiInbound x-ui / 3x-ui 数据库中的入站记录
+type xuiInbound struct {
+	ID             int
+	Up             int64
+	Down           int64
+	Total          int64
+	Remark         string
+	Enable         bool
+	ExpiryTime     int64
+	Listen         string
+	Port           int
+	Protocol       string
+	Settings       string
+	StreamSettings string
+	Tag            string
+	Sniffing       string
+}
+
+// xuiClientTraffic 3x-ui 数据库中的客户端流量记录
+type xuiClientTraffic struct {
+	InboundID  int
+	Enable     bool
+	Email      string
+	Up         int64
+	Down       int64
+	ExpiryTime int64
+	Total      int64
+}
+
+// xuiClient x-ui / 3x-ui 入站设置中的客户端
+type xuiClient struct {
+	ID         string          `json:"id"`
+	Password   string          `json:"password"`
+	Email      string          `json:"email"`
+	Enable     *bool           `json:"enable"`
+	ExpiryTime int64           `json:"expiryTime"`
+	TotalGB    int64           `json:"totalGB"`
+	SubID      string          `json:"subId"`
+	Comment    string          `json:"comment"`
+	Flow       string          `json:"flow"`
+	LimitIP    int             `json:"limitIp"`
+	TgID       json.RawMessage `json:"tgId"`
+	Reset      int             `json:"reset"`
+}
+
+// XUIImportReport x-ui导入报告
+type XUIImportReport struct {
+	Import   *ImportReport `json:"import"`
+	Unmapped []string      `json:"unmapped"`
+}
+
+// ImportFromXUI 读取x-ui或3x-ui数据库中的入站和客户端流量并导入
+func (s *InboundService) ImportFromXUI(dbPath string, options *ImportOptions) (*XUIImportReport, error) {
+	bundle, unmapped, err := s.ReadXUIDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	report, err := s.ImportInbounds(bundle, options)
+	if err != nil {
+		return nil, err
+	}
+	return &XUIImportReport{
+		Import:   report,
+		Unmapped: unmapped,
+	}, nil
+}
+
+// ReadXUIDatabase 将x-ui或3x-ui数据库转换为导出数据，同时返回无法映射的内容
+func (s *InboundService) ReadXUIDatabase(dbPath string) (*ExportBundle, []string, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, nil, err
+	}
+	xuiDB, err := gorm.Open(sqlite.Open("file:"+dbPath+"?mode=ro"), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sqlDB, err := xuiDB.DB()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer sqlDB.Close()
+
+	if !xuiDB.Migrator().HasTable("inbounds") {
+		return nil, nil, errors.New("不是x-ui数据库：缺少inbounds表")
+	}
+	var inbounds []xuiInbound
+	err = xuiDB.Table("inbounds").Order("id ASC").Find(&inbounds).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 3x-ui 将客户端流量保存在 client_traffics 表中，x-ui 只有入站级流量
+	traffics := map[string]*xuiClientTraffic{}
+	hasClientTraffics := xuiDB.Migrator().HasTable("client_traffics")
+	if hasClientTraffics {
+		var rows []*xuiClientTraffic
+		err = xuiDB.Table("client_traffics").Find(&rows).Error
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, row := range rows {
+			traffics[row.Email] = row
+		}
+	}
+
+	bundle := &ExportBundle{
+		Version:  exportBundleVersion,
+		Source:   "x-ui",
+		Inbounds: []*ExportInbound{},
+	}
+	if hasClientTraffics {
+		bundle.Source = "3x-ui"
+	}
+
+	var unmapped []string
+	for _, inbound := range inbounds {
+		exportInbound, notes, err := s.mapXUIInbound(&inbound, traffics, hasClientTraffics)
+		name := fmt.Sprintf("入站 %d(%s:%d)", inbound.ID, inbound.Protocol, inbound.Port)
+		if err != nil {
+			unmapped = append(unmapped, fmt.Sprintf("%s: 已跳过，%v", name, err))
+			continue
+		}
+		for _, note := range notes {
+			unmapped = append(unmapped, fmt.Sprintf("%s: %s", name, note))
+		}
+		bundle.Inbounds = append(bundle.Inbounds, exportInbound)
+	}
+	return bundle, unmapped, nil
+}
+
+// mapXUIInbound 将单个x-ui入站映射为导出入站，返回无法映射的说明
+func (s *InboundService) mapXUIInbound(inbound *xuiInbound, traffics map[string]*xuiClientTraffic, hasClientTraffics bool) (*ExportInbound, []string, error) {
+	var notes []string
+
+	settings := map[string]json.RawMessage{}
+	if inbound.Settings != "" {
+		err := json.Unmarshal([]byte(inbound.Settings), &settings)
+		if err != nil {
+			return nil, nil, fmt.Errorf("settings不是有效的JSON: %v", err)
+		}
+	}
+	var xuiClients []xuiClient
+	if raw, ok := settings["clients"]; ok {
+		err := json.Unmarshal(raw, &xuiClients)
+		if err != nil {
+			return nil, nil, fmt.Errorf("clients无法解析: %v", err)
+		}
+		// 客户端由面板单独管理，生成配置时再写回
+		delete(settings, "clients")
+	}
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if inbound.Listen != "" && inbound.Listen != "0.0.0.0" && inbound.Listen != "::" {
+		notes = append(notes, fmt.Sprintf("监听地址 %s 未导入", inbound.Listen))
+	}
+	if inbound.Sniffing != "" && inbound.Sniffing != "{}" {
+		notes = append(notes, "sniffing设置未导入")
+	}
+
+	streamSettings, err := mapXUIStreamSettings(inbound.StreamSettings)
+	if err != nil {
+		return nil, nil, fmt.Errorf("streamSettings不是有效的JSON: %v", err)
+	}
+
+	exportInbound := &ExportInbound{
+		Protocol:       inbound.Protocol,
+		Tag:            inbound.Tag,
+		Port:           inbound.Port,
+		Enable:         inbound.Enable,
+		Settings:       string(settingsJSON),
+		StreamSettings: streamSettings,
+		Remark:         inbound.Remark,
+		Clients:        []*ExportClient{},
+	}
+
+	for i, xc := range xuiClients {
+		client := &ExportClient{
+			Email:      xc.Email,
+			UUID:       xc.ID,
+			Enable:     xc.Enable == nil || *xc.Enable,
+			ExpiryTime: xc.ExpiryTime,
+			Limit:      xc.TotalGB,
+			SubID:      xc.SubID,
+			Remark:     xc.Comment,
+			LimitIP:    xc.LimitIP,
+		}
+		if client.UUID == "" {
+			client.UUID = xc.Password
+		}
+		if client.Email == "" {
+			client.Email = fmt.Sprintf("%s-%d-%d", inbound.Protocol, inbound.Port, i+1)
+			notes = append(notes, fmt.Sprintf("第%d个客户端没有邮箱，已命名为 %s", i+1, client.Email))
+		}
+		if client.ExpiryTime < 0 {
+			// 3x-ui 用负数表示首次使用后开始计时的天数
+			notes = append(notes, fmt.Sprintf("客户端 %s 的首次使用后计时到期(%d天)无法映射，已设为永不过期",
+				client.Email, -client.ExpiryTime/86400000))
+			client.ExpiryTime = 0
+		}
+		if traffic := traffics[xc.Email]; traffic != nil {
+			client.Used = traffic.Up + traffic.Down
+			if !traffic.Enable {
+				client.Enable = false
+			}
+		}
+		if xc.Flow != "" {
+			notes = append(notes, fmt.Sprintf("客户端 %s 的flow(%s)未导入", client.Email, xc.Flow))
+		}
+		if len(xc.TgID) > 0 && string(xc.TgID) != `""` && string(xc.TgID) != "0" {
+			notes = append(notes, fmt.Sprintf("客户端 %s 的Telegram ID未导入", client.Email))
+		}
+		if xc.Reset > 0 {
+			notes = append(notes, fmt.Sprintf("客户端 %s 的流量自动重置(%d天)未导入", client.Email, xc.Reset))
+		}
+		exportInbound.Clients = append(exportInbound.Clients, client)
+	}
+
+	// x-ui 的流量、限额和到期时间记录在入站上
+	if !hasClientTraffics && (inbound.Up+inbound.Down > 0 || inbound.Total > 0 || inbound.ExpiryTime > 0) {
+		if len(exportInbound.Clients) == 1 {
+			client := exportInbound.Clients[0]
+			client.Used = inbound.Up + inbound.Down
+			client.Limit = inbound.Total
+			client.ExpiryTime = inbound.ExpiryTime
+		} else {
+			notes = append(notes, "入站级的流量、限额和到期时间无法分配到客户端")
+		}
+	}
+	return exportInbound, notes, nil
+}
+
+// mapXUIStreamSettings 3x-ui 把REALITY客户端使用的publicKey、fingerprint和spiderX放在
+// realitySettings.settings中，移到realitySettings上，生成分享链接时使用
+func mapXUIStreamSettings(streamSettings string) (string, error) {
+	if streamSettings == "" {
+		return "", nil
+	}
+	stream := map[string]interface{}{}
+	err := json.Unmarshal([]byte(streamSettings), &stream)
+	if err != nil {
+		return "", err
+	}
+	reality := jsonMap(stream, "realitySettings")
+	clientSettings := jsonMap(reality, "settings")
+	if clientSettings == nil {
+		return streamSettings, nil
+	}
+	for _, key := range []string{"publicKey", "fingerprint", "spiderX"} {
+		if value := jsonString(clientSettings, key); value != "" && jsonString(reality, key) == "" {
+			reality[key] = value
+		}
+	}
+	delete(reality, "settings")
+	data, err := json.Marshal(stream)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"mx-ui/database"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// createXUIDatabase 创建一个3x-ui数据库：入站1与面板已有的端口冲突，包含一个邮箱冲突的客户端；
+// withTraffics为false时不创建client_traffics表，即旧版x-ui的数据库
+func createXUIDatabase(t *testing.T, withTraffics bool) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "x-ui.db")
+	xuiDB, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := xuiDB.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	statements := []string{
+		`CREATE TABLE inbounds (id INTEGER PRIMARY KEY, user_id INTEGER, up INTEGER, down INTEGER, total INTEGER,
+			remark TEXT, enable NUMERIC, expiry_time INTEGER, listen TEXT, port INTEGER, protocol TEXT,
+			settings TEXT, stream_settings TEXT, tag TEXT, sniffing TEXT)`,
+		`INSERT INTO inbounds VALUES (1, 1, 0, 0, 0, 'hk', 1, 0, '127.0.0.1', 443, 'vless',
+			'{"decryption":"none","clients":[{"id":"0b4c7a1e-8f5d-4d2a-9c3e-1f2a3b4c5d6e","email":"alice","flow":"xtls-rprx-vision","totalGB":1073741824,"subId":"alicesub"},{"id":"5e6f7a8b-9c0d-4e1f-8a2b-3c4d5e6f7a8b","email":"bob","enable":false}]}',
+			'{"network":"tcp","security":"reality","realitySettings":{"show":false,"dest":"www.example.com:443","serverNames":["www.example.com"],"privateKey":"kEw1mb28BeXYbo_gC3euhi8cFZoIS_PMTC_tX8XuRWQ","shortIds":["6ba85179e30d4fc2"],"settings":{"publicKey":"mkl7bGbRmD30UYU1-2LWDrgB-lGJ01nWSINW6pStlFU","fingerprint":"firefox","serverName":"","spiderX":"/x"}}}',
+			'inbound-443', '{"enabled":true}')`,
+		`INSERT INTO inbounds VALUES (2, 1, 100, 200, 1000, 'jp', 1, 1700000000000, '', 8443, 'vmess',
+			'{"clients":[{"id":"9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d","email":"carol"}]}',
+			'{"network":"ws"}', 'inbound-8443', '{}')`,
+		`INSERT INTO inbounds VALUES (3, 1, 0, 0, 0, 'broken', 1, 0, '', 9443, 'trojan', 'not json', '', 'inbound-9443', '')`,
+	}
+	if withTraffics {
+		statements = append(statements,
+			`CREATE TABLE client_traffics (id INTEGER PRIMARY KEY, inbound_id INTEGER, enable NUMERIC, email TEXT,
+				up INTEGER, down INTEGER, expiry_time INTEGER, total INTEGER, reset INTEGER)`,
+			`INSERT INTO client_traffics VALUES (1, 1, 1, 'alice', 10, 20, 0, 1073741824, 0)`,
+			`INSERT INTO client_traffics VALUES (2, 2, 0, 'carol', 1, 2, 0, 0, 0)`,
+		)
+	}
+	for _, statement := range statements {
+		err = xuiDB.Exec(statement).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+// setupImportTarget 创建面板中已有的数据：端口443上的入站和邮箱为bob的客户端
+func setupImportTarget(t *testing.T) *database.InboundConfig {
+	t.Helper()
+	setupTestDB(t)
+	inbound := createTestInbound(t, &database.InboundConfig{Protocol: "vless", Port: 443, Tag: "existing", Enable: true, Remark: "existing"})
+	createTestClient(t, &database.ClientConfig{InboundID: inbound.ID, Email: "bob", UUID: "11111111-2222-4333-8444-555555555555", Enable: true, SubID: "bobsub"})
+	return inbound
+}
+
+// reportActions 将报告中的各项整理为 名称 -> 操作
+func reportActions(items []*ImportItem) map[string]string {
+	actions := map[string]string{}
+	for _, item := range items {
+		actions[item.Name] = item.Action
+	}
+	return actions
+}
+
+func findImportItem(t *testing.T, items []*ImportItem, name string) *ImportItem {
+	t.Helper()
+	for _, item := range items {
+		if item.Name == name {
+			return item
+		}
+	}
+	t.Fatalf("报告中没有 %s", name)
+	return nil
+}
+
+func TestImportFromXUISkip(t *testing.T) {
+	setupImportTarget(t)
+	xuiPath := createXUIDatabase(t, true)
+
+	inboundService := InboundService{}
+	report, err := inboundService.ImportFromXUI(xuiPath, &ImportOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Import.DryRun {
+		t.Fatal("报告应标记为试运行")
+	}
+	inbounds := reportActions(report.Import.Inbounds)
+	if inbounds["vless:443 hk"] != importActionSkip || inbounds["vmess:8443 jp"] != importActionCreate {
+		t.Fatalf("入站操作错误: %v", inbounds)
+	}
+	if item := findImportItem(t, report.Import.Inbounds, "vless:443 hk"); item.Message != "端口 443 已被使用" {
+		t.Fatalf("跳过原因错误: %q", item.Message)
+	}
+	clients := reportActions(report.Import.Clients)
+	if clients["alice"] != importActionSkip || clients["bob"] != importActionSkip || clients["carol"] != importActionCreate {
+		t.Fatalf("客户端操作错误: %v", clients)
+	}
+
+	unmapped := strings.Join(report.Unmapped, "\n")
+	for _, note := range []string{"监听地址 127.0.0.1 未导入", "sniffing设置未导入", "flow(xtls-rprx-vision)未导入", "入站 3(trojan:9443): 已跳过"} {
+		if !strings.Contains(unmapped, note) {
+			t.Errorf("未映射内容中缺少 %q:\n%s", note, unmapped)
+		}
+	}
+
+	// 试运行不写入数据库
+	var count int64
+	database.GetDB().Model(&database.InboundConfig{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("试运行后入站数量为 %d，应为 1", count)
+	}
+}
+
+func TestImportFromXUIRenumber(t *testing.T) {
+	setupImportTarget(t)
+	xuiPath := createXUIDatabase(t, true)
+
+	inboundService := InboundService{}
+	report, err := inboundService.ImportFromXUI(xuiPath, &ImportOptions{
+		PortStrategy:  ConflictRenumber,
+		EmailStrategy: ConflictRenumber,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := findImportItem(t, report.Import.Inbounds, "vless:443 hk")
+	if item.Action != importActionCreate || item.Message != "端口 443 改为 444" {
+		t.Fatalf("入站报告错误: %+v", item)
+	}
+	item = findImportItem(t, report.Import.Clients, "bob")
+	if item.Action != importActionCreate || item.Message != "邮箱改为 bob_2" {
+		t.Fatalf("客户端报告错误: %+v", item)
+	}
+
+	inbounds, err := inboundService.GetInbounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports := map[int]*database.InboundConfig{}
+	for _, inbound := range inbounds {
+		ports[inbound.Port] = inbound
+	}
+	if len(inbounds) != 3 || ports[443].Tag != "existing" || ports[444] == nil || ports[8443] == nil {
+		t.Fatalf("导入后的入站错误: %v", ports)
+	}
+	if ports[444].Settings != `{"decryption":"none"}` {
+		t.Fatalf("入站settings应去掉clients: %s", ports[444].Settings)
+	}
+	// 3x-ui 的REALITY客户端参数移到realitySettings上
+	wantStream := `{"network":"tcp","realitySettings":{"dest":"www.example.com:443","fingerprint":"firefox",` +
+		`"privateKey":"kEw1mb28BeXYbo_gC3euhi8cFZoIS_PMTC_tX8XuRWQ","publicKey":"mkl7bGbRmD30UYU1-2LWDrgB-lGJ01nWSINW6pStlFU",` +
+		`"serverNames":["www.example.com"],"shortIds":["6ba85179e30d4fc2"],"show":false,"spiderX":"/x"},"security":"reality"}`
+	if ports[444].StreamSettings != wantStream {
+		t.Fatalf("入站streamSettings为 %s，期望 %s", ports[444].StreamSettings, wantStream)
+	}
+
+	clientService := ClientService{}
+	alice, err := clientService.GetClientByEmail("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.InboundID != ports[444].ID || alice.UUID != "0b4c7a1e-8f5d-4d2a-9c3e-1f2a3b4c5d6e" ||
+		alice.Limit != 1073741824 || alice.Used != 30 || alice.SubID != "alicesub" || !alice.Enable {
+		t.Fatalf("alice导入错误: %+v", alice)
+	}
+	link, err := clientService.GetShareLink(alice, "example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLink := "vless://0b4c7a1e-8f5d-4d2a-9c3e-1f2a3b4c5d6e@example.org:444?encryption=none&fp=firefox" +
+		"&pbk=mkl7bGbRmD30UYU1-2LWDrgB-lGJ01nWSINW6pStlFU&security=reality&sid=6ba85179e30d4fc2" +
+		"&sni=www.example.com&spx=%2Fx&type=tcp#hk-alice"
+	if link != wantLink {
+		t.Fatalf("alice的分享链接为 %s，期望 %s", link, wantLink)
+	}
+	bob, err := clientService.GetClientByEmail("bob_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bob.Enable || bob.InboundID != ports[444].ID {
+		t.Fatalf("bob_2导入错误: %+v", bob)
+	}
+	// client_traffics中禁用的客户端导入后也是禁用
+	carol, err := clientService.GetClientByEmail("carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if carol.Enable || carol.Used != 3 || carol.InboundID != ports[8443].ID {
+		t.Fatalf("carol导入错误: %+v", carol)
+	}
+}
+
+func TestImportFromXUIOverwrite(t *testing.T) {
+	existing := setupImportTarget(t)
+	xuiPath := createXUIDatabase(t, false)
+
+	inboundService := InboundService{}
+	report, err := inboundService.ImportFromXUI(xuiPath, &ImportOptions{
+		PortStrategy:  ConflictOverwrite,
+		EmailStrategy: ConflictOverwrite,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := findImportItem(t, report.Import.Inbounds, "vless:443 hk"); item.Action != importActionOverwrite {
+		t.Fatalf("入站报告错误: %+v", item)
+	}
+	if item := findImportItem(t, report.Import.Clients, "bob"); item.Action != importActionOverwrite {
+		t.Fatalf("客户端报告错误: %+v", item)
+	}
+
+	inbound, err := inboundService.GetInbound(existing.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inbound.Tag != "inbound-443" || inbound.Remark != "hk" {
+		t.Fatalf("入站未被覆盖: %+v", inbound)
+	}
+	clientService := ClientService{}
+	bob, err := clientService.GetClientByEmail("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bob.UUID != "5e6f7a8b-9c0d-4e1f-8a2b-3c4d5e6f7a8b" || bob.Enable {
+		t.Fatalf("客户端未被覆盖: %+v", bob)
+	}
+
+	// 旧版x-ui只有入站级的流量，入站只有一个客户端时分配给该客户端
+	carol, err := clientService.GetClientByEmail("carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if carol.Used != 300 || carol.Limit != 1000 || carol.ExpiryTime != 1700000000000 || !carol.Enable {
+		t.Fatalf("carol导入错误: %+v", carol)
+	}
+}
@@ -9,10 +9,10 @@ import (
 	"mx-ui/logger"
 	"mx-ui/web/controller"
 	"mx-ui/web/service"
+	"net"
 	"net/http"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -26,6 +26,15 @@ var staticFS embed.FS
 type Server struct {
 	httpServer *http.Server
 	router     *gin.Engine
+	tgBot      *service.Tgbot
+	backupJob  *service.BackupJob
+	accessLog  *service.AccessLogTailer
+	ipLimitJob *service.IPLimitJob
+	geoJob     *service.GeoAssetJob
+	trafficJob *service.TrafficJob
+	addr       string
+	socket     bool
+	errCh      chan error
 }
 
 // NewServer 创建一个新的Web服务器
@@ -46,65 +55,197 @@ func NewServer() *Server {
 
 	return &Server{
 		router: router,
+		errCh:  make(chan error, 1),
 	}
 }
 
-// Start 启动Web服务器
-func (s *Server) Start() error {
-	// 获取Web端口
+// Start 启动Web服务器，ctx取消时后台任务退出
+func (s *Server) Start(ctx context.Context) error {
+	// 获取Web端口，配置文件、环境变量或命令行参数中的端口优先于数据库中的设置
 	settingService := service.SettingService{}
-	port, err := settingService.GetPort()
-	if err != nil {
-		port = config.GetDefaultWebPort()
+	port := config.GetWebPort()
+	if port == 0 {
+		var err error
+		port, err = settingService.GetPort()
+		if err != nil {
+			port = config.GetDefaultWebPort()
+		}
 	}
 
-	// 获取证书文件
-	certFile, err := settingService.GetCertFile()
-	keyFile, err2 := settingService.GetKeyFile()
+	// 获取监听地址，同样优先使用配置文件、环境变量或命令行参数
+	listen := config.GetListenAddr()
+	if listen == "" {
+		listen, _ = settingService.GetListen()
+	}
+	socketMode, err := SocketMode()
+	if err != nil {
+		return err
+	}
 
+	listener, err := newListener(listen, port, socketMode)
+	if err != nil {
+		service.SetListenerState("web", "", false, err)
+		return fmt.Errorf("监听失败: %v", err)
+	}
+	s.addr = ListenerAddr(listener)
+	// systemd传入的套接字同样视为不开放面板自己的TCP端口
+	systemdFile, _ := systemdSocket()
+	s.socket = listener.Addr().Network() == "unix" || systemdFile != nil
+	service.SetListenerState("web", s.addr, true, nil)
+	// 开始停止服务器时取消请求的上下文，使日志跟踪等长连接结束，其他请求可以正常完成
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
 	s.httpServer = &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
 		Handler: s.router,
+		BaseContext: func(net.Listener) context.Context {
+			return requestCtx
+		},
+	}
+	s.httpServer.RegisterOnShutdown(cancelRequests)
+
+	s.startTgBot(ctx)
+
+	s.backupJob = service.NewBackupJob()
+	if err := s.backupJob.Start(ctx); err != nil {
+		logger.Warning("启动定时备份失败:", err)
+	}
+
+	s.accessLog = service.NewAccessLogTailer()
+	if err := s.accessLog.Start(ctx); err != nil {
+		logger.Warning("跟踪访问日志失败:", err)
+	}
+	s.ipLimitJob = service.NewIPLimitJob()
+	s.ipLimitJob.Start(ctx)
+	s.trafficJob = service.NewTrafficJob()
+	s.trafficJob.Start(ctx)
+	s.geoJob = service.NewGeoAssetJob()
+	if err := s.geoJob.Start(ctx); err != nil {
+		logger.Warning("启动geo数据文件定时更新失败:", err)
 	}
 
 	// 判断是否使用HTTPS
-	var startErr error
-	if certFile != "" && keyFile != "" && err == nil && err2 == nil {
-		// 检查文件是否存在
-		if _, err := os.Stat(certFile); err == nil {
-			if _, err := os.Stat(keyFile); err == nil {
-				logger.Info("使用HTTPS启动Web服务器，端口:", port)
-				go func() {
-					startErr = s.httpServer.ListenAndServeTLS(certFile, keyFile)
-					if startErr != nil && startErr != http.ErrServerClosed {
-						logger.Error("启动HTTPS服务器失败:", startErr)
-					}
-				}()
-				return nil
+	certFile, keyFile := tlsFiles()
+	if certFile != "" {
+		logger.Info("使用HTTPS启动Web服务器，监听:", s.addr)
+		go func() {
+			err := s.httpServer.ServeTLS(listener, certFile, keyFile)
+			if err != nil && err != http.ErrServerClosed {
+				logger.Error("HTTPS服务器运行失败:", err)
+				s.reportErr(err)
 			}
-		}
+		}()
+		return nil
 	}
 
 	// 使用HTTP启动
-	logger.Info("使用HTTP启动Web服务器，端口:", port)
+	logger.Info("使用HTTP启动Web服务器，监听:", s.addr)
 	go func() {
-		startErr = s.httpServer.ListenAndServe()
-		if startErr != nil && startErr != http.ErrServerClosed {
-			logger.Error("启动HTTP服务器失败:", startErr)
+		err := s.httpServer.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("HTTP服务器运行失败:", err)
+			s.reportErr(err)
 		}
 	}()
 
 	return nil
 }
 
-// Stop 停止Web服务器
-func (s *Server) Stop() error {
+// tlsFiles 获取HTTPS使用的证书和密钥文件，未设置、读取设置失败或文件不存在时返回空字符串，使用HTTP
+func tlsFiles() (string, string) {
+	settingService := service.SettingService{}
+	certFile, err := settingService.GetCertFile()
+	if err != nil || certFile == "" {
+		return "", ""
+	}
+	keyFile, err := settingService.GetKeyFile()
+	if err != nil || keyFile == "" {
+		return "", ""
+	}
+	if _, err := os.Stat(certFile); err != nil {
+		return "", ""
+	}
+	if _, err := os.Stat(keyFile); err != nil {
+		return "", ""
+	}
+	return certFile, keyFile
+}
+
+// Addr 返回Web服务器的监听地址，Unix套接字以 unix: 开头
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// UsesSocket 返回Web服务器是否监听Unix套接字或systemd传入的套接字
+func (s *Server) UsesSocket() bool {
+	return s.socket
+}
+
+// Err 返回服务运行中出错的通知通道
+func (s *Server) Err() <-chan error {
+	return s.errCh
+}
+
+// reportErr 通知运行中的错误，已有未处理的错误时丢弃
+func (s *Server) reportErr(err error) {
+	service.SetListenerState("web", "", false, err)
+	select {
+	case s.errCh <- err:
+	default:
+	}
+}
+
+// startTgBot 启用Telegram机器人时启动它
+func (s *Server) startTgBot(ctx context.Context) {
+	settingService := service.SettingService{}
+	enable, err := settingService.GetTgBotEnable()
+	if err != nil || !enable {
+		return
+	}
+	tgBot, err := service.NewTgbot()
+	if err != nil {
+		logger.Warning("启动Telegram机器人失败:", err)
+		return
+	}
+	tgBot.Start(ctx)
+	s.tgBot = tgBot
+}
+
+// Stop 停止接受新连接并等待进行中的请求完成，ctx到期后强制关闭，然后停止后台任务
+func (s *Server) Stop(ctx context.Context) error {
+	var err error
 	if s.httpServer != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		return s.httpServer.Shutdown(ctx)
+		service.SetListenerState("web", "", false, nil)
+		err = s.httpServer.Shutdown(ctx)
+		if err != nil {
+			logger.Warning("等待Web请求完成超时，强制关闭:", err)
+			s.httpServer.Close()
+		}
 	}
-	return nil
+
+	if s.tgBot != nil {
+		s.tgBot.Stop()
+		s.tgBot = nil
+	}
+	if s.backupJob != nil {
+		s.backupJob.Stop()
+		s.backupJob = nil
+	}
+	if s.ipLimitJob != nil {
+		s.ipLimitJob.Stop()
+		s.ipLimitJob = nil
+	}
+	if s.trafficJob != nil {
+		s.trafficJob.Stop()
+		s.trafficJob = nil
+	}
+	if s.geoJob != nil {
+		s.geoJob.Stop()
+		s.geoJob = nil
+	}
+	if s.accessLog != nil {
+		s.accessLog.Stop()
+		s.accessLog = nil
+	}
+	return err
 }
 
 // registerRoutes 注册路由
@@ -140,8 +281,12 @@ func registerRoutes(router *gin.Engine) {
 			{
 				inboundAPI.GET("", inboundController.GetInbounds)
 				inboundAPI.POST("", inboundController.AddInbound)
+				inboundAPI.GET("/export", inboundController.ExportInbounds)
+				inboundAPI.POST("/import", inboundController.ImportInbounds)
+				inboundAPI.POST("/import-xui", inboundController.ImportFromXUI)
 				inboundAPI.PUT("/:id", inboundController.UpdateInbound)
 				inboundAPI.DELETE("/:id", inboundController.DeleteInbound)
+				inboundAPI.POST("/:id/reality/check", inboundController.CheckReality)
 			}
 
 			// 客户端相关API
@@ -150,8 +295,36 @@ func registerRoutes(router *gin.Engine) {
 			{
 				clientAPI.GET("", clientController.GetClients)
 				clientAPI.POST("", clientController.AddClient)
+				clientAPI.GET("/connections", clientController.GetActivities)
+				clientAPI.GET("/:id/connections", clientController.GetConnections)
+				clientAPI.GET("/:id/links", clientController.GetLinks)
 				clientAPI.PUT("/:id", clientController.UpdateClient)
 				clientAPI.DELETE("/:id", clientController.DeleteClient)
+				clientAPI.POST("/:id/enable", clientController.SetClientEnable)
+				clientAPI.POST("/:id/resetTraffic", clientController.ResetClientTraffic)
+			}
+
+			// 出站相关API
+			outboundController := &controller.OutboundController{}
+			outboundAPI := api.Group("/outbounds")
+			{
+				outboundAPI.GET("", outboundController.GetOutbounds)
+				outboundAPI.POST("", outboundController.AddOutbound)
+				outboundAPI.PUT("/:id", outboundController.UpdateOutbound)
+				outboundAPI.DELETE("/:id", outboundController.DeleteOutbound)
+			}
+
+			// 路由规则相关API
+			routingController := &controller.RoutingController{}
+			routingAPI := api.Group("/routing")
+			{
+				routingAPI.GET("/rules", routingController.GetRules)
+				routingAPI.POST("/rules", routingController.AddRule)
+				routingAPI.PUT("/rules/order", routingController.SetOrder)
+				routingAPI.PUT("/rules/:id", routingController.UpdateRule)
+				routingAPI.DELETE("/rules/:id", routingController.DeleteRule)
+				routingAPI.GET("/presets", routingController.GetPresets)
+				routingAPI.POST("/presets/:name", routingController.ApplyPreset)
 			}
 
 			// 服务器状态API
@@ -165,10 +338,44 @@ func registerRoutes(router *gin.Engine) {
 			api.POST("/xray/stop", xrayController.Stop)
 			api.POST("/xray/start", xrayController.Start)
 			api.GET("/xray/config", xrayController.GetConfig)
+			api.GET("/xray/revisions", xrayController.GetRevisions)
+			api.GET("/xray/revisions/diff", xrayController.DiffRevisions)
+			api.GET("/xray/revisions/:id", xrayController.GetRevision)
+			api.POST("/xray/revisions/:id/rollback", xrayController.RollbackRevision)
+			api.GET("/xray/version", xrayController.GetVersion)
+			api.POST("/xray/version/install", xrayController.InstallVersion)
+			api.POST("/xray/version/upload", xrayController.UploadVersion)
+			api.POST("/xray/version/rollback", xrayController.RollbackVersion)
+
+			// 工具API
+			toolController := &controller.ToolController{}
+			api.GET("/tools/x25519", toolController.GenerateX25519)
+			api.GET("/tools/shortIds", toolController.GenerateShortIDs)
+			api.GET("/tools/uuid", toolController.GenerateUUID)
+			api.POST("/tools/reality/check", toolController.CheckRealityDest)
+
+			// geo数据文件相关API
+			geoAssetController := &controller.GeoAssetController{}
+			api.GET("/geo/assets", geoAssetController.GetAssets)
+			api.POST("/geo/assets/update", geoAssetController.Update)
+			api.POST("/geo/assets/upload", geoAssetController.Upload)
+			api.DELETE("/geo/assets/:name", geoAssetController.Delete)
+
+			// 备份相关API
+			backupController := &controller.BackupController{}
+			api.GET("/backup", backupController.Backup)
+			api.POST("/restore", backupController.Restore)
+
+			// 日志相关API
+			logController := &controller.LogController{}
+			api.GET("/logs", logController.GetLogs)
+			api.GET("/logs/level", logController.GetLevel)
+			api.PUT("/logs/level", logController.SetLevel)
 		}
 	}
 
 	// 前端路由
+	healthController := &controller.HealthController{}
 	router.NoRoute(func(c *gin.Context) {
 		// API路由返回404
 		if strings.HasPrefix(c.Request.URL.Path, apiPrefix) {
@@ -186,6 +393,19 @@ func registerRoutes(router *gin.Engine) {
 			webBasePath = "/"
 		}
 
+		// 健康检查接口位于网页基础路径下，可在设置中关闭
+		switch c.Request.URL.Path {
+		case webBasePath + "healthz", webBasePath + "readyz":
+			if enable, err := settingService.GetHealthEnable(); err == nil && enable {
+				if strings.HasSuffix(c.Request.URL.Path, "healthz") {
+					healthController.Healthz(c)
+				} else {
+					healthController.Readyz(c)
+				}
+				return
+			}
+		}
+
 		// 检查是否访问网页基础路径
 		if !strings.HasPrefix(c.Request.URL.Path, webBasePath) {
 			c.JSON(http.StatusNotFound, gin.H{
@@ -0,0 +1,56 @@
+package web
+
+import (
+	"mx-ui/config"
+	"mx-ui/database"
+	"mx-ui/web/service"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	dataDir := config.DataDirPath
+	config.DataDirPath = dir
+	err := database.InitDB(filepath.Join(dir, config.DBName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		database.CloseDB()
+		config.DataDirPath = dataDir
+	})
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	settingService := service.SettingService{}
+	if cert, key := tlsFiles(); cert != "" || key != "" {
+		t.Errorf("未设置证书时应使用HTTP，实际为 %q %q", cert, key)
+	}
+
+	err = settingService.SetCertFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = settingService.SetKeyFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(certFile, []byte("cert"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, key := tlsFiles(); cert != "" || key != "" {
+		t.Errorf("密钥文件不存在时应使用HTTP，实际为 %q %q", cert, key)
+	}
+
+	err = os.WriteFile(keyFile, []byte("key"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, key := tlsFiles(); cert != certFile || key != keyFile {
+		t.Errorf("证书文件为 %q %q，期望 %q %q", cert, key, certFile, keyFile)
+	}
+}
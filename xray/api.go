@@ -0,0 +1,153 @@
+package xray
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/app/proxyman/command"
+	statsCommand "github.com/xtls/xray-core/app/stats/command"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/proxy/trojan"
+	"github.com/xtls/xray-core/proxy/vless"
+	"github.com/xtls/xray-core/proxy/vmess"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+)
+
+// APITag 面板注入的内部API入站、API配置和路由规则使用的标签
+const APITag = "api"
+
+// 单次API调用的超时时间
+const apiTimeout = 5 * time.Second
+
+// HotUserProtocols 可以通过API增删用户、无需重启Xray的入站协议
+var HotUserProtocols = map[string]bool{
+	"vmess":  true,
+	"vless":  true,
+	"trojan": true,
+}
+
+// User 入站settings.clients中的一个用户
+type User struct {
+	Email    string `json:"email"`
+	ID       string `json:"id,omitempty"`
+	Password string `json:"password,omitempty"`
+	Flow     string `json:"flow,omitempty"`
+}
+
+// API 通过gRPC调用运行中Xray的HandlerService和StatsService
+type API struct {
+	conn    *grpc.ClientConn
+	handler command.HandlerServiceClient
+	stats   statsCommand.StatsServiceClient
+}
+
+// NewAPI 连接addr上的Xray API，例如 127.0.0.1:62789
+func NewAPI(addr string) (*API, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &API{
+		conn:    conn,
+		handler: command.NewHandlerServiceClient(conn),
+		stats:   statsCommand.NewStatsServiceClient(conn),
+	}, nil
+}
+
+// Close 关闭连接
+func (a *API) Close() error {
+	return a.conn.Close()
+}
+
+// AddUser 向标签为inboundTag的入站添加用户，flow为入站settings中的默认flow
+func (a *API) AddUser(inboundTag string, inboundProtocol string, flow string, user *User) error {
+	account, err := buildAccount(inboundProtocol, flow, user)
+	if err != nil {
+		return err
+	}
+	return a.alterInbound(inboundTag, &command.AddUserOperation{
+		User: &protocol.User{
+			Email:   user.Email,
+			Account: account,
+		},
+	})
+}
+
+// RemoveUser 从标签为inboundTag的入站删除用户
+func (a *API) RemoveUser(inboundTag string, email string) error {
+	return a.alterInbound(inboundTag, &command.RemoveUserOperation{
+		Email: email,
+	})
+}
+
+func (a *API) alterInbound(inboundTag string, operation proto.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	_, err := a.handler.AlterInbound(ctx, &command.AlterInboundRequest{
+		Tag:       inboundTag,
+		Operation: serial.ToTypedMessage(operation),
+	})
+	return err
+}
+
+// QueryUserTraffic 查询每个用户的上传和下载流量之和（字节），键为用户邮箱。
+// reset为true时同时清零计数器，返回的是上次清零以来的增量
+func (a *API) QueryUserTraffic(reset bool) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	resp, err := a.stats.QueryStats(ctx, &statsCommand.QueryStatsRequest{
+		Pattern: "user>>>",
+		Reset_:  reset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	traffic := map[string]int64{}
+	for _, stat := range resp.GetStat() {
+		// 计数器名称为 user>>>邮箱>>>traffic>>>uplink 或 downlink
+		parts := strings.Split(stat.GetName(), ">>>")
+		if len(parts) != 4 || parts[0] != "user" || parts[2] != "traffic" {
+			continue
+		}
+		if stat.GetValue() > 0 {
+			traffic[parts[1]] += stat.GetValue()
+		}
+	}
+	return traffic, nil
+}
+
+// buildAccount 按入站协议生成用户的账号信息
+func buildAccount(inboundProtocol string, flow string, user *User) (*serial.TypedMessage, error) {
+	switch inboundProtocol {
+	case "vmess":
+		return serial.ToTypedMessage(&vmess.Account{
+			Id: user.ID,
+			SecuritySettings: &protocol.SecurityConfig{
+				Type: protocol.SecurityType_AUTO,
+			},
+		}), nil
+	case "vless":
+		if user.Flow != "" {
+			flow = user.Flow
+		}
+		if flow == "none" {
+			flow = ""
+		}
+		return serial.ToTypedMessage(&vless.Account{
+			Id:   strings.ToLower(user.ID),
+			Flow: flow,
+		}), nil
+	case "trojan":
+		return serial.ToTypedMessage(&trojan.Account{
+			Password: user.Password,
+		}), nil
+	default:
+		return nil, fmt.Errorf("%s 入站不支持热更新用户", inboundProtocol)
+	}
+}
@@ -0,0 +1,173 @@
+package xray
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Config Xray配置结构，除入站和出站外的各部分以原始JSON形式保存
+type Config struct {
+	LogConfig       json.RawMessage  `json:"log,omitempty"`
+	API             json.RawMessage  `json:"api,omitempty"`
+	DNSConfig       json.RawMessage  `json:"dns,omitempty"`
+	RouterConfig    json.RawMessage  `json:"routing,omitempty"`
+	Policy          json.RawMessage  `json:"policy,omitempty"`
+	InboundConfigs  []InboundConfig  `json:"inbounds"`
+	OutboundConfigs []OutboundConfig `json:"outbounds,omitempty"`
+	Transport       json.RawMessage  `json:"transport,omitempty"`
+	Stats           json.RawMessage  `json:"stats,omitempty"`
+	Reverse         json.RawMessage  `json:"reverse,omitempty"`
+	FakeDNS         json.RawMessage  `json:"fakedns,omitempty"`
+	Observatory     json.RawMessage  `json:"observatory,omitempty"`
+}
+
+// InboundConfig Xray入站配置
+type InboundConfig struct {
+	Listen         json.RawMessage `json:"listen,omitempty"`
+	Port           int             `json:"port"`
+	Protocol       string          `json:"protocol"`
+	Settings       json.RawMessage `json:"settings,omitempty"`
+	StreamSettings json.RawMessage `json:"streamSettings,omitempty"`
+	Tag            string          `json:"tag"`
+	Sniffing       json.RawMessage `json:"sniffing,omitempty"`
+}
+
+// OutboundConfig Xray出站配置
+type OutboundConfig struct {
+	SendThrough    string          `json:"sendThrough,omitempty"`
+	Protocol       string          `json:"protocol"`
+	Settings       json.RawMessage `json:"settings,omitempty"`
+	Tag            string          `json:"tag,omitempty"`
+	StreamSettings json.RawMessage `json:"streamSettings,omitempty"`
+	ProxySettings  json.RawMessage `json:"proxySettings,omitempty"`
+	Mux            json.RawMessage `json:"mux,omitempty"`
+	TargetStrategy string          `json:"targetStrategy,omitempty"`
+}
+
+// RoutingRule Xray路由规则
+type RoutingRule struct {
+	Type        string   `json:"type"`
+	Domain      []string `json:"domain,omitempty"`
+	IP          []string `json:"ip,omitempty"`
+	Port        string   `json:"port,omitempty"`
+	Network     string   `json:"network,omitempty"`
+	Protocol    []string `json:"protocol,omitempty"`
+	InboundTag  []string `json:"inboundTag,omitempty"`
+	User        []string `json:"user,omitempty"`
+	OutboundTag string   `json:"outboundTag,omitempty"`
+	BalancerTag string   `json:"balancerTag,omitempty"`
+}
+
+// Equals 判断两个配置是否相同
+func (c *Config) Equals(other *Config) bool {
+	if c == nil || other == nil {
+		return c == other
+	}
+	a, err := json.Marshal(c)
+	if err != nil {
+		return false
+	}
+	b, err := json.Marshal(other)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(a, b)
+}
+
+// InboundUsers 一个入站中需要增删的用户
+type InboundUsers struct {
+	Tag      string
+	Protocol string
+	Flow     string
+	Added    []*User
+	Removed  []*User
+}
+
+// DiffUsers 比较新旧两个配置。只有入站的用户不同时返回各入站需要增删的用户，
+// 用户信息变化时先删除再添加；其他部分有变化或入站协议不支持热更新时返回false
+func DiffUsers(old *Config, new *Config) ([]*InboundUsers, bool) {
+	if old == nil || new == nil || len(old.InboundConfigs) != len(new.InboundConfigs) {
+		return nil, false
+	}
+	oldRest, err1 := withoutUsers(old)
+	newRest, err2 := withoutUsers(new)
+	if err1 != nil || err2 != nil || !oldRest.Equals(newRest) {
+		return nil, false
+	}
+
+	var changes []*InboundUsers
+	for i := range new.InboundConfigs {
+		oldInbound, newInbound := &old.InboundConfigs[i], &new.InboundConfigs[i]
+		oldUsers, oldFlow, err1 := parseUsers(oldInbound.Settings)
+		newUsers, newFlow, err2 := parseUsers(newInbound.Settings)
+		if err1 != nil || err2 != nil || oldFlow != newFlow {
+			return nil, false
+		}
+
+		change := &InboundUsers{
+			Tag:      newInbound.Tag,
+			Protocol: newInbound.Protocol,
+			Flow:     newFlow,
+		}
+		for email, user := range oldUsers {
+			if newUser, ok := newUsers[email]; !ok || *newUser != *user {
+				change.Removed = append(change.Removed, user)
+			}
+		}
+		for email, user := range newUsers {
+			if oldUser, ok := oldUsers[email]; !ok || *oldUser != *user {
+				change.Added = append(change.Added, user)
+			}
+		}
+		if len(change.Added) == 0 && len(change.Removed) == 0 {
+			continue
+		}
+		if !HotUserProtocols[newInbound.Protocol] {
+			return nil, false
+		}
+		changes = append(changes, change)
+	}
+	return changes, true
+}
+
+// withoutUsers 复制配置并去掉各入站settings中的clients
+func withoutUsers(c *Config) (*Config, error) {
+	copied := *c
+	copied.InboundConfigs = make([]InboundConfig, len(c.InboundConfigs))
+	for i, inbound := range c.InboundConfigs {
+		if len(inbound.Settings) > 0 {
+			settings := map[string]json.RawMessage{}
+			err := json.Unmarshal(inbound.Settings, &settings)
+			if err != nil {
+				return nil, err
+			}
+			delete(settings, "clients")
+			inbound.Settings, err = json.Marshal(settings)
+			if err != nil {
+				return nil, err
+			}
+		}
+		copied.InboundConfigs[i] = inbound
+	}
+	return &copied, nil
+}
+
+// parseUsers 解析入站settings中的用户（按邮箱索引）和默认flow
+func parseUsers(settings json.RawMessage) (map[string]*User, string, error) {
+	users := map[string]*User{}
+	if len(settings) == 0 {
+		return users, "", nil
+	}
+	var parsed struct {
+		Clients []*User `json:"clients"`
+		Flow    string  `json:"flow"`
+	}
+	err := json.Unmarshal(settings, &parsed)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, user := range parsed.Clients {
+		users[user.Email] = user
+	}
+	return users, parsed.Flow, nil
+}
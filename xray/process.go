@@ -0,0 +1,290 @@
+package xray
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mx-ui/config"
+	"mx-ui/logger"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 保留的Xray进程输出大小，超出时丢弃最早的输出
+const maxOutputSize = 64 * 1024
+
+// ErrBinaryNotFound Xray二进制文件不存在
+var ErrBinaryNotFound = errors.New("xray二进制文件不存在")
+
+// 检查配置的最长时间，加载较大的geo文件需要一些时间
+const testTimeout = 30 * time.Second
+
+// Process Xray进程
+type Process struct {
+	lock    sync.Mutex
+	cmd     *exec.Cmd
+	config  *Config
+	exitErr error
+	running bool
+	// stopped 进程是通过Stop停止的，退出不算作错误
+	stopped bool
+	// exited 进程退出并更新状态后关闭
+	exited chan struct{}
+	output  outputBuffer
+}
+
+// outputBuffer 并发安全的进程输出缓冲区，只保留最后maxOutputSize字节
+type outputBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	n := len(p)
+	if n > maxOutputSize {
+		p = p[n-maxOutputSize:]
+	}
+	b.buf.Write(p)
+	if over := b.buf.Len() - maxOutputSize; over > 0 {
+		b.buf.Next(over)
+		// 从下一行开始保留，避免截断的半行
+		if i := bytes.IndexByte(b.buf.Bytes(), '\n'); i >= 0 {
+			b.buf.Next(i + 1)
+		}
+	}
+	return n, nil
+}
+
+func (b *outputBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func (b *outputBuffer) Reset() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.buf.Reset()
+}
+
+// NewProcess 使用给定配置创建一个Xray进程
+func NewProcess(xrayConfig *Config) *Process {
+	return &Process{
+		config: xrayConfig,
+	}
+}
+
+// GetConfig 获取进程使用的配置
+func (p *Process) GetConfig() *Config {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.config
+}
+
+// IsRunning 判断进程是否在运行
+func (p *Process) IsRunning() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.running
+}
+
+// IsStopped 判断进程是否是通过Stop停止的
+func (p *Process) IsStopped() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.stopped
+}
+
+// GetErr 获取进程异常退出时的错误，通过Stop停止时为nil
+func (p *Process) GetErr() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.exitErr
+}
+
+// GetResult 获取进程的输出内容
+func (p *Process) GetResult() string {
+	return p.output.String()
+}
+
+// Start 写入配置文件并启动Xray进程
+func (p *Process) Start() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.running {
+		return errors.New("xray已在运行")
+	}
+
+	configPath := config.GetXrayConfigPath()
+	err := writeConfig(configPath, p.config)
+	if err != nil {
+		return err
+	}
+
+	p.output.Reset()
+	cmd := exec.Command(config.GetXrayBinaryPath(), "run", "-c", configPath)
+	cmd.Stdout = &p.output
+	cmd.Stderr = &p.output
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	p.cmd = cmd
+	p.exitErr = nil
+	p.running = true
+	p.stopped = false
+	exited := make(chan struct{})
+	p.exited = exited
+
+	go func() {
+		err := cmd.Wait()
+		defer close(exited)
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if p.cmd != cmd {
+			return
+		}
+		p.running = false
+		if p.stopped {
+			logger.Info("xray进程已停止")
+			return
+		}
+		if err != nil {
+			logger.Error("xray进程退出:", err)
+			p.exitErr = err
+		}
+	}()
+
+	return nil
+}
+
+// UpdateConfig 通过API热更新后记录进程当前使用的配置，并同步写入配置文件
+func (p *Process) UpdateConfig(xrayConfig *Config) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.config = xrayConfig
+	return writeConfig(config.GetXrayConfigPath(), xrayConfig)
+}
+
+// writeConfig 将配置写入path
+func writeConfig(path string, xrayConfig *Config) error {
+	data, err := json.MarshalIndent(xrayConfig, "", "  ")
+	if err != nil {
+		return fmt.Errorf("生成xray配置失败: %v", err)
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("创建配置目录失败: %v", err)
+	}
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("写入xray配置失败: %v", err)
+	}
+	return nil
+}
+
+// Stop 停止Xray进程
+func (p *Process) Stop() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.running || p.cmd == nil || p.cmd.Process == nil {
+		return errors.New("xray未在运行")
+	}
+	p.running = false
+	p.stopped = true
+	return p.cmd.Process.Kill()
+}
+
+// TestConfig 将配置写入临时目录，用 xray run -test 检查，不影响正在运行的进程和配置文件。
+// Xray二进制文件不存在时返回ErrBinaryNotFound
+func TestConfig(xrayConfig *Config) error {
+	binaryPath := config.GetXrayBinaryPath()
+	if _, err := os.Stat(binaryPath); os.IsNotExist(err) {
+		return ErrBinaryNotFound
+	}
+
+	data, err := json.MarshalIndent(xrayConfig, "", "  ")
+	if err != nil {
+		return fmt.Errorf("生成xray配置失败: %v", err)
+	}
+	err = os.MkdirAll(config.GetTempPath(), 0755)
+	if err != nil {
+		return fmt.Errorf("创建临时目录失败: %v", err)
+	}
+	file, err := os.CreateTemp(config.GetTempPath(), "xray-test-*.json")
+	if err != nil {
+		return fmt.Errorf("创建临时配置文件失败: %v", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("写入临时配置文件失败: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, binaryPath, "run", "-test", "-c", file.Name()).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("xray检查配置超时（%v）", testTimeout)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return fmt.Errorf("运行xray检查配置失败: %v", err)
+	}
+	return fmt.Errorf("xray配置检查未通过: %s", parseTestOutput(string(output), err))
+}
+
+// parseTestOutput 从 xray run -test 的输出中提取错误原因，
+// 去掉配置文件路径和各层的包名前缀，例如
+// "Failed to start: main: failed to load config files: [x.json] > infra/conf: failed to build ..."
+// 整理为 "failed to build ..."
+func parseTestOutput(output string, err error) string {
+	var message, lastLine string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if i := strings.Index(line, "Failed to start:"); i >= 0 {
+			message = strings.TrimSpace(line[i+len("Failed to start:"):])
+		} else if line != "" {
+			lastLine = line
+		}
+	}
+	if message == "" {
+		message = lastLine
+	}
+	if message == "" {
+		return err.Error()
+	}
+
+	parts := strings.Split(message, " > ")
+	if len(parts) > 1 && strings.Contains(parts[0], "failed to load config files") {
+		parts = parts[1:]
+	}
+	for i, part := range parts {
+		if j := strings.Index(part, ": "); j > 0 && isPackagePrefix(part[:j]) {
+			parts[i] = part[j+2:]
+		}
+	}
+	return strings.Join(parts, " > ")
+}
+
+// isPackagePrefix 判断是否是Xray错误信息中的包名前缀，例如 main、infra/conf
+func isPackagePrefix(prefix string) bool {
+	return prefix == "main" || (strings.Contains(prefix, "/") && !strings.Contains(prefix, " "))
+}
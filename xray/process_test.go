@@ -0,0 +1,102 @@
+package xray
+
+import (
+	"mx-ui/config"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputBufferLimit(t *testing.T) {
+	b := &outputBuffer{}
+	line := strings.Repeat("x", 99) + "\n"
+	for i := 0; i < 2*maxOutputSize/len(line); i++ {
+		b.Write([]byte(line))
+	}
+	b.Write([]byte("last\n"))
+
+	output := b.String()
+	if len(output) > maxOutputSize {
+		t.Fatalf("输出缓冲区大小为 %d，超过 %d", len(output), maxOutputSize)
+	}
+	if !strings.HasPrefix(output, line) {
+		t.Fatal("截断后应从完整的一行开始")
+	}
+	if !strings.HasSuffix(output, "last\n") {
+		t.Fatal("应保留最新的输出")
+	}
+
+	b.Write([]byte(strings.Repeat("y", 2*maxOutputSize)))
+	if len(b.String()) > maxOutputSize {
+		t.Fatal("单次写入超过上限时应只保留最后部分")
+	}
+}
+
+// startStubProcess 在临时目录中放置执行script的Xray二进制文件并启动进程
+func startStubProcess(t *testing.T, script string) *Process {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	err := os.MkdirAll(config.GetBinFolderPath(), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(config.GetXrayBinaryPath(), []byte("#!/bin/sh\n"+script+"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewProcess(&Config{})
+	err = p.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// waitExited 等待进程退出并更新状态
+func waitExited(t *testing.T, p *Process) {
+	t.Helper()
+	p.lock.Lock()
+	exited := p.exited
+	p.lock.Unlock()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待xray进程退出超时")
+	}
+}
+
+func TestProcessStopIsNotError(t *testing.T) {
+	p := startStubProcess(t, "exec sleep 60")
+	if !p.IsRunning() {
+		t.Fatal("启动后进程应在运行")
+	}
+	err := p.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitExited(t, p)
+	if p.IsRunning() {
+		t.Error("停止后进程不应在运行")
+	}
+	if !p.IsStopped() {
+		t.Error("通过Stop停止的进程应标记为已停止")
+	}
+	if err := p.GetErr(); err != nil {
+		t.Errorf("通过Stop停止时不应记录错误，实际为 %v", err)
+	}
+}
+
+func TestProcessUnexpectedExit(t *testing.T) {
+	p := startStubProcess(t, "echo broken config; exit 3")
+	waitExited(t, p)
+	if p.IsRunning() || p.IsStopped() {
+		t.Error("异常退出的进程不应在运行，也不应标记为已停止")
+	}
+	if err := p.GetErr(); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("异常退出时应记录错误，实际为 %v", err)
+	}
+	if !strings.Contains(p.GetResult(), "broken config") {
+		t.Errorf("应保留进程输出，实际为 %q", p.GetResult())
+	}
+}
@@ -0,0 +1,41 @@
+package xray
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// GenerateX25519 生成REALITY使用的x25519密钥对，与 xray x25519 的输出格式相同
+func GenerateX25519() (privateKey string, publicKey string, err error) {
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	if err != nil {
+		return "", "", err
+	}
+	// 与Xray相同，按curve25519的要求调整私钥
+	key[0] &= 248
+	key[31] &= 127
+	key[31] |= 64
+
+	privateKey = base64.RawURLEncoding.EncodeToString(key)
+	publicKey, err = X25519PublicKey(privateKey)
+	if err != nil {
+		return "", "", err
+	}
+	return privateKey, publicKey, nil
+}
+
+// X25519PublicKey 根据REALITY私钥计算公钥，即客户端使用的 pbk
+func X25519PublicKey(privateKey string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil || len(key) != 32 {
+		return "", errors.New("无效的x25519私钥")
+	}
+	priv, err := ecdh.X25519().NewPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(priv.PublicKey().Bytes()), nil
+}
@@ -0,0 +1,64 @@
+package xray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mx-ui/config"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 缓存的版本号，二进制文件的修改时间和大小不变时直接返回
+var versionCache struct {
+	lock    sync.Mutex
+	modTime time.Time
+	size    int64
+	version string
+}
+
+// GetVersion 获取当前Xray二进制文件的版本号，例如 1.8.24
+func GetVersion() (string, error) {
+	binaryPath := config.GetXrayBinaryPath()
+	info, err := os.Stat(binaryPath)
+	if os.IsNotExist(err) {
+		return "", ErrBinaryNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	versionCache.lock.Lock()
+	defer versionCache.lock.Unlock()
+	if versionCache.version != "" && versionCache.modTime.Equal(info.ModTime()) && versionCache.size == info.Size() {
+		return versionCache.version, nil
+	}
+	version, err := BinaryVersion(binaryPath)
+	if err != nil {
+		return "", err
+	}
+	versionCache.modTime = info.ModTime()
+	versionCache.size = info.Size()
+	versionCache.version = version
+	return version, nil
+}
+
+// BinaryVersion 运行 path version 获取版本号，可用于检查下载的二进制文件能否运行
+func BinaryVersion(path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, path, "version").Output()
+	if err != nil {
+		return "", fmt.Errorf("运行xray version失败: %v", err)
+	}
+	// 输出的第一行形如 "Xray 1.8.24 (Xray, Penetrates Everything.) ..."
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "Xray" {
+		return "", errors.New("无法识别xray version的输出")
+	}
+	return strings.TrimPrefix(fields[1], "v"), nil
+}